@@ -0,0 +1,65 @@
+package main
+
+import (
+  "fmt"
+  "github.com/mik3cap/haunts/game"
+  "os"
+)
+
+// When started with -ai-replay the game reads an ai turn that was dumped
+// from the ai debug panel, plays it again through the same script and
+// reports whether the ai did the same thing, e.g.
+//   haunts -ai-replay data/logs/ai-turn-2012-06-01-12-00-00.turn
+// Anything the script prints goes to the log, as it does in the game.
+func isAiReplay() bool {
+  return len(os.Args) > 1 && os.Args[1] == "-ai-replay"
+}
+
+// When started with -ai-record every ai turn is recorded, not just those
+// taken while the ai debugger is on, so that a turn that went wrong can be
+// dumped after the fact.
+func isAiRecording() bool {
+  for _, arg := range os.Args[1:] {
+    if arg == "-ai-record" {
+      return true
+    }
+  }
+  return false
+}
+
+// Returns the exit status for the process, 0 if the replay matched the
+// recording.
+func replayAiTurn() int {
+  if len(os.Args) != 3 {
+    fmt.Fprintf(os.Stderr, "Usage: %s -ai-replay <file>\n", os.Args[0])
+    return 2
+  }
+  f, err := os.Open(os.Args[2])
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%v\n", err)
+    return 1
+  }
+  rec, err := game.ReadAiTurnRecord(f)
+  f.Close()
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Unable to read %s: %v\n", os.Args[2], err)
+    return 1
+  }
+  fmt.Printf("%s, round %d, running %s\n", rec.Name, rec.Round, rec.Path)
+  fmt.Printf("State %s, seed %d\n", rec.State_hash, rec.Seed)
+  replay, err := game.ReplayAiTurn(rec)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+    return 1
+  }
+  fmt.Printf("Drew %d random numbers, did %d actions\n", len(replay.Draws), len(replay.Execs))
+  if replay.Error != "" {
+    fmt.Printf("Error: %s\n", replay.Error)
+  }
+  if err := game.CompareAiTurns(rec, replay); err != nil {
+    fmt.Fprintf(os.Stderr, "The replay diverged: %v\n", err)
+    return 1
+  }
+  fmt.Printf("The replay matched the recording.\n")
+  return 0
+}
@@ -0,0 +1,13 @@
+package base_test
+
+import (
+  "github.com/orfjackal/gospec/src/gospec"
+  "testing"
+)
+
+func TestAllSpecs(t *testing.T) {
+  r := gospec.NewRunner()
+  r.AddSpec(SaveFormatSpec)
+  r.AddSpec(SaveTreeSpec)
+  gospec.MainGoTest(r, t)
+}
@@ -0,0 +1,18 @@
+package base
+
+// In headless mode there is no window, no opengl context and no render
+// thread, so anything that would normally queue up work for the render thread
+// should check Headless() and skip it.  None of the game logic depends on
+// that work, it only matters for drawing, so a game can still be loaded and
+// simulated.  This is meant for tests and for tools like turn verification.
+var headless bool
+
+// Must be called before anything is loaded, it can't be turned off again
+// once things have been loaded without a render thread.
+func SetHeadless(h bool) {
+  headless = h
+}
+
+func Headless() bool {
+  return headless
+}
@@ -0,0 +1,200 @@
+package base
+
+import (
+  "bytes"
+  "crypto/sha1"
+  "encoding/gob"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "reflect"
+  "sort"
+  "strings"
+)
+
+// Every versioned save starts with this line, followed by a line of json
+// with the SaveHeader, followed by the payload.  Anything that doesn't start
+// with this line is from before saves were versioned and is treated as
+// version 0 with no header at all.
+const save_magic = "haunts save\n"
+
+type SaveHeader struct {
+  // What kind of data is in the payload, e.g. "game" or "player".
+  Kind string
+
+  // Version of the payload's format.  This is bumped whenever the payload
+  // changes in a way that needs a migration.
+  Version int
+
+  // Fingerprint of the types in the payload at the time it was saved, see
+  // SchemaOf().
+  Schema string
+}
+
+// A Migration turns a payload from one version into a payload for the next
+// version.
+type Migration func(payload []byte) ([]byte, error)
+
+// Describes the current format of one kind of save, and how to get to it
+// from all of the older formats.
+type SaveFormat struct {
+  Kind    string
+  Version int
+  Schema  string
+
+  // Maps from a version to the migration that turns that version into the
+  // next one.
+  migrations map[int]Migration
+}
+
+// Makes a format for the current version of the named kind of save.  types
+// should be zero values of everything that gets encoded in the payload, they
+// are used to make sure that nobody changes the payload without bumping
+// version.
+func MakeSaveFormat(kind string, version int, types ...interface{}) *SaveFormat {
+  return &SaveFormat{
+    Kind:       kind,
+    Version:    version,
+    Schema:     SchemaOf(types...),
+    migrations: make(map[int]Migration),
+  }
+}
+
+// Registers the migration from version from to version from+1.
+func (sf *SaveFormat) AddMigration(from int, m Migration) {
+  sf.migrations[from] = m
+}
+
+// Wraps payload, which must be in the current format, in a header.
+func (sf *SaveFormat) Encode(payload []byte) ([]byte, error) {
+  header, err := json.Marshal(SaveHeader{Kind: sf.Kind, Version: sf.Version, Schema: sf.Schema})
+  if err != nil {
+    return nil, err
+  }
+  buf := bytes.NewBuffer(nil)
+  buf.WriteString(save_magic)
+  buf.Write(header)
+  buf.WriteString("\n")
+  buf.Write(payload)
+  return buf.Bytes(), nil
+}
+
+// Reads the header from data, if there is one.  Data without a header is
+// returned as a version 0 payload.
+func ReadSaveHeader(data []byte) (SaveHeader, []byte, error) {
+  if !bytes.HasPrefix(data, []byte(save_magic)) {
+    return SaveHeader{}, data, nil
+  }
+  data = data[len(save_magic):]
+  end := bytes.IndexByte(data, '\n')
+  if end == -1 {
+    return SaveHeader{}, nil, errors.New("Save header was truncated.")
+  }
+  var header SaveHeader
+  err := json.Unmarshal(data[0:end], &header)
+  if err != nil {
+    return SaveHeader{}, nil, errors.New(fmt.Sprintf("Unable to read save header: %v", err))
+  }
+  return header, data[end+1:], nil
+}
+
+// Strips the header off of data and runs whatever migrations are needed to
+// bring the payload up to the current version.
+func (sf *SaveFormat) Decode(data []byte) ([]byte, error) {
+  header, payload, err := ReadSaveHeader(data)
+  if err != nil {
+    return nil, err
+  }
+  if header.Kind != "" && header.Kind != sf.Kind {
+    return nil, errors.New(fmt.Sprintf("Expected a %s save, not a %s save.", sf.Kind, header.Kind))
+  }
+  if header.Version > sf.Version {
+    return nil, errors.New(fmt.Sprintf("This %s was saved by a newer version of the game (%d > %d).", sf.Kind, header.Version, sf.Version))
+  }
+  if header.Version == sf.Version && header.Schema != sf.Schema {
+    return nil, errors.New(fmt.Sprintf("The %s format changed without its version being bumped past %d.", sf.Kind, sf.Version))
+  }
+  for version := header.Version; version < sf.Version; version++ {
+    m, ok := sf.migrations[version]
+    if !ok {
+      return nil, errors.New(fmt.Sprintf("There is no way to update a version %d %s.", version, sf.Kind))
+    }
+    payload, err = m(payload)
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("Unable to update a version %d %s: %v", version, sf.Kind, err))
+    }
+  }
+  return payload, nil
+}
+
+// Returns a fingerprint of everything gob would encode for values of the
+// specified types.  Renaming, adding, removing or changing the type of any
+// exported field, however deeply nested, changes the fingerprint.  Types that
+// do their own encoding with GobEncode, and the concrete types behind
+// interfaces, are only included by name, so they are responsible for their
+// own compatibility.
+func SchemaOf(types ...interface{}) string {
+  var parts []string
+  defs := make(map[string]string)
+  for _, t := range types {
+    parts = append(parts, describeType(reflect.TypeOf(t), defs))
+  }
+  // Struct definitions are kept separately from where they're used so that
+  // the order of fields doesn't matter, just like with gob.
+  var names []string
+  for name := range defs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    parts = append(parts, defs[name])
+  }
+  return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(parts, ";"))))
+}
+
+var gob_encoder_type = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+
+// Returns a description of t, any structs that it refers to are described
+// in defs.
+func describeType(t reflect.Type, defs map[string]string) string {
+  if t == nil {
+    return "nil"
+  }
+  if t.Implements(gob_encoder_type) || reflect.PtrTo(t).Implements(gob_encoder_type) {
+    return fmt.Sprintf("gob(%s)", t.String())
+  }
+  switch t.Kind() {
+  case reflect.Ptr:
+    return describeType(t.Elem(), defs)
+  case reflect.Slice:
+    return "[]" + describeType(t.Elem(), defs)
+  case reflect.Array:
+    return fmt.Sprintf("[%d]%s", t.Len(), describeType(t.Elem(), defs))
+  case reflect.Map:
+    return fmt.Sprintf("map[%s]%s", describeType(t.Key(), defs), describeType(t.Elem(), defs))
+  case reflect.Interface:
+    return "interface"
+  case reflect.Chan, reflect.Func:
+    // gob ignores these
+    return ""
+  case reflect.Struct:
+    name := t.String()
+    if _, ok := defs[name]; ok {
+      return name
+    }
+    defs[name] = ""
+    var fields []string
+    for i := 0; i < t.NumField(); i++ {
+      f := t.Field(i)
+      // gob skips unexported fields, including embedded unexported types
+      if f.PkgPath != "" {
+        continue
+      }
+      fields = append(fields, f.Name+" "+describeType(f.Type, defs))
+    }
+    sort.Strings(fields)
+    defs[name] = fmt.Sprintf("%s{%s}", name, strings.Join(fields, ", "))
+    return name
+  }
+  return t.Kind().String()
+}
@@ -0,0 +1,177 @@
+package base_test
+
+import (
+  "github.com/mik3cap/haunts/base"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "time"
+)
+
+type saveThing struct {
+  X    int
+  y    int
+  Next *saveThing
+  Tags map[string][]int
+}
+
+type renamedSaveThing struct {
+  Z    int
+  y    int
+  Next *saveThing
+  Tags map[string][]int
+}
+
+type saveShape interface {
+  Area() int
+}
+
+type saveSquare struct {
+  Side int
+}
+
+func (s *saveSquare) Area() int {
+  return s.Side * s.Side
+}
+
+func init() {
+  base.RegisterSaveType(&saveSquare{})
+}
+
+type saveTreeThing struct {
+  Name   string
+  Scale  float64
+  Data   []byte
+  When   time.Time
+  Shapes []saveShape
+  Ids    map[int]string
+  Next   *saveTreeThing
+  hidden int
+}
+
+func SaveFormatSpec(c gospec.Context) {
+  format := base.MakeSaveFormat("thing", 2, saveThing{})
+  format.AddMigration(0, func(payload []byte) ([]byte, error) {
+    return append(payload, '1'), nil
+  })
+  format.AddMigration(1, func(payload []byte) ([]byte, error) {
+    return append(payload, '2'), nil
+  })
+  data, err := format.Encode([]byte("payload"))
+  c.Assume(err, Equals, nil)
+
+  c.Specify("Current saves come back unchanged.", func() {
+    payload, err := format.Decode(data)
+    c.Assume(err, Equals, nil)
+    c.Expect(string(payload), Equals, "payload")
+  })
+
+  c.Specify("The header can be read on its own.", func() {
+    header, payload, err := base.ReadSaveHeader(data)
+    c.Assume(err, Equals, nil)
+    c.Expect(header.Kind, Equals, "thing")
+    c.Expect(header.Version, Equals, 2)
+    c.Expect(string(payload), Equals, "payload")
+  })
+
+  c.Specify("Saves without a header are migrated from version 0.", func() {
+    payload, err := format.Decode([]byte("old"))
+    c.Assume(err, Equals, nil)
+    c.Expect(string(payload), Equals, "old12")
+  })
+
+  c.Specify("Missing migrations are reported.", func() {
+    other := base.MakeSaveFormat("thing", 3, saveThing{})
+    _, err := other.Decode(data)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Saves from newer versions are rejected.", func() {
+    older := base.MakeSaveFormat("thing", 1, saveThing{})
+    _, err := older.Decode(data)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Saves of a different kind are rejected.", func() {
+    other := base.MakeSaveFormat("other", 2, saveThing{})
+    _, err := other.Decode(data)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Changing a field without bumping the version is caught.", func() {
+    renamed := base.MakeSaveFormat("thing", 2, renamedSaveThing{})
+    c.Expect(renamed.Schema, Not(Equals), format.Schema)
+    _, err := renamed.Decode(data)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Unexported fields don't affect the schema.", func() {
+    c.Expect(base.SchemaOf(saveThing{y: 1}), Equals, base.SchemaOf(saveThing{}))
+  })
+}
+
+func SaveTreeSpec(c gospec.Context) {
+  thing := saveTreeThing{
+    Name:   "thing",
+    Scale:  1.5,
+    Data:   []byte{1, 2, 3},
+    When:   time.Unix(1000, 0).UTC(),
+    Shapes: []saveShape{&saveSquare{Side: 3}, nil},
+    Ids:    map[int]string{2: "b", 1: "a"},
+    Next:   &saveTreeThing{Name: "next"},
+    hidden: 7,
+  }
+  data, err := base.EncodeSaveTree(thing)
+  c.Assume(err, Equals, nil)
+
+  c.Specify("Trees load back into what made them, except for unexported fields.", func() {
+    var loaded saveTreeThing
+    c.Assume(base.DecodeSaveTree(data, &loaded), Equals, nil)
+    c.Expect(loaded.Name, Equals, "thing")
+    c.Expect(loaded.Scale, Equals, 1.5)
+    c.Expect(string(loaded.Data), Equals, string([]byte{1, 2, 3}))
+    c.Expect(loaded.When.Equal(thing.When), Equals, true)
+    c.Assume(len(loaded.Shapes), Equals, 2)
+    c.Expect(loaded.Shapes[0].Area(), Equals, 9)
+    c.Expect(loaded.Shapes[1], Equals, nil)
+    c.Expect(loaded.Ids[1], Equals, "a")
+    c.Expect(loaded.Ids[2], Equals, "b")
+    c.Expect(loaded.Next.Name, Equals, "next")
+    c.Expect(loaded.Next.Next, Equals, (*saveTreeThing)(nil))
+    c.Expect(loaded.hidden, Equals, 0)
+  })
+
+  c.Specify("The same value always makes the same tree.", func() {
+    var loaded saveTreeThing
+    c.Assume(base.DecodeSaveTree(data, &loaded), Equals, nil)
+    again, err := base.EncodeSaveTree(loaded)
+    c.Assume(err, Equals, nil)
+    c.Expect(string(again), Equals, string(data))
+  })
+
+  c.Specify("Unregistered types in interfaces are reported.", func() {
+    _, err := base.EncodeSaveTree(struct{ Shape interface{} }{Shape: 3})
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Numbers that don't fit are reported.", func() {
+    var small struct{ N int8 }
+    err := base.DecodeSaveTree([]byte(`{"N": 300}`), &small)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Tree migrations can rename fields.", func() {
+    format := base.MakeSaveFormat("thing", 1, saveTreeThing{})
+    format.AddTreeMigration(0, func(tree interface{}) (interface{}, error) {
+      obj := tree.(map[string]interface{})
+      obj["Name"] = obj["Title"]
+      delete(obj, "Title")
+      return obj, nil
+    })
+    payload, err := format.Decode([]byte(`{"Title": "old", "Scale": 2}`))
+    c.Assume(err, Equals, nil)
+    var loaded saveTreeThing
+    c.Assume(base.DecodeSaveTree(payload, &loaded), Equals, nil)
+    c.Expect(loaded.Name, Equals, "old")
+    c.Expect(loaded.Scale, Equals, 2.0)
+  })
+}
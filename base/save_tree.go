@@ -0,0 +1,512 @@
+package base
+
+import (
+  "bytes"
+  "encoding"
+  "encoding/base64"
+  "encoding/gob"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "math"
+  "reflect"
+  "sort"
+  "strconv"
+)
+
+// Save payloads are encoded as a tree of plain json values so that a
+// migration can decode an old payload, rename or restructure whatever it
+// needs to, and pass it on without knowing anything about the types that
+// made it.  A tree is built from a value the same way gob would see it:
+//
+//   structs become objects of their exported fields, keyed by field name
+//   slices and arrays become lists, []byte becomes a base64 string
+//   maps with string keys become objects, other maps become lists of
+//     {"Key": ..., "Value": ...} ordered by key
+//   pointers become whatever they point at, or null
+//   interfaces become {"Type": ..., "Value": ...} where Type is the name the
+//     concrete type was registered with through RegisterSaveType()
+//
+// Types that need to control how they are saved can implement
+// SaveTreeMarshaler and SaveTreeUnmarshaler.  Otherwise types that implement
+// both halves of encoding.TextMarshaler are saved as their text and types
+// that implement both halves of gob.GobEncoder are saved as their gob in
+// base64.
+
+// Returns a value to save in place of the receiver.  The value is turned
+// into a tree like anything else.
+type SaveTreeMarshaler interface {
+  MarshalSaveTree() (interface{}, error)
+}
+
+// Loads the receiver from its tree.  decode must be given a pointer to a
+// value of the same type as was returned from MarshalSaveTree().
+type SaveTreeUnmarshaler interface {
+  UnmarshalSaveTree(decode func(interface{}) error) error
+}
+
+var save_types_by_name = make(map[string]reflect.Type)
+var save_names_by_type = make(map[reflect.Type]string)
+
+// Registers the concrete type of value so that it can be saved in interface
+// fields.  This also registers it with gob.
+func RegisterSaveType(value interface{}) {
+  gob.Register(value)
+  t := reflect.TypeOf(value)
+  name := t.String()
+  if prev, ok := save_types_by_name[name]; ok && prev != t {
+    panic(fmt.Sprintf("RegisterSaveType: registering duplicate types for %q", name))
+  }
+  save_types_by_name[name] = t
+  save_names_by_type[t] = name
+}
+
+// Encodes v as a tree, in json.
+func EncodeSaveTree(v interface{}) ([]byte, error) {
+  tree, err := saveTreeOf(reflect.ValueOf(v))
+  if err != nil {
+    return nil, err
+  }
+  return json.Marshal(tree)
+}
+
+// Decodes data, made by EncodeSaveTree(), into v, which must be a pointer.
+// Fields in data that v doesn't have are ignored, fields that v has that
+// aren't in data are left alone.
+func DecodeSaveTree(data []byte, v interface{}) error {
+  tree, err := readSaveTree(data)
+  if err != nil {
+    return err
+  }
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Ptr || rv.IsNil() {
+    return errors.New("DecodeSaveTree needs a non-nil pointer.")
+  }
+  return loadSaveTree(tree, rv.Elem())
+}
+
+// A TreeMigration is a Migration that works on a decoded payload.  tree is
+// made up of map[string]interface{}, []interface{}, json.Number, string,
+// bool and nil, and the migration returns the tree for the next version.
+type TreeMigration func(tree interface{}) (interface{}, error)
+
+// Registers the migration from version from to version from+1, for
+// payloads that were made with EncodeSaveTree().
+func (sf *SaveFormat) AddTreeMigration(from int, m TreeMigration) {
+  sf.AddMigration(from, func(payload []byte) ([]byte, error) {
+    tree, err := readSaveTree(payload)
+    if err != nil {
+      return nil, err
+    }
+    tree, err = m(tree)
+    if err != nil {
+      return nil, err
+    }
+    return json.Marshal(tree)
+  })
+}
+
+func readSaveTree(data []byte) (interface{}, error) {
+  dec := json.NewDecoder(bytes.NewBuffer(data))
+  dec.UseNumber()
+  var tree interface{}
+  err := dec.Decode(&tree)
+  return tree, err
+}
+
+var save_tree_marshaler_type = reflect.TypeOf((*SaveTreeMarshaler)(nil)).Elem()
+var save_tree_unmarshaler_type = reflect.TypeOf((*SaveTreeUnmarshaler)(nil)).Elem()
+var text_marshaler_type = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var text_unmarshaler_type = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var gob_decoder_type = reflect.TypeOf((*gob.GobDecoder)(nil)).Elem()
+
+// Returns something with all of v's methods, including the ones with
+// pointer receivers.
+func methodsOf(v reflect.Value) interface{} {
+  if v.CanAddr() {
+    return v.Addr().Interface()
+  }
+  p := reflect.New(v.Type())
+  p.Elem().Set(v)
+  return p.Interface()
+}
+
+func implements(t, iface reflect.Type) bool {
+  return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
+
+func saveTreeOf(v reflect.Value) (interface{}, error) {
+  if !v.IsValid() {
+    return nil, nil
+  }
+  t := v.Type()
+  if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface {
+    switch {
+    case implements(t, save_tree_marshaler_type) && implements(t, save_tree_unmarshaler_type):
+      value, err := methodsOf(v).(SaveTreeMarshaler).MarshalSaveTree()
+      if err != nil {
+        return nil, err
+      }
+      return saveTreeOf(reflect.ValueOf(value))
+
+    case implements(t, text_marshaler_type) && implements(t, text_unmarshaler_type):
+      text, err := methodsOf(v).(encoding.TextMarshaler).MarshalText()
+      return string(text), err
+
+    case implements(t, gob_encoder_type) && implements(t, gob_decoder_type):
+      // Always a string, even when empty, so that the decoder still gets
+      // called on load.
+      data, err := methodsOf(v).(gob.GobEncoder).GobEncode()
+      return base64.StdEncoding.EncodeToString(data), err
+    }
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    return v.Bool(), nil
+
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return v.Int(), nil
+
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    return v.Uint(), nil
+
+  case reflect.Float32, reflect.Float64:
+    f := v.Float()
+    if math.IsNaN(f) || math.IsInf(f, 0) {
+      // json can't hold these as numbers
+      return strconv.FormatFloat(f, 'g', -1, 64), nil
+    }
+    return f, nil
+
+  case reflect.String:
+    return v.String(), nil
+
+  case reflect.Ptr:
+    if v.IsNil() {
+      return nil, nil
+    }
+    return saveTreeOf(v.Elem())
+
+  case reflect.Interface:
+    if v.IsNil() {
+      return nil, nil
+    }
+    concrete := v.Elem()
+    name, ok := save_names_by_type[concrete.Type()]
+    if !ok {
+      return nil, errors.New(fmt.Sprintf("Type %v was not registered with RegisterSaveType.", concrete.Type()))
+    }
+    value, err := saveTreeOf(concrete)
+    if err != nil {
+      return nil, err
+    }
+    return map[string]interface{}{"Type": name, "Value": value}, nil
+
+  case reflect.Slice, reflect.Array:
+    if t.Kind() == reflect.Slice && v.IsNil() {
+      return nil, nil
+    }
+    if t.Elem().Kind() == reflect.Uint8 {
+      data := make([]byte, v.Len())
+      reflect.Copy(reflect.ValueOf(data), v)
+      return data, nil
+    }
+    list := make([]interface{}, v.Len())
+    for i := range list {
+      var err error
+      list[i], err = saveTreeOf(v.Index(i))
+      if err != nil {
+        return nil, err
+      }
+    }
+    return list, nil
+
+  case reflect.Map:
+    if v.IsNil() {
+      return nil, nil
+    }
+    if t.Key().Kind() == reflect.String {
+      obj := make(map[string]interface{})
+      for _, key := range v.MapKeys() {
+        value, err := saveTreeOf(v.MapIndex(key))
+        if err != nil {
+          return nil, err
+        }
+        obj[key.String()] = value
+      }
+      return obj, nil
+    }
+    var pairs []interface{}
+    var order []string
+    for _, key := range v.MapKeys() {
+      k, err := saveTreeOf(key)
+      if err != nil {
+        return nil, err
+      }
+      value, err := saveTreeOf(v.MapIndex(key))
+      if err != nil {
+        return nil, err
+      }
+      // Order by the key's json so that the same map always makes the same
+      // tree.
+      sorted, err := json.Marshal(k)
+      if err != nil {
+        return nil, err
+      }
+      pairs = append(pairs, map[string]interface{}{"Key": k, "Value": value})
+      order = append(order, string(sorted))
+    }
+    sort.Sort(pairsByKey{pairs, order})
+    return pairs, nil
+
+  case reflect.Struct:
+    obj := make(map[string]interface{})
+    for i := 0; i < t.NumField(); i++ {
+      f := t.Field(i)
+      // Same as gob, see describeType()
+      if f.PkgPath != "" || f.Type.Kind() == reflect.Chan || f.Type.Kind() == reflect.Func {
+        continue
+      }
+      value, err := saveTreeOf(v.Field(i))
+      if err != nil {
+        return nil, err
+      }
+      obj[f.Name] = value
+    }
+    return obj, nil
+  }
+  return nil, errors.New(fmt.Sprintf("Can't save a %v.", t))
+}
+
+type pairsByKey struct {
+  pairs []interface{}
+  order []string
+}
+
+func (p pairsByKey) Len() int           { return len(p.pairs) }
+func (p pairsByKey) Less(i, j int) bool { return p.order[i] < p.order[j] }
+func (p pairsByKey) Swap(i, j int) {
+  p.pairs[i], p.pairs[j] = p.pairs[j], p.pairs[i]
+  p.order[i], p.order[j] = p.order[j], p.order[i]
+}
+
+func saveTreeMismatch(tree interface{}, t reflect.Type) error {
+  return errors.New(fmt.Sprintf("Can't load a %T into a %v.", tree, t))
+}
+
+// Loads tree into v, which must be settable.
+func loadSaveTree(tree interface{}, v reflect.Value) error {
+  t := v.Type()
+  if tree == nil {
+    v.Set(reflect.Zero(t))
+    return nil
+  }
+  if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface {
+    switch {
+    case implements(t, save_tree_marshaler_type) && implements(t, save_tree_unmarshaler_type):
+      return v.Addr().Interface().(SaveTreeUnmarshaler).UnmarshalSaveTree(func(dst interface{}) error {
+        return loadSaveTree(tree, reflect.ValueOf(dst).Elem())
+      })
+
+    case implements(t, text_marshaler_type) && implements(t, text_unmarshaler_type):
+      text, ok := tree.(string)
+      if !ok {
+        return saveTreeMismatch(tree, t)
+      }
+      return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+
+    case implements(t, gob_encoder_type) && implements(t, gob_decoder_type):
+      data, err := bytesOfSaveTree(tree, t)
+      if err != nil {
+        return err
+      }
+      return v.Addr().Interface().(gob.GobDecoder).GobDecode(data)
+    }
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    b, ok := tree.(bool)
+    if !ok {
+      return saveTreeMismatch(tree, t)
+    }
+    v.SetBool(b)
+    return nil
+
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    n, ok := tree.(json.Number)
+    if !ok {
+      return saveTreeMismatch(tree, t)
+    }
+    i, err := strconv.ParseInt(string(n), 10, 64)
+    if err != nil {
+      return err
+    }
+    if v.OverflowInt(i) {
+      return errors.New(fmt.Sprintf("%d doesn't fit in a %v.", i, t))
+    }
+    v.SetInt(i)
+    return nil
+
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    n, ok := tree.(json.Number)
+    if !ok {
+      return saveTreeMismatch(tree, t)
+    }
+    u, err := strconv.ParseUint(string(n), 10, 64)
+    if err != nil {
+      return err
+    }
+    if v.OverflowUint(u) {
+      return errors.New(fmt.Sprintf("%d doesn't fit in a %v.", u, t))
+    }
+    v.SetUint(u)
+    return nil
+
+  case reflect.Float32, reflect.Float64:
+    var s string
+    switch n := tree.(type) {
+    case json.Number:
+      s = string(n)
+    case string:
+      s = n
+    default:
+      return saveTreeMismatch(tree, t)
+    }
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+      return err
+    }
+    v.SetFloat(f)
+    return nil
+
+  case reflect.String:
+    s, ok := tree.(string)
+    if !ok {
+      return saveTreeMismatch(tree, t)
+    }
+    v.SetString(s)
+    return nil
+
+  case reflect.Ptr:
+    if v.IsNil() {
+      v.Set(reflect.New(t.Elem()))
+    }
+    return loadSaveTree(tree, v.Elem())
+
+  case reflect.Interface:
+    obj, ok := tree.(map[string]interface{})
+    if !ok {
+      return saveTreeMismatch(tree, t)
+    }
+    name, _ := obj["Type"].(string)
+    concrete, ok := save_types_by_name[name]
+    if !ok {
+      return errors.New(fmt.Sprintf("Type %q was not registered with RegisterSaveType.", name))
+    }
+    if !concrete.AssignableTo(t) {
+      return errors.New(fmt.Sprintf("%v can't be stored in a %v.", concrete, t))
+    }
+    value := reflect.New(concrete).Elem()
+    if err := loadSaveTree(obj["Value"], value); err != nil {
+      return err
+    }
+    v.Set(value)
+    return nil
+
+  case reflect.Slice, reflect.Array:
+    if t.Elem().Kind() == reflect.Uint8 {
+      data, err := bytesOfSaveTree(tree, t)
+      if err != nil {
+        return err
+      }
+      if t.Kind() == reflect.Slice {
+        v.Set(reflect.MakeSlice(t, len(data), len(data)))
+      }
+      reflect.Copy(v, reflect.ValueOf(data))
+      return nil
+    }
+    list, ok := tree.([]interface{})
+    if !ok {
+      return saveTreeMismatch(tree, t)
+    }
+    if t.Kind() == reflect.Slice {
+      v.Set(reflect.MakeSlice(t, len(list), len(list)))
+    }
+    for i := 0; i < len(list) && i < v.Len(); i++ {
+      if err := loadSaveTree(list[i], v.Index(i)); err != nil {
+        return err
+      }
+    }
+    return nil
+
+  case reflect.Map:
+    v.Set(reflect.MakeMap(t))
+    if t.Key().Kind() == reflect.String {
+      obj, ok := tree.(map[string]interface{})
+      if !ok {
+        return saveTreeMismatch(tree, t)
+      }
+      for k, elem := range obj {
+        key := reflect.New(t.Key()).Elem()
+        key.SetString(k)
+        value := reflect.New(t.Elem()).Elem()
+        if err := loadSaveTree(elem, value); err != nil {
+          return err
+        }
+        v.SetMapIndex(key, value)
+      }
+      return nil
+    }
+    pairs, ok := tree.([]interface{})
+    if !ok {
+      return saveTreeMismatch(tree, t)
+    }
+    for _, pair := range pairs {
+      obj, ok := pair.(map[string]interface{})
+      if !ok {
+        return saveTreeMismatch(pair, t)
+      }
+      key := reflect.New(t.Key()).Elem()
+      if err := loadSaveTree(obj["Key"], key); err != nil {
+        return err
+      }
+      value := reflect.New(t.Elem()).Elem()
+      if err := loadSaveTree(obj["Value"], value); err != nil {
+        return err
+      }
+      v.SetMapIndex(key, value)
+    }
+    return nil
+
+  case reflect.Struct:
+    obj, ok := tree.(map[string]interface{})
+    if !ok {
+      return saveTreeMismatch(tree, t)
+    }
+    for i := 0; i < t.NumField(); i++ {
+      f := t.Field(i)
+      if f.PkgPath != "" {
+        continue
+      }
+      elem, ok := obj[f.Name]
+      if !ok {
+        continue
+      }
+      if err := loadSaveTree(elem, v.Field(i)); err != nil {
+        return errors.New(fmt.Sprintf("%s: %v", f.Name, err))
+      }
+    }
+    return nil
+  }
+  return errors.New(fmt.Sprintf("Can't load a %v.", t))
+}
+
+func bytesOfSaveTree(tree interface{}, t reflect.Type) ([]byte, error) {
+  s, ok := tree.(string)
+  if !ok {
+    return nil, saveTreeMismatch(tree, t)
+  }
+  return base64.StdEncoding.DecodeString(s)
+}
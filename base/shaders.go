@@ -74,6 +74,9 @@ func SetUniformF(shader, variable string, f float32) {
 }
 
 func InitShaders() {
+  if Headless() {
+    return
+  }
   render.Queue(func() {
     vertex_shaders = make(map[string]uint32)
     fragment_shaders = make(map[string]uint32)
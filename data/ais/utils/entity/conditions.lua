@@ -0,0 +1,7 @@
+-- Returns true if ent has the condition with the given name.
+function hasCondition(ent, condition)
+	if ent.Conditions[condition] then
+		return true
+	end
+	return false
+end
@@ -0,0 +1,15 @@
+-- Fights harder once it has taken half its Hp in damage.  ModifyBase can't
+-- see current Hp, so ModifyDamage keeps track of how much has been taken.
+function ModifyDamage(self, dmg)
+  if dmg.Hp < 0 then
+    self.State.Taken = (self.State.Taken or 0) - dmg.Hp
+  end
+  return nil
+end
+
+function ModifyBase(self, base, kind)
+  if (self.State.Taken or 0) >= base.Hp_max / 2 then
+    base.Attack = base.Attack + 2
+  end
+  return base
+end
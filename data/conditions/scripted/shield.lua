@@ -0,0 +1,16 @@
+-- Soaks up to 6 Hp of damage, then breaks.
+function ModifyDamage(self, dmg)
+  if self.State.Broken or dmg.Hp >= 0 then
+    return nil
+  end
+  local left = self.State.Left or 6
+  local soaked = math.min(left, -dmg.Hp)
+  dmg.Hp = dmg.Hp + soaked
+  self.State.Left = left - soaked
+  self.State.Broken = self.State.Left == 0
+  return dmg
+end
+
+function OnRound(self)
+  return nil, self.State.Broken
+end
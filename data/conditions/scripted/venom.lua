@@ -0,0 +1,4 @@
+-- Does 1 more damage every round that it lasts.
+function OnRound(self)
+  return { Hp = -(self.Time + 1) }
+end
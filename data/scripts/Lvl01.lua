@@ -142,7 +142,7 @@ function denizensSetup()
   -- Just like before the user gets a ui to place these entities, but this
   -- time they can place more, and this time they go into spawn points that
   -- match anything with the prefix "Servitor_".
-  points = 6
+  points = Script.MinionPoints(6)
   if Side() == "Intruders" then
     servitor_spawn = Script.GetSpawnPointsMatching("Servitors_Start1")
     while points > 0 do
@@ -276,7 +276,7 @@ function ValueForReinforce()
       end 
     end
   end
-  nValToReturn = (6 - nTotalValueOnBoard) 
+  nValToReturn = (Script.MinionPoints(6) - nTotalValueOnBoard) 
   if store.nFirstWaypointDown then
     nValToReturn = nValToReturn + 2
   end
@@ -347,6 +347,7 @@ function checkExec(exec, is_playback)
       --The intruders got to the third waypoint.  Game over, man.  Game over.
       Script.Sleep(2)
       Script.DialogBox("ui/dialog/Lvl01/Victory_Intruders.json")
+      Script.SetWinner("intruders")
       store.tension = 0.7
       Script.SetMusicParam("tension_level", 0.7)
       Script.EndGame()
@@ -357,6 +358,7 @@ function checkExec(exec, is_playback)
   if not AnyIntrudersAlive() then
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl01/Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end 
 
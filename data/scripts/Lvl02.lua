@@ -97,7 +97,7 @@ function Init(data)
   }  
 
   setLosModeToRoomsWithSpawnsMatching("denizens", "Servitors_.*")
-  placed = Script.PlaceEntities("Servitors_.*", ServitorEnts, 0, 8)
+  placed = Script.PlaceEntities("Servitors_.*", ServitorEnts, 0, Script.MinionPoints(8))
   MoveWaypoint()
   store.execs = {}  
 end
@@ -188,6 +188,7 @@ function OnAction(intruders, round, exec)
       --master is dead.  Intruders win.
       Script.Sleep(2)
       Script.DialogBox("ui/dialog/Lvl02/Lvl_02_Victory_Intruders.json")
+      Script.SetWinner("intruders")
       Script.EndGame()
     end
   end
@@ -213,6 +214,7 @@ function OnAction(intruders, round, exec)
     --game over, the denizens win.
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl02/Lvl_02_Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end
 
@@ -143,7 +143,7 @@ function denizensSetup()
   -- time they can place more, and this time they go into spawn points that
   -- match anything with the prefix "Servitor_".
   setLosModeToRoomsWithSpawnsMatching("denizens", "Servitors_Start")
-  placed = Script.PlaceEntities("Servitors_Start", ServitorEnts, 0,10)
+  placed = Script.PlaceEntities("Servitors_Start", ServitorEnts, 0, Script.MinionPoints(10))
 end
 
 function RoundStart(intruders, round)
@@ -366,6 +366,7 @@ function checkExec(exec, is_playback)
           --The intruders got to the exit with the Antidote.  Game over.
           Script.Sleep(2)
           Script.DialogBox("ui/dialog/Lvl03/Lvl_03_Victory_Intruders.json")    
+          Script.SetWinner("intruders")
           Script.EndGame()
         end
       end
@@ -385,6 +386,7 @@ function checkExec(exec, is_playback)
   if not AnyIntrudersAlive() then
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl03/Lvl_03_Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end 
 
@@ -623,7 +625,7 @@ function ValueForReinforce()
       end 
     end
   end
-  return (10 - nTotalValueOnBoard)
+  return (Script.MinionPoints(10) - nTotalValueOnBoard)
 end
 
 function pointIsInSpawns(pos, regexp)
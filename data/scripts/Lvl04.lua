@@ -118,7 +118,7 @@ function denizensSetup()
   -- time they can place more, and this time they go into spawn points that
   -- match anything with the prefix "Servitor_".
   setLosModeToRoomsWithSpawnsMatching("denizens", "Servitors_Start")
-  placed = Script.PlaceEntities("Servitors_Start", ServitorEnts, 0, 7)
+  placed = Script.PlaceEntities("Servitors_Start", ServitorEnts, 0, Script.MinionPoints(7))
 end
 
 function RoundStart(intruders, round)
@@ -273,6 +273,7 @@ function checkExec(exec, is_playback)
       --Intruders win
       Script.Sleep(2)
       Script.DialogBox("ui/dialog/Lvl04/Lvl_04_Victory_Intruders.json")
+      Script.SetWinner("intruders")
       Script.EndGame()
     end 
   end
@@ -281,6 +282,7 @@ function checkExec(exec, is_playback)
   if not AnyIntrudersAlive() then
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl04/Lvl_04_Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end 
 
@@ -621,7 +623,7 @@ function ValueForReinforce()
       end 
     end
   end
-  nAmountToReturn = (7 - nTotalValueOnBoard) + store.BeaconCount
+  nAmountToReturn = (Script.MinionPoints(7) - nTotalValueOnBoard) + store.BeaconCount
   if nAmountToReturn <= 0 then
     nAmountToReturn = 0
   end
@@ -284,6 +284,7 @@ function OnAction(intruders, round, exec)
       if exec.Target.HpCur <= 0 then
         Script.Sleep(2)
         Script.DialogBox("ui/dialog/Lvl05/Lvl_05_Victory_Intruders.json")
+        Script.SetWinner("intruders")
         Script.EndGame()
       end
     end
@@ -292,6 +293,7 @@ function OnAction(intruders, round, exec)
   if not AnyIntrudersAlive() then
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl05/Lvl_05_Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end
 
@@ -347,6 +349,7 @@ function RoundEnd(intruders, round)
       Script.DialogBox("ui/dialog/Lvl05/pass_to_denizens.json")
       if store.nTurnsRemaining == 0 and store.bSummoning then
         Script.DialogBox("ui/dialog/Lvl05/Lvl_05_Victory_Denizens.json")
+        Script.SetWinner("denizens")
         Script.EndGame()
       end
       if store.bMasterAttacked then
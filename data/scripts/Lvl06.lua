@@ -173,11 +173,13 @@ function OnAction(intruders, round, exec)
         if store.ScoreCounter >= 20 then
           Script.Sleep(2)
           Script.DialogBox("ui/dialog/Lvl06/Lvl_06_Victory_Intruders.json")
+          Script.SetWinner("intruders")
           Script.EndGame()
         end
         if store.ScoreCounter <= 0 then
           Script.Sleep(2)
           Script.DialogBox("ui/dialog/Lvl06/Lvl_06_Victory_Denizens.json")
+          Script.SetWinner("denizens")
           Script.EndGame()
         end
       end
@@ -88,11 +88,11 @@ function denizensSetup()
   }  
 
   setLosModeToRoomsWithSpawnsMatching("denizens", "Wax_Denizen_1")
-  placed = Script.PlaceEntities("Wax_Denizen_1", ServitorEnts, 0,3)
+  placed = Script.PlaceEntities("Wax_Denizen_1", ServitorEnts, 0, Script.MinionPoints(3))
   setLosModeToRoomsWithSpawnsMatching("denizens", "Wax_Denizen_2")
-  placed = Script.PlaceEntities("Wax_Denizen_2", ServitorEnts, 0,3)
+  placed = Script.PlaceEntities("Wax_Denizen_2", ServitorEnts, 0, Script.MinionPoints(3))
   setLosModeToRoomsWithSpawnsMatching("denizens", "Wax_Denizen_3")
-  placed = Script.PlaceEntities("Wax_Denizen_3", ServitorEnts, 0,4)
+  placed = Script.PlaceEntities("Wax_Denizen_3", ServitorEnts, 0, Script.MinionPoints(4))
 
   SaveDeniPositions()
   --put wax dudes in the rest of the deni spawnpoints
@@ -220,6 +220,7 @@ function OnAction(intruders, round, exec)
         --The intruders got to the exit.  Game over.
         Script.Sleep(2)
         Script.DialogBox("ui/dialog/Lvl07/Lvl_07_Victory_Intruders.json")    
+        Script.SetWinner("intruders")
         Script.EndGame()
       end
     end
@@ -229,6 +230,7 @@ function OnAction(intruders, round, exec)
   if not AnyIntrudersAlive() then
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl07/Lvl_07_Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end 
 
@@ -175,6 +175,7 @@ function OnAction(intruders, round, exec)
         --they did it.
         Script.Sleep(2)
         Script.DialogBox("ui/dialog/Lvl08/Lvl_08_Victory_Intruders.json")   
+        Script.SetWinner("intruders")
         Script.EndGame()
       end
     end
@@ -195,6 +196,7 @@ function OnAction(intruders, round, exec)
     --game over, the denizens win.
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl08/Lvl_08_Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end
 
@@ -139,6 +139,7 @@ function OnAction(intruders, round, exec)
     --game over, the denizens win.
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl09/Lvl_09_Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end
 
@@ -147,6 +148,7 @@ function OnAction(intruders, round, exec)
     --game over, the denizens win.
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl09/Lvl_09_Victory_Intruders.json")
+    Script.SetWinner("intruders")
     Script.EndGame()
   end  
 
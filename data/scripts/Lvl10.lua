@@ -61,7 +61,7 @@ function Init(data)
   end
 
   setLosModeToRoomsWithSpawnsMatching("denizens", "Servitors_.*")
-  Script.PlaceEntities("Servitors_Start", ServitorEnts, 0, 10)
+  Script.PlaceEntities("Servitors_Start", ServitorEnts, 0, Script.MinionPoints(10))
 print("poo")
   Script.FocusPos(MasterEnt().Pos)   
   store.execs = {}
@@ -156,6 +156,7 @@ function OnAction(intruders, round, exec)
   if not AnyIntrudersAlive() and store.bIntruderIntroDone then
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl10/Lvl_10_Victory_Denizens.json")
+    Script.SetWinner("denizens")
     Script.EndGame()
   end
 
@@ -163,6 +164,7 @@ function OnAction(intruders, round, exec)
   if not AnyDenizensAlive() then
     Script.Sleep(2)
     Script.DialogBox("ui/dialog/Lvl10/Lvl_10_Victory_Intruders.json")
+    Script.SetWinner("intruders")
     Script.EndGame()
   end  
 
@@ -229,6 +231,7 @@ function RoundEnd(intruders, round)
       next_store.OpCurrent = next_store.OpCurrent + next_store.occupiedPoints
       if next_store.OpCurrent >= next_store.OpGoal then
         Script.DialogBox("ui/dialog/Lvl10/Lvl_10_Victory_Intruders.json")
+        Script.SetWinner("intruders")
         Script.EndGame()
       else 
         Script.DialogBox("ui/dialog/Lvl10/Lvl_10_Score_Intruders.json", {points=next_store.occupiedPoints, countdown=(next_store.OpGoal - next_store.OpCurrent)})
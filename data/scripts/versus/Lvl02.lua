@@ -117,7 +117,7 @@ function denizensSetup()
   -- time they can place more, and this time they go into spawn points that
   -- match anything with the prefix "Servitor_".
   setLosModeToRoomsWithSpawnsMatching("denizens", "Servitors_.*")
-  placed = Script.PlaceEntities("Servitors_.*", ents, 0, 10)
+  placed = Script.PlaceEntities("Servitors_.*", ents, 0, Script.MinionPoints(10))
 end
 
 function RoundStart(intruders, round)
@@ -187,12 +187,14 @@ function OnAction(intruders, round, exec)
     if exec.Target.Name == MasterName and exec.Target.Hp <= 0 then
       --master is dead.  Intruders win.
       Script.DialogBox("ui/dialog/Lvl02/Lvl_02_Victory_Intruders.json")
+      Script.SetWinner("intruders")
     end
   end
 
   if  exec.Ent.Side.Intruder and GetDistanceBetweenEnts(exec.Ent, Relic) <= 3 and not store.bCountdownTriggered then
     --The intruders got to the relic before the master.  They win.
     Script.DialogBox("ui/dialog/Lvl02/Lvl_02_Victory_Intruders.json")
+    Script.SetWinner("intruders")
   end 
 
   if exec.Ent.Name == MasterName and GetDistanceBetweenEnts(exec.Ent, Relic) <= 3 and not store.bCountdownTriggered then
@@ -235,6 +237,7 @@ function RoundEnd(intruders, round)
   if store.nCountdown == 0 then
     --game over, the denizens win.
     Script.DialogBox("ui/dialog/Lvl02/Lvl_02_Victory_Denizens.json")
+    Script.SetWinner("denizens")
   end
 
   if store.side == "Humans" then
@@ -114,7 +114,7 @@ function denizensSetup()
   -- time they can place more, and this time they go into spawn points that
   -- match anything with the prefix "Servitor_".
   setLosModeToRoomsWithSpawnsMatching("denizens", "Servitors_Start1")
-  placed = Script.PlaceEntities("Servitors_Start1", ServitorEnts, 0,6)
+  placed = Script.PlaceEntities("Servitors_Start1", ServitorEnts, 0, Script.MinionPoints(6))
 end
 
 function RoundStart(intruders, round)
@@ -206,7 +206,7 @@ function ValueForReinforce()
       end 
     end
   end
-  nValToReturn = (6 - nTotalValueOnBoard) 
+  nValToReturn = (Script.MinionPoints(6) - nTotalValueOnBoard) 
   if store.nFirstWaypointDown then
     nValToReturn = nValToReturn + 2
   end
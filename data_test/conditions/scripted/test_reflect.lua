@@ -0,0 +1,7 @@
+function OnEvent(self, event)
+  if event.Trigger ~= "Attacked" or not event.Hit then
+    return nil
+  end
+  self.State.Reflected = (self.State.Reflected or 0) + 1
+  return { Other = { Hp = event.Damage.Hp * self.State.Reflected }, Conditions = { "Thorns" } }
+end
@@ -0,0 +1,17 @@
+function ModifyDamage(self, dmg)
+  if dmg.Hp >= 0 then
+    return nil
+  end
+  local left = self.State.Left or 3
+  local soaked = math.min(left, -dmg.Hp)
+  dmg.Hp = dmg.Hp + soaked
+  self.State.Left = left - soaked
+  return dmg
+end
+
+function ModifyBase(self, base, kind)
+  if kind == "Fire" then
+    base.Corpus = base.Corpus + 2
+  end
+  return base
+end
@@ -0,0 +1,3 @@
+function OnRound(self)
+  return { Hp = -(self.Time + 1) }, self.Time == 2
+end
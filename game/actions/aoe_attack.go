@@ -1,7 +1,6 @@
 package actions
 
 import (
-  "encoding/gob"
   "github.com/mik3cap/glop/gin"
   "github.com/mik3cap/glop/gui"
   "github.com/mik3cap/glop/util/algorithm"
@@ -39,8 +38,8 @@ func registerAoeAttacks() map[string]func() game.Action {
 
 func init() {
   game.RegisterActionMakers(registerAoeAttacks)
-  gob.Register(&AoeAttack{})
-  gob.Register(&aoeExec{})
+  base.RegisterSaveType(&AoeAttack{})
+  base.RegisterSaveType(&aoeExec{})
 }
 
 // Aoe Attacks are untargeted and instant, they are also readyable
@@ -131,6 +130,12 @@ func (a *AoeAttack) Push(L *lua.State) {
 func (a *AoeAttack) AP() int {
   return a.Ap
 }
+func (a *AoeAttack) Threat() (rng, diameter, damage int) {
+  if a.Current_ammo == 0 {
+    return 0, 0, 0
+  }
+  return a.Range, a.Diameter, a.Damage
+}
 func (a *AoeAttack) Pos() (int, int) {
   return a.tx, a.ty
 }
@@ -375,17 +380,24 @@ func (a *AoeAttack) Maintain(dt int64, g *game.Game, ae game.ActionExec) game.Ma
   }
   a.ent.Sprite().Command(a.Animation)
   for _, target := range a.targets {
-    if g.DoAttack(a.ent, target, a.Strength, a.Kind) {
+    hp := target.Stats.HpCur()
+    if roll := g.DoAttack(a.ent, target, a.Strength, a.Kind); roll.Hit {
+      var applied []string
       for _, name := range a.Conditions {
-        target.Stats.ApplyCondition(status.MakeCondition(name))
+        if target.Stats.ApplyCondition(status.MakeCondition(name)) {
+          applied = append(applied, name)
+        }
       }
       target.Stats.ApplyDamage(0, -a.Damage, a.Kind)
+      g.CombatLog().SetOutcome(roll, applied, hp-target.Stats.HpCur(), hp > 0 && target.Stats.HpCur() <= 0)
+      g.TriggerAttack(a.ent, target, true, status.Damage{Dynamic: status.Dynamic{Hp: -a.Damage}, Kind: a.Kind}, hp)
       if target.Stats.HpCur() <= 0 {
         target.Sprite().CommandN([]string{"defend", "killed"})
       } else {
         target.Sprite().CommandN([]string{"defend", "damaged"})
       }
     } else {
+      g.TriggerAttack(a.ent, target, false, status.Damage{Kind: a.Kind}, hp)
       target.Sprite().CommandN([]string{"defend", "undamaged"})
     }
   }
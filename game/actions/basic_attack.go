@@ -1,7 +1,6 @@
 package actions
 
 import (
-  "encoding/gob"
   "github.com/mik3cap/glop/gin"
   "github.com/mik3cap/glop/gui"
   "github.com/mik3cap/glop/sprite"
@@ -41,8 +40,8 @@ func registerBasicAttacks() map[string]func() game.Action {
 
 func init() {
   game.RegisterActionMakers(registerBasicAttacks)
-  gob.Register(&BasicAttack{})
-  gob.Register(&basicAttackExec{})
+  base.RegisterSaveType(&BasicAttack{})
+  base.RegisterSaveType(&basicAttackExec{})
 }
 
 // Basic Attacks are single target and instant, they are also readyable
@@ -79,6 +78,9 @@ type basicAttackTempData struct {
 
   // exec that we're currently executing
   exec *basicAttackExec
+
+  // Set if exec is a readied attack, which has already been paid for.
+  reaction bool
 }
 
 type basicAttackExec struct {
@@ -171,6 +173,12 @@ func dist(x, y, x2, y2 int) int {
 func (a *BasicAttack) AP() int {
   return a.Ap
 }
+func (a *BasicAttack) Threat() (rng, diameter, damage int) {
+  if !a.Target_enemies || a.Current_ammo == 0 {
+    return 0, 0, 0
+  }
+  return a.Range, 1, a.Damage
+}
 func (a *BasicAttack) Pos() (int, int) {
   return 0, 0
 }
@@ -235,6 +243,12 @@ func (a *BasicAttack) AiAttackTarget(ent *game.Entity, target *game.Entity) game
   }
   return a.makeExec(ent, target)
 }
+func (a *BasicAttack) ReactionExec(ent, target *game.Entity) game.ActionExec {
+  if a.Current_ammo == 0 || !a.validTarget(ent, target) {
+    return nil
+  }
+  return a.makeExec(ent, target)
+}
 func (a *BasicAttack) makeExec(ent, target *game.Entity) *basicAttackExec {
   var exec basicAttackExec
   exec.id = exec_id
@@ -283,7 +297,8 @@ func (a *BasicAttack) Maintain(dt int64, g *game.Game, ae game.ActionExec) game.
       a.target.Info.LastEntThatAttackedMe = a.ent.Id
     }
 
-    if a.Ap > a.ent.Stats.ApCur() {
+    a.reaction = g.UseReadied(a.ent, a.exec)
+    if !a.reaction && a.Ap > a.ent.Stats.ApCur() {
       base.Error().Printf("Got a basic attack that required more ap than available: %v", a.exec)
       base.Error().Printf("Ent: %s, Ap: %d", a.ent.Name, a.ent.Stats.ApCur())
       return game.Complete
@@ -300,13 +315,21 @@ func (a *BasicAttack) Maintain(dt int64, g *game.Game, ae game.ActionExec) game.
     if a.Current_ammo > 0 {
       a.Current_ammo--
     }
-    a.ent.Stats.ApplyDamage(-a.Ap, 0, status.Unspecified)
+    if !a.reaction {
+      a.ent.Stats.ApplyDamage(-a.Ap, 0, status.Unspecified)
+    }
     var defender_cmds []string
-    if g.DoAttack(a.ent, a.target, a.Strength, a.Kind) {
+    hp := a.target.Stats.HpCur()
+    if roll := g.DoAttack(a.ent, a.target, a.Strength, a.Kind); roll.Hit {
+      var applied []string
       for _, name := range a.Conditions {
-        a.target.Stats.ApplyCondition(status.MakeCondition(name))
+        if a.target.Stats.ApplyCondition(status.MakeCondition(name)) {
+          applied = append(applied, name)
+        }
       }
       a.target.Stats.ApplyDamage(0, -a.Damage, a.Kind)
+      g.CombatLog().SetOutcome(roll, applied, hp-a.target.Stats.HpCur(), hp > 0 && a.target.Stats.HpCur() <= 0)
+      g.TriggerAttack(a.ent, a.target, true, status.Damage{Dynamic: status.Dynamic{Hp: -a.Damage}, Kind: a.Kind}, hp)
       if a.target.Stats.HpCur() <= 0 {
         defender_cmds = []string{"defend", "killed"}
       } else {
@@ -314,6 +337,7 @@ func (a *BasicAttack) Maintain(dt int64, g *game.Game, ae game.ActionExec) game.
       }
       results[a.exec.id] = BasicAttackResult{Hit: true}
     } else {
+      g.TriggerAttack(a.ent, a.target, false, status.Damage{Kind: a.Kind}, hp)
       defender_cmds = []string{"defend", "undamaged"}
       results[a.exec.id] = BasicAttackResult{Hit: false}
     }
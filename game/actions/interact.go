@@ -1,7 +1,6 @@
 package actions
 
 import (
-  "encoding/gob"
   "path/filepath"
   "github.com/mik3cap/glop/gin"
   "github.com/mik3cap/glop/gui"
@@ -32,8 +31,8 @@ func registerInteracts() map[string]func() game.Action {
 
 func init() {
   game.RegisterActionMakers(registerInteracts)
-  gob.Register(&Interact{})
-  gob.Register(&interactExec{})
+  base.RegisterSaveType(&Interact{})
+  base.RegisterSaveType(&interactExec{})
 }
 
 type Interact struct {
@@ -1,7 +1,6 @@
 package actions
 
 import (
-  "encoding/gob"
   gl "github.com/chsc/gogl/gl21"
   "github.com/mik3cap/glop/gin"
   "github.com/mik3cap/glop/gui"
@@ -37,8 +36,8 @@ var path_tex *house.LosTexture
 
 func init() {
   game.RegisterActionMakers(registerMoves)
-  gob.Register(&Move{})
-  gob.Register(&moveExec{})
+  base.RegisterSaveType(&Move{})
+  base.RegisterSaveType(&moveExec{})
 }
 
 type Move struct {
@@ -59,6 +58,19 @@ type Move struct {
   path [][2]int
   cost int
 
+  // How many cells the entity has moved so far, and the room it was last
+  // in, for triggering conditions.
+  cells int
+  room  int
+
+  exec *moveExec
+
+  // len(path) when enemies last had a chance to react to the move, and the
+  // reaction that the move is waiting on, if any.  See game.Reaction.
+  checked       int
+  reaction      game.Action
+  reaction_exec game.ActionExec
+
   // Ap remaining before the ability was used
   threshold int
 }
@@ -308,6 +320,9 @@ func (a *Move) Cancel() {
   a.ent = nil
   a.path = nil
   a.calculated = false
+  a.exec = nil
+  a.reaction = nil
+  a.reaction_exec = nil
 }
 func (a *Move) Maintain(dt int64, g *game.Game, ae game.ActionExec) game.MaintenanceStatus {
   if ae != nil {
@@ -341,26 +356,81 @@ func (a *Move) Maintain(dt int64, g *game.Game, ae game.ActionExec) game.Mainten
     })
     base.Log().Printf("Path Validated: %v", exec)
     a.ent.Stats.ApplyDamage(-a.cost, 0, status.Unspecified)
+    a.cells = 0
+    a.room = a.ent.CurrentRoom()
+    a.exec = exec
+    a.checked = len(a.path)
     src := g.ToVertex(a.ent.Pos())
     graph := g.Graph(a.ent.Side(), true, nil)
     a.drawPath(a.ent, g, graph, src)
   }
+  if a.reaction != nil {
+    res := a.reaction.Maintain(dt, g, a.reaction_exec)
+    a.reaction_exec = nil
+    if res != game.Complete {
+      return game.InProgress
+    }
+    a.reaction.Cancel()
+    a.reaction = nil
+
+    // The move ends where the reaction stopped it.
+    a.exec.TruncatePath(len(a.exec.Path) - len(a.path))
+    if a.ent.Stats.HpCur() > 0 {
+      a.ent.Info.RoomsExplored[a.ent.CurrentRoom()] = true
+      a.enteredRoom(g)
+      g.TriggerConditions(a.ent, nil, status.Event{Trigger: status.TriggerMoved, Cells: a.cells})
+    }
+    a.ent = nil
+    return game.Complete
+  }
   // Do stuff
   factor := float32(math.Pow(2, a.ent.Walking_speed))
   dist := a.ent.DoAdvance(factor*float32(dt)/200, a.path[0][0], a.path[0][1])
   for dist > 0 {
+    // a.ent has just reached a.path[0], so any enemies with readied actions
+    // get a chance to use them before it goes any further.
+    if len(a.path) < a.checked {
+      a.checked = len(a.path)
+      if reaction, exec := g.TriggerReaction(a.ent); reaction != nil {
+        a.ent.DoAdvance(0, 0, 0)
+        a.reaction = reaction
+        a.reaction_exec = exec
+        return game.InProgress
+      }
+    }
     if len(a.path) == 1 {
       a.ent.DoAdvance(0, 0, 0)
       a.ent.Info.RoomsExplored[a.ent.CurrentRoom()] = true
+      a.enteredRoom(g)
+      g.TriggerConditions(a.ent, nil, status.Event{Trigger: status.TriggerMoved, Cells: a.cells})
       a.ent = nil
       return game.Complete
     }
     a.path = a.path[1:]
+    a.cells++
     a.ent.Info.RoomsExplored[a.ent.CurrentRoom()] = true
+    a.enteredRoom(g)
     dist = a.ent.DoAdvance(dist, a.path[0][0], a.path[0][1])
   }
   return game.InProgress
 }
+
+// Lets a.ent's conditions know if it has walked into a different room.
+func (a *Move) enteredRoom(g *game.Game) {
+  room := a.ent.CurrentRoom()
+  if room == a.room {
+    return
+  }
+  a.room = room
+  if room < 0 {
+    return
+  }
+  g.TriggerConditions(a.ent, nil, status.Event{
+    Trigger: status.TriggerEnteredRoom,
+    Room:    g.House.Floors[0].Rooms[room].Name,
+  })
+}
+
 func (a *Move) Interrupt() bool {
   return true
 }
@@ -1,7 +1,6 @@
 package actions
 
 import (
-  "encoding/gob"
   "github.com/mik3cap/glop/gin"
   "github.com/mik3cap/glop/gui"
   "github.com/mik3cap/haunts/base"
@@ -37,8 +36,8 @@ func registerSummonActions() map[string]func() game.Action {
 
 func init() {
   game.RegisterActionMakers(registerSummonActions)
-  gob.Register(&SummonAction{})
-  gob.Register(&summonExec{})
+  base.RegisterSaveType(&SummonAction{})
+  base.RegisterSaveType(&summonExec{})
 }
 
 // Summon Actions target a single cell, are instant, and unreadyable.
@@ -1,7 +1,6 @@
 package ai
 
 import (
-  "encoding/gob"
   "errors"
   "fmt"
   "github.com/howeyc/fsnotify"
@@ -52,6 +51,20 @@ type Ai struct {
   pause chan struct{}
   execs chan game.ActionExec
 
+  // How much longer the ai can think for this turn, see sendExec().
+  budget aiBudget
+
+  // Where randN() gets its numbers from, reseeded every turn so that the
+  // turn can be replayed.
+  rand *rand.Rand
+
+  // The turn in progress, see record.go.
+  record *game.AiTurnRecord
+
+  // Set by ReplayWith() for the next turn only.
+  replay_from *game.AiTurnRecord
+  replay_to   *game.AiTurnRecord
+
   // This exists so that we can gob this without error.  Gob doesn't like
   // gobbing things that don't have any exported fields, and since we might
   // want exported fields later we'll just have this here for now so we can
@@ -60,7 +73,7 @@ type Ai struct {
 }
 
 func init() {
-  gob.Register(&Ai{})
+  base.RegisterSaveType(&Ai{})
   game.SetAiMaker(makeAi)
 }
 
@@ -83,6 +96,7 @@ func makeAi(path string, g *game.Game, ent *game.Entity, dst_iface *game.Ai, kin
   ai_struct.terminate = make(chan struct{})
   ai_struct.execs = make(chan game.ActionExec)
   ai_struct.kind = kind
+  ai_struct.rand = rand.New(rand.NewSource(rand.Int63()))
 
   err = ai_struct.setupLuaState()
   if err != nil {
@@ -99,15 +113,35 @@ func makeAi(path string, g *game.Game, ent *game.Entity, dst_iface *game.Ai, kin
 }
 
 func (a *Ai) setupLuaState() error {
-  base.CheckPathCasing(a.path)
-  prog, err := ioutil.ReadFile(a.path)
-  if err != nil {
-    return errors.New(fmt.Sprintf("Unable to load ai file %s: %v", a.path, err))
+  var tree *BehaviourTreeDef
+  if name, ok := BehaviourTreeName(a.path); ok {
+    dir := filepath.Join(filepath.Dir(a.path), "trees")
+    var err error
+    tree, err = loadBehaviourTree(dir, name)
+    if err != nil {
+      return err
+    }
+    a.Prog = behaviour_tree_prog
+    a.watcher.Watch(dir)
+  } else {
+    base.CheckPathCasing(a.path)
+    prog, err := ioutil.ReadFile(a.path)
+    if err != nil {
+      return errors.New(fmt.Sprintf("Unable to load ai file %s: %v", a.path, err))
+    }
+    a.Prog = string(prog)
+    a.watcher.Watch(a.path)
   }
-  a.Prog = string(prog)
-  a.watcher.Watch(a.path)
   a.L = lua.NewState()
   a.L.OpenLibs()
+  a.addHelperContext()
+  a.L.Register("__ai_break", a.breakFunc())
+  a.L.DoString(ai_debugger)
+  a.addRecordContext()
+  a.L.DoString(ai_sandbox)
+  if tree != nil {
+    a.setupBehaviourTree(tree)
+  }
   switch a.kind {
   case game.EntityAi:
     a.addEntityContext()
@@ -135,6 +169,7 @@ func (a *Ai) setupLuaState() error {
     panic("Unknown ai kind")
   }
   // Add this to all contexts
+  a.addBlackboardContext()
   a.L.Register("print", func(L *lua.State) int {
     var res string
     n := L.GetTop()
@@ -155,10 +190,23 @@ func (a *Ai) setupLuaState() error {
       base.Error().Printf("Can't call randN with a value <= 0.")
       return 0
     }
-    L.PushInteger(rand.Intn(val) + 1)
+    L.PushInteger(a.draw(a.rand.Intn(val) + 1))
+    return 1
+  })
+  a.L.Register("difficulty", func(L *lua.State) int {
+    L.PushString(a.game.Difficulty.String())
     return 1
   })
-  a.L.DoString(a.Prog)
+  a.L.Register("combatLog", func(L *lua.State) int {
+    n := 0
+    if L.GetTop() > 0 && L.IsNumber(-1) {
+      n = L.ToInteger(-1)
+    }
+    game.LuaPushCombatLog(L, a.game, n)
+    return 1
+  })
+  a.callHelper("record_baseline", 0)
+  a.loadChunk(a.Prog, a.path)
   return nil
 }
 
@@ -180,7 +228,7 @@ func (a *Ai) loadUtils(dir string) {
         return nil
       }
       base.Log().Printf("Loaded lua utils file '%s'", path)
-      a.L.DoString(string(data))
+      a.loadChunk(string(data), path)
     }
     return nil
   })
@@ -230,13 +278,10 @@ func (a *Ai) masterRoutine() {
             base.Log().Printf("Eval ent: %p", a.ent)
           }
           base.Log().Printf("Evaluating lua script: %s", a.Prog)
-          // Reset the execution limit in case it was set to 0 due to a
-          // previous error
-          a.L.SetExecutionLimit(2500000)
-
-          // DoString will panic, and we can catch that, calling it manually
-          // will exit() if it fails, which we cannot catch
-          a.L.DoString("Think()")
+          // If this fails the error is reported and the ai is done for
+          // the turn, same as if Think() had returned.
+          a.think("Think")
+          a.finishRecord()
           if a.ent == nil {
             base.Log().Printf("Completed master")
           } else {
@@ -273,6 +318,7 @@ no_more_events:
     a.setupLuaState()
     base.Log().Printf("Reloaded lua state for '%p'", a)
   }
+  a.startRecord()
   a.active_set <- true
 }
 
@@ -0,0 +1,25 @@
+package ai
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  "path/filepath"
+  "testing"
+)
+
+func init() {
+  datadir, _ := filepath.Abs("../../data")
+  err := game.SetupHeadless(datadir)
+  if err != nil {
+    panic(err)
+  }
+}
+
+func TestAllSpecs(t *testing.T) {
+  r := gospec.NewRunner()
+  r.AddSpec(SandboxSpec)
+  r.AddSpec(InfluenceSpec)
+  r.AddSpec(BehaviourTreeSpec)
+  r.AddSpec(DebuggerSpec)
+  gospec.MainGoTest(r, t)
+}
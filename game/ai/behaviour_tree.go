@@ -0,0 +1,289 @@
+package ai
+
+import (
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  lua "github.com/xenith-studios/golua"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+)
+
+// Behaviour trees let an ai be put together in a data file out of the
+// lua functions that ais already use, rather than each ai having its own
+// Think().  Trees are loaded from data/ais/trees, and an ai path whose last
+// element is "bt:<name>" runs the tree with that name, e.g.
+//   "Ai_path": "ais/bt:female_cultist"
+// The tree's functions are looked up in the same lua state that a script
+// would have had, so the utils for the ai's kind are all available.
+
+const behaviour_tree_prefix = "bt:"
+
+// If path selects a behaviour tree returns its name and true.
+func BehaviourTreeName(path string) (string, bool) {
+  name := filepath.Base(path)
+  if !strings.HasPrefix(name, behaviour_tree_prefix) {
+    return "", false
+  }
+  return strings.TrimPrefix(name, behaviour_tree_prefix), true
+}
+
+type BehaviourTreeDef struct {
+  Name string
+  Root BehaviourNode
+}
+
+// Every node either succeeds or fails each time it runs.
+//   Sequence:  Runs its Children in order until one fails, succeeds if none
+//              of them failed.
+//   Selector:  Runs its Children in order until one succeeds, fails if none
+//              of them succeeded.
+//   Condition: Calls Func, succeeds if it returned anything other than nil
+//              or false.  Conditions should only look at the game.
+//   Action:    The same as a Condition, but Func is expected to do something.
+//   Invert:    Runs Child and succeeds if it failed.
+//   Succeed:   Runs Child and succeeds no matter what, useful for Actions
+//              whose Func doesn't return anything.
+//   Repeat:    Runs Child until it fails, or Times times if Times is set, and
+//              succeeds if Child succeeded at least once.
+type BehaviourNode struct {
+  Type string
+
+  Children []BehaviourNode
+  Child    *BehaviourNode
+
+  // Name of the lua function that a Condition or Action calls, this can be
+  // a global function like "pursue" or one in a table like "Do.Move".
+  Func string
+
+  // Numbers, strings and booleans passed to Func.  A string that starts with
+  // '$' is replaced with the variable of the same name, e.g. "$target".
+  Args []interface{}
+
+  // If set, the first value returned by Func is saved in this variable.
+  // Variables only last for one turn.
+  Store string
+
+  Times int
+}
+
+func (n *BehaviourNode) check() error {
+  switch n.Type {
+  case "Sequence", "Selector":
+    if len(n.Children) == 0 {
+      return errors.New(fmt.Sprintf("%s nodes need Children.", n.Type))
+    }
+    for i := range n.Children {
+      if err := n.Children[i].check(); err != nil {
+        return err
+      }
+    }
+
+  case "Condition", "Action":
+    if n.Func == "" {
+      return errors.New(fmt.Sprintf("%s nodes need a Func.", n.Type))
+    }
+    for _, arg := range n.Args {
+      switch arg.(type) {
+      case float64, string, bool:
+      default:
+        return errors.New(fmt.Sprintf("Args to '%s' must be numbers, strings or booleans.", n.Func))
+      }
+    }
+
+  case "Invert", "Succeed", "Repeat":
+    if n.Child == nil {
+      return errors.New(fmt.Sprintf("%s nodes need a Child.", n.Type))
+    }
+    if n.Type == "Repeat" && n.Times < 0 {
+      return errors.New("Repeat nodes can't have a negative number of Times.")
+    }
+    return n.Child.check()
+
+  default:
+    return errors.New(fmt.Sprintf("Unknown behaviour tree node type '%s'.", n.Type))
+  }
+  return nil
+}
+
+// Pushes n onto the stack as a table with the same fields.
+func (n *BehaviourNode) push(L *lua.State) {
+  L.NewTable()
+  L.PushString("Type")
+  L.PushString(n.Type)
+  L.SetTable(-3)
+  if len(n.Children) > 0 {
+    L.PushString("Children")
+    L.NewTable()
+    for i := range n.Children {
+      L.PushInteger(i + 1)
+      n.Children[i].push(L)
+      L.SetTable(-3)
+    }
+    L.SetTable(-3)
+  }
+  if n.Child != nil {
+    L.PushString("Child")
+    n.Child.push(L)
+    L.SetTable(-3)
+  }
+  if n.Func != "" {
+    L.PushString("Func")
+    L.PushString(n.Func)
+    L.SetTable(-3)
+  }
+  L.PushString("Args")
+  L.NewTable()
+  for i, arg := range n.Args {
+    L.PushInteger(i + 1)
+    switch v := arg.(type) {
+    case float64:
+      L.PushNumber(v)
+    case string:
+      L.PushString(v)
+    case bool:
+      L.PushBoolean(v)
+    }
+    L.SetTable(-3)
+  }
+  L.SetTable(-3)
+  if n.Store != "" {
+    L.PushString("Store")
+    L.PushString(n.Store)
+    L.SetTable(-3)
+  }
+  L.PushString("Times")
+  L.PushInteger(n.Times)
+  L.SetTable(-3)
+}
+
+var behaviour_trees struct {
+  sync.Mutex
+  registry map[string]*BehaviourTreeDef
+}
+
+// Reloads every tree in dir and returns the one called name.  Trees are
+// reloaded each time an ai is made so that they can be edited while the
+// game is running, the same as scripts.
+func loadBehaviourTree(dir, name string) (*BehaviourTreeDef, error) {
+  behaviour_trees.Lock()
+  defer behaviour_trees.Unlock()
+  behaviour_trees.registry = make(map[string]*BehaviourTreeDef)
+  base.RemoveRegistry("ai-behaviour_trees")
+  base.RegisterRegistry("ai-behaviour_trees", behaviour_trees.registry)
+  if _, err := os.Stat(dir); err == nil {
+    base.RegisterAllObjectsInDir("ai-behaviour_trees", dir, ".json", "json")
+  }
+  tree, ok := behaviour_trees.registry[name]
+  if !ok {
+    return nil, errors.New(fmt.Sprintf("No behaviour tree named '%s' in %s.", name, dir))
+  }
+  if err := tree.Root.check(); err != nil {
+    return nil, errors.New(fmt.Sprintf("Behaviour tree '%s': %v", name, err))
+  }
+  return tree, nil
+}
+
+// The Think() used by ais that run a behaviour tree.
+const behaviour_tree_prog = `
+function Think()
+  __bt_run(__bt_tree, {})
+end
+`
+
+// Runs behaviour trees, see BehaviourNode for what each node does.  Func
+// is looked up when the node runs, so trees can use functions from utils
+// files that are loaded after this.
+const ai_behaviour_tree = `
+do
+  local gmatch = string.gmatch
+  local sub = string.sub
+  local unpack = unpack
+  local type = type
+  local error = error
+  local G = _G
+
+  local function lookup(name)
+    local v = G
+    for part in gmatch(name, "[^%.]+") do
+      if type(v) ~= "table" then
+        return nil
+      end
+      v = v[part]
+    end
+    return v
+  end
+
+  local function call(node, vars)
+    local f = lookup(node.Func)
+    if type(f) ~= "function" then
+      error(node.Func .. " is not a function.", 0)
+    end
+    local args = {}
+    for i = 1, #node.Args do
+      local arg = node.Args[i]
+      if type(arg) == "string" and sub(arg, 1, 1) == "$" then
+        args[i] = vars[sub(arg, 2)]
+      else
+        args[i] = arg
+      end
+    end
+    local res = f(unpack(args, 1, #node.Args))
+    if node.Store then
+      vars[node.Store] = res
+    end
+    return res ~= nil and res ~= false
+  end
+
+  local run
+  run = function(node, vars)
+    local t = node.Type
+    if t == "Sequence" then
+      for i = 1, #node.Children do
+        if not run(node.Children[i], vars) then
+          return false
+        end
+      end
+      return true
+    elseif t == "Selector" then
+      for i = 1, #node.Children do
+        if run(node.Children[i], vars) then
+          return true
+        end
+      end
+      return false
+    elseif t == "Condition" or t == "Action" then
+      return call(node, vars)
+    elseif t == "Invert" then
+      return not run(node.Child, vars)
+    elseif t == "Succeed" then
+      run(node.Child, vars)
+      return true
+    elseif t == "Repeat" then
+      local count = 0
+      while node.Times == 0 or count < node.Times do
+        if not run(node.Child, vars) then
+          break
+        end
+        count = count + 1
+      end
+      return count > 0
+    end
+    error("Unknown behaviour tree node type " .. t .. ".", 0)
+  end
+
+  function __bt_run(tree, vars)
+    run(tree, vars)
+  end
+end
+`
+
+// Loads the runtime and tree into a's lua state, behaviour_tree_prog is what
+// actually runs it.
+func (a *Ai) setupBehaviourTree(tree *BehaviourTreeDef) {
+  a.L.DoString(ai_behaviour_tree)
+  tree.Root.push(a.L)
+  a.L.SetGlobal("__bt_tree")
+}
@@ -0,0 +1,197 @@
+package ai
+
+import (
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  lua "github.com/xenith-studios/golua"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+)
+
+// Lua functions for trees to call.  Each one adds its argument to log so
+// that specs can see what ran and in what order.
+const behaviour_tree_test_funcs = `
+log = ""
+function yes(s)
+  log = log .. s
+  return true
+end
+function no(s)
+  log = log .. s
+  return false
+end
+function nothing(s)
+  log = log .. s
+end
+count = 0
+function upTo(n)
+  count = count + 1
+  log = log .. count
+  return count <= n
+end
+function pick()
+  return "picked"
+end
+`
+
+func leaf(kind, f string, args ...interface{}) BehaviourNode {
+  return BehaviourNode{Type: kind, Func: f, Args: args}
+}
+
+func branch(kind string, children ...BehaviourNode) BehaviourNode {
+  return BehaviourNode{Type: kind, Children: children}
+}
+
+func decorate(kind string, child BehaviourNode) BehaviourNode {
+  return BehaviourNode{Type: kind, Child: &child}
+}
+
+// Runs root once and returns whether lua ran it without an error, along
+// with the log that the test functions left.
+func runBehaviourTree(root BehaviourNode) (bool, string) {
+  a := &Ai{path: "bt:test", kind: game.EntityAi}
+  a.L = lua.NewState()
+  defer a.L.Close()
+  a.L.OpenLibs()
+  a.L.DoString(behaviour_tree_test_funcs)
+  a.setupBehaviourTree(&BehaviourTreeDef{Name: "test", Root: root})
+  ok := a.L.DoString("__bt_run(__bt_tree, {})")
+  a.L.GetGlobal("log")
+  return ok, a.L.ToString(-1)
+}
+
+func BehaviourTreeSpec(c gospec.Context) {
+  c.Specify("Only ai paths starting with bt: select trees.", func() {
+    name, ok := BehaviourTreeName("ais/bt:female_cultist")
+    c.Expect(ok, Equals, true)
+    c.Expect(name, Equals, "female_cultist")
+    _, ok = BehaviourTreeName("ais/female_cultist.lua")
+    c.Expect(ok, Equals, false)
+  })
+
+  c.Specify("Nodes are checked before they are run.", func() {
+    good := leaf("Action", "yes", "a")
+    c.Expect(good.check(), Equals, nil)
+    bad := []BehaviourNode{
+      {Type: "Parallel", Children: []BehaviourNode{good}},
+      {Type: "Sequence"},
+      {Type: "Selector"},
+      {Type: "Condition"},
+      leaf("Action", "yes", map[string]interface{}{}),
+      {Type: "Invert"},
+      {Type: "Succeed"},
+      {Type: "Repeat"},
+      {Type: "Repeat", Child: &good, Times: -1},
+      branch("Sequence", good, branch("Selector", good, BehaviourNode{Type: "Action"})),
+      decorate("Invert", BehaviourNode{Type: "Sequence"}),
+    }
+    for _, node := range bad {
+      c.Expect(node.check(), Not(Equals), nil)
+    }
+  })
+
+  c.Specify("Trees are loaded by name.", func() {
+    dir := filepath.Join(base.GetDataDir(), "ais", "trees")
+    tree, err := loadBehaviourTree(dir, "female_cultist")
+    c.Assume(err, Equals, nil)
+    c.Expect(tree.Root.Type, Equals, "Sequence")
+    c.Expect(len(tree.Root.Children), Equals, 2)
+    _, err = loadBehaviourTree(dir, "not a tree")
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Trees that don't check out aren't loaded.", func() {
+    dir, err := ioutil.TempDir("", "trees")
+    c.Assume(err, Equals, nil)
+    defer os.RemoveAll(dir)
+    trees := map[string]string{
+      "good":        `{"Name": "good", "Root": {"Type": "Action", "Func": "yes"}}`,
+      "bad_type":    `{"Name": "bad_type", "Root": {"Type": "Parallel", "Children": [{"Type": "Action", "Func": "yes"}]}}`,
+      "no_children": `{"Name": "no_children", "Root": {"Type": "Sequence", "Children": []}}`,
+      "no_child":    `{"Name": "no_child", "Root": {"Type": "Sequence", "Children": [{"Type": "Succeed"}]}}`,
+    }
+    for name, tree := range trees {
+      c.Assume(ioutil.WriteFile(filepath.Join(dir, name+".json"), []byte(tree), 0644), Equals, nil)
+    }
+    _, err = loadBehaviourTree(dir, "good")
+    c.Expect(err, Equals, nil)
+    for _, name := range []string{"bad_type", "no_children", "no_child"} {
+      _, err = loadBehaviourTree(dir, name)
+      c.Expect(err, Not(Equals), nil)
+    }
+  })
+
+  c.Specify("Sequences stop at the first child that fails.", func() {
+    ok, log := runBehaviourTree(branch("Sequence",
+      leaf("Condition", "yes", "a"),
+      leaf("Condition", "no", "b"),
+      leaf("Action", "yes", "c")))
+    c.Expect(ok, Equals, true)
+    c.Expect(log, Equals, "ab")
+  })
+
+  c.Specify("Selectors stop at the first child that succeeds.", func() {
+    ok, log := runBehaviourTree(branch("Selector",
+      leaf("Condition", "no", "a"),
+      leaf("Condition", "yes", "b"),
+      leaf("Action", "yes", "c")))
+    c.Expect(ok, Equals, true)
+    c.Expect(log, Equals, "ab")
+  })
+
+  c.Specify("Failure propagates up through nested nodes.", func() {
+    ok, log := runBehaviourTree(branch("Selector",
+      branch("Sequence",
+        leaf("Condition", "yes", "a"),
+        branch("Selector", leaf("Condition", "no", "b"), leaf("Condition", "nothing", "c")),
+        leaf("Action", "yes", "d")),
+      leaf("Action", "yes", "e")))
+    c.Expect(ok, Equals, true)
+    c.Expect(log, Equals, "abce")
+  })
+
+  c.Specify("Invert and Succeed change what their child returns.", func() {
+    ok, log := runBehaviourTree(branch("Sequence",
+      decorate("Invert", leaf("Condition", "no", "a")),
+      decorate("Succeed", leaf("Action", "nothing", "b")),
+      decorate("Invert", leaf("Condition", "yes", "c")),
+      leaf("Action", "yes", "d")))
+    c.Expect(ok, Equals, true)
+    c.Expect(log, Equals, "abc")
+  })
+
+  c.Specify("Repeat runs its child until it fails.", func() {
+    ok, log := runBehaviourTree(branch("Sequence",
+      decorate("Repeat", leaf("Condition", "upTo", 3.0)),
+      leaf("Action", "yes", "a")))
+    c.Expect(ok, Equals, true)
+    c.Expect(log, Equals, "1234a")
+  })
+
+  c.Specify("Repeat stops after Times, and fails if its child never succeeded.", func() {
+    limited := decorate("Repeat", leaf("Condition", "upTo", 10.0))
+    limited.Times = 2
+    ok, log := runBehaviourTree(branch("Sequence",
+      limited,
+      decorate("Invert", decorate("Repeat", leaf("Condition", "no", "a"))),
+      leaf("Action", "yes", "b")))
+    c.Expect(ok, Equals, true)
+    c.Expect(log, Equals, "12ab")
+  })
+
+  c.Specify("Stored values can be passed to later nodes.", func() {
+    store := leaf("Condition", "pick")
+    store.Store = "target"
+    ok, log := runBehaviourTree(branch("Sequence", store, leaf("Action", "yes", "$target")))
+    c.Expect(ok, Equals, true)
+    c.Expect(log, Equals, "picked")
+  })
+
+  c.Specify("Missing functions are errors.", func() {
+    ok, _ := runBehaviourTree(leaf("Action", "Do.Nothing"))
+    c.Expect(ok, Equals, false)
+  })
+}
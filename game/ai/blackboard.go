@@ -0,0 +1,274 @@
+package ai
+
+import (
+  "fmt"
+  "github.com/mik3cap/haunts/game"
+  lua "github.com/xenith-studios/golua"
+)
+
+// Every kind of ai gets a Team table for reading and writing its side's
+// game.Blackboard.
+func (a *Ai) addBlackboardContext() {
+  a.L.NewTable()
+  game.LuaPushSmartFunctionTable(a.L, game.FunctionTable{
+    "Get":        func() { a.L.PushGoFunctionAsCFunction(teamGetFunc(a)) },
+    "Set":        func() { a.L.PushGoFunctionAsCFunction(teamSetFunc(a)) },
+    "Keys":       func() { a.L.PushGoFunctionAsCFunction(teamKeysFunc(a)) },
+    "SetRole":    func() { a.L.PushGoFunctionAsCFunction(teamSetRoleFunc(a)) },
+    "Role":       func() { a.L.PushGoFunctionAsCFunction(teamRoleFunc(a)) },
+    "WithRole":   func() { a.L.PushGoFunctionAsCFunction(teamWithRoleFunc(a)) },
+    "Reserve":    func() { a.L.PushGoFunctionAsCFunction(teamReserveFunc(a)) },
+    "Unreserve":  func() { a.L.PushGoFunctionAsCFunction(teamUnreserveFunc(a)) },
+    "ReservedBy": func() { a.L.PushGoFunctionAsCFunction(teamReservedByFunc(a)) },
+  })
+  a.L.SetMetaTable(-2)
+  a.L.SetGlobal("Team")
+}
+
+// Returns the side that this ai plays for.
+func (a *Ai) side() game.Side {
+  switch a.kind {
+  case game.EntityAi:
+    return a.ent.Side()
+  case game.IntrudersAi:
+    return game.SideExplorers
+  }
+  return game.SideHaunt
+}
+
+func (a *Ai) blackboard() *game.Blackboard {
+  return a.game.Blackboard(a.side())
+}
+
+// Returns the entity at index if it is on this ai's side, otherwise raises
+// an error and returns nil.
+func teamEntity(a *Ai, L *lua.State, name string, index int) *game.Entity {
+  ent := game.LuaToEntity(L, a.game, index)
+  if ent == nil {
+    game.LuaDoError(L, fmt.Sprintf("Tried to Team.%s on an invalid entity.", name))
+    return nil
+  }
+  if ent.Side() != a.side() {
+    game.LuaDoError(L, fmt.Sprintf("Tried to Team.%s on an entity on the other side.", name))
+    return nil
+  }
+  return ent
+}
+
+func luaPushBlackboardValue(L *lua.State, g *game.Game, v game.BlackboardValue) {
+  switch v.Kind {
+  case game.BlackboardNumber:
+    L.PushNumber(v.Number)
+  case game.BlackboardString:
+    L.PushString(v.String)
+  case game.BlackboardBoolean:
+    L.PushBoolean(v.Boolean)
+  case game.BlackboardEntity:
+    ent := g.EntityById(v.Entity)
+    if ent == nil {
+      L.PushNil()
+    } else {
+      game.LuaPushEntity(L, ent)
+    }
+  case game.BlackboardPoint:
+    game.LuaPushPoint(L, v.X, v.Y)
+  default:
+    L.PushNil()
+  }
+}
+
+func luaToBlackboardValue(L *lua.State, g *game.Game, index int) (game.BlackboardValue, bool) {
+  var v game.BlackboardValue
+  switch {
+  case L.IsBoolean(index):
+    v.Kind = game.BlackboardBoolean
+    v.Boolean = L.ToBoolean(index)
+  case L.IsNumber(index):
+    v.Kind = game.BlackboardNumber
+    v.Number = L.ToNumber(index)
+  case L.IsString(index):
+    v.Kind = game.BlackboardString
+    v.String = L.ToString(index)
+  case L.IsTable(index) && game.LuaIsEntity(L, index):
+    ent := game.LuaToEntity(L, g, index)
+    if ent == nil {
+      return v, false
+    }
+    v.Kind = game.BlackboardEntity
+    v.Entity = ent.Id
+  case L.IsTable(index):
+    L.PushString("X")
+    L.GetTable(index - 1)
+    has_x := L.IsNumber(-1)
+    L.Pop(1)
+    L.PushString("Y")
+    L.GetTable(index - 1)
+    has_y := L.IsNumber(-1)
+    L.Pop(1)
+    if !has_x || !has_y {
+      return v, false
+    }
+    v.Kind = game.BlackboardPoint
+    v.X, v.Y = game.LuaToPoint(L, index)
+  default:
+    return v, false
+  }
+  return v, true
+}
+
+func teamGetFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "Get", game.LuaString) {
+      return 0
+    }
+    v, ok := a.blackboard().Get(L.ToString(-1))
+    if !ok {
+      L.PushNil()
+      return 1
+    }
+    luaPushBlackboardValue(L, a.game, v)
+    return 1
+  }
+}
+
+func teamSetFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "Set", game.LuaString, game.LuaAnything) {
+      return 0
+    }
+    key := L.ToString(-2)
+    if L.IsNil(-1) {
+      a.blackboard().Clear(key)
+      return 0
+    }
+    v, ok := luaToBlackboardValue(L, a.game, -1)
+    if !ok {
+      game.LuaDoError(L, fmt.Sprintf("Team.Set('%s', ...) needs a number, string, boolean, Entity or Point.", key))
+      return 0
+    }
+    a.blackboard().Set(key, v)
+    return 0
+  }
+}
+
+func teamKeysFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "Keys") {
+      return 0
+    }
+    L.NewTable()
+    for i, key := range a.blackboard().Keys() {
+      L.PushInteger(i + 1)
+      L.PushString(key)
+      L.SetTable(-3)
+    }
+    return 1
+  }
+}
+
+func teamSetRoleFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "SetRole", game.LuaEntity, game.LuaString) {
+      return 0
+    }
+    ent := teamEntity(a, L, "SetRole", -2)
+    if ent == nil {
+      return 0
+    }
+    a.blackboard().SetRole(ent.Id, L.ToString(-1))
+    return 0
+  }
+}
+
+func teamRoleFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "Role", game.LuaEntity) {
+      return 0
+    }
+    ent := teamEntity(a, L, "Role", -1)
+    if ent == nil {
+      return 0
+    }
+    L.PushString(a.blackboard().Role(ent.Id))
+    return 1
+  }
+}
+
+func teamWithRoleFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "WithRole", game.LuaString) {
+      return 0
+    }
+    ids := a.blackboard().WithRole(L.ToString(-1))
+    L.NewTable()
+    count := 0
+    for _, id := range ids {
+      ent := a.game.EntityById(id)
+      if ent == nil {
+        continue
+      }
+      count++
+      L.PushInteger(count)
+      game.LuaPushEntity(L, ent)
+      L.SetTable(-3)
+    }
+    return 1
+  }
+}
+
+func teamReserveFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "Reserve", game.LuaEntity, game.LuaEntity) {
+      return 0
+    }
+    by := teamEntity(a, L, "Reserve", -2)
+    if by == nil {
+      return 0
+    }
+    target := game.LuaToEntity(L, a.game, -1)
+    if target == nil {
+      game.LuaDoError(L, "Tried to Team.Reserve an invalid entity.")
+      return 0
+    }
+    L.PushBoolean(a.blackboard().Reserve(by.Id, target.Id))
+    return 1
+  }
+}
+
+func teamUnreserveFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "Unreserve", game.LuaEntity, game.LuaEntity) {
+      return 0
+    }
+    by := teamEntity(a, L, "Unreserve", -2)
+    if by == nil {
+      return 0
+    }
+    target := game.LuaToEntity(L, a.game, -1)
+    if target == nil {
+      return 0
+    }
+    a.blackboard().Unreserve(by.Id, target.Id)
+    return 0
+  }
+}
+
+func teamReservedByFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "ReservedBy", game.LuaEntity) {
+      return 0
+    }
+    target := game.LuaToEntity(L, a.game, -1)
+    if target == nil {
+      L.PushNil()
+      return 1
+    }
+    holder := a.game.EntityById(a.blackboard().ReservedBy(target.Id))
+    if holder == nil {
+      L.PushNil()
+      return 1
+    }
+    game.LuaPushEntity(L, holder)
+    return 1
+  }
+}
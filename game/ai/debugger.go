@@ -0,0 +1,276 @@
+package ai
+
+import (
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game"
+  lua "github.com/xenith-studios/golua"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+// Lua half of the debugger.  This is part of the sandbox so that it can hold
+// onto the debug library after the ai's access to it is removed.  When
+// debugging is on the sandbox's call helper runs with hook() set as a line
+// hook, which replaces the instruction limit, and hook() calls into Go
+// whenever the ai needs to stop.  The sandbox takes __ai_debug_start() and
+// __ai_debug_stop() for itself before any ai code is loaded.
+const ai_debugger = `
+do
+  local sethook = debug.sethook
+  local getinfo = debug.getinfo
+  local getlocal = debug.getlocal
+  local sub = string.sub
+  local brk = __ai_break
+  local debugging = false
+  local breakpoints = nil
+
+  -- nil, or the command that the ai was last resumed with.
+  local mode = nil
+  local depth = 0
+
+  local function stackDepth()
+    local d = 1
+    while getinfo(d + 1, "l") do
+      d = d + 1
+    end
+    return d
+  end
+
+  local function hook(event, line)
+    local source = getinfo(2, "S").source
+    -- Only ai files are loaded with names, this skips the debugger and the
+    -- sandbox themselves.
+    if sub(source, 1, 1) ~= "@" then
+      return
+    end
+    local stop = false
+    if mode == "step" then
+      stop = true
+    elseif mode == "next" then
+      stop = stackDepth() <= depth
+    elseif mode == "out" then
+      stop = stackDepth() < depth
+    end
+    if not stop then
+      local lines = breakpoints[source]
+      stop = lines ~= nil and lines[line] == true
+    end
+    if not stop then
+      return
+    end
+    local locals = {}
+    local i = 1
+    while true do
+      local name, value = getlocal(2, i)
+      if not name then
+        break
+      end
+      if sub(name, 1, 1) ~= "(" then
+        locals[#locals + 1] = {name, value}
+      end
+      i = i + 1
+    end
+    local d = stackDepth()
+    mode = brk(source, line, getinfo(2, "n").name or "?", locals)
+    depth = d
+    if mode == "continue" then
+      mode = nil
+    end
+  end
+
+  function __ai_debug_start(on, lines)
+    mode = nil
+    debugging = on
+    breakpoints = lines
+    if debugging then
+      sethook(hook, "l")
+    end
+  end
+
+  function __ai_debug_stop()
+    if debugging then
+      sethook()
+    end
+  end
+
+  function Breakpoint()
+    mode = "step"
+  end
+end
+__ai_break = nil
+`
+
+// Returns the name that an ai's trace is kept under.
+func (a *Ai) traceName() string {
+  if a.ent == nil {
+    return a.kind.String()
+  }
+  return fmt.Sprintf("%s (%d)", a.ent.Name, a.ent.Id)
+}
+
+// Returns a short description of the lua value at index, tables are
+// described up to depth levels deep.
+func describeLuaValue(L *lua.State, index int, depth int) string {
+  if index < 0 {
+    index = L.GetTop() + index + 1
+  }
+  switch {
+  case L.IsNil(index):
+    return "nil"
+  case L.IsBoolean(index):
+    if L.ToBoolean(index) {
+      return "true"
+    }
+    return "false"
+  case L.IsNumber(index):
+    // Not ToString, which would turn keys into strings in the middle of
+    // iterating over a table.
+    return fmt.Sprintf("%g", L.ToNumber(index))
+  case L.IsString(index):
+    return fmt.Sprintf("%q", L.ToString(index))
+  case L.IsTable(index):
+    // Entities and doors are tables whose interesting fields are all
+    // generated by their metatables, so just name them.
+    L.PushString("type")
+    L.GetTable(index)
+    kind := ""
+    if L.IsString(-1) {
+      kind = L.ToString(-1)
+    }
+    L.Pop(1)
+    if kind == "Entity" {
+      L.PushString("Name")
+      L.GetTable(index)
+      name := L.ToString(-1)
+      L.Pop(1)
+      return fmt.Sprintf("Entity(%s)", name)
+    }
+    if depth <= 0 {
+      return "{...}"
+    }
+    var parts []string
+    L.PushNil()
+    for L.Next(index) != 0 {
+      parts = append(parts, fmt.Sprintf("%s=%s", describeLuaValue(L, -2, 0), describeLuaValue(L, -1, depth-1)))
+      L.Pop(1)
+      if len(parts) == 10 {
+        parts = append(parts, "...")
+        L.Pop(1)
+        break
+      }
+    }
+    return "{" + strings.Join(parts, ", ") + "}"
+  }
+  return L.ToString(index)
+}
+
+// Wraps one of the Do* functions so that every call to it, along with its
+// arguments and results, is added to the ai's trace.
+func (a *Ai) traced(name string, f lua.GoFunction) lua.GoFunction {
+  return func(L *lua.State) int {
+    var entry game.AiTraceEntry
+    entry.Time = time.Now()
+    entry.Round = (a.game.Turn + 1) / 2
+    entry.Call = name
+    for i := 1; i <= L.GetTop(); i++ {
+      entry.Args = append(entry.Args, describeLuaValue(L, i, 2))
+    }
+    n := f(L)
+    top := L.GetTop()
+    for i := top - n + 1; i <= top; i++ {
+      entry.Result = append(entry.Result, describeLuaValue(L, i, 2))
+    }
+    if n == 0 {
+      entry.Result = []string{"error"}
+    }
+    game.RecordAiCall(a.traceName(), entry)
+    return n
+  }
+}
+
+// Returns what the ai would see if it looked at Me, or nothing if the ai
+// isn't an entity ai.
+func (a *Ai) entityContext() []game.AiVar {
+  ent := a.ent
+  if ent == nil {
+    return nil
+  }
+  x, y := ent.Pos()
+  var names []string
+  for _, action := range ent.Actions {
+    names = append(names, action.String())
+  }
+  conditions := ent.Stats.ConditionNames()
+  sort.Strings(conditions)
+  return []game.AiVar{
+    {Name: "Name", Value: ent.Name},
+    {Name: "id", Value: fmt.Sprintf("%d", ent.Id)},
+    {Name: "Pos", Value: fmt.Sprintf("(%d, %d)", x, y)},
+    {Name: "HpCur", Value: fmt.Sprintf("%d / %d", ent.Stats.HpCur(), ent.Stats.HpMax())},
+    {Name: "ApCur", Value: fmt.Sprintf("%d / %d", ent.Stats.ApCur(), ent.Stats.ApMax())},
+    {Name: "Actions", Value: strings.Join(names, ", ")},
+    {Name: "Conditions", Value: strings.Join(conditions, ", ")},
+  }
+}
+
+// Returns the lua function that the debugger hook calls when the ai stops.
+// It blocks until the ai is resumed, and the ai's time budget doesn't run
+// while it waits.
+func (a *Ai) breakFunc() lua.GoFunction {
+  return func(L *lua.State) int {
+    var b game.AiBreak
+    b.Path = a.path
+    if a.ent != nil {
+      b.Entity = a.traceName()
+    }
+    b.File = strings.TrimPrefix(L.ToString(1), "@")
+    if rel, err := filepath.Rel(base.GetDataDir(), b.File); err == nil {
+      b.File = filepath.ToSlash(rel)
+    }
+    b.Line = L.ToInteger(2)
+    b.Function = L.ToString(3)
+    n := int(L.ObjLen(4))
+    for i := 1; i <= n; i++ {
+      L.PushInteger(i)
+      L.GetTable(4)
+      L.PushInteger(1)
+      L.GetTable(-2)
+      name := L.ToString(-1)
+      L.Pop(1)
+      L.PushInteger(2)
+      L.GetTable(-2)
+      b.Locals = append(b.Locals, game.AiVar{Name: name, Value: describeLuaValue(L, -1, 2)})
+      L.Pop(2)
+    }
+    b.Context = a.entityContext()
+
+    a.budget.pause()
+    cmd := game.AiBreakpointHit(b)
+    a.budget.resume()
+    L.PushString(string(cmd))
+    return 1
+  }
+}
+
+// Pushes whether or not the debugger is on, and a table of the breakpoints
+// it has, for the sandbox's call helper to hand to __ai_debug_start().
+func (a *Ai) pushDebugger() {
+  on := game.AiDebugging()
+  a.L.PushBoolean(on)
+  a.L.NewTable()
+  if on {
+    for file, lines := range game.AiBreakpoints() {
+      a.L.PushString("@" + filepath.Join(base.GetDataDir(), filepath.FromSlash(file)))
+      a.L.NewTable()
+      for _, line := range lines {
+        a.L.PushInteger(line)
+        a.L.PushBoolean(true)
+        a.L.SetTable(-3)
+      }
+      a.L.SetTable(-3)
+    }
+  }
+}
@@ -0,0 +1,192 @@
+package ai
+
+import (
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  lua "github.com/xenith-studios/golua"
+  "path/filepath"
+  "time"
+)
+
+const debugger_spec_file = "ais/debugger_spec.lua"
+
+const debugger_spec_prog = `function Think()
+  local a = 1
+  local b = a + 1
+  helper(b)
+  local c = b + 1
+end
+
+function helper(x)
+  local y = x * 2
+  return y
+end`
+
+// Makes a sandbox ai that runs prog as though it were debugger_spec_file, so
+// that breakpoints set on that file apply to it.
+func makeDebuggerAi(prog string) *Ai {
+  path := filepath.Join(base.GetDataDir(), filepath.FromSlash(debugger_spec_file))
+  return makeSandboxAiAt(path, prog)
+}
+
+// Runs Think on its own goroutine, the returned channel is closed once it
+// is done.
+func thinkInBackground(a *Ai) chan struct{} {
+  done := make(chan struct{})
+  go func() {
+    a.think("Think")
+    close(done)
+  }()
+  return done
+}
+
+// Waits for an ai to stop anywhere other than at last, returns nil if none
+// do.
+func waitForBreak(last *game.AiBreak) *game.AiBreak {
+  for i := 0; i < 500; i++ {
+    if b := game.PausedAi(); b != nil && b != last {
+      return b
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+  return nil
+}
+
+// Returns true if done is closed within a few seconds.
+func finishes(done chan struct{}) bool {
+  select {
+  case <-done:
+    return true
+  case <-time.After(5 * time.Second):
+  }
+  return false
+}
+
+func DebuggerSpec(c gospec.Context) {
+  game.ClearAiErrors()
+  game.SetAiDebugging(true)
+  defer game.SetAiDebugging(false)
+
+  c.Specify("Ais stop at breakpoints and show their locals.", func() {
+    game.SetAiBreakpoint(debugger_spec_file, 3, true)
+    defer game.SetAiBreakpoint(debugger_spec_file, 3, false)
+    a := makeDebuggerAi(debugger_spec_prog)
+    defer a.L.Close()
+    done := thinkInBackground(a)
+    b := waitForBreak(nil)
+    c.Assume(b == nil, Equals, false)
+    c.Expect(b.File, Equals, debugger_spec_file)
+    c.Expect(b.Line, Equals, 3)
+    c.Assume(len(b.Locals), Equals, 1)
+    c.Expect(b.Locals[0], Equals, game.AiVar{Name: "a", Value: "1"})
+    game.ResumeAi(game.AiContinue)
+    c.Expect(finishes(done), Equals, true)
+    c.Expect(len(game.AiErrors()), Equals, 0)
+  })
+
+  c.Specify("Ais don't stop while debugging is off.", func() {
+    game.SetAiDebugging(false)
+    game.SetAiBreakpoint(debugger_spec_file, 3, true)
+    defer game.SetAiBreakpoint(debugger_spec_file, 3, false)
+    a := makeDebuggerAi(debugger_spec_prog)
+    defer a.L.Close()
+    c.Expect(finishes(thinkInBackground(a)), Equals, true)
+  })
+
+  c.Specify("Stepping goes into functions and stepping out comes back.", func() {
+    game.SetAiBreakpoint(debugger_spec_file, 3, true)
+    defer game.SetAiBreakpoint(debugger_spec_file, 3, false)
+    a := makeDebuggerAi(debugger_spec_prog)
+    defer a.L.Close()
+    done := thinkInBackground(a)
+    b := waitForBreak(nil)
+    c.Assume(b == nil, Equals, false)
+    game.ResumeAi(game.AiStep)
+    b = waitForBreak(b)
+    c.Assume(b == nil, Equals, false)
+    c.Expect(b.Line, Equals, 4)
+    game.ResumeAi(game.AiStep)
+    b = waitForBreak(b)
+    c.Assume(b == nil, Equals, false)
+    c.Expect(b.Line, Equals, 9)
+    c.Expect(b.Function, Equals, "helper")
+    game.ResumeAi(game.AiStepOut)
+    b = waitForBreak(b)
+    c.Assume(b == nil, Equals, false)
+    c.Expect(b.Function == "helper", Equals, false)
+    c.Expect(b.Line >= 4 && b.Line <= 5, Equals, true)
+    game.ResumeAi(game.AiContinue)
+    c.Expect(finishes(done), Equals, true)
+  })
+
+  c.Specify("Stepping over a call doesn't stop inside it.", func() {
+    game.SetAiBreakpoint(debugger_spec_file, 4, true)
+    defer game.SetAiBreakpoint(debugger_spec_file, 4, false)
+    a := makeDebuggerAi(debugger_spec_prog)
+    defer a.L.Close()
+    done := thinkInBackground(a)
+    b := waitForBreak(nil)
+    c.Assume(b == nil, Equals, false)
+    c.Expect(b.Line, Equals, 4)
+    game.ResumeAi(game.AiStepOver)
+    b = waitForBreak(b)
+    c.Assume(b == nil, Equals, false)
+    c.Expect(b.Line, Equals, 5)
+    c.Expect(len(b.Locals), Equals, 2)
+    game.ResumeAi(game.AiContinue)
+    c.Expect(finishes(done), Equals, true)
+  })
+
+  c.Specify("Breakpoint() stops on the line after it.", func() {
+    a := makeDebuggerAi("function Think()\n  Breakpoint()\n  local x = 1\nend")
+    defer a.L.Close()
+    done := thinkInBackground(a)
+    b := waitForBreak(nil)
+    c.Assume(b == nil, Equals, false)
+    c.Expect(b.File, Equals, debugger_spec_file)
+    c.Expect(b.Line, Equals, 3)
+    game.ResumeAi(game.AiContinue)
+    c.Expect(finishes(done), Equals, true)
+  })
+
+  c.Specify("Turning debugging off lets a stopped ai finish.", func() {
+    game.SetAiBreakpoint(debugger_spec_file, 3, true)
+    defer game.SetAiBreakpoint(debugger_spec_file, 3, false)
+    a := makeDebuggerAi(debugger_spec_prog)
+    defer a.L.Close()
+    done := thinkInBackground(a)
+    c.Assume(waitForBreak(nil) == nil, Equals, false)
+    game.SetAiDebugging(false)
+    c.Expect(finishes(done), Equals, true)
+  })
+
+  c.Specify("Do* calls are traced with their arguments and results.", func() {
+    s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+    c.Assume(err, Equals, nil)
+    a := &Ai{path: "trace.lua", kind: game.IntrudersAi, game: s.Game}
+    a.L = lua.NewState()
+    defer a.L.Close()
+    a.L.OpenLibs()
+    a.L.Register("Double", a.traced("Double", func(L *lua.State) int {
+      L.PushInteger(2 * L.ToInteger(1))
+      return 1
+    }))
+    a.L.Register("Fail", a.traced("Fail", func(L *lua.State) int {
+      return 0
+    }))
+    c.Assume(a.L.DoString(`Double(21) Fail({x = 1})`), Equals, true)
+    trace := game.AiTrace(a.traceName())
+    c.Assume(len(trace) >= 2, Equals, true)
+    double := trace[len(trace)-2]
+    c.Expect(double.Call, Equals, "Double")
+    c.Expect(double.Round, Equals, (s.Game.Turn+1)/2)
+    c.Expect(double.Args, ContainsExactly, Values("21"))
+    c.Expect(double.Result, ContainsExactly, Values("42"))
+    fail := trace[len(trace)-1]
+    c.Expect(fail.Call, Equals, "Fail")
+    c.Expect(fail.Args, ContainsExactly, Values(`{"x"=1}`))
+    c.Expect(fail.Result, ContainsExactly, Values("error"))
+  })
+}
@@ -95,11 +95,15 @@ func execDenizen(a *Ai) lua.GoFunction {
       game.LuaDoError(L, fmt.Sprintf("Tried to ExecDenizen '%s', who is not active.", ent.Name))
       return 0
     }
+    // Time spent waiting on the entity's ai counts against its budget, not
+    // this one.
+    a.budget.pause()
     exec := <-ent.Ai.ActionExecs()
     if exec != nil {
       a.execs <- exec
     }
     <-a.pause
+    a.budget.resume()
     return 0
   }
 }
@@ -19,11 +19,13 @@ func (a *Ai) addEntityContext() {
 
   a.L.NewTable()
   game.LuaPushSmartFunctionTable(a.L, game.FunctionTable{
-    "BasicAttack":        func() { a.L.PushGoFunctionAsCFunction(DoBasicAttackFunc(a)) },
-    "AoeAttack":          func() { a.L.PushGoFunctionAsCFunction(DoAoeAttackFunc(a)) },
-    "Move":               func() { a.L.PushGoFunctionAsCFunction(DoMoveFunc(a)) },
-    "DoorToggle":         func() { a.L.PushGoFunctionAsCFunction(DoDoorToggleFunc(a)) },
-    "InteractWithObject": func() { a.L.PushGoFunctionAsCFunction(DoInteractWithObjectFunc(a)) },
+    "BasicAttack":        func() { a.L.PushGoFunctionAsCFunction(a.traced("BasicAttack", DoBasicAttackFunc(a))) },
+    "AoeAttack":          func() { a.L.PushGoFunctionAsCFunction(a.traced("AoeAttack", DoAoeAttackFunc(a))) },
+    "Ready":              func() { a.L.PushGoFunctionAsCFunction(a.traced("Ready", DoReadyFunc(a))) },
+    "Move":               func() { a.L.PushGoFunctionAsCFunction(a.traced("Move", DoMoveFunc(a))) },
+    "DoorToggle":         func() { a.L.PushGoFunctionAsCFunction(a.traced("DoorToggle", DoDoorToggleFunc(a))) },
+    "InteractWithObject": func() { a.L.PushGoFunctionAsCFunction(a.traced("InteractWithObject", DoInteractWithObjectFunc(a))) },
+    "Plan":               func() { a.L.PushGoFunctionAsCFunction(a.traced("Plan", DoPlanFunc(a))) },
   })
   a.L.SetMetaTable(-2)
   a.L.SetGlobal("Do")
@@ -47,6 +49,9 @@ func (a *Ai) addEntityContext() {
     "DoorIsOpen":                 func() { a.L.PushGoFunctionAsCFunction(DoorIsOpenFunc(a)) },
     "RoomPositions":              func() { a.L.PushGoFunctionAsCFunction(RoomPositionsFunc(a)) },
     "Rand":                       func() { a.L.PushGoFunctionAsCFunction(randFunc(a)) },
+    "ThreatAt":                   func() { a.L.PushGoFunctionAsCFunction(ThreatAtFunc(a)) },
+    "SafestReachablePos":         func() { a.L.PushGoFunctionAsCFunction(SafestReachablePosFunc(a)) },
+    "BestFlankingPos":            func() { a.L.PushGoFunctionAsCFunction(BestFlankingPosFunc(a)) },
   })
   a.L.SetMetaTable(-2)
   a.L.SetGlobal("Utils")
@@ -97,6 +102,41 @@ func init() {
   }
 }
 
+// Returns the vertices that ent can walk to from (x1, y1) that are at least
+// min and at most max away from (x2, y2), and that (x2, y2) can see.
+func pathablePoints(ent *game.Entity, x1, y1, x2, y2, min, max int) []int {
+  ent.Game().DetermineLos(x2, y2, max, grid)
+  var dst []int
+  for x := x2 - max; x <= x2+max; x++ {
+    for y := y2 - max; y <= y2+max; y++ {
+      if x > x2-min && x < x2+min && y > y2-min && y < y2+min {
+        continue
+      }
+      if x < 0 || y < 0 || x >= len(grid) || y >= len(grid[0]) {
+        continue
+      }
+      if !grid[x][y] {
+        continue
+      }
+      dst = append(dst, ent.Game().ToVertex(x, y))
+    }
+  }
+  vis := 0
+  for i := range grid {
+    for j := range grid[i] {
+      if grid[i][j] {
+        vis++
+      }
+    }
+  }
+  base.Log().Printf("Visible: %d", vis)
+  graph := ent.Game().Graph(ent.Side(), true, nil)
+  src := []int{ent.Game().ToVertex(x1, y1)}
+  reachable := algorithm.ReachableDestinations(graph, src, dst)
+  base.Log().Printf("%d/%d reachable from (%d, %d) -> (%d, %d)", len(reachable), len(dst), x1, y1, x2, y2)
+  return reachable
+}
+
 // Returns an array of all points that can be reached by walking from a
 // specific location that end in a certain general area.  Assumes that a 1x1
 // unit is doing the walking.
@@ -120,37 +160,8 @@ func AllPathablePointsFunc(a *Ai) lua.GoFunction {
     max := L.ToInteger(-1)
     x1, y1 := game.LuaToPoint(L, -4)
     x2, y2 := game.LuaToPoint(L, -3)
-
-    a.ent.Game().DetermineLos(x2, y2, max, grid)
-    var dst []int
-    for x := x2 - max; x <= x2+max; x++ {
-      for y := y2 - max; y <= y2+max; y++ {
-        if x > x2-min && x < x2+min && y > y2-min && y < y2+min {
-          continue
-        }
-        if x < 0 || y < 0 || x >= len(grid) || y >= len(grid[0]) {
-          continue
-        }
-        if !grid[x][y] {
-          continue
-        }
-        dst = append(dst, a.ent.Game().ToVertex(x, y))
-      }
-    }
-    vis := 0
-    for i := range grid {
-      for j := range grid[i] {
-        if grid[i][j] {
-          vis++
-        }
-      }
-    }
-    base.Log().Printf("Visible: %d", vis)
-    graph := a.ent.Game().Graph(a.ent.Side(), true, nil)
-    src := []int{a.ent.Game().ToVertex(x1, y1)}
-    reachable := algorithm.ReachableDestinations(graph, src, dst)
+    reachable := pathablePoints(a.ent, x1, y1, x2, y2, min, max)
     L.NewTable()
-    base.Log().Printf("%d/%d reachable from (%d, %d) -> (%d, %d)", len(reachable), len(dst), x1, y1, x2, y2)
     for i, v := range reachable {
       _, x, y := a.ent.Game().FromVertex(v)
       L.PushInteger(i + 1)
@@ -197,8 +208,7 @@ func DoBasicAttackFunc(a *Ai) lua.GoFunction {
     }
     exec := attack.AiAttackTarget(me, target)
     if exec != nil {
-      a.execs <- exec
-      <-a.pause
+      a.sendExec(exec)
       result := actions.GetBasicAttackResult(exec)
       if result == nil {
         L.PushNil()
@@ -215,6 +225,40 @@ func DoBasicAttackFunc(a *Ai) lua.GoFunction {
   }
 }
 
+// Readies an action so that it is used on the first enemy that comes into
+// range during the other side's turn.
+//    Format:
+//    res = DoReady(action)
+//
+//    Inputs:
+//    action - string - Name of the action to ready.
+//
+//    Outputs:
+//    res - boolean - True if the action was readied.
+//    res will be nil if the action could not be readied.
+func DoReadyFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "DoReady", game.LuaString) {
+      return 0
+    }
+    me := a.ent
+    name := L.ToString(-1)
+    action := getActionByName(me, name)
+    if action == nil {
+      game.LuaDoError(L, fmt.Sprintf("Entity '%s' (id=%d) has no action named '%s'.", me.Name, me.Id, name))
+      return 0
+    }
+    exec := game.MakeReadyExec(me, action)
+    if exec != nil {
+      a.sendExec(exec)
+      L.PushBoolean(me.Readied != nil)
+    } else {
+      L.PushNil()
+    }
+    return 1
+  }
+}
+
 // Performs an aoe attack against centered at the specified position.
 //    Format:
 //    res = DoAoeAttack(attack, pos)
@@ -245,8 +289,7 @@ func DoAoeAttackFunc(a *Ai) lua.GoFunction {
     tx, ty := game.LuaToPoint(L, -1)
     exec := attack.AiAttackPosition(me, tx, ty)
     if exec != nil {
-      a.execs <- exec
-      <-a.pause
+      a.sendExec(exec)
       L.PushBoolean(true)
     } else {
       L.PushNil()
@@ -362,8 +405,7 @@ func DoMoveFunc(a *Ai) lua.GoFunction {
     }
     exec := move.AiMoveToPos(me, dsts, max_ap)
     if exec != nil {
-      a.execs <- exec
-      <-a.pause
+      a.sendExec(exec)
       // TODO: Need to get a resolution
       x, y := me.Pos()
       v := me.Game().ToVertex(x, y)
@@ -973,8 +1015,7 @@ func DoDoorToggleFunc(a *Ai) lua.GoFunction {
     }
     exec := interact.AiToggleDoor(a.ent, door)
     if exec != nil {
-      a.execs <- exec
-      <-a.pause
+      a.sendExec(exec)
       L.PushBoolean(door.IsOpened())
     } else {
       L.PushNil()
@@ -1004,8 +1045,7 @@ func DoInteractWithObjectFunc(a *Ai) lua.GoFunction {
     }
     exec := interact.AiInteractWithObject(a.ent, object)
     if exec != nil {
-      a.execs <- exec
-      <-a.pause
+      a.sendExec(exec)
       L.PushBoolean(true)
     } else {
       L.PushNil()
@@ -1054,7 +1094,7 @@ func randFunc(a *Ai) lua.GoFunction {
       return 0
     }
     n := L.ToInteger(-1)
-    L.PushInteger(int(a.game.Rand.Int63()%int64(n)) + 1)
+    L.PushInteger(a.draw(int(a.game.Rand.Int63()%int64(n)) + 1))
     return 1
   }
 }
@@ -0,0 +1,211 @@
+package ai
+
+import (
+  "fmt"
+  "github.com/mik3cap/haunts/game"
+  lua "github.com/xenith-studios/golua"
+  "sort"
+)
+
+// Returns the cost, in Ap, of getting to every vertex that ent can walk to
+// while spending at most max_ap.
+func reachableWithin(ent *game.Entity, max_ap int) map[int]int {
+  g := ent.Game()
+  graph := g.Graph(ent.Side(), true, nil)
+  src := g.ToVertex(ent.Pos())
+  cost := map[int]int{src: 0}
+  if max_ap < 0 {
+    return cost
+  }
+
+  // Moving always costs a whole number of Ap, so rather than a heap we can
+  // keep a list of the vertices for each cost and go through them in order.
+  buckets := make([][]int, max_ap+1)
+  buckets[0] = []int{src}
+  for c := range buckets {
+    for i := 0; i < len(buckets[c]); i++ {
+      v := buckets[c][i]
+      if cost[v] != c {
+        continue
+      }
+      adj, weight := graph.Adjacent(v)
+      for j := range adj {
+        next := c + int(weight[j])
+        if next > max_ap {
+          continue
+        }
+        if prev, ok := cost[adj[j]]; ok && prev <= next {
+          continue
+        }
+        cost[adj[j]] = next
+        buckets[next] = append(buckets[next], adj[j])
+      }
+    }
+  }
+  return cost
+}
+
+// Returns the vertices in reachable in increasing order, so that ties are
+// always broken the same way.
+func sortedVertices(reachable map[int]int) []int {
+  var vs []int
+  for v := range reachable {
+    vs = append(vs, v)
+  }
+  sort.Ints(vs)
+  return vs
+}
+
+// Returns how much damage the enemies of this ai's entity could do to it
+// at a position.
+//    Format:
+//    threat = ThreatAt(pos)
+//
+//    Inputs:
+//    pos - table[x,y] - The position to check.
+//
+//    Outputs:
+//    threat - number
+func ThreatAtFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "ThreatAt", game.LuaPoint) {
+      return 0
+    }
+    x, y := game.LuaToPoint(L, -1)
+    L.PushNumber(a.game.Influence(a.ent.Side()).ThreatAt(x, y))
+    return 1
+  }
+}
+
+// Finds the position with the least threat that this ai's entity can walk
+// to.
+//    Format:
+//    pos, threat = SafestReachablePos(max_ap)
+//
+//    Inputs:
+//    max_ap - integer - Most Ap to spend getting there.
+//
+//    Outputs:
+//    pos    - table[x,y] - The safest position, the nearest if there is a tie.
+//    threat - number     - The threat at pos.
+func SafestReachablePosFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "SafestReachablePos", game.LuaInteger) {
+      return 0
+    }
+    max_ap := L.ToInteger(-1)
+    if max_ap > a.ent.Stats.ApCur() {
+      max_ap = a.ent.Stats.ApCur()
+    }
+    influence := a.game.Influence(a.ent.Side())
+    reachable := reachableWithin(a.ent, max_ap)
+    best := -1
+    var best_threat float64
+    for _, v := range sortedVertices(reachable) {
+      _, x, y := a.game.FromVertex(v)
+      threat := influence.ThreatAt(x, y)
+      if best == -1 || threat < best_threat || (threat == best_threat && reachable[v] < reachable[best]) {
+        best = v
+        best_threat = threat
+      }
+    }
+    _, x, y := a.game.FromVertex(best)
+    game.LuaPushPoint(L, x, y)
+    L.PushNumber(best_threat)
+    return 2
+  }
+}
+
+// Finds a position that this ai's entity can walk to and then attack a
+// target from.  Of those positions the one with the least threat is
+// chosen, then the one furthest from any allies that can also attack the
+// target, so that a target gets surrounded rather than approached from
+// one side.
+//    Format:
+//    pos = BestFlankingPos(attack, target)
+//
+//    Inputs:
+//    attack - string - Name of the attack to use.
+//    target - Entity - The entity to attack.
+//
+//    Outputs:
+//    pos - table[x,y] - Where to attack from, or nil if there is nowhere.
+func BestFlankingPosFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !game.LuaCheckParamsOk(L, "BestFlankingPos", game.LuaString, game.LuaEntity) {
+      return 0
+    }
+    me := a.ent
+    name := L.ToString(-2)
+    action := getActionByName(me, name)
+    if action == nil {
+      game.LuaDoError(L, fmt.Sprintf("Entity '%s' (id=%d) has no action named '%s'.", me.Name, me.Id, name))
+      return 0
+    }
+    threat, ok := action.(game.Threat)
+    if !ok {
+      game.LuaDoError(L, fmt.Sprintf("Action '%s' is not an attack.", name))
+      return 0
+    }
+    target := game.LuaToEntity(L, a.game, -1)
+    if target == nil {
+      L.PushNil()
+      return 1
+    }
+    rng, _, _ := threat.Threat()
+    tx, ty := target.Pos()
+
+    // Allies that are already in range of the target.
+    var allies [][2]int
+    for _, ent := range a.game.Ents {
+      if ent == me || ent.Side() != me.Side() || ent.Stats == nil || ent.Stats.HpCur() <= 0 {
+        continue
+      }
+      if rangedDistBetween(ent, target) <= rng {
+        x, y := ent.Pos()
+        allies = append(allies, [2]int{x, y})
+      }
+    }
+
+    a.game.DetermineLos(tx, ty, rng, grid)
+    influence := a.game.Influence(me.Side())
+    reachable := reachableWithin(me, me.Stats.ApCur()-action.AP())
+    best := -1
+    var best_threat float64
+    var best_spread int
+    for _, v := range sortedVertices(reachable) {
+      _, x, y := a.game.FromVertex(v)
+      if x < 0 || y < 0 || x >= len(grid) || y >= len(grid[x]) || !grid[x][y] {
+        continue
+      }
+      if chebyshev(x, y, tx, ty) > rng {
+        continue
+      }
+      threat := influence.ThreatAt(x, y)
+      spread := rng
+      for _, ally := range allies {
+        if d := chebyshev(x, y, ally[0], ally[1]); d < spread {
+          spread = d
+        }
+      }
+      better := best == -1 || threat < best_threat
+      if !better && threat == best_threat {
+        better = spread > best_spread || (spread == best_spread && reachable[v] < reachable[best])
+      }
+      if better {
+        best = v
+        best_threat = threat
+        best_spread = spread
+      }
+    }
+    if best == -1 {
+      L.PushNil()
+      return 1
+    }
+    _, x, y := a.game.FromVertex(best)
+    game.LuaPushPoint(L, x, y)
+    return 1
+  }
+}
+
+var chebyshev = game.Chebyshev
@@ -0,0 +1,90 @@
+package ai
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  lua "github.com/xenith-studios/golua"
+)
+
+// Makes an ai for ent that only has the influence queries.
+func makeInfluenceAi(ent *game.Entity) *Ai {
+  a := &Ai{path: "influence.lua", kind: game.EntityAi, game: ent.Game(), ent: ent}
+  a.L = lua.NewState()
+  a.L.OpenLibs()
+  a.L.Register("ThreatAt", ThreatAtFunc(a))
+  a.L.Register("SafestReachablePos", SafestReachablePosFunc(a))
+  a.L.Register("BestFlankingPos", BestFlankingPosFunc(a))
+  return a
+}
+
+func globalPoint(L *lua.State, name string) (x, y int) {
+  L.GetGlobal(name)
+  x, y = game.LuaToPoint(L, -1)
+  L.Pop(1)
+  return
+}
+
+func InfluenceSpec(c gospec.Context) {
+  // An Angry Shade next to a Teen, in the middle of the dining room.  Angry
+  // Shades only have Chill Touch, range 1 and 2 damage.
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+  teen, err := s.Spawn("Teen", 47, 25)
+  c.Assume(err, Equals, nil)
+  shade, err := s.Spawn("Angry Shade", 47, 26)
+  c.Assume(err, Equals, nil)
+  s.Game.SetLosMode(game.SideExplorers, game.LosModeAll, nil)
+  s.Game.SetLosMode(game.SideHaunt, game.LosModeAll, nil)
+
+  c.Specify("ThreatAt gives the threat to the ai's side.", func() {
+    a := makeInfluenceAi(teen)
+    defer a.L.Close()
+    c.Assume(a.L.DoString("near = ThreatAt({X = 47, Y = 25})\nfar = ThreatAt({X = 47, Y = 22})"), Equals, true)
+    a.L.GetGlobal("near")
+    c.Expect(a.L.ToNumber(-1), Equals, 2.0)
+    a.L.GetGlobal("far")
+    c.Expect(a.L.ToNumber(-1), Equals, 0.0)
+  })
+
+  c.Specify("SafestReachablePos moves out of reach of enemies.", func() {
+    a := makeInfluenceAi(teen)
+    defer a.L.Close()
+    c.Assume(a.L.DoString("pos, threat = SafestReachablePos(4)"), Equals, true)
+    a.L.GetGlobal("threat")
+    c.Expect(a.L.ToNumber(-1), Equals, 0.0)
+    x, y := globalPoint(a.L, "pos")
+    c.Expect(chebyshev(x, y, 47, 26) > 1, Equals, true)
+  })
+
+  c.Specify("SafestReachablePos stays put if it can't move.", func() {
+    a := makeInfluenceAi(teen)
+    defer a.L.Close()
+    c.Assume(a.L.DoString("pos, threat = SafestReachablePos(0)"), Equals, true)
+    a.L.GetGlobal("threat")
+    c.Expect(a.L.ToNumber(-1), Equals, 2.0)
+    x, y := globalPoint(a.L, "pos")
+    c.Expect(x, Equals, 47)
+    c.Expect(y, Equals, 25)
+  })
+
+  c.Specify("BestFlankingPos finds somewhere to attack the target from.", func() {
+    a := makeInfluenceAi(shade)
+    defer a.L.Close()
+    game.LuaPushEntity(a.L, teen)
+    a.L.SetGlobal("target")
+    c.Assume(a.L.DoString(`pos = BestFlankingPos("Chill Touch", target)`), Equals, true)
+    x, y := globalPoint(a.L, "pos")
+    c.Expect(chebyshev(x, y, 47, 25), Equals, 1)
+  })
+
+  c.Specify("BestFlankingPos only works with attacks.", func() {
+    a := makeInfluenceAi(shade)
+    defer a.L.Close()
+    game.LuaPushEntity(a.L, teen)
+    a.L.SetGlobal("target")
+    a.L.DoString(`pos = BestFlankingPos("Move", target)`)
+    a.L.GetGlobal("pos")
+    c.Expect(a.L.IsNil(-1), Equals, true)
+  })
+}
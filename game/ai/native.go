@@ -0,0 +1,218 @@
+package ai
+
+import (
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/game/actions"
+  "sort"
+)
+
+// Native versions of the basic ais.  They do the same thing as the simplest
+// lua ais, and serve as examples for writing more native ais.
+//   basic_denizen, basic_intruder - Entity ais that go after the nearest
+//                                   enemy they can see, attacking it with
+//                                   their first basic attack.
+//   planner                       - Entity ai, for either side, that uses
+//                                   the planner with its default settings.
+//   denizens, minions, intruders  - Master ais that run every active entity
+//                                   of theirs until none are left.
+
+func init() {
+  game.RegisterNativeAiMakers(registerNativeAis)
+}
+
+func registerNativeAis() map[string]game.NativeAiMaker {
+  return map[string]game.NativeAiMaker{
+    "basic_denizen":  makeBasicEntityAi("basic_denizen", game.SideHaunt),
+    "basic_intruder": makeBasicEntityAi("basic_intruder", game.SideExplorers),
+    "planner":        makePlannerAi,
+    "denizens":       makeNativeMasterAi("denizens", game.DenizensAi, isNonMinionDenizen),
+    "minions":        makeNativeMasterAi("minions", game.MinionsAi, isMinion),
+    "intruders":      makeNativeMasterAi("intruders", game.IntrudersAi, isIntruder),
+  }
+}
+
+func makeBasicEntityAi(name string, side game.Side) game.NativeAiMaker {
+  return func(g *game.Game, ent *game.Entity, kind game.AiKind) (game.Ai, error) {
+    if kind != game.EntityAi {
+      return nil, game.NativeAiKindError(name, kind)
+    }
+    if ent.Side() != side || ent.Stats == nil {
+      return nil, errors.New(fmt.Sprintf("Native ai '%s' can't be used by '%s'.", name, ent.Name))
+    }
+    return game.MakeNativeAi(name, g, ent, kind, basicEntityThink), nil
+  }
+}
+
+func makePlannerAi(g *game.Game, ent *game.Entity, kind game.AiKind) (game.Ai, error) {
+  if kind != game.EntityAi {
+    return nil, game.NativeAiKindError("planner", kind)
+  }
+  if ent.Stats == nil {
+    return nil, errors.New(fmt.Sprintf("Native ai 'planner' can't be used by '%s'.", ent.Name))
+  }
+  return game.MakeNativeAi("planner", g, ent, kind, func(a *game.NativeAi) {
+    me := a.Entity()
+    config := difficultyPlanConfig(a.Game(), default_plan_config)
+    for {
+      ap := me.Stats.ApCur()
+      exec := planNextExec(me, config)
+      if exec == nil || !a.Exec(exec) || me.Stats.ApCur() >= ap {
+        return
+      }
+    }
+  }), nil
+}
+
+func makeNativeMasterAi(name string, master game.AiKind, runs func(*game.Entity) bool) game.NativeAiMaker {
+  return func(g *game.Game, ent *game.Entity, kind game.AiKind) (game.Ai, error) {
+    if kind != master {
+      return nil, game.NativeAiKindError(name, kind)
+    }
+    return game.MakeNativeAi(name, g, ent, kind, func(a *game.NativeAi) {
+      for {
+        next := activeEntity(a.Game(), runs)
+        if next == nil {
+          return
+        }
+        if !a.ExecEntity(next) && next.Ai.Active() {
+          // The entity's ai stopped giving us execs without finishing, so
+          // give up on the rest of the turn rather than wait on it forever.
+          return
+        }
+      }
+    }), nil
+  }
+}
+
+func isNonMinionDenizen(ent *game.Entity) bool {
+  return ent.HauntEnt != nil && ent.HauntEnt.Level != game.LevelMinion
+}
+
+func isMinion(ent *game.Entity) bool {
+  return ent.HauntEnt != nil && ent.HauntEnt.Level == game.LevelMinion
+}
+
+func isIntruder(ent *game.Entity) bool {
+  return ent.ExplorerEnt != nil
+}
+
+// Returns the first living entity that runs says a master should run and
+// whose ai still has something to do this turn, or nil if there aren't any.
+func activeEntity(g *game.Game, runs func(*game.Entity) bool) *game.Entity {
+  for _, ent := range g.Ents {
+    if !runs(ent) || ent.Stats == nil || ent.Stats.HpCur() <= 0 {
+      continue
+    }
+    if ent.Ai != nil && ent.Ai.Active() {
+      return ent
+    }
+  }
+  return nil
+}
+
+// Returns the living entities on the other side that me's team can see,
+// nearest first.
+func visibleEnemies(me *game.Entity) []*game.Entity {
+  var eds entityDistSlice
+  for _, ent := range me.Game().Ents {
+    if ent.Stats == nil || ent.Stats.HpCur() <= 0 {
+      continue
+    }
+    if ent.Side() == me.Side() || (ent.Side() != game.SideHaunt && ent.Side() != game.SideExplorers) {
+      continue
+    }
+    x, y := ent.Pos()
+    dx, dy := ent.Dims()
+    if !me.HasTeamLos(x, y, dx, dy) {
+      continue
+    }
+    eds = append(eds, entityDist{rangedDistBetween(me, ent), ent})
+  }
+  sort.Sort(eds)
+  var ents []*game.Entity
+  for _, ed := range eds {
+    ents = append(ents, ed.ent)
+  }
+  return ents
+}
+
+// Returns the nearest living entity on the other side that me's team can
+// see, or nil if there isn't one.
+func nearestEnemy(me *game.Entity) *game.Entity {
+  enemies := visibleEnemies(me)
+  if len(enemies) == 0 {
+    return nil
+  }
+  return enemies[0]
+}
+
+// Returns the first basic attack that me has the ammo for, or nil.
+func firstBasicAttack(me *game.Entity) *actions.BasicAttack {
+  for _, action := range me.Actions {
+    attack, ok := action.(*actions.BasicAttack)
+    if ok && attack.Target_enemies && attack.Current_ammo != 0 {
+      return attack
+    }
+  }
+  return nil
+}
+
+func firstMove(me *game.Entity) *actions.Move {
+  for _, action := range me.Actions {
+    if move, ok := action.(*actions.Move); ok {
+      return move
+    }
+  }
+  return nil
+}
+
+// Attacks the nearest enemy if it can, otherwise moves towards it, keeping
+// enough ap to attack once it gets there.  Stops once it can't do either.
+func basicEntityThink(a *game.NativeAi) {
+  me := a.Entity()
+  for {
+    target := nearestEnemy(me)
+    if target == nil {
+      return
+    }
+    ap := me.Stats.ApCur()
+    attack := firstBasicAttack(me)
+    if attack != nil && ap >= attack.Ap {
+      if exec := attack.AiAttackTarget(me, target); exec != nil {
+        if !a.Exec(exec) || me.Stats.ApCur() >= ap {
+          return
+        }
+        continue
+      }
+    }
+
+    move := firstMove(me)
+    if move == nil {
+      return
+    }
+    dist := 1
+    max_ap := ap
+    if attack != nil {
+      dist = attack.Range
+      max_ap -= attack.Ap
+    }
+    if max_ap <= 0 {
+      return
+    }
+    x, y := me.Pos()
+    tx, ty := target.Pos()
+    dsts := pathablePoints(me, x, y, tx, ty, 1, dist)
+    if len(dsts) == 0 {
+      return
+    }
+    exec := move.AiMoveToPos(me, dsts, max_ap)
+    if exec == nil {
+      return
+    }
+    if !a.Exec(exec) || me.Stats.ApCur() >= ap {
+      return
+    }
+  }
+}
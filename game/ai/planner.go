@@ -0,0 +1,362 @@
+package ai
+
+import (
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/game/actions"
+  lua "github.com/xenith-studios/golua"
+  "math/rand"
+  "time"
+)
+
+// The planner picks an entity's next action by trying out everything that
+// looks reasonable on copies of the game.  Each candidate action is played
+// out several times, each time with different random numbers and followed by
+// the best looking attacks, and the candidate with the best average outcome
+// is chosen.  Nothing the planner does touches the real game, including its
+// random numbers, so planning doesn't affect replays.
+
+// How hard the planner tries, these are what levels can change to make ais
+// smarter or dumber.
+type PlanConfig struct {
+  // Most candidate actions that are tried each time an action is chosen.
+  Candidates int
+
+  // How many times each candidate is played out.
+  Rollouts int
+
+  // How many actions, including the candidate, are played out each time.
+  Depth int
+
+  // The planner stops trying candidates after this long, as long as it
+  // has tried at least one.
+  Time time.Duration
+
+  // How much the planner cares about getting close to its enemies when it
+  // can't hurt them right away, 0 means that it won't move unless it can
+  // attack afterwards.
+  Aggression float64
+}
+
+var default_plan_config = PlanConfig{
+  Candidates: 12,
+  Rollouts:   4,
+  Depth:      3,
+  Time:       time.Second,
+  Aggression: 1,
+}
+
+// Returns config adjusted for the difficulty of g, harder difficulties try
+// more candidates and look further ahead.
+func difficultyPlanConfig(g *game.Game, config PlanConfig) PlanConfig {
+  scale := g.Difficulty.Settings().Planning
+  adjust := func(v int) int {
+    v = int(float64(v)*scale + 0.5)
+    if v < 1 {
+      v = 1
+    }
+    return v
+  }
+  config.Candidates = adjust(config.Candidates)
+  config.Rollouts = adjust(config.Rollouts)
+  config.Depth = adjust(config.Depth)
+  return config
+}
+
+// Only this many of the nearest enemies are considered as places to move to.
+const plan_move_targets = 3
+
+// Bonus for killing an entity, on top of the damage done to it.
+const plan_kill_bonus = 5
+
+type planner struct {
+  ent    *game.Entity
+  config PlanConfig
+
+  // Every entity with stats when planning started, by id.
+  start map[game.EntityId]planEntity
+}
+
+type planEntity struct {
+  hp   int
+  side game.Side
+}
+
+// Returns the actions that ent might want to do next: attacks against every
+// enemy it can hit, then moves towards the nearest enemies.
+func planCandidates(ent *game.Entity) []game.ActionExec {
+  var execs []game.ActionExec
+  ap := ent.Stats.ApCur()
+  enemies := visibleEnemies(ent)
+  for _, action := range ent.Actions {
+    switch attack := action.(type) {
+    case *actions.BasicAttack:
+      if attack.Current_ammo == 0 || attack.Ap > ap || !attack.Target_enemies {
+        continue
+      }
+      for _, target := range enemies {
+        if exec := attack.AiAttackTarget(ent, target); exec != nil {
+          execs = append(execs, exec)
+        }
+      }
+    case *actions.AoeAttack:
+      if attack.Current_ammo == 0 || attack.Ap > ap {
+        continue
+      }
+      x, y, hits := attack.AiBestTarget(ent, 0, actions.AiAoeHitNoAllies)
+      if len(hits) == 0 {
+        continue
+      }
+      if exec := attack.AiAttackPosition(ent, x, y); exec != nil {
+        execs = append(execs, exec)
+      }
+    }
+  }
+
+  move := firstMove(ent)
+  if move == nil {
+    return execs
+  }
+  // Moves try to get within range of each attack, saving enough ap to use
+  // it, and also just to get next to the enemy.
+  type approach struct {
+    dist, reserve int
+  }
+  approaches := []approach{{1, 0}}
+  for _, action := range ent.Actions {
+    switch attack := action.(type) {
+    case *actions.BasicAttack:
+      approaches = append(approaches, approach{attack.Range, attack.Ap})
+    case *actions.AoeAttack:
+      approaches = append(approaches, approach{attack.Range, attack.Ap})
+    }
+  }
+  x, y := ent.Pos()
+  for i, target := range enemies {
+    if i == plan_move_targets {
+      break
+    }
+    tx, ty := target.Pos()
+    for _, app := range approaches {
+      if app.dist < 1 || ap-app.reserve <= 0 {
+        continue
+      }
+      dsts := pathablePoints(ent, x, y, tx, ty, 1, app.dist)
+      if len(dsts) == 0 {
+        continue
+      }
+      if exec := move.AiMoveToPos(ent, dsts, ap-app.reserve); exec != nil {
+        execs = append(execs, exec)
+      }
+    }
+  }
+  return execs
+}
+
+// Returns how good things look for p.ent in g compared to when planning
+// started.
+func (p *planner) score(g *game.Game) float64 {
+  var score float64
+  for id, start := range p.start {
+    before := start.hp
+    ent := g.EntityById(id)
+    after := 0
+    if ent != nil && ent.Stats != nil {
+      after = ent.Stats.HpCur()
+    }
+    if after < 0 {
+      after = 0
+    }
+    lost := float64(before - after)
+    if after == 0 && before > 0 {
+      lost += plan_kill_bonus
+    }
+    if start.side == p.ent.Side() {
+      score -= lost
+    } else {
+      score += lost
+    }
+  }
+  me := g.EntityById(p.ent.Id)
+  if me == nil || me.Stats == nil || me.Stats.HpCur() <= 0 {
+    return score
+  }
+  if target := nearestEnemy(me); target != nil {
+    score -= p.config.Aggression * 0.1 * float64(rangedDistBetween(me, target))
+  }
+  return score
+}
+
+// Plays out first on a new Sim made from fork, followed by up to
+// p.config.Depth-1 of the most promising attacks, and returns the score.
+func (p *planner) rollout(fork *game.Fork, first game.ActionExec, seed int64) (float64, bool) {
+  sim, err := fork.Sim(seed)
+  if err != nil {
+    base.Warn().Printf("Unable to plan for %s: %v", p.ent.Name, err)
+    return 0, false
+  }
+  if err := sim.Exec(first); err != nil {
+    return 0, false
+  }
+  me := sim.Game.EntityById(p.ent.Id)
+  for depth := 1; depth < p.config.Depth && me != nil && me.Stats != nil && me.Stats.HpCur() > 0; depth++ {
+    var next game.ActionExec
+    for _, exec := range planCandidates(me) {
+      if _, ok := me.Actions[exec.ActionIndex()].(*actions.Move); !ok {
+        next = exec
+        break
+      }
+    }
+    if next == nil || sim.Exec(next) != nil {
+      break
+    }
+  }
+  return p.score(sim.Game), true
+}
+
+// Returns the best thing for ent to do next, or nil if it is better off doing
+// nothing.  On easier difficulties this sometimes returns something that is
+// better than nothing but isn't the best.
+func planNextExec(ent *game.Entity, config PlanConfig) game.ActionExec {
+  if ent.Stats == nil || ent.Stats.ApCur() <= 0 {
+    return nil
+  }
+  candidates := planCandidates(ent)
+  if len(candidates) == 0 {
+    return nil
+  }
+  if len(candidates) > config.Candidates {
+    candidates = candidates[0:config.Candidates]
+  }
+  fork, err := ent.Game().Fork()
+  if err != nil {
+    base.Warn().Printf("Unable to plan for %s: %v", ent.Name, err)
+    return nil
+  }
+  p := planner{ent: ent, config: config, start: make(map[game.EntityId]planEntity)}
+  for _, e := range ent.Game().Ents {
+    if e.Stats != nil {
+      p.start[e.Id] = planEntity{e.Stats.HpCur(), e.Side()}
+    }
+  }
+
+  // Seeds only depend on the situation so that the same situation is always
+  // planned the same way.
+  seed := int64(ent.Game().Turn)*1000003 + int64(ent.Id)*1009
+  start := time.Now()
+  nothing := p.score(ent.Game())
+  best := nothing
+  var best_exec game.ActionExec
+  var better []game.ActionExec
+  for i, exec := range candidates {
+    if i > 0 && time.Since(start) > config.Time {
+      base.Log().Printf("Planner for %s ran out of time after %d candidates", ent.Name, i)
+      break
+    }
+    var total float64
+    runs := 0
+    for r := 0; r < config.Rollouts; r++ {
+      score, ok := p.rollout(fork, exec, seed+int64(r))
+      if !ok {
+        break
+      }
+      total += score
+      runs++
+    }
+    if runs == 0 {
+      continue
+    }
+    avg := total / float64(runs)
+    if avg > nothing {
+      better = append(better, exec)
+    }
+    if avg > best {
+      best = avg
+      best_exec = exec
+    }
+  }
+  blunder := ent.Game().Difficulty.Settings().Blunder
+  if blunder > 0 && len(better) > 1 {
+    r := rand.New(rand.NewSource(seed))
+    if r.Float64() < blunder {
+      return better[r.Intn(len(better))]
+    }
+  }
+  return best_exec
+}
+
+// Reads a PlanConfig from the lua table at index, anything that isn't in the
+// table keeps its default value.
+func luaToPlanConfig(L *lua.State, index int) PlanConfig {
+  config := default_plan_config
+  field := func(name string) (float64, bool) {
+    L.PushString(name)
+    L.GetTable(index - 1)
+    defer L.Pop(1)
+    if !L.IsNumber(-1) {
+      return 0, false
+    }
+    return L.ToNumber(-1), true
+  }
+  if v, ok := field("Candidates"); ok {
+    config.Candidates = int(v)
+  }
+  if v, ok := field("Rollouts"); ok {
+    config.Rollouts = int(v)
+  }
+  if v, ok := field("Depth"); ok {
+    config.Depth = int(v)
+  }
+  if v, ok := field("Time"); ok {
+    config.Time = time.Duration(v * float64(time.Second))
+  }
+  if v, ok := field("Aggression"); ok {
+    config.Aggression = v
+  }
+  return config
+}
+
+// Does the rest of the entity's turn using the planner, each action is
+// planned after the previous one is done.
+//    Format:
+//    n = DoPlan(config)
+//
+//    Inputs:
+//    config - table - Optional, any of the following values:
+//                     Candidates (integer) - Most actions to consider each time.
+//                     Rollouts (integer) - Times to play out each action.
+//                     Depth (integer) - Actions to look ahead, including the
+//                                       one being considered.
+//                     Time (number) - Seconds to spend choosing each action.
+//                     Aggression (number) - How much to value getting close
+//                                           to enemies.
+//
+//    Outputs:
+//    n - integer - The number of actions done.
+func DoPlanFunc(a *Ai) lua.GoFunction {
+  return func(L *lua.State) int {
+    config := default_plan_config
+    if L.GetTop() > 0 {
+      if !game.LuaCheckParamsOk(L, "DoPlan", game.LuaTable) {
+        return 0
+      }
+      config = luaToPlanConfig(L, -1)
+    }
+    config = difficultyPlanConfig(a.game, config)
+    n := 0
+    for {
+      ap := a.ent.Stats.ApCur()
+      exec := planNextExec(a.ent, config)
+      if exec == nil {
+        break
+      }
+      a.sendExec(exec)
+      n++
+      if a.ent.Stats.ApCur() >= ap {
+        break
+      }
+    }
+    L.PushInteger(n)
+    return 1
+  }
+}
@@ -0,0 +1,179 @@
+package ai
+
+import (
+  "github.com/mik3cap/haunts/game"
+  lua "github.com/xenith-studios/golua"
+  "math/rand"
+)
+
+// Each turn is recorded so that it can be replayed, see game.AiTurnRecord.
+// The seed for randN() is taken from math/rand, so seeding that still
+// decides what the ais do, but each turn's seed is kept so that any one turn
+// can be played again on its own.  Turns are only recorded while
+// game.AiRecording() is true, since it means gobbing the whole game every
+// time an ai is activated.
+
+// Writes out and reads back the globals that an ai's script keeps between
+// turns.  Anything that is already in _G when the baseline helper is called,
+// which is everything but the ai's own script, is the same in every lua
+// state and isn't recorded.  Only numbers, strings, booleans, entities and
+// tables of those are recorded, functions are defined again when the script
+// is loaded.  This runs before the sandbox so that it can keep loadstring,
+// and its helpers are kept the same way as the sandbox's.  Recorded globals
+// are read back with nothing but __ai_entity() in their environment.
+const ai_record = `
+do
+  local pairs = pairs
+  local type = type
+  local tostring = tostring
+  local getmetatable = getmetatable
+  local loadstring = loadstring
+  local setfenv = setfenv
+  local entity = __ai_entity
+  local keep = __ai_keep
+  local format = string.format
+  local sub = string.sub
+  local concat = table.concat
+  local huge = math.huge
+  local G = _G
+  local baseline = {}
+
+  local encode
+  encode = function(v, seen)
+    local t = type(v)
+    if t == "boolean" then
+      return tostring(v)
+    elseif t == "number" then
+      if v ~= v then
+        return "0/0"
+      elseif v == huge then
+        return "1/0"
+      elseif v == -huge then
+        return "-1/0"
+      end
+      return format("%.17g", v)
+    elseif t == "string" then
+      return format("%q", v)
+    elseif t ~= "table" or seen[v] then
+      return nil
+    end
+    if getmetatable(v) then
+      if v.type == "Entity" and type(v.id) == "number" then
+        return "__ai_entity(" .. v.id .. ")"
+      end
+      return nil
+    end
+    seen[v] = true
+    local fields = {}
+    for k, x in pairs(v) do
+      local ek = encode(k, seen)
+      local ex = encode(x, seen)
+      if ek and ex then
+        fields[#fields + 1] = "[" .. ek .. "]=" .. ex
+      end
+    end
+    seen[v] = nil
+    return "{" .. concat(fields, ",") .. "}"
+  end
+
+  keep("record_baseline", function()
+    for k in pairs(G) do
+      baseline[k] = true
+    end
+  end)
+
+  keep("save_globals", function()
+    local fields = {}
+    for k, v in pairs(G) do
+      if type(k) == "string" and not baseline[k] and sub(k, 1, 2) ~= "__" then
+        local ev = encode(v, {})
+        if ev then
+          fields[#fields + 1] = format("[%q]=%s", k, ev)
+        end
+      end
+    end
+    return "{" .. concat(fields, ",") .. "}"
+  end)
+
+  keep("load_globals", function(globals)
+    local f = loadstring("return " .. globals)
+    if not f then
+      return
+    end
+    setfenv(f, {__ai_entity = entity})
+    for k, v in pairs(f()) do
+      G[k] = v
+    end
+  end)
+end
+__ai_entity = nil
+`
+
+// Gets a's lua state ready to record its globals, this must be done after
+// addHelperContext() and before the sandbox is set up.
+func (a *Ai) addRecordContext() {
+  a.L.Register("__ai_entity", func(L *lua.State) int {
+    game.LuaPushEntity(L, a.game.EntityById(game.EntityId(L.ToInteger(-1))))
+    return 1
+  })
+  a.L.DoString(ai_record)
+}
+
+func (a *Ai) ReplayWith(from, rec *game.AiTurnRecord) {
+  a.replay_from = from
+  a.replay_to = rec
+}
+
+// Returns the ai's globals as lua source that loadGlobals() can read.
+func (a *Ai) saveGlobals() string {
+  a.L.SetExecutionLimit(ai_instruction_limit)
+  return a.callHelper("save_globals", 0)
+}
+
+func (a *Ai) loadGlobals(globals string) {
+  a.L.SetExecutionLimit(ai_instruction_limit)
+  a.L.PushString(globals)
+  a.callHelper("load_globals", 1)
+}
+
+// Called when the ai is activated, before it starts thinking.
+func (a *Ai) startRecord() {
+  seed := rand.Int63()
+  if from := a.replay_from; from != nil {
+    seed = from.Seed
+    if from.Globals != "" {
+      a.loadGlobals(from.Globals)
+    }
+  }
+  a.rand.Seed(seed)
+  if a.replay_to == nil && !game.AiRecording() {
+    a.record = nil
+    return
+  }
+  a.record = game.MakeAiTurnRecord(a.game, a.ent, a.kind, a.path, a.traceName(), seed)
+  a.record.Globals = a.saveGlobals()
+}
+
+// Called once the ai is done thinking for the turn.
+func (a *Ai) finishRecord() {
+  rec := a.record
+  a.record = nil
+  if rec == nil {
+    return
+  }
+  if a.replay_to != nil {
+    *a.replay_to = *rec
+    a.replay_from = nil
+    a.replay_to = nil
+    return
+  }
+  game.RecordAiTurn(rec)
+}
+
+// Records n as one of the random numbers used this turn and returns it.
+func (a *Ai) draw(n int) int {
+  if a.record != nil {
+    a.record.AddDraw(n)
+  }
+  return n
+}
@@ -0,0 +1,313 @@
+package ai
+
+import (
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game"
+  lua "github.com/xenith-studios/golua"
+  "regexp"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Limits on how much an ai can do each time it is asked to think.  The
+// instruction limit and the time limit are both needed since an ai can spend
+// a long time in Go functions, like pathing, without using many
+// instructions.  Time spent waiting for the game to execute actions doesn't
+// count against the time limit.
+const (
+  ai_instruction_limit = 2500000
+  ai_time_limit        = 5 * time.Second
+  ai_memory_limit_kb   = 64 * 1024
+)
+
+// Run right after the standard libraries are opened.  It sets up the
+// functions that load and call ai code with proper error reporting, then
+// removes everything from the standard library that an ai has no business
+// using.  The helpers are handed to __ai_keep(), which puts them in the
+// registry where ai code can't reach them, see callHelper().  They return
+// the error, if there was one, since DoString only tells us whether or not
+// something went wrong.
+const ai_sandbox = `
+do
+  local traceback = debug.traceback
+  local loadstring = loadstring
+  local xpcall = xpcall
+  local tostring = tostring
+  local type = type
+  local G = _G
+  local keep = __ai_keep
+  local debug_start = __ai_debug_start
+  local debug_stop = __ai_debug_stop
+
+  local function run(f)
+    local ok, err = xpcall(f, traceback)
+    if not ok then
+      return tostring(err)
+    end
+    return nil
+  end
+
+  keep("load", function(src, name)
+    local f, err = loadstring(src, name)
+    if not f then
+      return err
+    end
+    return run(f)
+  end)
+
+  keep("call", function(name, debugging, breakpoints)
+    local f = G[name]
+    if type(f) ~= "function" then
+      return name .. " is not a function."
+    end
+    debug_start(debugging, breakpoints)
+    local err = run(f)
+    debug_stop()
+    return err
+  end)
+end
+__ai_keep = nil
+__ai_debug_start = nil
+__ai_debug_stop = nil
+os = nil
+io = nil
+debug = nil
+package = nil
+require = nil
+module = nil
+dofile = nil
+loadfile = nil
+load = nil
+loadstring = nil
+collectgarbage = nil
+`
+
+// Prefix for the keys that sandbox helpers are kept under in the registry.
+const ai_helper_prefix = "haunts.ai."
+
+// Sets up __ai_keep() for the sandbox, and anything run before it, to hand
+// helpers to.  The sandbox removes it again before any ai code is loaded.
+func (a *Ai) addHelperContext() {
+  a.L.Register("__ai_keep", func(L *lua.State) int {
+    L.PushValue(2)
+    L.SetField(lua.LUA_REGISTRYINDEX, ai_helper_prefix+L.ToString(1))
+    return 0
+  })
+}
+
+// Calls the sandbox helper with the specified name, with the nargs values
+// on top of the stack, up to three of them, as its arguments.  Returns what
+// the helper returned, or "" if that wasn't a string.  The helper is only a
+// global for as long as it takes to pick it up again, so the ai never gets
+// to see it, and everything is passed with raw sets and gets so that it
+// doesn't matter what the ai has done to _G.
+func (a *Ai) callHelper(name string, nargs int) string {
+  a.L.NewTable()
+  a.L.Insert(-(nargs + 1))
+  for i := nargs; i > 0; i-- {
+    a.L.RawSeti(-(i + 1), i)
+  }
+  a.setRawGlobal("__ai_args")
+  a.L.GetField(lua.LUA_REGISTRYINDEX, ai_helper_prefix+name)
+  a.setRawGlobal("__ai_helper")
+  a.L.PushBoolean(false)
+  a.setRawGlobal("__ai_result")
+  a.L.DoString(`
+    local f, args = __ai_helper, __ai_args
+    __ai_helper = nil
+    __ai_args = nil
+    __ai_result = f(args[1], args[2], args[3])
+  `)
+  // The chunk may have been stopped before it got as far as clearing these.
+  for _, global := range []string{"__ai_helper", "__ai_args"} {
+    a.L.PushNil()
+    a.setRawGlobal(global)
+  }
+  a.L.PushString("__ai_result")
+  a.L.RawGet(lua.LUA_GLOBALSINDEX)
+  var res string
+  if a.L.IsString(-1) {
+    res = a.L.ToString(-1)
+  }
+  a.L.Pop(1)
+  a.L.PushNil()
+  a.setRawGlobal("__ai_result")
+  return res
+}
+
+// Pops the value on top of the stack into the global with the specified
+// name, without going through _G's metatable.
+func (a *Ai) setRawGlobal(name string) {
+  a.L.PushString(name)
+  a.L.Insert(-2)
+  a.L.RawSet(lua.LUA_GLOBALSINDEX)
+}
+
+// Tracks how much time an ai has spent thinking.  The clock only runs
+// between start() and stop(), and not while paused.
+type aiBudget struct {
+  mutex     sync.Mutex
+  remaining time.Duration
+  resumed   time.Time
+  timer     *time.Timer
+  expire    func()
+
+  // Set once the ai has gone over its time or memory limit, this is what
+  // gets reported instead of whatever lua says when the ai is stopped.
+  exceeded string
+}
+
+func (b *aiBudget) start(limit time.Duration, expire func()) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  b.remaining = limit
+  b.expire = expire
+  b.exceeded = ""
+  b.resumeLocked()
+}
+
+func (b *aiBudget) resumeLocked() {
+  b.resumed = time.Now()
+  b.timer = time.AfterFunc(b.remaining, func() {
+    b.mutex.Lock()
+    if b.exceeded == "" {
+      b.exceeded = fmt.Sprintf("Spent more than %v thinking.", ai_time_limit)
+    }
+    b.mutex.Unlock()
+    b.expire()
+  })
+}
+
+func (b *aiBudget) pause() {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if b.timer == nil {
+    return
+  }
+  b.timer.Stop()
+  b.timer = nil
+  b.remaining -= time.Since(b.resumed)
+}
+
+func (b *aiBudget) resume() {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if b.timer != nil || b.expire == nil {
+    return
+  }
+  b.resumeLocked()
+}
+
+// Stops the clock and returns the reason the ai went over its budget, or ""
+// if it didn't.
+func (b *aiBudget) stop() string {
+  b.pause()
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  b.expire = nil
+  exceeded := b.exceeded
+  b.exceeded = ""
+  return exceeded
+}
+
+func (b *aiBudget) exceed(msg string) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if b.exceeded == "" {
+    b.exceeded = msg
+  }
+}
+
+// Stops whatever lua is running on a.L as soon as it runs its next
+// instruction.  This only sets a hook, which lua allows from any thread, so
+// it is safe to call while the ai is running.
+func (a *Ai) abort() {
+  a.L.SetExecutionLimit(1)
+}
+
+// Sends exec to the game and waits for it to complete.  The ai's time budget
+// doesn't run while it waits.
+func (a *Ai) sendExec(exec game.ActionExec) {
+  a.budget.pause()
+  if a.record != nil {
+    a.record.AddExec(exec)
+  }
+  a.execs <- exec
+  <-a.pause
+  a.budget.resume()
+  a.checkMemory()
+}
+
+// Stops the ai if it is using more memory than it is allowed to.  Lua
+// doesn't let us limit memory as it is allocated, so this is checked
+// whenever the ai hands control back to the game.
+func (a *Ai) checkMemory() {
+  kb := a.L.GC(lua.LUA_GCCOUNT, 0)
+  if kb > ai_memory_limit_kb {
+    a.budget.exceed(fmt.Sprintf("Used %dKB of memory, only %dKB is allowed.", kb, ai_memory_limit_kb))
+    a.abort()
+  }
+}
+
+// Runs the chunk src, as loaded from path, in the ai's lua state.
+func (a *Ai) loadChunk(src, path string) {
+  a.L.PushString(src)
+  a.L.PushString("@" + path)
+  a.reportError(a.callHelper("load", 2), "")
+}
+
+// Calls the global lua function with the specified name, within the ai's
+// budgets.  If the debugger is on the instruction limit is replaced by the
+// debugger's hook, since lua can only have one.
+func (a *Ai) think(name string) {
+  a.L.SetExecutionLimit(ai_instruction_limit)
+  a.budget.start(ai_time_limit, a.abort)
+  a.L.PushString(name)
+  a.pushDebugger()
+  a.reportError(a.callHelper("call", 3), a.budget.stop())
+}
+
+// Matches the location that lua puts at the start of an error message.
+var lua_error_location = regexp.MustCompile(`^(.+?):(\d+): (.*)$`)
+
+// Reports msg, the error returned by one of the sandbox helpers, to the log
+// and the ai debug panel.  If exceeded is set it is used as the message,
+// since lua doesn't know why it was stopped, and it is reported even if lua
+// didn't return an error.
+func (a *Ai) reportError(msg, exceeded string) {
+  if msg == "" && exceeded == "" {
+    return
+  }
+
+  report := game.AiError{
+    Time: time.Now(),
+    Path: a.path,
+    Kind: a.kind,
+  }
+  if a.ent != nil {
+    report.Entity = a.ent.Name
+  }
+  lines := strings.SplitN(msg, "\n", 2)
+  report.Message = lines[0]
+  if len(lines) > 1 {
+    report.Traceback = lines[1]
+  }
+  if m := lua_error_location.FindStringSubmatch(report.Message); m != nil {
+    report.File = m[1]
+    fmt.Sscanf(m[2], "%d", &report.Line)
+    report.Message = m[3]
+  }
+  if exceeded != "" {
+    report.Message = exceeded
+  }
+  if a.record != nil {
+    a.record.Error = report.Message
+  }
+  game.ReportAiError(report)
+  base.Error().Printf("%v", &report)
+  if report.Traceback != "" {
+    base.Error().Printf("%s", report.Traceback)
+  }
+}
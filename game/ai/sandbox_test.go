@@ -0,0 +1,138 @@
+package ai
+
+import (
+  "fmt"
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  lua "github.com/xenith-studios/golua"
+  "strings"
+  "time"
+)
+
+// Makes an ai that runs prog in the sandbox, without a game or any of the
+// functions that the game normally gives it.
+func makeSandboxAi(prog string) *Ai {
+  return makeSandboxAiAt("sandbox.lua", prog)
+}
+
+// Same as makeSandboxAi, but prog is loaded as though it came from path.
+func makeSandboxAiAt(path, prog string) *Ai {
+  a := &Ai{path: path, kind: game.EntityAi}
+  a.L = lua.NewState()
+  a.L.OpenLibs()
+  a.addHelperContext()
+  a.L.Register("__ai_break", a.breakFunc())
+  a.L.DoString(ai_debugger)
+  a.L.DoString(ai_sandbox)
+  a.loadChunk(prog, a.path)
+  return a
+}
+
+// Calls Think the way think() does, but within whatever budget the spec has
+// started, and returns what the sandbox had to say about it.
+func callThink(a *Ai) string {
+  a.L.PushString("Think")
+  a.L.PushBoolean(false)
+  a.L.PushNil()
+  return a.callHelper("call", 3)
+}
+
+func SandboxSpec(c gospec.Context) {
+  game.ClearAiErrors()
+
+  c.Specify("Lua errors are reported with where they happened.", func() {
+    a := makeSandboxAi("function Think()\n  error(\"oops\")\nend")
+    defer a.L.Close()
+    a.think("Think")
+    errs := game.AiErrors()
+    c.Assume(len(errs), Equals, 1)
+    c.Expect(errs[0].Message, Equals, "oops")
+    c.Expect(errs[0].File, Equals, "sandbox.lua")
+    c.Expect(errs[0].Line, Equals, 2)
+  })
+
+  c.Specify("Ais that think without any trouble aren't reported.", func() {
+    a := makeSandboxAi("function Think() end")
+    defer a.L.Close()
+    a.think("Think")
+    c.Expect(len(game.AiErrors()), Equals, 0)
+  })
+
+  c.Specify("Ais that go over budget are reported even if lua has nothing to say.", func() {
+    a := makeSandboxAi("function Think() end")
+    defer a.L.Close()
+    a.budget.start(time.Minute, func() {})
+    msg := callThink(a)
+    a.budget.exceed("Used too much memory.")
+    a.reportError(msg, a.budget.stop())
+    errs := game.AiErrors()
+    c.Assume(len(errs), Equals, 1)
+    c.Expect(errs[0].Message, Equals, "Used too much memory.")
+  })
+
+  c.Specify("Ais that think for too long are stopped and reported.", func() {
+    a := makeSandboxAi("function Think()\n  while true do end\nend")
+    defer a.L.Close()
+    a.budget.start(10*time.Millisecond, a.abort)
+    a.reportError(callThink(a), a.budget.stop())
+    errs := game.AiErrors()
+    c.Assume(len(errs), Equals, 1)
+    c.Expect(errs[0].Message, Equals, fmt.Sprintf("Spent more than %v thinking.", ai_time_limit))
+  })
+  c.Specify("Ais can't get at the sandbox's helpers.", func() {
+    a := makeSandboxAi(`
+      function Think()
+        for _, name in ipairs({"__ai_keep", "__ai_helper", "__ai_args", "__ai_break", "__ai_debug_start", "__ai_debug_stop"}) do
+          if _G[name] ~= nil then
+            error(name .. " is reachable")
+          end
+        end
+      end`)
+    defer a.L.Close()
+    a.think("Think")
+    c.Expect(len(game.AiErrors()), Equals, 0)
+  })
+
+  c.Specify("Ais can't intercept the sandbox's helpers by watching _G.", func() {
+    a := makeSandboxAi(`
+      seen = {}
+      function Think()
+        setmetatable(_G, {
+          __newindex = function(t, k, v)
+            seen[#seen + 1] = v
+            rawset(t, k, v)
+          end,
+        })
+      end
+      function Check()
+        for _, v in ipairs(seen) do
+          if type(v) == "function" then
+            error("got a helper")
+          end
+        end
+      end`)
+    defer a.L.Close()
+    a.think("Think")
+    a.think("Think")
+    a.think("Check")
+    c.Expect(len(game.AiErrors()), Equals, 0)
+  })
+
+  c.Specify("Ais that replace helpers still have to stay within their memory.", func() {
+    a := makeSandboxAi(`
+      function Think()
+        __ai_memory = function() __ai_memory_kb = 0 end
+        __ai_call = function() end
+        hoard = string.rep("x", 65 * 1024 * 1024)
+      end`)
+    defer a.L.Close()
+    a.budget.start(time.Minute, func() {})
+    msg := callThink(a)
+    a.checkMemory()
+    a.reportError(msg, a.budget.stop())
+    errs := game.AiErrors()
+    c.Assume(len(errs), Equals, 1)
+    c.Expect(strings.HasPrefix(errs[0].Message, "Used "), Equals, true)
+  })
+}
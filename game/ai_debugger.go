@@ -0,0 +1,271 @@
+package game
+
+import (
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "io"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// The ai debugger lets designers stop an ai at a breakpoint, step through
+// it, and look at its locals and its entity.  It also keeps a trace of every
+// Do* call made by each entity.  The ai package does the actual work of
+// stopping, this is just the state that it shares with the ui.
+//
+// Breakpoints are given by file, relative to the data directory, e.g.
+// "ais/technician.lua", and line.  They can also be listed in
+// datadir/ai_breakpoints.json, which is a map from file to a list of lines
+// and is re-read whenever debugging is turned on.  Ais can also call
+// Breakpoint() to stop on the following line.
+
+// What to do after an ai has stopped at a breakpoint.
+type AiDebugCommand string
+
+const (
+  AiContinue AiDebugCommand = "continue"
+  AiStep     AiDebugCommand = "step"
+  AiStepOver AiDebugCommand = "next"
+  AiStepOut  AiDebugCommand = "out"
+)
+
+type AiVar struct {
+  Name  string
+  Value string
+}
+
+// Everything the debugger knows about an ai that is stopped.
+type AiBreak struct {
+  // Path to the ai script, and the entity running it, if any.
+  Path   string
+  Entity string
+
+  // Where the ai is stopped.
+  File     string
+  Line     int
+  Function string
+
+  // Locals of the function the ai is stopped in, in the order they were
+  // declared.
+  Locals []AiVar
+
+  // What the ai would see if it looked at Me.
+  Context []AiVar
+}
+
+// One call an ai made to one of the Do* functions.
+type AiTraceEntry struct {
+  Time   time.Time
+  Round  int
+  Call   string
+  Args   []string
+  Result []string
+}
+
+func (e AiTraceEntry) String() string {
+  return fmt.Sprintf("%s round %d: Do.%s(%s) -> %s", e.Time.Format("15:04:05"), e.Round, e.Call, strings.Join(e.Args, ", "), strings.Join(e.Result, ", "))
+}
+
+// Only this many of the most recent calls are kept for each entity.
+const max_ai_trace = 200
+
+var ai_debugger struct {
+  sync.Mutex
+  enabled     bool
+  breakpoints map[string]map[int]bool
+
+  paused *AiBreak
+  resume chan AiDebugCommand
+
+  traces map[string][]AiTraceEntry
+}
+
+func init() {
+  ai_debugger.breakpoints = make(map[string]map[int]bool)
+  ai_debugger.traces = make(map[string][]AiTraceEntry)
+  ai_debugger.resume = make(chan AiDebugCommand)
+}
+
+// Turns the debugger on or off.  Breakpoints are ignored while it is off,
+// and turning it off lets any stopped ai continue.  Turning it on reloads
+// datadir/ai_breakpoints.json if it exists.
+func SetAiDebugging(on bool) {
+  if on {
+    path := filepath.Join(base.GetDataDir(), "ai_breakpoints.json")
+    if _, err := os.Stat(path); err == nil {
+      err := LoadAiBreakpoints(path)
+      if err != nil {
+        base.Warn().Printf("Unable to load ai breakpoints: %v", err)
+      }
+    }
+  }
+  ai_debugger.Lock()
+  ai_debugger.enabled = on
+  paused := ai_debugger.paused != nil
+  ai_debugger.Unlock()
+  if !on && paused {
+    ResumeAi(AiContinue)
+  }
+}
+
+func AiDebugging() bool {
+  ai_debugger.Lock()
+  defer ai_debugger.Unlock()
+  return ai_debugger.enabled
+}
+
+func aiBreakpointKey(file string) string {
+  return filepath.ToSlash(filepath.Clean(file))
+}
+
+// Sets or clears a breakpoint.  file is relative to the data directory.
+func SetAiBreakpoint(file string, line int, on bool) {
+  ai_debugger.Lock()
+  defer ai_debugger.Unlock()
+  file = aiBreakpointKey(file)
+  if on {
+    if ai_debugger.breakpoints[file] == nil {
+      ai_debugger.breakpoints[file] = make(map[int]bool)
+    }
+    ai_debugger.breakpoints[file][line] = true
+  } else {
+    delete(ai_debugger.breakpoints[file], line)
+    if len(ai_debugger.breakpoints[file]) == 0 {
+      delete(ai_debugger.breakpoints, file)
+    }
+  }
+}
+
+// Returns a map from file, relative to the data directory, to the sorted
+// lines that have breakpoints in that file.
+func AiBreakpoints() map[string][]int {
+  ai_debugger.Lock()
+  defer ai_debugger.Unlock()
+  bps := make(map[string][]int)
+  for file, lines := range ai_debugger.breakpoints {
+    for line := range lines {
+      bps[file] = append(bps[file], line)
+    }
+    sort.Ints(bps[file])
+  }
+  return bps
+}
+
+// Replaces all breakpoints with those in the specified json file.
+func LoadAiBreakpoints(path string) error {
+  var bps map[string][]int
+  err := base.LoadJson(path, &bps)
+  if err != nil {
+    return err
+  }
+  ai_debugger.Lock()
+  ai_debugger.breakpoints = make(map[string]map[int]bool)
+  ai_debugger.Unlock()
+  for file, lines := range bps {
+    for _, line := range lines {
+      SetAiBreakpoint(file, line, true)
+    }
+  }
+  return nil
+}
+
+// Called by an ai that has hit a breakpoint.  Blocks until the ai is told
+// what to do next with ResumeAi().  If debugging is off this returns
+// AiContinue right away.
+func AiBreakpointHit(b AiBreak) AiDebugCommand {
+  ai_debugger.Lock()
+  if !ai_debugger.enabled {
+    ai_debugger.Unlock()
+    return AiContinue
+  }
+  ai_debugger.paused = &b
+  ai_debugger.Unlock()
+  base.Log().Printf("Ai stopped at %s:%d", b.File, b.Line)
+  cmd := <-ai_debugger.resume
+  ai_debugger.Lock()
+  ai_debugger.paused = nil
+  ai_debugger.Unlock()
+  return cmd
+}
+
+// Returns the ai that is stopped at a breakpoint, or nil if none are.
+func PausedAi() *AiBreak {
+  ai_debugger.Lock()
+  defer ai_debugger.Unlock()
+  return ai_debugger.paused
+}
+
+// Tells the stopped ai what to do next.  Does nothing if no ai is stopped.
+func ResumeAi(cmd AiDebugCommand) {
+  if PausedAi() == nil {
+    return
+  }
+  ai_debugger.resume <- cmd
+}
+
+// Adds a call to entity's trace.
+func RecordAiCall(entity string, entry AiTraceEntry) {
+  ai_debugger.Lock()
+  defer ai_debugger.Unlock()
+  trace := append(ai_debugger.traces[entity], entry)
+  if len(trace) > max_ai_trace {
+    trace = trace[len(trace)-max_ai_trace:]
+  }
+  ai_debugger.traces[entity] = trace
+}
+
+// Returns the names of all of the entities that have a trace, sorted.
+func AiTracedEntities() []string {
+  ai_debugger.Lock()
+  defer ai_debugger.Unlock()
+  var names []string
+  for name := range ai_debugger.traces {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// Returns entity's trace, oldest call first.
+func AiTrace(entity string) []AiTraceEntry {
+  ai_debugger.Lock()
+  defer ai_debugger.Unlock()
+  return append([]AiTraceEntry(nil), ai_debugger.traces[entity]...)
+}
+
+// Writes the traces of every entity to w.
+func WriteAiTraces(w io.Writer) error {
+  for _, name := range AiTracedEntities() {
+    _, err := fmt.Fprintf(w, "%s:\n", name)
+    if err != nil {
+      return err
+    }
+    for _, entry := range AiTrace(name) {
+      _, err = fmt.Fprintf(w, "  %v\n", entry)
+      if err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+// Writes the traces of every entity to a new file in datadir/logs and
+// returns its path.
+func DumpAiTraces() (string, error) {
+  if len(AiTracedEntities()) == 0 {
+    return "", errors.New("No ais have done anything yet.")
+  }
+  path := filepath.Join(base.GetDataDir(), "logs", "ai-trace-"+time.Now().Format("2006-01-02-15-04-05")+".txt")
+  f, err := os.Create(path)
+  if err != nil {
+    return "", err
+  }
+  defer f.Close()
+  return path, WriteAiTraces(f)
+}
@@ -0,0 +1,103 @@
+package game_test
+
+import (
+  "bytes"
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "io/ioutil"
+  "os"
+  "strings"
+  "time"
+)
+
+// Waits for an ai to stop at a breakpoint, returns nil if none do.
+func waitForPausedAi() *game.AiBreak {
+  for i := 0; i < 500; i++ {
+    if b := game.PausedAi(); b != nil {
+      return b
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+  return nil
+}
+
+func AiDebuggerSpec(c gospec.Context) {
+  c.Specify("Breakpoints are kept by clean path in line order.", func() {
+    game.SetAiBreakpoint("ais/spec/../debug.lua", 7, true)
+    game.SetAiBreakpoint("ais/debug.lua", 3, true)
+    c.Expect(game.AiBreakpoints()["ais/debug.lua"], ContainsInOrder, Values(3, 7))
+    game.SetAiBreakpoint("ais/debug.lua", 7, false)
+    game.SetAiBreakpoint("ais/debug.lua", 3, false)
+    _, ok := game.AiBreakpoints()["ais/debug.lua"]
+    c.Expect(ok, Equals, false)
+  })
+
+  c.Specify("Loading breakpoints replaces the ones that were set.", func() {
+    game.SetAiBreakpoint("ais/old.lua", 1, true)
+    f, err := ioutil.TempFile("", "ai_breakpoints")
+    c.Assume(err, Equals, nil)
+    defer os.Remove(f.Name())
+    f.WriteString(`{"ais/new.lua": [5, 2]}`)
+    f.Close()
+    c.Assume(game.LoadAiBreakpoints(f.Name()), Equals, nil)
+    bps := game.AiBreakpoints()
+    c.Expect(len(bps), Equals, 1)
+    c.Expect(bps["ais/new.lua"], ContainsInOrder, Values(2, 5))
+    game.SetAiBreakpoint("ais/new.lua", 2, false)
+    game.SetAiBreakpoint("ais/new.lua", 5, false)
+  })
+
+  c.Specify("Ais only stop while debugging is on.", func() {
+    game.SetAiDebugging(false)
+    c.Expect(game.AiBreakpointHit(game.AiBreak{Line: 1}), Equals, game.AiContinue)
+    c.Expect(game.PausedAi() == nil, Equals, true)
+  })
+
+  c.Specify("Stopped ais wait for a command.", func() {
+    game.SetAiDebugging(true)
+    defer game.SetAiDebugging(false)
+    cmd := make(chan game.AiDebugCommand, 1)
+    go func() {
+      cmd <- game.AiBreakpointHit(game.AiBreak{File: "ais/debug.lua", Line: 4})
+    }()
+    b := waitForPausedAi()
+    c.Assume(b == nil, Equals, false)
+    c.Expect(b.Line, Equals, 4)
+    game.ResumeAi(game.AiStepOver)
+    c.Expect(<-cmd, Equals, game.AiStepOver)
+    c.Expect(game.PausedAi() == nil, Equals, true)
+  })
+
+  c.Specify("Turning debugging off lets a stopped ai continue.", func() {
+    game.SetAiDebugging(true)
+    cmd := make(chan game.AiDebugCommand, 1)
+    go func() {
+      cmd <- game.AiBreakpointHit(game.AiBreak{File: "ais/debug.lua", Line: 4})
+    }()
+    c.Assume(waitForPausedAi() == nil, Equals, false)
+    game.SetAiDebugging(false)
+    c.Expect(<-cmd, Equals, game.AiContinue)
+  })
+
+  c.Specify("Traces only keep the most recent calls.", func() {
+    name := "Trace spec (1)"
+    for i := 0; i < game.MaxAiTrace+5; i++ {
+      game.RecordAiCall(name, game.AiTraceEntry{Round: i, Call: "Move"})
+    }
+    trace := game.AiTrace(name)
+    c.Assume(len(trace), Equals, game.MaxAiTrace)
+    c.Expect(trace[0].Round, Equals, 5)
+    c.Expect(trace[len(trace)-1].Round, Equals, game.MaxAiTrace+4)
+  })
+
+  c.Specify("Traces are written out under their entity.", func() {
+    name := "Trace spec (2)"
+    entry := game.AiTraceEntry{Round: 3, Call: "Attack", Args: []string{"Entity(Teen)"}, Result: []string{"true"}}
+    game.RecordAiCall(name, entry)
+    var buf bytes.Buffer
+    c.Assume(game.WriteAiTraces(&buf), Equals, nil)
+    c.Expect(strings.Contains(buf.String(), name+":\n  "+entry.String()+"\n"), Equals, true)
+    c.Expect(strings.Contains(entry.String(), "Do.Attack(Entity(Teen)) -> true"), Equals, true)
+  })
+}
@@ -0,0 +1,86 @@
+package game
+
+import (
+  "fmt"
+  "sync"
+  "time"
+)
+
+func (k AiKind) String() string {
+  switch k {
+  case EntityAi:
+    return "entity"
+  case MinionsAi:
+    return "minions"
+  case DenizensAi:
+    return "denizens"
+  case IntrudersAi:
+    return "intruders"
+  }
+  return "unknown"
+}
+
+// An AiError describes something that went wrong while running an ai script,
+// either a lua error or the ai going over one of its budgets.  Whatever the
+// ai was doing is abandoned, and if it was thinking its turn is over.
+type AiError struct {
+  Time time.Time
+
+  // Path to the ai script.
+  Path string
+
+  // File and line that the error happened on, if known.  File is usually
+  // Path, but might be one of the utils files that the ai loaded.
+  File string
+  Line int
+
+  Message   string
+  Traceback string
+
+  Kind AiKind
+
+  // Name of the entity that was running the ai, only set for entity ais.
+  Entity string
+}
+
+func (e *AiError) Error() string {
+  who := e.Kind.String()
+  if e.Entity != "" {
+    who = e.Entity
+  }
+  if e.File != "" {
+    return fmt.Sprintf("Ai error (%s) at %s:%d: %s", who, e.File, e.Line, e.Message)
+  }
+  return fmt.Sprintf("Ai error (%s) in %s: %s", who, e.Path, e.Message)
+}
+
+// Only this many of the most recent errors are kept.
+const max_ai_errors = 50
+
+var ai_errors struct {
+  sync.Mutex
+  errs []AiError
+}
+
+// Records err so that it can be shown in the ai debug panel.
+func ReportAiError(err AiError) {
+  ai_errors.Lock()
+  defer ai_errors.Unlock()
+  ai_errors.errs = append(ai_errors.errs, err)
+  if len(ai_errors.errs) > max_ai_errors {
+    ai_errors.errs = ai_errors.errs[len(ai_errors.errs)-max_ai_errors:]
+  }
+}
+
+// Returns the most recent ai errors, oldest first.
+func AiErrors() []AiError {
+  ai_errors.Lock()
+  defer ai_errors.Unlock()
+  return append([]AiError(nil), ai_errors.errs...)
+}
+
+func ClearAiErrors() {
+  ai_errors.Lock()
+  defer ai_errors.Unlock()
+  ai_errors.errs = nil
+}
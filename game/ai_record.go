@@ -0,0 +1,308 @@
+package game
+
+import (
+  "bytes"
+  "crypto/sha1"
+  "encoding/gob"
+  "errors"
+  "fmt"
+  "github.com/mik3cap/glop/sprite"
+  "github.com/mik3cap/haunts/base"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sync"
+  "time"
+)
+
+// Every turn that an ai takes is recorded along with everything it depends
+// on, so that a turn that went wrong can be written to a file and played
+// back exactly, through the same script, by whoever is fixing it.  Only the
+// most recent turn of each ai is kept.
+//
+// A turn is one activation of an ai, from Activate() until it sends a nil
+// ActionExec.  Given the same game state and the same random numbers an ai
+// always makes the same decisions, so a turn only needs to record those,
+// plus what the ai did so that a replay can be checked against it.
+
+// Everything needed to replay one ai turn.
+type AiTurnRecord struct {
+  // Path to the ai, relative to the data directory if it is in it.
+  Path string
+  Kind AiKind
+
+  // The entity the ai was running, 0 for master ais.
+  Entity EntityId
+
+  // The name the ai goes by in traces, see RecordAiCall().
+  Name string
+
+  Round int
+  Time  time.Time
+
+  // The game when the ai was activated, as made by Game.GobEncode(), and
+  // its sha1 so that a damaged record is noticed before it is replayed.
+  State      []byte
+  State_hash string
+
+  // The ai's own random numbers, like randN(), come from this seed.  Draws
+  // are all of the random numbers that the ai asked for, in order,
+  // including those that came from the game's Rand.
+  Seed  int64
+  Draws []int
+
+  // The globals that the ai's script kept from earlier turns, as lua source,
+  // so that a replay starts out knowing the same things.
+  Globals string
+
+  // Every ActionExec the ai sent, gobbed.
+  Execs [][]byte
+
+  // The error that ended the turn, if there was one.
+  Error string
+}
+
+// Ais whose turns can be recorded and replayed implement this.
+type ReplayableAi interface {
+  Ai
+
+  // The next turn the ai takes starts with the random seed and globals that
+  // from was recorded with, and is recorded into rec instead of being kept
+  // as the ai's most recent turn.
+  ReplayWith(from, rec *AiTurnRecord)
+}
+
+const ai_turn_save_version = 5
+
+var ai_turn_save_format = base.MakeSaveFormat("ai-turn", ai_turn_save_version,
+  AiTurnRecord{}, gameDataGobbable{}, []sprite.SpriteState{})
+
+// Starts a record of the turn that an ai is about to take.  ent is nil for
+// master ais.
+func MakeAiTurnRecord(g *Game, ent *Entity, kind AiKind, path, name string, seed int64) *AiTurnRecord {
+  rec := &AiTurnRecord{
+    Path:  path,
+    Kind:  kind,
+    Name:  name,
+    Round: (g.Turn + 1) / 2,
+    Time:  time.Now(),
+    Seed:  seed,
+  }
+  if rel, err := filepath.Rel(base.GetDataDir(), path); err == nil {
+    rec.Path = filepath.ToSlash(rel)
+  }
+  if ent != nil {
+    rec.Entity = ent.Id
+  }
+  state, err := g.GobEncode()
+  if err != nil {
+    rec.Error = fmt.Sprintf("Unable to record the game: %v", err)
+    return rec
+  }
+  rec.State = state
+  rec.State_hash = stateHash(state)
+  return rec
+}
+
+func stateHash(state []byte) string {
+  return fmt.Sprintf("%x", sha1.Sum(state))
+}
+
+func (rec *AiTurnRecord) AddDraw(n int) {
+  rec.Draws = append(rec.Draws, n)
+}
+
+func (rec *AiTurnRecord) AddExec(exec ActionExec) {
+  rec.Execs = append(rec.Execs, encodeActionExec(exec))
+}
+
+var ai_turns struct {
+  sync.Mutex
+  recent    map[string]*AiTurnRecord
+  recording bool
+}
+
+func init() {
+  ai_turns.recent = make(map[string]*AiTurnRecord)
+
+  // Version 2 added Entity.Readied to the recorded game.
+  ai_turn_save_format.AddMigration(1, sameSavePayload)
+
+  // Version 3 added Game.Combat_log to the recorded game.
+  ai_turn_save_format.AddMigration(2, sameSavePayload)
+
+  // Version 4 added AiTurnRecord.Globals, turns recorded before then start
+  // from a freshly loaded script when they are replayed.
+  ai_turn_save_format.AddMigration(3, sameSavePayload)
+
+  // Version 5 added Game.Winner to the recorded game.
+  ai_turn_save_format.AddMigration(4, sameSavePayload)
+}
+
+// Turns recording on or off.  Recording an ai's turn means gobbing the whole
+// game when it is activated, so turns are only recorded while this is on or
+// while the ai debugger is on.
+func SetAiRecording(on bool) {
+  ai_turns.Lock()
+  defer ai_turns.Unlock()
+  ai_turns.recording = on
+}
+
+func AiRecording() bool {
+  ai_turns.Lock()
+  recording := ai_turns.recording
+  ai_turns.Unlock()
+  return recording || AiDebugging()
+}
+
+// Keeps rec as the most recent turn of the ai that it names.
+func RecordAiTurn(rec *AiTurnRecord) {
+  ai_turns.Lock()
+  defer ai_turns.Unlock()
+  ai_turns.recent[rec.Name] = rec
+}
+
+// Returns the most recent turn of the named ai, or nil if it hasn't taken
+// one.
+func LastAiTurn(name string) *AiTurnRecord {
+  ai_turns.Lock()
+  defer ai_turns.Unlock()
+  return ai_turns.recent[name]
+}
+
+func WriteAiTurnRecord(w io.Writer, rec *AiTurnRecord) error {
+  buf := bytes.NewBuffer(nil)
+  err := gob.NewEncoder(buf).Encode(rec)
+  if err != nil {
+    return err
+  }
+  data, err := ai_turn_save_format.Encode(buf.Bytes())
+  if err != nil {
+    return err
+  }
+  _, err = w.Write(data)
+  return err
+}
+
+func ReadAiTurnRecord(r io.Reader) (*AiTurnRecord, error) {
+  data, err := ioutil.ReadAll(r)
+  if err != nil {
+    return nil, err
+  }
+  payload, err := ai_turn_save_format.Decode(data)
+  if err != nil {
+    return nil, err
+  }
+  var rec AiTurnRecord
+  err = gob.NewDecoder(bytes.NewBuffer(payload)).Decode(&rec)
+  if err != nil {
+    return nil, err
+  }
+  if stateHash(rec.State) != rec.State_hash {
+    return nil, errors.New("The recorded game state is damaged.")
+  }
+  return &rec, nil
+}
+
+// Writes the most recent turn of the named ai to a new file in
+// datadir/logs and returns its path.
+func DumpAiTurn(name string) (string, error) {
+  rec := LastAiTurn(name)
+  if rec == nil {
+    return "", errors.New(fmt.Sprintf("%s hasn't taken a turn since recording was turned on.", name))
+  }
+  path := filepath.Join(base.GetDataDir(), "logs", "ai-turn-"+time.Now().Format("2006-01-02-15-04-05")+".turn")
+  f, err := os.Create(path)
+  if err != nil {
+    return "", err
+  }
+  defer f.Close()
+  return path, WriteAiTurnRecord(f, rec)
+}
+
+// Plays rec back through the same ai script, starting from the same game
+// and with the same random numbers, and returns the record of the replay.
+// The ActionExecs that the ai sends are run as they would be in the game.
+// Only entity ais can be replayed, since master ais work by running the
+// entity ais, whose turns are recorded separately.  Nothing is drawn, so
+// this should only be run headless.
+func ReplayAiTurn(rec *AiTurnRecord) (*AiTurnRecord, error) {
+  if rec.Kind != EntityAi {
+    return nil, errors.New(fmt.Sprintf("%s is a master ai, replay the turns of its entities instead.", rec.Name))
+  }
+  if rec.State == nil {
+    return nil, errors.New("The game state wasn't recorded.")
+  }
+  var g Game
+  g.forked = true
+  err := g.GobDecode(rec.State)
+  if err != nil {
+    return nil, err
+  }
+  ent := g.EntityById(rec.Entity)
+  if ent == nil {
+    return nil, errors.New(fmt.Sprintf("There is no entity with id %d.", rec.Entity))
+  }
+  path := filepath.FromSlash(rec.Path)
+  if !filepath.IsAbs(path) {
+    path = filepath.Join(base.GetDataDir(), path)
+  }
+  var ai Ai
+  makeAi(path, &g, ent, &ai, EntityAi)
+  if ai == nil {
+    return nil, errors.New(fmt.Sprintf("Unable to make the ai '%s'.", rec.Path))
+  }
+  defer ai.Terminate()
+  replayable, ok := ai.(ReplayableAi)
+  if !ok {
+    return nil, errors.New(fmt.Sprintf("The ai '%s' can't be replayed.", rec.Path))
+  }
+  ent.Ai = ai
+
+  var replay AiTurnRecord
+  replayable.ReplayWith(rec, &replay)
+  ai.Activate()
+  for {
+    exec := <-ai.ActionExecs()
+    if exec == nil {
+      break
+    }
+    err := replayExec(&g, ent.Side(), exec)
+    if err != nil {
+      return &replay, err
+    }
+  }
+  return &replay, nil
+}
+
+// Returns an error describing the first place where replay did something
+// different from rec, or nil if they did the same things.
+func CompareAiTurns(rec, replay *AiTurnRecord) error {
+  for i := range rec.Draws {
+    if i >= len(replay.Draws) {
+      return errors.New(fmt.Sprintf("The replay only drew %d random numbers, not %d.", len(replay.Draws), len(rec.Draws)))
+    }
+    if rec.Draws[i] != replay.Draws[i] {
+      return errors.New(fmt.Sprintf("Random number %d was %d, not %d.", i+1, replay.Draws[i], rec.Draws[i]))
+    }
+  }
+  if len(replay.Draws) > len(rec.Draws) {
+    return errors.New(fmt.Sprintf("The replay drew %d random numbers, not %d.", len(replay.Draws), len(rec.Draws)))
+  }
+  for i := range rec.Execs {
+    if i >= len(replay.Execs) {
+      return errors.New(fmt.Sprintf("The replay only did %d actions, not %d.", len(replay.Execs), len(rec.Execs)))
+    }
+    if !bytes.Equal(rec.Execs[i], replay.Execs[i]) {
+      return errors.New(fmt.Sprintf("Action %d was different.", i+1))
+    }
+  }
+  if len(replay.Execs) > len(rec.Execs) {
+    return errors.New(fmt.Sprintf("The replay did %d actions, not %d.", len(replay.Execs), len(rec.Execs)))
+  }
+  if rec.Error != replay.Error {
+    return errors.New(fmt.Sprintf("The replay ended with error '%s', not '%s'.", replay.Error, rec.Error))
+  }
+  return nil
+}
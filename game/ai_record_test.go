@@ -0,0 +1,123 @@
+package game_test
+
+import (
+  "bytes"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+)
+
+// Moves one cell further east every turn, so each turn depends on a global
+// left over from the turns before it.
+const record_test_ai = `
+moves = 0
+function Think()
+  moves = moves + 1
+  randN(6)
+  Do.Move({{X = 47 + moves, Y = 25}}, 10)
+end
+`
+
+func AiRecordSpec(c gospec.Context) {
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+  ent, err := s.Spawn("Technician", 47, 25)
+  c.Assume(err, Equals, nil)
+
+  rec := game.MakeAiTurnRecord(s.Game, ent, game.EntityAi, "ais/technician.lua", "Technician", 7)
+  rec.AddDraw(3)
+  rec.AddExec(game.BasicActionExec{Ent: ent.Id, Index: 0})
+
+  c.Specify("Turns record the game and who took them.", func() {
+    c.Expect(rec.Entity, Equals, ent.Id)
+    c.Expect(rec.State_hash, Not(Equals), "")
+    c.Expect(rec.Error, Equals, "")
+  })
+
+  c.Specify("Turns can be written and read back.", func() {
+    buf := bytes.NewBuffer(nil)
+    c.Assume(game.WriteAiTurnRecord(buf, rec), Equals, nil)
+    read, err := game.ReadAiTurnRecord(buf)
+    c.Assume(err, Equals, nil)
+    c.Expect(read.Seed, Equals, int64(7))
+    c.Expect(read.State_hash, Equals, rec.State_hash)
+    c.Expect(game.CompareAiTurns(rec, read), Equals, nil)
+  })
+
+  c.Specify("Damaged game states are noticed.", func() {
+    damaged := *rec
+    damaged.State = append([]byte{}, rec.State...)
+    damaged.State[len(damaged.State)/2]++
+    buf := bytes.NewBuffer(nil)
+    c.Assume(game.WriteAiTurnRecord(buf, &damaged), Equals, nil)
+    _, err := game.ReadAiTurnRecord(buf)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Replays that do something different are reported.", func() {
+    other := *rec
+    other.Draws = []int{4}
+    c.Expect(game.CompareAiTurns(rec, &other), Not(Equals), nil)
+    other.Draws = rec.Draws
+    other.Execs = nil
+    c.Expect(game.CompareAiTurns(rec, &other), Not(Equals), nil)
+  })
+
+  c.Specify("Master ai turns can't be replayed.", func() {
+    master := game.MakeAiTurnRecord(s.Game, nil, game.DenizensAi, "ais/denizens.lua", "DenizensAi", 7)
+    c.Expect(master.Entity, Equals, game.EntityId(0))
+    _, err := game.ReplayAiTurn(master)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Recorded turns play back the same way, globals and all.", func() {
+    dir, err := ioutil.TempDir("", "ai-record")
+    c.Assume(err, Equals, nil)
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "record.lua")
+    c.Assume(ioutil.WriteFile(path, []byte(record_test_ai), 0644), Equals, nil)
+    game.SetAiRecording(true)
+    defer game.SetAiRecording(false)
+
+    s.EndTurn()
+    ent.Ai_file_override = base.Path(path)
+    ent.LoadAi()
+    defer ent.Ai.Terminate()
+    takeTurn := func() {
+      ent.Ai.Activate()
+      for exec := <-ent.Ai.ActionExecs(); exec != nil; exec = <-ent.Ai.ActionExecs() {
+        c.Assume(s.Exec(exec), Equals, nil)
+      }
+    }
+    takeTurn()
+    takeTurn()
+    x, y := ent.Pos()
+    c.Assume(x, Equals, 49)
+    c.Assume(y, Equals, 25)
+
+    rec := game.LastAiTurn(fmt.Sprintf("%s (%d)", ent.Name, ent.Id))
+    c.Assume(rec, Not(Equals), (*game.AiTurnRecord)(nil))
+    c.Expect(len(rec.Draws), Equals, 1)
+    c.Expect(len(rec.Execs), Equals, 1)
+    c.Expect(rec.Globals, Not(Equals), "")
+    replay, err := game.ReplayAiTurn(rec)
+    c.Assume(err, Equals, nil)
+    c.Expect(game.CompareAiTurns(rec, replay), Equals, nil)
+
+    fresh := *rec
+    fresh.Globals = ""
+    replay, err = game.ReplayAiTurn(&fresh)
+    c.Assume(err, Equals, nil)
+    c.Expect(game.CompareAiTurns(rec, replay), Not(Equals), nil)
+  })
+
+  c.Specify("Turns aren't recorded unless someone wants them.", func() {
+    game.SetAiRecording(false)
+    c.Expect(game.AiRecording(), Equals, game.AiDebugging())
+  })
+}
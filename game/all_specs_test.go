@@ -0,0 +1,25 @@
+package game_test
+
+import (
+  "github.com/orfjackal/gospec/src/gospec"
+  "testing"
+)
+
+func TestAllSpecs(t *testing.T) {
+  r := gospec.NewRunner()
+  r.AddSpec(SimSpec)
+  r.AddSpec(SaveSlotSpec)
+  r.AddSpec(NativeAiSpec)
+  r.AddSpec(DifficultySpec)
+  r.AddSpec(TournamentSpec)
+  r.AddSpec(BlackboardSpec)
+  r.AddSpec(InfluenceSpec)
+  r.AddSpec(AiRecordSpec)
+  r.AddSpec(ReactionSpec)
+  r.AddSpec(CombatLogSpec)
+  r.AddSpec(NetSpec)
+  r.AddSpec(VerifySpec)
+  r.AddSpec(ReplaySpec)
+  r.AddSpec(AiDebuggerSpec)
+  gospec.MainGoTest(r, t)
+}
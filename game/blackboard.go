@@ -0,0 +1,187 @@
+package game
+
+import (
+  "sort"
+  "sync"
+)
+
+// Every ai on a side shares a Blackboard so that they can coordinate without
+// having to go through their master.  Ais can post values for each other,
+// give each other roles, and reserve targets so that two of them don't go
+// after the same one.  Blackboards are saved along with the rest of the game.
+// Each ai runs on its own goroutine, so everything here is safe to call
+// from any of them.
+type Blackboard struct {
+  Values map[string]BlackboardValue
+
+  // Roles that have been given to entities on this side.
+  Roles map[EntityId]string
+
+  // Maps each reserved target to the entity that reserved it.
+  Reservations map[EntityId]EntityId
+
+  mutex sync.Mutex
+  game  *Game
+}
+
+type BlackboardKind int
+
+const (
+  BlackboardNumber BlackboardKind = iota
+  BlackboardString
+  BlackboardBoolean
+  BlackboardEntity
+  BlackboardPoint
+)
+
+// A value on a Blackboard, only the field for its Kind is used.
+type BlackboardValue struct {
+  Kind    BlackboardKind
+  Number  float64
+  String  string
+  Boolean bool
+  Entity  EntityId
+  X, Y    int
+}
+
+// Returns the blackboard shared by the ais on side, or nil if side isn't
+// the denizens or the intruders.
+func (g *Game) Blackboard(side Side) *Blackboard {
+  blackboard_mutex.Lock()
+  defer blackboard_mutex.Unlock()
+  var b **Blackboard
+  switch side {
+  case SideHaunt:
+    b = &g.Blackboards.Denizens
+  case SideExplorers:
+    b = &g.Blackboards.Intruders
+  default:
+    return nil
+  }
+  // Games saved before there were blackboards won't have them.
+  if *b == nil {
+    *b = &Blackboard{}
+  }
+  (*b).game = g
+  return *b
+}
+
+// Only guards making blackboards, each Blackboard has its own mutex.
+var blackboard_mutex sync.Mutex
+
+// Returns true if id is an entity that is still alive, or at least still in
+// the game if it doesn't have stats.
+func (b *Blackboard) exists(id EntityId) bool {
+  ent := b.game.EntityById(id)
+  return ent != nil && (ent.Stats == nil || ent.Stats.HpCur() > 0)
+}
+
+func (b *Blackboard) Get(key string) (BlackboardValue, bool) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  v, ok := b.Values[key]
+  return v, ok
+}
+
+func (b *Blackboard) Set(key string, v BlackboardValue) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if b.Values == nil {
+    b.Values = make(map[string]BlackboardValue)
+  }
+  b.Values[key] = v
+}
+
+func (b *Blackboard) Clear(key string) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  delete(b.Values, key)
+}
+
+// Returns every key with a value, sorted.
+func (b *Blackboard) Keys() []string {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  var keys []string
+  for key := range b.Values {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  return keys
+}
+
+// Gives id a role, an empty role takes away whatever role it had.
+func (b *Blackboard) SetRole(id EntityId, role string) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if role == "" {
+    delete(b.Roles, id)
+    return
+  }
+  if b.Roles == nil {
+    b.Roles = make(map[EntityId]string)
+  }
+  b.Roles[id] = role
+}
+
+func (b *Blackboard) Role(id EntityId) string {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  return b.Roles[id]
+}
+
+// Returns the living entities that have role, ordered by id.
+func (b *Blackboard) WithRole(role string) []EntityId {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  var ids []EntityId
+  for id, r := range b.Roles {
+    if r == role && b.exists(id) {
+      ids = append(ids, id)
+    }
+  }
+  sort.Sort(entityIds(ids))
+  return ids
+}
+
+type entityIds []EntityId
+
+func (e entityIds) Len() int           { return len(e) }
+func (e entityIds) Less(i, j int) bool { return e[i] < e[j] }
+func (e entityIds) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// Reserves target for by.  Returns false if something else already has it
+// reserved.  Reservations held by entities that have died, or on targets
+// that have died, are forgotten.
+func (b *Blackboard) Reserve(by, target EntityId) bool {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if holder, ok := b.Reservations[target]; ok && holder != by && b.exists(holder) && b.exists(target) {
+    return false
+  }
+  if b.Reservations == nil {
+    b.Reservations = make(map[EntityId]EntityId)
+  }
+  b.Reservations[target] = by
+  return true
+}
+
+// Gives up by's reservation on target, if it has one.
+func (b *Blackboard) Unreserve(by, target EntityId) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if b.Reservations[target] == by {
+    delete(b.Reservations, target)
+  }
+}
+
+// Returns the entity that has target reserved, or 0 if nothing does.
+func (b *Blackboard) ReservedBy(target EntityId) EntityId {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  holder, ok := b.Reservations[target]
+  if !ok || !b.exists(holder) || !b.exists(target) {
+    return 0
+  }
+  return holder
+}
@@ -0,0 +1,75 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+)
+
+func BlackboardSpec(c gospec.Context) {
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+  a, err := s.Spawn("Technician", 47, 25)
+  c.Assume(err, Equals, nil)
+  b, err := s.Spawn("Technician", 47, 27)
+  c.Assume(err, Equals, nil)
+  target, err := s.Spawn("Technician", 49, 25)
+  c.Assume(err, Equals, nil)
+  board := s.Game.Blackboard(game.SideHaunt)
+
+  c.Specify("Each side has its own blackboard.", func() {
+    c.Expect(board, Equals, s.Game.Blackboard(game.SideHaunt))
+    c.Expect(board, Not(Equals), s.Game.Blackboard(game.SideExplorers))
+  })
+
+  c.Specify("Values can be set and cleared.", func() {
+    board.Set("focus", game.BlackboardValue{Kind: game.BlackboardPoint, X: 3, Y: 4})
+    v, ok := board.Get("focus")
+    c.Expect(ok, Equals, true)
+    c.Expect(v.X, Equals, 3)
+    c.Expect(board.Keys(), ContainsExactly, Values("focus"))
+    board.Clear("focus")
+    _, ok = board.Get("focus")
+    c.Expect(ok, Equals, false)
+  })
+
+  c.Specify("Entities can be found by role.", func() {
+    board.SetRole(b.Id, "guard")
+    board.SetRole(a.Id, "guard")
+    c.Expect(board.Role(a.Id), Equals, "guard")
+    c.Expect(board.WithRole("guard"), ContainsInOrder, Values(a.Id, b.Id))
+    board.SetRole(a.Id, "")
+    c.Expect(board.WithRole("guard"), ContainsExactly, Values(b.Id))
+  })
+
+  c.Specify("Only one entity can reserve a target at a time.", func() {
+    c.Expect(board.Reserve(a.Id, target.Id), Equals, true)
+    c.Expect(board.Reserve(b.Id, target.Id), Equals, false)
+    c.Expect(board.ReservedBy(target.Id), Equals, a.Id)
+    board.Unreserve(a.Id, target.Id)
+    c.Expect(board.Reserve(b.Id, target.Id), Equals, true)
+  })
+
+  c.Specify("Reservations are dropped when their holder dies.", func() {
+    c.Expect(board.Reserve(a.Id, target.Id), Equals, true)
+    a.Stats.SetHp(0)
+    c.Expect(board.ReservedBy(target.Id), Equals, game.EntityId(0))
+    c.Expect(board.Reserve(b.Id, target.Id), Equals, true)
+  })
+
+  c.Specify("Blackboards are saved along with the game.", func() {
+    board.Set("alarm", game.BlackboardValue{Kind: game.BlackboardBoolean, Boolean: true})
+    board.SetRole(a.Id, "guard")
+    board.Reserve(b.Id, target.Id)
+    fork, err := s.Game.Fork()
+    c.Assume(err, Equals, nil)
+    s2, err := fork.Sim(1)
+    c.Assume(err, Equals, nil)
+    board2 := s2.Game.Blackboard(game.SideHaunt)
+    v, ok := board2.Get("alarm")
+    c.Expect(ok, Equals, true)
+    c.Expect(v.Boolean, Equals, true)
+    c.Expect(board2.Role(a.Id), Equals, "guard")
+    c.Expect(board2.ReservedBy(target.Id), Equals, b.Id)
+  })
+}
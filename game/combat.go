@@ -1,10 +1,17 @@
 package game
 
 import (
+  "fmt"
   "github.com/mik3cap/haunts/game/status"
+  lua "github.com/xenith-studios/golua"
+  "strings"
+  "sync"
 )
 
-func (g *Game) DoAttack(attacker, defender *Entity, strength int, kind status.Kind) bool {
+// Rolls an attack by attacker on defender, adds it to the combat log and
+// returns its entry.  Once the caller has done whatever the attack does it
+// should record that with CombatLog.SetOutcome().
+func (g *Game) DoAttack(attacker, defender *Entity, strength int, kind status.Kind) *CombatLogEntry {
   // get attacker's bonus for using the specified kind of attack
   // get defender's bonus for defending against the specified kind of attack
   // get the defender's current ego/corpus
@@ -12,5 +19,209 @@ func (g *Game) DoAttack(attacker, defender *Entity, strength int, kind status.Ki
   attack := attacker.Stats.AttackBonusWith(kind)
   defense := defender.Stats.DefenseVs(kind)
   roll := int(g.Rand.Int63()%10) + 1
-  return strength+attack+roll >= defense
+  entry := &CombatLogEntry{
+    Round:         (g.Turn + 1) / 2,
+    Attacker:      attacker.Id,
+    Attacker_name: attacker.Name,
+    Defender:      defender.Id,
+    Defender_name: defender.Name,
+    Strength:      strength,
+    Kind:          kind,
+    Attack:        attack,
+    Defense:       defense,
+    Roll:          roll,
+    Hit:           strength+attack+roll >= defense,
+  }
+  g.CombatLog().add(entry)
+  return entry
+}
+
+// One attack, with everything that went into deciding whether it hit.
+type CombatLogEntry struct {
+  Round int
+
+  Attacker, Defender           EntityId
+  Attacker_name, Defender_name string
+
+  Strength int
+  Kind     status.Kind
+
+  // The attacker's bonus with Kind and the defender's Corpus or Ego against
+  // it, whichever Kind uses.
+  Attack  int
+  Defense int
+
+  // 1d10, the attack hits if Strength + Attack + Roll >= Defense.
+  Roll int
+  Hit  bool
+
+  // What the attack did, only set if it hit.  Damage is how much Hp the
+  // defender actually lost, after its conditions had their say.
+  Conditions []string
+  Damage     int
+  Killed     bool
+}
+
+func (e *CombatLogEntry) String() string {
+  defense := "Corpus"
+  if e.Kind.Primary() == status.Ego {
+    defense = "Ego"
+  }
+  result := "missed"
+  if e.Hit {
+    result = "hit"
+  }
+  str := fmt.Sprintf("%s %s %s: %d strength + %d attack + %d roll vs %d %s (%s)",
+    e.Attacker_name, result, e.Defender_name,
+    e.Strength, e.Attack, e.Roll, e.Defense, defense, e.Kind)
+  if !e.Hit {
+    return str
+  }
+  str += fmt.Sprintf(", %d damage", e.Damage)
+  if len(e.Conditions) > 0 {
+    str += ", " + strings.Join(e.Conditions, ", ")
+  }
+  if e.Killed {
+    str += ", killed"
+  }
+  return str
+}
+
+// Attacks are only remembered for this long, so that saves don't grow
+// forever.
+const combat_log_max = 200
+
+// Every attack in the game, oldest first.  The log is saved along with the
+// rest of the game.
+type CombatLog struct {
+  Entries []*CombatLogEntry
+
+  // Number of entries added since the log was made or loaded, which keeps
+  // going up after old entries are dropped.
+  added int
+
+  mutex sync.Mutex
+}
+
+// Returns the game's combat log.
+func (g *Game) CombatLog() *CombatLog {
+  combat_log_mutex.Lock()
+  defer combat_log_mutex.Unlock()
+  // Games saved before there was a combat log won't have one.
+  if g.Combat_log == nil {
+    g.Combat_log = &CombatLog{}
+  }
+  return g.Combat_log
+}
+
+// Guards g.Combat_log so that CombatLog() only ever makes one log for a
+// game.  The entries in a log are guarded by the log's own mutex.
+var combat_log_mutex sync.Mutex
+
+func (l *CombatLog) add(e *CombatLogEntry) {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+  l.Entries = append(l.Entries, e)
+  l.added++
+  if len(l.Entries) > combat_log_max {
+    l.Entries = l.Entries[len(l.Entries)-combat_log_max:]
+  }
+}
+
+// Records what the attack in e did to its defender.
+func (l *CombatLog) SetOutcome(e *CombatLogEntry, conditions []string, damage int, killed bool) {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+  e.Conditions = conditions
+  e.Damage = damage
+  e.Killed = killed
+}
+
+// Returns the last n entries in the log, oldest first, or all of them if
+// n <= 0.
+func (l *CombatLog) Last(n int) []CombatLogEntry {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+  if n <= 0 || n > len(l.Entries) {
+    n = len(l.Entries)
+  }
+  entries := make([]CombatLogEntry, n)
+  for i, e := range l.Entries[len(l.Entries)-n:] {
+    entries[i] = *e
+  }
+  return entries
+}
+
+// Returns the number of entries in the log.
+func (l *CombatLog) Len() int {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+  return len(l.Entries)
+}
+
+// Returns the number of entries added since the log was made or loaded.
+// Unlike Len() this still changes once the log is full.
+func (l *CombatLog) Added() int {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+  return l.added
+}
+
+// Pushes the last n entries in g's combat log onto the stack as an array of
+// tables, oldest first, for Script.CombatLog() and combatLog() in ais.
+func LuaPushCombatLog(L *lua.State, g *Game, n int) {
+  L.NewTable()
+  for i, e := range g.CombatLog().Last(n) {
+    L.PushInteger(i + 1)
+    luaPushCombatLogEntry(L, g, e)
+    L.SetTable(-3)
+  }
+}
+
+func luaPushCombatLogEntry(L *lua.State, g *Game, e CombatLogEntry) {
+  L.NewTable()
+  L.PushString("Attacker")
+  LuaPushEntity(L, g.EntityById(e.Attacker))
+  L.SetTable(-3)
+  L.PushString("Defender")
+  LuaPushEntity(L, g.EntityById(e.Defender))
+  L.SetTable(-3)
+  L.PushString("Attacker_name")
+  L.PushString(e.Attacker_name)
+  L.SetTable(-3)
+  L.PushString("Defender_name")
+  L.PushString(e.Defender_name)
+  L.SetTable(-3)
+  L.PushString("Kind")
+  L.PushString(string(e.Kind))
+  L.SetTable(-3)
+  for _, field := range []struct {
+    name  string
+    value int
+  }{
+    {"Round", e.Round},
+    {"Strength", e.Strength},
+    {"Attack", e.Attack},
+    {"Defense", e.Defense},
+    {"Roll", e.Roll},
+    {"Damage", e.Damage},
+  } {
+    L.PushString(field.name)
+    L.PushInteger(field.value)
+    L.SetTable(-3)
+  }
+  L.PushString("Hit")
+  L.PushBoolean(e.Hit)
+  L.SetTable(-3)
+  L.PushString("Killed")
+  L.PushBoolean(e.Killed)
+  L.SetTable(-3)
+  L.PushString("Conditions")
+  L.NewTable()
+  for _, name := range e.Conditions {
+    L.PushString(name)
+    L.PushBoolean(true)
+    L.SetTable(-3)
+  }
+  L.SetTable(-3)
 }
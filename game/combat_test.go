@@ -0,0 +1,61 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/game/status"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+)
+
+func CombatLogSpec(c gospec.Context) {
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+  a, err := s.Spawn("Technician", 47, 25)
+  c.Assume(err, Equals, nil)
+  b, err := s.Spawn("Technician", 47, 27)
+  c.Assume(err, Equals, nil)
+
+  c.Specify("Attacks are logged with their rolls.", func() {
+    c.Expect(s.Game.CombatLog().Len(), Equals, 0)
+    roll := s.Game.DoAttack(a, b, 3, status.Brutal)
+    c.Assume(s.Game.CombatLog().Len(), Equals, 1)
+    e := s.Game.CombatLog().Last(1)[0]
+    c.Expect(e.Attacker, Equals, a.Id)
+    c.Expect(e.Defender, Equals, b.Id)
+    c.Expect(e.Strength, Equals, 3)
+    c.Expect(e.Attack, Equals, a.Stats.AttackBonusWith(status.Brutal))
+    c.Expect(e.Defense, Equals, b.Stats.CorpusVs(status.Brutal))
+    c.Expect(e.Roll >= 1 && e.Roll <= 10, Equals, true)
+    c.Expect(e.Hit, Equals, e.Strength+e.Attack+e.Roll >= e.Defense)
+    c.Expect(e.Hit, Equals, roll.Hit)
+  })
+
+  c.Specify("Outcomes are recorded.", func() {
+    roll := s.Game.DoAttack(a, b, 100, status.Brutal)
+    c.Assume(roll.Hit, Equals, true)
+    s.Game.CombatLog().SetOutcome(roll, []string{"Poison"}, 2, false)
+    e := s.Game.CombatLog().Last(0)[0]
+    c.Expect(e.Damage, Equals, 2)
+    c.Expect(e.Conditions, ContainsExactly, Values("Poison"))
+  })
+
+  c.Specify("Only the most recent attacks are kept.", func() {
+    for i := 0; i < 250; i++ {
+      s.Game.DoAttack(a, b, i, status.Brutal)
+    }
+    c.Expect(s.Game.CombatLog().Len(), Equals, 200)
+    c.Expect(s.Game.CombatLog().Added(), Equals, 250)
+    c.Expect(s.Game.CombatLog().Last(1)[0].Strength, Equals, 249)
+    c.Expect(s.Game.CombatLog().Last(0)[0].Strength, Equals, 50)
+  })
+
+  c.Specify("The log is saved along with the game.", func() {
+    s.Game.DoAttack(a, b, 7, status.Brutal)
+    fork, err := s.Game.Fork()
+    c.Assume(err, Equals, nil)
+    s2, err := fork.Sim(1)
+    c.Assume(err, Equals, nil)
+    c.Assume(s2.Game.CombatLog().Len(), Equals, 1)
+    c.Expect(s2.Game.CombatLog().Last(1)[0].Strength, Equals, 7)
+  })
+}
@@ -0,0 +1,129 @@
+package game
+
+import (
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/game/status"
+  "math"
+  "strings"
+)
+
+// How hard the ais are.  This is chosen when a game is started and is saved
+// along with it.  It only changes things for sides that are played by an ai,
+// so pass-and-play and online games are the same at every difficulty.
+type Difficulty string
+
+const (
+  DifficultyEasy   Difficulty = "Easy"
+  DifficultyNormal Difficulty = "Normal"
+  DifficultyHard   Difficulty = "Hard"
+)
+
+// Everything that a difficulty changes.
+type DifficultySettings struct {
+  // Hp_max, Corpus and Ego of entities on a side played by an ai are
+  // multiplied by this.
+  Stats float64
+
+  // Minion budgets of masters played by an ai are multiplied by this.
+  Minions float64
+
+  // The number of candidates, rollouts and how far ahead the planner looks
+  // are multiplied by this.
+  Planning float64
+
+  // Chance that an ai does something other than the best thing it could
+  // find.
+  Blunder float64
+}
+
+var difficulty_settings = map[Difficulty]DifficultySettings{
+  DifficultyEasy:   {Stats: 0.75, Minions: 0.5, Planning: 0.5, Blunder: 0.3},
+  DifficultyNormal: {Stats: 1, Minions: 1, Planning: 1, Blunder: 0},
+  DifficultyHard:   {Stats: 1.25, Minions: 1.5, Planning: 2, Blunder: 0},
+}
+
+// Returns the difficulty named by s, ignoring case.  If s isn't a
+// difficulty this returns DifficultyNormal along with an error.
+func ParseDifficulty(s string) (Difficulty, error) {
+  for d := range difficulty_settings {
+    if strings.EqualFold(string(d), s) {
+      return d, nil
+    }
+  }
+  return DifficultyNormal, errors.New(fmt.Sprintf("'%s' is not a difficulty.", s))
+}
+
+// Games saved before there were difficulties have no difficulty, they get
+// the settings for DifficultyNormal.
+func (d Difficulty) Settings() DifficultySettings {
+  if settings, ok := difficulty_settings[d]; ok {
+    return settings
+  }
+  return difficulty_settings[DifficultyNormal]
+}
+
+func (d Difficulty) String() string {
+  if _, ok := difficulty_settings[d]; ok {
+    return string(d)
+  }
+  return string(DifficultyNormal)
+}
+
+// Multiplies v by scale, but never goes below 1 if v was at least 1.
+func scaleStat(v int, scale float64) int {
+  if v <= 0 {
+    return v
+  }
+  scaled := int(math.Floor(float64(v)*scale + 0.5))
+  if scaled < 1 {
+    scaled = 1
+  }
+  return scaled
+}
+
+// Returns true if side is being played by an ai.
+func (g *Game) AiPlays(side Side) bool {
+  var ai Ai
+  switch side {
+  case SideHaunt:
+    ai = g.Ai.denizens
+  case SideExplorers:
+    ai = g.Ai.intruders
+  }
+  if ai == nil {
+    return false
+  }
+  _, human := ai.(inactiveAi)
+  return !human
+}
+
+// Returns b adjusted for the difficulty if side is played by an ai.
+func (g *Game) difficultyBase(side Side, b status.Base) status.Base {
+  if !g.AiPlays(side) {
+    return b
+  }
+  scale := g.Difficulty.Settings().Stats
+  b.Hp_max = scaleStat(b.Hp_max, scale)
+  b.Corpus = scaleStat(b.Corpus, scale)
+  b.Ego = scaleStat(b.Ego, scale)
+  return b
+}
+
+// Returns how many points worth of minions a master gets, adjusted for the
+// difficulty.  Entities that aren't masters don't get any.
+func (e *Entity) MinionBudget() int {
+  if e.HauntEnt == nil || e.HauntEnt.Level != LevelMaster {
+    return 0
+  }
+  return e.Game().MinionPoints(e.HauntEnt.Minions)
+}
+
+// Returns points, a minion budget that a level was balanced around, adjusted
+// for the difficulty if the denizens are played by an ai.
+func (g *Game) MinionPoints(points int) int {
+  if !g.AiPlays(SideHaunt) {
+    return points
+  }
+  return scaleStat(points, g.Difficulty.Settings().Minions)
+}
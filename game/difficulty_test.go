@@ -0,0 +1,41 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+)
+
+func DifficultySpec(c gospec.Context) {
+  c.Specify("Difficulties are parsed ignoring case.", func() {
+    d, err := game.ParseDifficulty("hard")
+    c.Expect(err, Equals, nil)
+    c.Expect(d, Equals, game.DifficultyHard)
+    d, err = game.ParseDifficulty("Impossible")
+    c.Expect(err, Not(Equals), nil)
+    c.Expect(d, Equals, game.DifficultyNormal)
+  })
+
+  c.Specify("Games without a difficulty play like Normal.", func() {
+    var d game.Difficulty
+    c.Expect(d.Settings(), Equals, game.DifficultyNormal.Settings())
+    c.Expect(d.String(), Equals, "Normal")
+  })
+
+  c.Specify("Harder difficulties are harder.", func() {
+    easy := game.DifficultyEasy.Settings()
+    normal := game.DifficultyNormal.Settings()
+    hard := game.DifficultyHard.Settings()
+    c.Expect(easy.Stats < normal.Stats && normal.Stats < hard.Stats, Equals, true)
+    c.Expect(easy.Minions < normal.Minions && normal.Minions < hard.Minions, Equals, true)
+    c.Expect(easy.Planning < normal.Planning && normal.Planning < hard.Planning, Equals, true)
+    c.Expect(easy.Blunder > hard.Blunder, Equals, true)
+  })
+
+  c.Specify("Minion budgets only change when an ai plays the denizens.", func() {
+    var g game.Game
+    g.Difficulty = game.DifficultyHard
+    c.Expect(g.MinionPoints(6), Equals, 6)
+    c.Expect(g.MinionPoints(0), Equals, 0)
+  })
+}
@@ -1,7 +1,6 @@
 package game
 
 import (
-  "encoding/gob"
   gl "github.com/chsc/gogl/gl21"
   "github.com/mik3cap/glop/sprite"
   "github.com/mik3cap/glop/util/algorithm"
@@ -38,7 +37,7 @@ func (a inactiveAi) Activate()                      {}
 func (a inactiveAi) Active() bool                   { return false }
 func (a inactiveAi) ActionExecs() <-chan ActionExec { return nil }
 func init() {
-  gob.Register(inactiveAi{})
+  base.RegisterSaveType(inactiveAi{})
 }
 
 type AiKind int
@@ -120,12 +119,12 @@ func (e *Entity) LoadAi() {
   if e.Ai_file_override != "" {
     filename = e.Ai_file_override.String()
   }
-  if filename == "" {
+  if filename == "" || e.Game().forked {
     base.Log().Printf("No ai for %s", e.Name)
     e.Ai = inactiveAi{}
     return
   }
-  ai_maker(filename, e.Game(), e, &e.Ai, EntityAi)
+  makeAi(filename, e.Game(), e, &e.Ai, EntityAi)
   if e.Ai == nil {
     e.Ai = inactiveAi{}
     base.Log().Printf("Failed to make Ai for '%s' with %s", e.Name, filename)
@@ -139,6 +138,9 @@ func (e *Entity) LoadAi() {
 func (e *Entity) Load(g *Game) {
   e.sprite.Load(e.Sprite_path.String())
   e.Sprite().SetTriggerFunc(func(s *sprite.Sprite, name string) {
+    if e.Game().forked {
+      return
+    }
     x, y := e.Pos()
     dx, dy := e.Dims()
     volume := 1.0
@@ -189,7 +191,7 @@ func MakeEntity(name string, g *Game) *Entity {
   }
 
   if ent.Side() == SideHaunt || ent.Side() == SideExplorers {
-    stats := status.MakeInst(ent.Base)
+    stats := status.MakeInst(g.difficultyBase(ent.Side(), ent.Base))
     stats.OnBegin()
     ent.Stats = &stats
   }
@@ -407,6 +409,10 @@ type EntityInst struct {
 
   Stats *status.Inst
 
+  // The action this entity has readied to use during the other side's turn,
+  // if any, see reaction.go.
+  Readied *ReadiedAction
+
   // Ai stuff - the channels cannot be gobbed, so they need to be remade when
   // loading an ent from a file
   Ai               Ai
@@ -0,0 +1,95 @@
+package game
+
+import (
+  "bytes"
+  "context"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/mrgnet"
+  lua "github.com/xenith-studios/golua"
+)
+
+// Gives the specs in game_test access to the online code, which is otherwise
+// only reachable through the online menu and level scripts.
+
+type NetGame = netGame
+
+var DoOnlineAction = doOnlineAction
+
+// Lists games the same way the online menu does, as the player with the
+// specified id, and returns what the menu would have shown.
+func ListOnlineGames(id mrgnet.NetId, unstarted bool) mrgnet.ListGamesResponse {
+  net_id = id
+  var sm OnlineMenu
+  glb := gameListBox{update: make(chan mrgnet.ListGamesResponse, 1)}
+  sm.listGames(context.Background(), &glb, unstarted)
+  return <-glb.update
+}
+
+// Encodes g the same way Script.SaveGameState() does, for VerifyTurn().
+func EncodeVerifyState(g *Game) ([]byte, error) {
+  str, err := encodeGameState(totalState{Game: &g})
+  return []byte(str), err
+}
+
+// Encodes execs the same way Net.UpdateExecs() does, for VerifyTurn().
+func EncodeVerifyExecs(execs ...ActionExec) ([]byte, error) {
+  L := lua.NewState()
+  defer L.Close()
+  L.NewTable()
+  for i, exec := range execs {
+    str, err := base.ToGobToBase64([]ActionExec{exec})
+    if err != nil {
+      return nil, err
+    }
+    L.PushInteger(i + 1)
+    L.NewTable()
+    L.PushString("__encoded")
+    L.PushString(str)
+    L.SetTable(-3)
+    L.SetTable(-3)
+  }
+  buf := bytes.NewBuffer(nil)
+  err := LuaEncodeValue(buf, L, -1)
+  return buf.Bytes(), err
+}
+
+type ReplayControl = replayControl
+
+var MakeReplayControl = makeReplayControl
+
+var CompleteTurns = completeTurns
+
+// Lets the spec stand in for the script goroutine.
+func (rc *replayControl) WaitForExec() bool {
+  return rc.waitForExec()
+}
+
+// Moves the replay to turn the same way running it would have.
+func (rc *replayControl) SetTurn(turn int) {
+  rc.mutex.Lock()
+  defer rc.mutex.Unlock()
+  rc.turn = turn
+  rc.seek = -1
+}
+
+// Starts a script goroutine that waits on the game the same way level
+// scripts do, and returns the script's stop().
+func StartWaitingScript() func() {
+  gs := &gameScript{
+    L:    lua.NewState(),
+    sync: make(chan struct{}),
+    done: make(chan struct{}),
+  }
+  comm := make(chan interface{})
+  gs.running.Add(1)
+  go func() {
+    defer gs.running.Done()
+    gs.syncStart()
+    gs.send(comm, nil)
+    gs.receive(comm)
+    gs.syncEnd()
+  }()
+  return gs.stop
+}
+
+const MaxAiTrace = max_ai_trace
@@ -6,6 +6,7 @@ import (
   "github.com/mik3cap/haunts/base"
   "github.com/mik3cap/haunts/house"
   "github.com/mik3cap/haunts/mrgnet"
+  "image"
   "math/rand"
   "sort"
 )
@@ -13,7 +14,8 @@ import (
 type GamePanel struct {
   *gui.AnchorBox
 
-  main_bar *MainBar
+  main_bar   *MainBar
+  combat_log *CombatLogPanel
 
   // Keep track of this so we know how much time has passed between
   // calls to Think()
@@ -23,6 +25,21 @@ type GamePanel struct {
 
   script *gameScript
   game   *Game
+
+  // Only set if this panel is replaying a recorded game, in which case the
+  // player can watch but not act.
+  replay *replayControl
+
+  // If set this is called with a thumbnail of the viewer the next time the
+  // panel is drawn, see requestThumbnail().
+  thumbnail func(image.Image)
+
+  // Difficulty that the player chose before starting the game, this is
+  // given to the game once the script loads a house.
+  difficulty Difficulty
+
+  // Only set if this panel is playing a headless match, see PlayMatch().
+  match *match
 }
 
 func MakeGamePanel(script string, p *Player, data map[string]string, game_key mrgnet.GameKey) *GamePanel {
@@ -72,6 +89,11 @@ func (gp *GamePanel) Think(ui *gui.Gui, t int64) {
 }
 
 func (gp *GamePanel) Draw(region gui.Region) {
+  if gp.thumbnail != nil && gp.Active() {
+    f := gp.thumbnail
+    gp.thumbnail = nil
+    f(gp.takeThumbnail())
+  }
   gp.AnchorBox.Draw(region)
   region.PushClipPlanes()
   defer region.PopClipPlanes()
@@ -174,6 +196,10 @@ func (gp *GamePanel) Respond(ui *gui.Gui, group gui.EventGroup) bool {
     }
   }
 
+  if gp.replay != nil {
+    return false
+  }
+
   if found, event := group.FindEvent(gin.Escape); found && event.Type == gin.Press {
     if gp.game.selected_ent != nil {
       switch gp.game.Action_state {
@@ -207,6 +233,15 @@ func (gp *GamePanel) Respond(ui *gui.Gui, group gui.EventGroup) bool {
   }
 
   if gp.game.Action_state == preppingAction {
+    // The ready key readies the current action instead of using it, see
+    // reaction.go.
+    if found, event := group.FindEvent(base.GetDefaultKeyMap()["ready"].Id()); found && event.Type == gin.Press {
+      exec := MakeReadyExec(gp.game.selected_ent, gp.game.current_action)
+      if exec != nil {
+        gp.game.current_exec = exec
+      }
+      return true
+    }
     consumed, exec := gp.game.current_action.HandleInput(group, gp.game)
     if consumed {
       if exec != nil {
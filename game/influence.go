@@ -0,0 +1,153 @@
+package game
+
+import (
+  "github.com/mik3cap/haunts/house"
+  "sync"
+)
+
+// Actions that can hurt other entities implement Threat so that influence
+// maps know how far they reach and how much they hurt.
+type Threat interface {
+  // rng is the furthest ranged distance that the target can be, diameter is
+  // the width of the area hit, 1 for actions that hit a single entity.
+  Threat() (rng, diameter, damage int)
+}
+
+// An InfluenceMap records, for every cell in the house, how much damage the
+// enemies of a side could do to something standing there without moving,
+// and how much damage the side itself could do there.  Only enemies that
+// the side can see are counted, and an entity only threatens cells that it
+// has los to.
+type InfluenceMap struct {
+  Side Side
+
+  // Game.Turn when this map was made, maps are only made once per turn.
+  Turn int
+
+  Threat  [][]float64
+  Control [][]float64
+}
+
+// Returns the influence map for side, making it if it hasn't already been
+// made this turn.  Returns nil if side isn't the denizens or the intruders.
+func (g *Game) Influence(side Side) *InfluenceMap {
+  g.influence.Lock()
+  defer g.influence.Unlock()
+  var m **InfluenceMap
+  switch side {
+  case SideHaunt:
+    m = &g.influence.denizens
+  case SideExplorers:
+    m = &g.influence.intruders
+  default:
+    return nil
+  }
+  if *m == nil || (*m).Turn != g.Turn {
+    *m = g.makeInfluenceMap(side)
+  }
+  return *m
+}
+
+type influenceData struct {
+  sync.Mutex
+  denizens, intruders *InfluenceMap
+}
+
+func makeInfluenceGrid() [][]float64 {
+  raw := make([]float64, house.LosTextureSizeSquared)
+  grid := make([][]float64, house.LosTextureSize)
+  for i := range grid {
+    grid[i] = raw[i*house.LosTextureSize : (i+1)*house.LosTextureSize]
+  }
+  return grid
+}
+
+func (g *Game) makeInfluenceMap(side Side) *InfluenceMap {
+  m := &InfluenceMap{
+    Side:    side,
+    Turn:    g.Turn,
+    Threat:  makeInfluenceGrid(),
+    Control: makeInfluenceGrid(),
+  }
+  los := make([][]bool, house.LosTextureSize)
+  raw := make([]bool, house.LosTextureSizeSquared)
+  for i := range los {
+    los[i] = raw[i*house.LosTextureSize : (i+1)*house.LosTextureSize]
+  }
+  for _, ent := range g.Ents {
+    if ent.Stats == nil || ent.Stats.HpCur() <= 0 {
+      continue
+    }
+    if ent.Side() != SideHaunt && ent.Side() != SideExplorers {
+      continue
+    }
+    x, y := ent.Pos()
+    dx, dy := ent.Dims()
+    var dst [][]float64
+    if ent.Side() == side {
+      dst = m.Control
+    } else {
+      if !g.TeamLos(side, x, y, dx, dy) {
+        continue
+      }
+      dst = m.Threat
+    }
+
+    // An entity can only use one attack at a time, so each cell gets
+    // whichever of its attacks would do the most damage there.
+    reach := make(map[[2]int]float64)
+    for _, action := range ent.Actions {
+      t, ok := action.(Threat)
+      if !ok {
+        continue
+      }
+      rng, diameter, damage := t.Threat()
+      dist := rng + diameter/2
+      if dist <= 0 {
+        continue
+      }
+      if damage < 1 {
+        damage = 1
+      }
+      g.DetermineLos(x, y, dist, los)
+      for i := x - dist; i <= x+dist; i++ {
+        for j := y - dist; j <= y+dist; j++ {
+          if i < 0 || j < 0 || i >= len(los) || j >= len(los[i]) {
+            continue
+          }
+          if !los[i][j] {
+            continue
+          }
+          cell := [2]int{i, j}
+          if float64(damage) > reach[cell] {
+            reach[cell] = float64(damage)
+          }
+        }
+      }
+    }
+    for cell, damage := range reach {
+      dst[cell[0]][cell[1]] += damage
+    }
+  }
+  return m
+}
+
+func (m *InfluenceMap) inBounds(x, y int) bool {
+  return x >= 0 && y >= 0 && x < len(m.Threat) && y < len(m.Threat[x])
+}
+
+// Returns the damage that enemies could do at (x, y).
+func (m *InfluenceMap) ThreatAt(x, y int) float64 {
+  if !m.inBounds(x, y) {
+    return 0
+  }
+  return m.Threat[x][y]
+}
+
+// Returns the damage that m's side could do at (x, y).
+func (m *InfluenceMap) ControlAt(x, y int) float64 {
+  if !m.inBounds(x, y) {
+    return 0
+  }
+  return m.Control[x][y]
+}
@@ -0,0 +1,61 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+)
+
+func InfluenceSpec(c gospec.Context) {
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+  _, err = s.Spawn("Technician", 47, 25)
+  c.Assume(err, Equals, nil)
+
+  c.Specify("Influence maps are only made once per turn.", func() {
+    m := s.Game.Influence(game.SideHaunt)
+    c.Expect(s.Game.Influence(game.SideHaunt), Equals, m)
+    s.EndTurn()
+    c.Expect(s.Game.Influence(game.SideHaunt), Not(Equals), m)
+    c.Expect(s.Game.Influence(game.SideHaunt).Turn, Equals, s.Game.Turn)
+  })
+
+  c.Specify("Entities control the cells their attacks reach.", func() {
+    m := s.Game.Influence(game.SideHaunt)
+    c.Expect(m.ControlAt(47, 26) > 0, Equals, true)
+    c.Expect(m.ControlAt(47, 45), Equals, 0.0)
+    c.Expect(m.ThreatAt(47, 26), Equals, 0.0)
+  })
+
+  // Angry Shades only have Chill Touch, range 1 and 2 damage.
+  c.Specify("Enemies that can be seen threaten the cells their attacks reach.", func() {
+    _, err := s.Spawn("Angry Shade", 47, 26)
+    c.Assume(err, Equals, nil)
+    s.Game.SetLosMode(game.SideExplorers, game.LosModeAll, nil)
+    m := s.Game.Influence(game.SideExplorers)
+    c.Expect(m.ThreatAt(47, 25), Equals, 2.0)
+    c.Expect(m.ThreatAt(48, 27), Equals, 2.0)
+    c.Expect(m.ThreatAt(47, 24), Equals, 0.0)
+    c.Expect(m.ThreatAt(-1, 25), Equals, 0.0)
+  })
+
+  c.Specify("Threat from different enemies adds up.", func() {
+    _, err := s.Spawn("Angry Shade", 47, 26)
+    c.Assume(err, Equals, nil)
+    _, err = s.Spawn("Angry Shade", 48, 26)
+    c.Assume(err, Equals, nil)
+    s.Game.SetLosMode(game.SideExplorers, game.LosModeAll, nil)
+    m := s.Game.Influence(game.SideExplorers)
+    c.Expect(m.ThreatAt(47, 25), Equals, 4.0)
+    c.Expect(m.ThreatAt(49, 25), Equals, 2.0)
+    c.Expect(m.ThreatAt(46, 25), Equals, 2.0)
+  })
+
+  c.Specify("Enemies that can't be seen don't threaten anything.", func() {
+    _, err := s.Spawn("Angry Shade", 47, 26)
+    c.Assume(err, Equals, nil)
+    s.Game.SetLosMode(game.SideExplorers, game.LosModeNone, nil)
+    m := s.Game.Influence(game.SideExplorers)
+    c.Expect(m.ThreatAt(47, 25), Equals, 0.0)
+  })
+}
@@ -9,6 +9,7 @@ import (
   "github.com/mik3cap/glop/sprite"
   "github.com/mik3cap/glop/util/algorithm"
   "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game/status"
   "github.com/mik3cap/haunts/house"
   "github.com/mik3cap/haunts/mrgnet"
   "reflect"
@@ -133,14 +134,28 @@ type gameDataTransient struct {
     game *mrgnet.Game
     side Side
   }
+
+  // Set for games made from a Fork, which are only used by ais to try
+  // things out.
+  forked bool
+
+  // Influence maps for each side, see Game.Influence().
+  influence influenceData
 }
 
 func (gdt *gameDataTransient) alloc() {
   if gdt.los.denizens.tex != nil {
     return
   }
-  gdt.los.denizens.tex = house.MakeLosTexture()
-  gdt.los.intruders.tex = house.MakeLosTexture()
+  if gdt.forked {
+    // Forked games are only simulated, never drawn.  Ais fork games
+    // constantly, so this keeps them from piling up textures.
+    gdt.los.denizens.tex = house.MakeUndrawnLosTexture()
+    gdt.los.intruders.tex = house.MakeUndrawnLosTexture()
+  } else {
+    gdt.los.denizens.tex = house.MakeLosTexture()
+    gdt.los.intruders.tex = house.MakeLosTexture()
+  }
   gdt.los.full_merger = make([]bool, house.LosTextureSizeSquared)
   gdt.los.merger = make([][]bool, house.LosTextureSize)
   for i := range gdt.los.merger {
@@ -194,6 +209,21 @@ type gameDataGobbable struct {
   // indicates that a complete round has happened.
   Turn int
 
+  // How hard the ais are, see Difficulty.
+  Difficulty Difficulty
+
+  // The side that has won, set by the level script with SetWinner(), or
+  // SideNone if the game isn't over.
+  Winner Side
+
+  // Shared by all of the ais on each side, see Game.Blackboard().
+  Blackboards struct {
+    Denizens, Intruders *Blackboard
+  }
+
+  // Every attack made so far, see Game.CombatLog().
+  Combat_log *CombatLog
+
   // PRNG, need it here so that we serialize it along with everything
   // else so that replays work properly.
   Rand *cmwc.Cmwc
@@ -237,6 +267,38 @@ type Game struct {
 }
 
 func (g *Game) GobDecode(data []byte) error {
+  dec := gob.NewDecoder(bytes.NewBuffer(data))
+  return g.load(func(gdg *gameDataGobbable, sss *[]sprite.SpriteState) error {
+    if err := dec.Decode(gdg); err != nil {
+      return err
+    }
+    return dec.Decode(sss)
+  })
+}
+
+// How a Game is saved, see base.SaveTreeMarshaler.
+type gameSaveTree struct {
+  Data    *gameDataGobbable
+  Sprites []sprite.SpriteState
+}
+
+func (g *Game) MarshalSaveTree() (interface{}, error) {
+  return gameSaveTree{Data: &g.gameDataGobbable, Sprites: g.spriteStates()}, nil
+}
+
+func (g *Game) UnmarshalSaveTree(decode func(interface{}) error) error {
+  return g.load(func(gdg *gameDataGobbable, sss *[]sprite.SpriteState) error {
+    tree := gameSaveTree{Data: gdg}
+    err := decode(&tree)
+    *sss = tree.Sprites
+    return err
+  })
+}
+
+// Throws away whatever g was and replaces it with the game that decode
+// fills in, decode gets the data to fill in and the sprite state of each
+// entity.
+func (g *Game) load(decode func(*gameDataGobbable, *[]sprite.SpriteState) error) error {
   g.gameDataPrivate = gameDataPrivate{}
   for ent := range g.all_ents_in_memory {
     ent.Release()
@@ -253,8 +315,8 @@ func (g *Game) GobDecode(data []byte) error {
 
   g.gameDataGobbable = gameDataGobbable{}
 
-  dec := gob.NewDecoder(bytes.NewBuffer(data))
-  if err := dec.Decode(&g.gameDataGobbable); err != nil {
+  var sss []sprite.SpriteState
+  if err := decode(&g.gameDataGobbable, &sss); err != nil {
     return err
   }
 
@@ -275,10 +337,6 @@ func (g *Game) GobDecode(data []byte) error {
   g.mergeLos(SideHaunt)
   g.mergeLos(SideExplorers)
 
-  var sss []sprite.SpriteState
-  if err := dec.Decode(&sss); err != nil {
-    return err
-  }
   if len(sss) != len(g.Ents) {
     return errors.New("SpriteStates were not recorded properly.")
   }
@@ -286,22 +344,26 @@ func (g *Game) GobDecode(data []byte) error {
     g.Ents[i].Sprite().SetSpriteState(sss[i])
   }
 
+  if g.forked {
+    return nil
+  }
+
   // If Ais were bound then their paths will be listed here and we have to
   // reload them
   if g.Ai.Path.Denizens != "" {
-    ai_maker(g.Ai.Path.Denizens, g, nil, &g.Ai.denizens, DenizensAi)
+    makeAi(g.Ai.Path.Denizens, g, nil, &g.Ai.denizens, DenizensAi)
   }
   if g.Ai.denizens == nil {
     g.Ai.denizens = inactiveAi{}
   }
   if g.Ai.Path.Intruders != "" {
-    ai_maker(g.Ai.Path.Intruders, g, nil, &g.Ai.intruders, IntrudersAi)
+    makeAi(g.Ai.Path.Intruders, g, nil, &g.Ai.intruders, IntrudersAi)
   }
   if g.Ai.intruders == nil {
     g.Ai.intruders = inactiveAi{}
   }
   if g.Ai.Path.Minions != "" {
-    ai_maker(g.Ai.Path.Minions, g, nil, &g.Ai.minions, MinionsAi)
+    makeAi(g.Ai.Path.Minions, g, nil, &g.Ai.minions, MinionsAi)
   }
   if g.Ai.minions == nil {
     g.Ai.minions = inactiveAi{}
@@ -316,14 +378,18 @@ func (g *Game) GobEncode() ([]byte, error) {
   if err := enc.Encode(g.gameDataGobbable); err != nil {
     return nil, err
   }
+  if err := enc.Encode(g.spriteStates()); err != nil {
+    return nil, err
+  }
+  return buf.Bytes(), nil
+}
+
+func (g *Game) spriteStates() []sprite.SpriteState {
   var sss []sprite.SpriteState
   for i := range g.Ents {
     sss = append(sss, g.Ents[i].Sprite().GetSpriteState())
   }
-  if err := enc.Encode(sss); err != nil {
-    return nil, err
-  }
-  return buf.Bytes(), nil
+  return sss
 }
 
 func (g *Game) EntityById(id EntityId) *Entity {
@@ -410,6 +476,23 @@ func (g *Game) SetVisibility(side Side) {
   }
 }
 
+// Starts the turn for every entity on g.Side, which restores their Ap and
+// lets their conditions take effect.  VerifyTurn() uses this to check the
+// state a player says their turn starts from.
+func (g *Game) startEntsTurn() {
+  for i := range g.Ents {
+    if g.Ents[i].Side() == g.Side {
+      ent := g.Ents[i]
+      ent.Readied = nil
+      alive := ent.Stats != nil && ent.Stats.HpCur() > 0
+      ent.OnRound()
+      if alive && ent.Stats.HpCur() <= 0 {
+        g.TriggerConditions(ent, nil, status.Event{Trigger: status.TriggerKilled})
+      }
+    }
+  }
+}
+
 // This is called if the player is ready to end the turn, if the turn ends
 // then the following things happen:
 // 1. The game script gets to run its OnRound() function
@@ -440,11 +523,7 @@ func (g *Game) OnRound(do_scripts bool) {
     g.viewer.Los_tex.Remap()
   }
 
-  for i := range g.Ents {
-    if g.Ents[i].Side() == g.Side {
-      g.Ents[i].OnRound()
-    }
-  }
+  g.startEntsTurn()
 
   // The entity ais must be activated before the master ais, otherwise the
   // masters might be running with stale data if one of the entities has been
@@ -474,7 +553,8 @@ func (g *Game) OnRound(do_scripts bool) {
     return ent.Stats == nil || ent.Stats.HpCur() > 0
   })
 
-  if do_scripts {
+  // A game being simulated by a Sim has no script.
+  if do_scripts && g.script != nil {
     g.script.OnRound(g)
   }
 
@@ -1009,7 +1089,7 @@ func (g *Game) Think(dt int64) {
         g.Action_state = doingAction
         ent := g.EntityById(g.current_exec.EntityId())
         g.viewer.RemoveFloorDrawable(g.current_action)
-        g.current_action = ent.Actions[g.current_exec.ActionIndex()]
+        g.current_action = ent.actionFor(g.current_exec)
         g.viewer.AddFloorDrawable(g.current_action)
         ent.current_action = g.current_action
       } else {
@@ -1032,7 +1112,7 @@ func (g *Game) Think(dt int64) {
   if g.current_exec != nil && g.Action_state != verifyingAction && g.Turn_state != turnStateMainPhaseOver {
     ent := g.EntityById(g.current_exec.EntityId())
     g.viewer.RemoveFloorDrawable(g.current_action)
-    g.current_action = ent.Actions[g.current_exec.ActionIndex()]
+    g.current_action = ent.actionFor(g.current_exec)
     g.viewer.AddFloorDrawable(g.current_action)
     ent.current_action = g.current_action
     g.Action_state = verifyingAction
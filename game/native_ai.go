@@ -0,0 +1,252 @@
+package game
+
+import (
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "path/filepath"
+  "runtime/debug"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Native ais are written in Go instead of lua, which makes sense for ais that
+// need to be fast or that are worth testing thoroughly.  They are registered
+// by name, the same way actions are, and an ai path whose last element is
+// "go:<name>" runs the native ai with that name instead of a script, e.g.
+//   "Ai_path": "go:basic_denizen"
+// in an entity, or
+//   bindAi("denizen", "go:denizens")
+// from a script.
+
+// Makes a native ai.  ent is only set if kind is EntityAi.  Should return an
+// error if the ai can't be used for that kind of ai or that entity.
+type NativeAiMaker func(g *Game, ent *Entity, kind AiKind) (Ai, error)
+
+const native_ai_prefix = "go:"
+
+var native_ai_map map[string]NativeAiMaker
+
+var native_ai_makers []func() map[string]NativeAiMaker
+
+func RegisterNativeAiMakers(f func() map[string]NativeAiMaker) {
+  native_ai_makers = append(native_ai_makers, f)
+}
+
+func RegisterNativeAis() {
+  native_ai_map = make(map[string]NativeAiMaker)
+  for _, maker := range native_ai_makers {
+    m := maker()
+    for name, f := range m {
+      if _, ok := native_ai_map[name]; ok {
+        panic(fmt.Sprintf("Tried to register more than one native ai by the same name: '%s'", name))
+      }
+      native_ai_map[name] = f
+    }
+  }
+}
+
+// Returns the names of all registered native ais, sorted.
+func NativeAiNames() []string {
+  var names []string
+  for name := range native_ai_map {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// If path selects a native ai returns its name and true.
+func NativeAiName(path string) (string, bool) {
+  name := filepath.Base(path)
+  if !strings.HasPrefix(name, native_ai_prefix) {
+    return "", false
+  }
+  return strings.TrimPrefix(name, native_ai_prefix), true
+}
+
+// Makes the ai at path, which is either a native ai or a script.  Should be
+// used instead of calling ai_maker directly.  dst is left alone if the ai
+// couldn't be made.
+func makeAi(path string, g *Game, ent *Entity, dst *Ai, kind AiKind) {
+  name, ok := NativeAiName(path)
+  if !ok {
+    ai_maker(path, g, ent, dst, kind)
+    return
+  }
+  f, ok := native_ai_map[name]
+  if !ok {
+    base.Error().Printf("Unable to find a native ai named '%s'", name)
+    return
+  }
+  ai, err := f(g, ent, kind)
+  if err != nil {
+    base.Error().Printf("Unable to make native ai '%s': %v", name, err)
+    return
+  }
+  *dst = ai
+}
+
+// NativeAi does all of the bookkeeping needed to implement Ai, so a native
+// ai only needs to supply a think function.  Each time the ai is activated
+// think is run once, on its own goroutine, and does the ai's turn by calling
+// Exec() for every action it wants to take.  The ai is done for the turn as
+// soon as think returns.
+type NativeAi struct {
+  name  string
+  game  *Game
+  ent   *Entity
+  kind  AiKind
+  think func(a *NativeAi)
+
+  mutex      sync.Mutex
+  active     bool
+  evaluating bool
+
+  execs          chan ActionExec
+  resume         chan struct{}
+  terminate      chan struct{}
+  terminate_once sync.Once
+}
+
+func init() {
+  base.RegisterSaveType(&NativeAi{})
+}
+
+func MakeNativeAi(name string, g *Game, ent *Entity, kind AiKind, think func(a *NativeAi)) *NativeAi {
+  var a NativeAi
+  a.name = name
+  a.game = g
+  a.ent = ent
+  a.kind = kind
+  a.think = think
+  a.execs = make(chan ActionExec)
+  a.resume = make(chan struct{})
+  a.terminate = make(chan struct{})
+  return &a
+}
+
+func (a *NativeAi) Game() *Game {
+  return a.game
+}
+
+// Returns the entity running this ai, or nil if it isn't an entity ai.
+func (a *NativeAi) Entity() *Entity {
+  return a.ent
+}
+
+func (a *NativeAi) Kind() AiKind {
+  return a.kind
+}
+
+// Hands exec to the game and waits until it has been executed.  Returns
+// false if the ai was terminated in the meantime, in which case think should
+// return as soon as possible.
+func (a *NativeAi) Exec(exec ActionExec) bool {
+  select {
+  case a.execs <- exec:
+  case <-a.terminate:
+    return false
+  }
+  select {
+  case <-a.resume:
+  case <-a.terminate:
+    return false
+  }
+  return true
+}
+
+// Used by master ais, runs ent's ai until it gives up an exec and then
+// passes that exec along.  Returns false if ent's ai was already done for the
+// turn, or if it finished without doing anything.
+func (a *NativeAi) ExecEntity(ent *Entity) bool {
+  if !ent.Ai.Active() {
+    return false
+  }
+  exec := <-ent.Ai.ActionExecs()
+  if exec == nil {
+    return false
+  }
+  return a.Exec(exec)
+}
+
+func (a *NativeAi) run() {
+  defer func() {
+    if r := recover(); r != nil {
+      report := AiError{
+        Time:      time.Now(),
+        Path:      native_ai_prefix + a.name,
+        Message:   fmt.Sprintf("%v", r),
+        Traceback: string(debug.Stack()),
+        Kind:      a.kind,
+      }
+      if a.ent != nil {
+        report.Entity = a.ent.Name
+      }
+      ReportAiError(report)
+      base.Error().Printf("%v", &report)
+    }
+    a.mutex.Lock()
+    a.active = false
+    a.evaluating = false
+    a.mutex.Unlock()
+    select {
+    case a.execs <- nil:
+    case <-a.terminate:
+    }
+  }()
+  a.think(a)
+}
+
+// Stops think at its next Exec().  Safe to call more than once.
+func (a *NativeAi) Terminate() {
+  a.terminate_once.Do(func() {
+    close(a.terminate)
+  })
+}
+
+func (a *NativeAi) Activate() {
+  a.mutex.Lock()
+  defer a.mutex.Unlock()
+  a.active = true
+}
+
+func (a *NativeAi) Active() bool {
+  a.mutex.Lock()
+  defer a.mutex.Unlock()
+  return a.active
+}
+
+// Asking for execs again means that the last one is done, so this lets a
+// waiting Exec() return, and starts think if it hasn't been started yet this
+// turn.
+func (a *NativeAi) ActionExecs() <-chan ActionExec {
+  select {
+  case a.resume <- struct{}{}:
+  default:
+  }
+  a.mutex.Lock()
+  defer a.mutex.Unlock()
+  if a.active && !a.evaluating {
+    a.evaluating = true
+    go a.run()
+  }
+  return a.execs
+}
+
+// Native ais are remade from their paths when a game is loaded, so there is
+// nothing to save.
+func (a *NativeAi) GobDecode([]byte) error {
+  return nil
+}
+func (a *NativeAi) GobEncode() ([]byte, error) {
+  return nil, nil
+}
+
+// Returns an error suitable for a NativeAiMaker that was asked to make an
+// ai of a kind that it doesn't support.
+func NativeAiKindError(name string, kind AiKind) error {
+  return errors.New(fmt.Sprintf("Native ai '%s' can't be used as a %v ai.", name, kind))
+}
@@ -0,0 +1,80 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+)
+
+func NativeAiSpec(c gospec.Context) {
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+
+  c.Specify("Native ais are selected by paths ending in go:<name>.", func() {
+    name, ok := game.NativeAiName("/data/ais/go:basic_denizen")
+    c.Expect(ok, Equals, true)
+    c.Expect(name, Equals, "basic_denizen")
+    _, ok = game.NativeAiName("/data/ais/technician.lua")
+    c.Expect(ok, Equals, false)
+  })
+
+  c.Specify("The basic native ais are registered.", func() {
+    names := make(map[string]bool)
+    for _, name := range game.NativeAiNames() {
+      names[name] = true
+    }
+    for _, name := range []string{"basic_denizen", "basic_intruder", "denizens", "minions", "intruders"} {
+      c.Expect(names[name], Equals, true)
+    }
+  })
+
+  c.Specify("Native ais hand over their execs one at a time.", func() {
+    ent, err := s.Spawn("Technician", 47, 25)
+    c.Assume(err, Equals, nil)
+    first := game.BasicActionExec{Ent: ent.Id, Index: 0}
+    second := game.BasicActionExec{Ent: ent.Id, Index: 1}
+    ai := game.MakeNativeAi("test", s.Game, ent, game.EntityAi, func(a *game.NativeAi) {
+      a.Exec(first)
+      a.Exec(second)
+    })
+    defer ai.Terminate()
+    c.Expect(ai.Active(), Equals, false)
+    ai.Activate()
+    c.Expect(ai.Active(), Equals, true)
+    c.Expect(<-ai.ActionExecs(), Equals, game.ActionExec(first))
+    c.Expect(<-ai.ActionExecs(), Equals, game.ActionExec(second))
+    c.Expect(<-ai.ActionExecs(), Equals, nil)
+    c.Expect(ai.Active(), Equals, false)
+  })
+
+  c.Specify("Native ais that panic are done for the turn.", func() {
+    game.ClearAiErrors()
+    ai := game.MakeNativeAi("test", s.Game, nil, game.DenizensAi, func(a *game.NativeAi) {
+      panic("oops")
+    })
+    defer ai.Terminate()
+    ai.Activate()
+    c.Expect(<-ai.ActionExecs(), Equals, nil)
+    c.Expect(ai.Active(), Equals, false)
+    c.Expect(len(game.AiErrors()), Equals, 1)
+  })
+
+  c.Specify("Native ais can be terminated more than once.", func() {
+    ai := game.MakeNativeAi("test", s.Game, nil, game.DenizensAi, func(a *game.NativeAi) {})
+    ai.Terminate()
+    ai.Terminate()
+    c.Expect(ai.Exec(game.BasicActionExec{}), Equals, false)
+  })
+
+  c.Specify("The basic denizen does nothing without an intruder in sight.", func() {
+    ent, err := s.Spawn("Technician", 47, 25)
+    c.Assume(err, Equals, nil)
+    ent.Ai_file_override = "go:basic_denizen"
+    ent.LoadAi()
+    _, ok := ent.Ai.(*game.NativeAi)
+    c.Assume(ok, Equals, true)
+    ent.Ai.Activate()
+    c.Expect(<-ent.Ai.ActionExecs(), Equals, nil)
+    c.Expect(ent.Ai.Active(), Equals, false)
+  })
+}
@@ -0,0 +1,186 @@
+package game
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/mrgnet"
+  "time"
+)
+
+// The local player's side of an online game.  The Net.* functions that level
+// scripts use talk to the server through this.
+type netGame struct {
+  Id  mrgnet.NetId
+  Key mrgnet.GameKey
+
+  // If empty the session token is used, see mrgnet.SetToken().
+  Token string
+}
+
+// Returns the netGame for gp's game, as the player that is logged in.
+func (gp *GamePanel) netGame() netGame {
+  var id mrgnet.NetId
+  fmt.Sscanf(base.GetStoreVal("netid"), "%d", &id)
+  return netGame{Id: id, Key: gp.game.net.key}
+}
+
+// Returns "Denizens" or "Intruders", whichever side the player is on in
+// game, or "" if they aren't playing in it.
+func (ng netGame) Side(game *mrgnet.Game) string {
+  switch {
+  case game.Denizens_id == ng.Id:
+    return "Denizens"
+  case game.Intruders_id == ng.Id:
+    return "Intruders"
+  }
+  return ""
+}
+
+func (ng netGame) updateRequest(turn int, side Side) mrgnet.UpdateGameRequest {
+  var req mrgnet.UpdateGameRequest
+  req.Id = ng.Id
+  req.Token = ng.Token
+  req.Game_key = ng.Key
+  req.Round = (turn+1)/2 - 1 // Server is base-0, lua is base-1
+  req.Intruders = side == SideExplorers
+  return req
+}
+
+func (ng netGame) update(req mrgnet.UpdateGameRequest) error {
+  var resp mrgnet.UpdateGameResponse
+  if err := mrgnet.DoAction("update", req, &resp); err != nil {
+    return err
+  }
+  if resp.Err != "" {
+    return errors.New(resp.Err)
+  }
+  return nil
+}
+
+// Sends the state that side's turn on the specified turn starts from.
+func (ng netGame) UpdateState(turn int, side Side, state []byte) error {
+  req := ng.updateRequest(turn, side)
+  req.Before = state
+  return ng.update(req)
+}
+
+// Sends what side did on the specified turn, and the state that it left the
+// game in.
+func (ng netGame) UpdateExecs(turn int, side Side, state, execs []byte) error {
+  req := ng.updateRequest(turn, side)
+  req.Execs = execs
+  req.After = state
+  return ng.update(req)
+}
+
+// Tells the server that side won.
+func (ng netGame) SetWinner(side Side) error {
+  req := mrgnet.SetWinnerRequest{
+    Id:        ng.Id,
+    Token:     ng.Token,
+    Game_key:  ng.Key,
+    Intruders: side == SideExplorers,
+  }
+  var resp mrgnet.SetWinnerResponse
+  if err := mrgnet.DoAction("winner", req, &resp); err != nil {
+    return err
+  }
+  if resp.Err != "" {
+    return errors.New(resp.Err)
+  }
+  return nil
+}
+
+// Returns the game as the server has it, if sizes_only is set the states and
+// execs are left empty and only tell how many of each there are.
+func (ng netGame) Status(sizes_only bool) (*mrgnet.Game, error) {
+  var req mrgnet.StatusRequest
+  req.Id = ng.Id
+  req.Token = ng.Token
+  req.Game_key = ng.Key
+  req.Sizes_only = sizes_only
+  var resp mrgnet.StatusResponse
+  if err := mrgnet.DoAction("status", req, &resp); err != nil {
+    return nil, err
+  }
+  if resp.Err != "" {
+    return nil, errors.New(resp.Err)
+  }
+  if resp.Game == nil {
+    return nil, errors.New(fmt.Sprintf("No game exists with the key '%s'.", ng.Key))
+  }
+  return resp.Game, nil
+}
+
+// Blocks until the server has the states and execs for the first turns
+// turns.  This only returns an error if the server says something is wrong
+// with the game, if the server can't be reached it keeps trying.
+func (ng netGame) Wait(turns int) error {
+  // Find out where the server is at before checking the status so that an
+  // update can't slip in between the two without us hearing about it.
+  since := ng.waitForChange(0)
+  for {
+    var req mrgnet.StatusRequest
+    req.Id = ng.Id
+    req.Token = ng.Token
+    req.Game_key = ng.Key
+    req.Sizes_only = true
+    var resp mrgnet.StatusResponse
+    if err := mrgnet.DoAction("status", req, &resp); err != nil {
+      // The connection might come back, so keep waiting rather than giving
+      // up on the game entirely.
+      base.Warn().Printf("Unable to get game status, will try again: %v", err)
+      since = ng.waitForChange(since)
+      continue
+    }
+    if resp.Err != "" {
+      return errors.New(resp.Err)
+    }
+    if resp.Game == nil {
+      return errors.New(fmt.Sprintf("No game exists with the key '%s'.", ng.Key))
+    }
+    if len(resp.Game.Before) == len(resp.Game.Execs) && len(resp.Game.Before) == turns {
+      base.Log().Printf("Found the expected %d states", turns)
+      return nil
+    }
+    base.Log().Printf("Found %d instead of %d states", len(resp.Game.Execs), turns)
+    since = ng.waitForChange(since)
+  }
+}
+
+// Blocks until the server says that the game has changed since seq since, and
+// returns the seq to pass in next time.  If the server can't be asked, for
+// instance because it doesn't support waiting, this sleeps for a bit and
+// returns 0 so that the caller ends up polling instead.
+func (ng netGame) waitForChange(since int64) int64 {
+  var resp mrgnet.WaitResponse
+  req := mrgnet.WaitRequest{Id: ng.Id, Token: ng.Token, Game_key: ng.Key, Since: since}
+  err := mrgnet.Wait(context.Background(), req, &resp)
+  if err == nil && resp.Err == "" {
+    return resp.Seq
+  }
+  if err != nil {
+    base.Warn().Printf("Unable to wait on game: %v", err)
+  } else {
+    base.Warn().Printf("Unable to wait on game: %s", resp.Err)
+  }
+  time.Sleep(time.Second * 5)
+  return 0
+}
+
+// Returns the state that the last turn started from and what was done on it.
+func (ng netGame) Latest() (state, execs []byte, err error) {
+  game, err := ng.Status(false)
+  if err != nil {
+    return nil, nil, err
+  }
+  if len(game.Before) != len(game.Execs) {
+    return nil, nil, errors.New("Not the same number of States and Execs")
+  }
+  if len(game.Before) == 0 {
+    return nil, nil, errors.New("No turns have been played yet.")
+  }
+  return game.Before[len(game.Before)-1], game.Execs[len(game.Execs)-1], nil
+}
@@ -0,0 +1,114 @@
+package game_test
+
+import (
+  "errors"
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/mrgnet"
+  "github.com/mik3cap/haunts/mrgnet/server"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "time"
+)
+
+// Fails every request as if the server couldn't be reached.
+type downHandler struct{}
+
+func (downHandler) Handle(name string, data []byte) ([]byte, error) {
+  return nil, errors.New("The server is down.")
+}
+
+func netRegister(name string) mrgnet.RegisterResponse {
+  var resp mrgnet.RegisterResponse
+  mrgnet.DoAction("register", mrgnet.RegisterRequest{Name: name, Secret: "secret " + name}, &resp)
+  return resp
+}
+
+func NetSpec(c gospec.Context) {
+  transport := mrgnet.GetTransport()
+  defer mrgnet.SetTransport(transport)
+  defer mrgnet.SetToken("")
+  mrgnet.SetTransport(mrgnet.LoopbackTransport{Handler: server.MakeServer(server.MakeMemoryStore())})
+
+  alice := netRegister("alice")
+  c.Assume(alice.Err, Equals, "")
+  bob := netRegister("bob")
+  c.Assume(bob.Err, Equals, "")
+
+  // Alice starts a game and bob joins it, so alice is playing the denizens.
+  var new_game mrgnet.NewGameResponse
+  c.Assume(mrgnet.DoAction("new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_game), Equals, nil)
+  c.Assume(new_game.Err, Equals, "")
+  var join mrgnet.JoinGameResponse
+  c.Assume(mrgnet.DoAction("join", mrgnet.JoinGameRequest{Id: bob.Id, Token: bob.Token, Game_key: new_game.Game_key}, &join), Equals, nil)
+  c.Assume(join.Successful, Equals, true)
+
+  denizens := game.NetGame{Id: alice.Id, Key: new_game.Game_key, Token: alice.Token}
+  intruders := game.NetGame{Id: bob.Id, Key: new_game.Game_key, Token: bob.Token}
+
+  c.Specify("The online menu lists games that can be joined separately from active games.", func() {
+    var open mrgnet.NewGameResponse
+    c.Assume(mrgnet.DoAction("new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &open), Equals, nil)
+    c.Assume(open.Err, Equals, "")
+
+    mrgnet.SetToken(bob.Token)
+    unstarted := game.ListOnlineGames(bob.Id, true)
+    c.Expect(unstarted.Err, Equals, "")
+    c.Expect(unstarted.Game_keys, ContainsExactly, Values(open.Game_key))
+    active := game.ListOnlineGames(bob.Id, false)
+    c.Expect(active.Err, Equals, "")
+    c.Expect(active.Game_keys, ContainsExactly, Values(new_game.Game_key))
+  })
+
+  c.Specify("The online menu tells the player when the server can't be reached.", func() {
+    mrgnet.SetTransport(mrgnet.LoopbackTransport{Handler: downHandler{}})
+    mrgnet.SetToken(bob.Token)
+    var resp mrgnet.ListGamesResponse
+    c.Expect(game.DoOnlineAction("list", mrgnet.ListGamesRequest{Id: bob.Id}, &resp), Not(Equals), "")
+    c.Expect(game.ListOnlineGames(bob.Id, false).Err, Not(Equals), "")
+  })
+
+  c.Specify("Players know which side they are playing.", func() {
+    g, err := denizens.Status(true)
+    c.Assume(err, Equals, nil)
+    c.Expect(denizens.Side(g), Equals, "Denizens")
+    c.Expect(intruders.Side(g), Equals, "Intruders")
+    c.Expect(game.NetGame{Id: mrgnet.RandomId()}.Side(g), Equals, "")
+  })
+
+  c.Specify("Turns sent by one player are seen by the other.", func() {
+    c.Assume(denizens.UpdateState(1, game.SideHaunt, []byte("state")), Equals, nil)
+    c.Assume(denizens.UpdateExecs(1, game.SideHaunt, []byte("after"), []byte("execs")), Equals, nil)
+    c.Expect(intruders.Wait(1), Equals, nil)
+    state, execs, err := intruders.Latest()
+    c.Assume(err, Equals, nil)
+    c.Expect(string(state), Equals, "state")
+    c.Expect(string(execs), Equals, "execs")
+  })
+
+  c.Specify("Players can't send turns for the other side.", func() {
+    c.Expect(intruders.UpdateState(1, game.SideHaunt, []byte("state")), Not(Equals), nil)
+    c.Expect(denizens.UpdateState(2, game.SideExplorers, []byte("state")), Not(Equals), nil)
+    _, _, err := denizens.Latest()
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Waiting blocks until the other player finishes their turn.", func() {
+    done := make(chan error, 1)
+    go func() {
+      done <- intruders.Wait(1)
+    }()
+    c.Assume(denizens.UpdateState(1, game.SideHaunt, []byte("state")), Equals, nil)
+    select {
+    case <-done:
+      c.Expect("Wait returned before the turn was finished", Equals, "")
+    case <-time.After(100 * time.Millisecond):
+    }
+    c.Assume(denizens.UpdateExecs(1, game.SideHaunt, []byte("after"), []byte("execs")), Equals, nil)
+    select {
+    case err := <-done:
+      c.Expect(err, Equals, nil)
+    case <-time.After(5 * time.Second):
+      c.Expect("Wait never returned", Equals, "")
+    }
+  })
+}
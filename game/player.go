@@ -5,13 +5,11 @@ package game
 
 import (
   "fmt"
-  "io"
   "os"
   "bytes"
   "hash/fnv"
   "path/filepath"
   "github.com/mik3cap/haunts/base"
-  "encoding/gob"
   lua "github.com/xenith-studios/golua"
 )
 
@@ -53,14 +51,15 @@ func GetAllPlayers() map[string]string {
       return nil
     }
     defer f.Close()
-    dec := gob.NewDecoder(f)
-    var name string
-    err = dec.Decode(&name)
+    var name struct {
+      Name string
+    }
+    err = decodePlayerInto(f, &name)
     if err != nil {
-      base.Warn().Printf("Unable to read player file: %s.", path)
+      base.Warn().Printf("Unable to read player file: %s: %v", path, err)
       return nil
     }
-    players[name] = path
+    players[name.Name] = path
     return nil
   })
   return players
@@ -77,28 +76,6 @@ func UpdatePlayer(p *Player, L *lua.State) {
   p.Lua_store = buffer.Bytes()
 }
 
-// Encode a player's name, then the entire player.  This way we can just read
-// the first value to get it's name without having to de-gob the entire file.
-func EncodePlayer(w io.Writer, p *Player) error {
-  enc := gob.NewEncoder(w)
-  err := enc.Encode(p.Name)
-  if err != nil {
-    return err
-  }
-  return enc.Encode(p)
-}
-
-func DecodePlayer(r io.Reader) (*Player, error) {
-  var p Player
-  dec := gob.NewDecoder(r)
-  err := dec.Decode(&p.Name)
-  if err != nil {
-    return nil, err
-  }
-  err = dec.Decode(&p)
-  return &p, err
-}
-
 func LoadPlayer(path string) (*Player, error) {
   f, err := os.Open(path)
   if err != nil {
@@ -0,0 +1,137 @@
+package game
+
+import (
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game/status"
+)
+
+// An entity can spend the Ap for a readyable action ahead of time so that it
+// can use the action during the other side's turn.  Whenever an enemy moves
+// to a new cell every entity with a readied action gets a chance to use it
+// on the enemy.  The move waits for the reaction to finish and then stops
+// in that cell, whether or not the enemy survived it.  A readied action is
+// used at most once, and is lost at the start of its entity's next turn if
+// it wasn't used.
+//
+// Readying goes through the same path as any other action, with a ReadyExec
+// for the action to ready, so that it works the same for players, ais,
+// replays and online games.
+
+// Actions that can be readied implement this as well as returning true
+// from Readyable().
+type Reaction interface {
+  Action
+
+  // Returns an exec that uses the action on target as a reaction, or nil if
+  // the action can't be used on target right now.  The action should check
+  // UseReadied() when it runs the exec, and not spend any Ap if it returns
+  // true, since that was spent when the action was readied.
+  ReactionExec(ent, target *Entity) ActionExec
+}
+
+type ReadiedAction struct {
+  // Index into Entity.Actions.
+  Index int
+}
+
+func init() {
+  base.RegisterSaveType(&ReadyExec{})
+}
+
+// Readies the action at Index instead of using it.
+type ReadyExec struct {
+  BasicActionExec
+}
+
+// Returns an exec that readies action, or nil if ent can't ready it.
+func MakeReadyExec(ent *Entity, action Action) ActionExec {
+  if !CanReady(ent, action) {
+    return nil
+  }
+  var exec ReadyExec
+  exec.SetBasicData(ent, action)
+  return &exec
+}
+
+// Returns true if ent can ready action right now.
+func CanReady(ent *Entity, action Action) bool {
+  if _, ok := action.(Reaction); !ok || !action.Readyable() {
+    return false
+  }
+  return ent.Stats != nil && ent.Stats.ApCur() >= action.AP()
+}
+
+// Returns the action that should run exec.  This is normally just the
+// entity's action at exec.ActionIndex(), but readying an action is handled
+// by the game rather than the action itself.
+func (e *Entity) actionFor(exec ActionExec) Action {
+  action := e.Actions[exec.ActionIndex()]
+  if _, ok := exec.(*ReadyExec); ok {
+    return &readyAction{Action: action}
+  }
+  return action
+}
+
+// Runs a ReadyExec.  Everything but Maintain is left to the action being
+// readied, except that it doesn't draw anything.
+type readyAction struct {
+  Action
+}
+
+func (a *readyAction) RenderOnFloor() {}
+
+func (a *readyAction) Maintain(dt int64, g *Game, exec ActionExec) MaintenanceStatus {
+  if exec == nil {
+    return Complete
+  }
+  ent := g.EntityById(exec.EntityId())
+  if ent == nil || !CanReady(ent, a.Action) {
+    base.Error().Printf("Got a ready exec that was invalid: %v", exec)
+    return Complete
+  }
+  ent.Stats.ApplyDamage(-a.Action.AP(), 0, status.Unspecified)
+  ent.Readied = &ReadiedAction{Index: exec.ActionIndex()}
+  return Complete
+}
+
+// Called whenever mover reaches a new cell.  If an enemy of mover has a
+// readied action that it can use on mover it is returned along with the exec
+// to run it with, otherwise nil is returned.  The readied action is used up
+// once the exec is run, see UseReadied().
+func (g *Game) TriggerReaction(mover *Entity) (Action, ActionExec) {
+  for _, ent := range g.Ents {
+    if ent.Readied == nil || ent.Side() == mover.Side() {
+      continue
+    }
+    if ent.Stats == nil || ent.Stats.HpCur() <= 0 {
+      continue
+    }
+    index := ent.Readied.Index
+    if index < 0 || index >= len(ent.Actions) {
+      ent.Readied = nil
+      continue
+    }
+    reaction, ok := ent.Actions[index].(Reaction)
+    if !ok || !reaction.Interrupt() {
+      continue
+    }
+    exec := reaction.ReactionExec(ent, mover)
+    if exec == nil {
+      continue
+    }
+    return reaction, exec
+  }
+  return nil, nil
+}
+
+// Returns true if exec is ent using its readied action during the other
+// side's turn, in which case the readied action is used up.  Actions call
+// this when they run an exec rather than trusting the exec, which may have
+// come from another player.
+func (g *Game) UseReadied(ent *Entity, exec ActionExec) bool {
+  if ent.Readied == nil || ent.Side() == g.Side || ent.Readied.Index != exec.ActionIndex() {
+    return false
+  }
+  ent.Readied = nil
+  return true
+}
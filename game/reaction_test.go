@@ -0,0 +1,89 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/game/actions"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+)
+
+func ReactionSpec(c gospec.Context) {
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+  ent, err := s.Spawn("Technician", 47, 25)
+  c.Assume(err, Equals, nil)
+  // Move, Interact, Envenomed Dart, Inject
+  move := ent.Actions[0]
+  dart := ent.Actions[2]
+
+  c.Specify("Only attacks can be readied.", func() {
+    c.Expect(game.CanReady(ent, dart), Equals, true)
+    c.Expect(game.CanReady(ent, move), Equals, false)
+    c.Expect(game.MakeReadyExec(ent, move), Equals, nil)
+  })
+
+  c.Specify("Readying an action spends its Ap.", func() {
+    ap := ent.Stats.ApCur()
+    exec := game.MakeReadyExec(ent, dart)
+    c.Assume(exec, Not(Equals), nil)
+    c.Expect(s.Exec(exec), Equals, nil)
+    c.Assume(ent.Readied, Not(Equals), nil)
+    c.Expect(ent.Readied.Index, Equals, 2)
+    c.Expect(ent.Stats.ApCur(), Equals, ap-dart.AP())
+  })
+
+  c.Specify("Actions can't be readied without enough Ap.", func() {
+    exec := game.MakeReadyExec(ent, dart)
+    c.Assume(exec, Not(Equals), nil)
+    c.Expect(s.Exec(exec), Equals, nil)
+    exec = game.MakeReadyExec(ent, dart)
+    c.Assume(exec, Not(Equals), nil)
+    c.Expect(s.Exec(exec), Equals, nil)
+    c.Expect(game.CanReady(ent, dart), Equals, false)
+  })
+
+  c.Specify("Readied actions last until their side's next turn.", func() {
+    c.Assume(s.Exec(game.MakeReadyExec(ent, dart)), Equals, nil)
+    s.EndTurn()
+    c.Expect(ent.Readied, Not(Equals), nil)
+    s.EndTurn()
+    c.Expect(ent.Readied, Equals, (*game.ReadiedAction)(nil))
+  })
+
+  c.Specify("Entities don't react to their own side.", func() {
+    ally, err := s.Spawn("Technician", 47, 27)
+    c.Assume(err, Equals, nil)
+    c.Assume(s.Exec(game.MakeReadyExec(ent, dart)), Equals, nil)
+    action, exec := s.Game.TriggerReaction(ally)
+    c.Expect(action, Equals, nil)
+    c.Expect(exec, Equals, nil)
+    c.Expect(ent.Readied, Not(Equals), nil)
+  })
+
+  // Chill Touch has range 1, so it can reach 48,23 from 48,25 but not 48,22.
+  c.Specify("Readied attacks are used on enemies that move into range.", func() {
+    shade, err := s.Spawn("Angry Shade", 48, 25)
+    c.Assume(err, Equals, nil)
+    teen, err := s.Spawn("Teen", 48, 22)
+    c.Assume(err, Equals, nil)
+    s.Game.UpdateEntLos(shade, true)
+    touch := shade.Actions[2]
+    c.Assume(s.Exec(game.MakeReadyExec(shade, touch)), Equals, nil)
+    ap := shade.Stats.ApCur()
+    s.EndTurn()
+
+    entries := s.Game.CombatLog().Len()
+    move := teen.Actions[0].(*actions.Move)
+    exec := move.AiMoveToPos(teen, []int{s.Game.ToVertex(48, 24)}, 10)
+    c.Assume(exec, Not(Equals), nil)
+    c.Expect(s.Exec(exec), Equals, nil)
+    c.Expect(s.Game.CombatLog().Len(), Equals, entries+1)
+    c.Expect(shade.Readied, Equals, (*game.ReadiedAction)(nil))
+    c.Expect(shade.Stats.ApCur(), Equals, ap)
+
+    // The move stops where the attack was made.
+    x, y := teen.Pos()
+    c.Expect(x, Equals, 48)
+    c.Expect(y, Equals, 23)
+  })
+}
@@ -0,0 +1,512 @@
+package game
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "github.com/mik3cap/glop/gin"
+  "github.com/mik3cap/glop/gui"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/mrgnet"
+  "github.com/mik3cap/opengl/gl"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+)
+
+// Keeps track of where a replay is and what the player has asked it to do.
+// The script goroutine plays the game back turn by turn and checks in here
+// before every exec, the replay bar changes it from the ui.
+type replayControl struct {
+  game *mrgnet.Game
+
+  // The replay bar, this is kept on top of the viewer whenever a state is
+  // loaded.
+  bar gui.Widget
+
+  mutex sync.Mutex
+  cond  *sync.Cond
+
+  // The turn that is being played, or will be played next.  Once the whole
+  // game has been played this is len(game.Execs).
+  turn int
+
+  playing bool
+
+  // While paused, the number of execs that can still be run.
+  steps int
+
+  // The turn to jump to, or -1 if the player hasn't asked to jump anywhere.
+  seek int
+
+  // Set once the replay has been closed.
+  done bool
+}
+
+func makeReplayControl(game *mrgnet.Game) *replayControl {
+  rc := &replayControl{game: game, seek: -1}
+  rc.cond = sync.NewCond(&rc.mutex)
+  return rc
+}
+
+// Starts playing, or pauses if already playing.  If the whole game has
+// already been played it starts again from the beginning.
+func (rc *replayControl) TogglePlay() {
+  rc.mutex.Lock()
+  defer rc.mutex.Unlock()
+  if !rc.playing && rc.seek < 0 && rc.turn >= len(rc.game.Execs) {
+    rc.seek = 0
+  }
+  rc.playing = !rc.playing
+  rc.cond.Broadcast()
+}
+
+// Runs a single exec and then pauses.
+func (rc *replayControl) Step() {
+  rc.mutex.Lock()
+  defer rc.mutex.Unlock()
+  rc.playing = false
+  rc.steps++
+  rc.cond.Broadcast()
+}
+
+// Jumps to the start of the specified turn using the state that was stored
+// for it.  Seeking to len(game.Execs) shows the state after the last turn.
+func (rc *replayControl) Seek(turn int) {
+  rc.mutex.Lock()
+  defer rc.mutex.Unlock()
+  if turn < 0 {
+    turn = 0
+  }
+  if turn > len(rc.game.Execs) {
+    turn = len(rc.game.Execs)
+  }
+  rc.seek = turn
+  rc.steps = 0
+  rc.cond.Broadcast()
+}
+
+// Returns the turn that is being played and whether or not the replay is
+// running on its own.
+func (rc *replayControl) Status() (turn int, playing bool) {
+  rc.mutex.Lock()
+  defer rc.mutex.Unlock()
+  if rc.seek >= 0 {
+    return rc.seek, rc.playing
+  }
+  return rc.turn, rc.playing
+}
+
+// Stops the replay for good, the script goroutine exits as soon as it checks
+// in.
+func (rc *replayControl) stop() {
+  rc.mutex.Lock()
+  defer rc.mutex.Unlock()
+  rc.done = true
+  rc.cond.Broadcast()
+}
+
+// Must be called with rc.mutex held.
+func (rc *replayControl) waitLocked() {
+  for !rc.done && !rc.playing && rc.steps == 0 && rc.seek < 0 {
+    rc.cond.Wait()
+  }
+}
+
+// Called before each exec, blocks until the player lets the exec run.
+// Returns false if the exec should be skipped because the replay is about
+// to jump somewhere else or has been closed.
+func (rc *replayControl) waitForExec() bool {
+  rc.mutex.Lock()
+  defer rc.mutex.Unlock()
+  rc.waitLocked()
+  if rc.done || rc.seek >= 0 {
+    return false
+  }
+  if !rc.playing {
+    rc.steps--
+  }
+  return true
+}
+
+// The state from before the turn, or the final state if turn is past the
+// last turn.
+func (rc *replayControl) stateAt(turn int) []byte {
+  if turn < len(rc.game.Before) {
+    return rc.game.Before[turn]
+  }
+  return rc.game.After[len(rc.game.After)-1]
+}
+
+// Called by loadGameStateRaw every time a state is loaded while replaying.
+func (rc *replayControl) stateLoaded(gp *GamePanel) {
+  // Execs can only be run when the game thinks that the main phase is over,
+  // this is the same state the game is in when a player watches their
+  // opponent's turn.
+  gp.game.Turn_state = turnStateMainPhaseOver
+  gp.AnchorBox.RemoveChild(rc.bar)
+  gp.AnchorBox.AddChild(rc.bar, gui.Anchor{0.5, 0, 0.5, 0})
+}
+
+// Runs on the script goroutine until the replay is closed.
+func (rc *replayControl) run(gp *GamePanel) {
+  rc.loadTurn(gp, 0)
+  gp.script.L.DoString("OnStartup()")
+  for {
+    rc.mutex.Lock()
+    rc.waitLocked()
+    if rc.done {
+      rc.mutex.Unlock()
+      return
+    }
+    if rc.seek >= 0 {
+      rc.turn = rc.seek
+      rc.seek = -1
+      turn := rc.turn
+      rc.mutex.Unlock()
+      rc.loadTurn(gp, turn)
+      continue
+    }
+    if rc.turn >= len(rc.game.Execs) {
+      rc.playing = false
+      rc.steps = 0
+      rc.mutex.Unlock()
+      continue
+    }
+    turn := rc.turn
+    rc.mutex.Unlock()
+
+    rc.playTurn(gp, turn)
+
+    rc.mutex.Lock()
+    if rc.seek < 0 {
+      rc.turn = turn + 1
+    }
+    rc.mutex.Unlock()
+  }
+}
+
+func (rc *replayControl) loadTurn(gp *GamePanel, turn int) {
+  gp.script.syncStart()
+  defer gp.script.syncEnd()
+  loadGameStateRaw(gp, gp.script.L, string(rc.stateAt(turn)))
+}
+
+// Plays back a single turn with the script's DoPlayback(), the same way the
+// other player would have seen it.
+func (rc *replayControl) playTurn(gp *GamePanel, turn int) {
+  L := gp.script.L
+  L.GetGlobal("DoPlayback")
+  has_playback := L.IsFunction(-1)
+  L.Pop(1)
+  if !has_playback {
+    base.Warn().Printf("Script has no DoPlayback(), replaying execs directly.")
+    rc.playTurnDirectly(gp, turn)
+    return
+  }
+
+  gp.script.syncStart()
+  // The level scripts keep track of whose turn it is in this global.
+  _, intruders := mrgnet.TurnRound(turn)
+  L.PushBoolean(intruders)
+  L.SetGlobal("intruders")
+  L.PushString(string(rc.game.Before[turn]))
+  L.SetGlobal("__replay_state")
+  err := LuaDecodeValue(bytes.NewBuffer(rc.game.Execs[turn]), L, gp.game)
+  if err != nil {
+    gp.script.syncEnd()
+    base.Error().Printf("Unable to decode execs for turn %d: %v", turn, err)
+    return
+  }
+  L.SetGlobal("__replay_execs")
+  gp.script.syncEnd()
+
+  if !L.DoString("DoPlayback(__replay_state, __replay_execs)") {
+    base.Error().Printf("There was an error replaying turn %d.", turn)
+  }
+}
+
+// Plays back the actions from a turn without any help from the script, so
+// anything the script did on its own during the turn is lost.
+func (rc *replayControl) playTurnDirectly(gp *GamePanel, turn int) {
+  rc.loadTurn(gp, turn)
+  encoded, err := decodeEncodedExecs(rc.game.Execs[turn])
+  if err != nil {
+    base.Error().Printf("Unable to decode execs for turn %d: %v", turn, err)
+    return
+  }
+  for _, str := range encoded {
+    if !rc.waitForExec() {
+      return
+    }
+    var execs []ActionExec
+    err := base.FromBase64FromGob(&execs, str)
+    if err != nil {
+      base.Error().Printf("Error decoding exec: %v", err)
+      return
+    }
+    for _, exec := range execs {
+      runExec(gp, exec)
+    }
+  }
+}
+
+// Writes the game being replayed to the replays directory so that it can be
+// watched later with InsertReplayFromFile.  Returns the path it was written
+// to.
+func (rc *replayControl) export(name string) (string, error) {
+  dir := filepath.Join(base.GetDataDir(), "replays")
+  err := os.MkdirAll(dir, 0755)
+  if err != nil {
+    return "", err
+  }
+  path := filepath.Join(dir, filepath.Base(name)+".replay")
+  f, err := os.Create(path)
+  if err != nil {
+    return "", err
+  }
+  defer f.Close()
+  return path, mrgnet.WriteGame(f, rc.game)
+}
+
+// Returns game with only the turns that have been finished, those that have
+// a state from before the turn, the turn's execs and a state from after it.
+// A game that is still going usually has a turn that has started but not
+// ended yet.
+func completeTurns(game *mrgnet.Game) *mrgnet.Game {
+  turns := len(game.Execs)
+  if len(game.Before) < turns {
+    turns = len(game.Before)
+  }
+  if len(game.After) < turns {
+    turns = len(game.After)
+  }
+  complete := *game
+  complete.Before = game.Before[0:turns]
+  complete.Execs = game.Execs[0:turns]
+  complete.After = game.After[0:turns]
+  return &complete
+}
+
+// Makes a panel that plays back game, which can be a game from the server or
+// one read from an exported file.  name is used for the exported file.  A
+// turn that hasn't been finished yet is left out.
+func MakeReplayPanel(game *mrgnet.Game, name string) (*GamePanel, error) {
+  game = completeTurns(game)
+  if len(game.Execs) == 0 || len(game.Script) == 0 {
+    return nil, errors.New("Nothing has happened in this game yet.")
+  }
+  var gp GamePanel
+  gp.AnchorBox = gui.MakeAnchorBox(gui.Dims{1024, 768})
+  gp.replay = makeReplayControl(game)
+  bar, err := makeReplayBar(&gp, name)
+  if err != nil {
+    return nil, err
+  }
+  gp.replay.bar = bar
+  gp.AnchorBox.AddChild(bar, gui.Anchor{0.5, 0, 0.5, 0})
+
+  makeGameScript(&gp, &Player{}, "")
+  gp.script.L.NewTable()
+  gp.script.L.SetGlobal("store")
+  if !gp.script.L.DoString(string(game.Script)) {
+    return nil, errors.New("There was an error running the game's script.")
+  }
+  gp.script.L.SetExecutionLimit(250000)
+  gp.script.sync = make(chan struct{})
+  go gp.replay.run(&gp)
+  return &gp, nil
+}
+
+// Reads a game exported from a replay and starts playing it back.
+func InsertReplayFromFile(ui gui.WidgetParent, path string) error {
+  f, err := os.Open(path)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  game, err := mrgnet.ReadGame(f)
+  if err != nil {
+    return errors.New(fmt.Sprintf("Unable to read replay %s: %v", path, err))
+  }
+  name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+  gp, err := MakeReplayPanel(game, name)
+  if err != nil {
+    return err
+  }
+  ui.AddChild(gp)
+  return nil
+}
+
+type replayLayout struct {
+  Dx, Dy int
+
+  Prev, Play, Step, Next Button
+  Export, Quit           Button
+
+  Status struct {
+    X, Y int
+    Size int
+  }
+}
+
+// The strip of controls along the bottom of a replay.
+type replayBar struct {
+  layout  replayLayout
+  region  gui.Region
+  buttons []ButtonLike
+  mx, my  int
+  last_t  int64
+
+  rc *replayControl
+
+  // Shown after the status, e.g. where the game was exported to.
+  message string
+}
+
+func makeReplayBar(gp *GamePanel, name string) (*replayBar, error) {
+  var rb replayBar
+  datadir := base.GetDataDir()
+  err := base.LoadAndProcessObject(filepath.Join(datadir, "ui", "replay", "layout.json"), "json", &rb.layout)
+  if err != nil {
+    return nil, err
+  }
+  rb.rc = gp.replay
+  rb.buttons = []ButtonLike{
+    &rb.layout.Prev,
+    &rb.layout.Play,
+    &rb.layout.Step,
+    &rb.layout.Next,
+    &rb.layout.Export,
+    &rb.layout.Quit,
+  }
+  rb.layout.Prev.f = func(interface{}) {
+    turn, _ := rb.rc.Status()
+    rb.rc.Seek(turn - 1)
+  }
+  rb.layout.Next.f = func(interface{}) {
+    turn, _ := rb.rc.Status()
+    rb.rc.Seek(turn + 1)
+  }
+  rb.layout.Play.f = func(interface{}) {
+    rb.rc.TogglePlay()
+  }
+  rb.layout.Play.key = gin.Space
+  rb.layout.Step.f = func(interface{}) {
+    rb.rc.Step()
+  }
+  rb.layout.Export.f = func(interface{}) {
+    path, err := rb.rc.export(name)
+    if err != nil {
+      base.Error().Printf("Unable to export replay: %v", err)
+      rb.message = "Unable to export."
+      return
+    }
+    rb.message = fmt.Sprintf("Saved to %s", path)
+  }
+  rb.layout.Quit.f = func(interface{}) {
+    rb.rc.stop()
+    if gp.game != nil {
+      gp.game.Ents = nil
+      gp.game.Think(1) // This should clean things up
+    }
+    Restart()
+  }
+  return &rb, nil
+}
+
+func (rb *replayBar) Requested() gui.Dims {
+  return gui.Dims{rb.layout.Dx, rb.layout.Dy}
+}
+
+func (rb *replayBar) Expandable() (bool, bool) {
+  return false, false
+}
+
+func (rb *replayBar) Rendered() gui.Region {
+  return rb.region
+}
+
+func (rb *replayBar) Think(g *gui.Gui, t int64) {
+  if rb.last_t == 0 {
+    rb.last_t = t
+    return
+  }
+  dt := t - rb.last_t
+  rb.last_t = t
+  if rb.mx == 0 && rb.my == 0 {
+    rb.mx, rb.my = gin.In().GetCursor("Mouse").Point()
+  }
+  if _, playing := rb.rc.Status(); playing {
+    rb.layout.Play.Text.String = "Pause"
+  } else {
+    rb.layout.Play.Text.String = "Play"
+  }
+  for _, button := range rb.buttons {
+    button.Think(rb.region.X, rb.region.Y, rb.mx, rb.my, dt)
+  }
+}
+
+func (rb *replayBar) Respond(g *gui.Gui, group gui.EventGroup) bool {
+  cursor := group.Events[0].Key.Cursor()
+  if cursor != nil {
+    rb.mx, rb.my = cursor.Point()
+  }
+  if found, event := group.FindEvent(gin.MouseLButton); found && event.Type == gin.Press {
+    for _, button := range rb.buttons {
+      if button.handleClick(rb.mx, rb.my, nil) {
+        return true
+      }
+    }
+  }
+  for _, button := range rb.buttons {
+    if button.Respond(group, nil) {
+      return true
+    }
+  }
+  return cursor != nil && (gui.Point{rb.mx, rb.my}.Inside(rb.region))
+}
+
+func (rb *replayBar) Draw(region gui.Region) {
+  rb.region = region
+  gl.Disable(gl.TEXTURE_2D)
+  gl.Color4ub(0, 0, 0, 160)
+  gl.Begin(gl.QUADS)
+  gl.Vertex2i(region.X, region.Y)
+  gl.Vertex2i(region.X, region.Y+region.Dy)
+  gl.Vertex2i(region.X+region.Dx, region.Y+region.Dy)
+  gl.Vertex2i(region.X+region.Dx, region.Y)
+  gl.End()
+
+  for _, button := range rb.buttons {
+    button.RenderAt(region.X, region.Y)
+  }
+
+  turn, _ := rb.rc.Status()
+  var status string
+  if turn >= len(rb.rc.game.Execs) {
+    status = "End of game"
+  } else {
+    round, intruders := mrgnet.TurnRound(turn)
+    side := "Denizens"
+    if intruders {
+      side = "Intruders"
+    }
+    status = fmt.Sprintf("Round %d, %s (turn %d of %d)", round+1, side, turn+1, len(rb.rc.game.Execs))
+  }
+  if rb.message != "" {
+    status = fmt.Sprintf("%s - %s", status, rb.message)
+  }
+  gl.Disable(gl.TEXTURE_2D)
+  gl.Color4ub(255, 255, 255, 255)
+  d := base.GetDictionary(rb.layout.Status.Size)
+  d.RenderString(status, float64(region.X+rb.layout.Status.X), float64(region.Y+rb.layout.Status.Y), 0, d.MaxHeight(), gui.Left)
+}
+
+func (rb *replayBar) DrawFocused(region gui.Region) {
+}
+
+func (rb *replayBar) String() string {
+  return "replay bar"
+}
@@ -0,0 +1,76 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/mrgnet"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+)
+
+func ReplaySpec(c gospec.Context) {
+  // Three finished turns and a fourth that has started but not ended.
+  g := &mrgnet.Game{
+    Script: []byte("script"),
+    Before: [][]byte{[]byte("b0"), []byte("b1"), []byte("b2"), []byte("b3")},
+    Execs:  [][]byte{[]byte("e0"), []byte("e1"), []byte("e2")},
+    After:  [][]byte{[]byte("a0"), []byte("a1"), []byte("a2")},
+  }
+
+  c.Specify("Turns that haven't been finished are left out of replays.", func() {
+    complete := game.CompleteTurns(g)
+    c.Expect(len(complete.Before), Equals, 3)
+    c.Expect(len(complete.Execs), Equals, 3)
+    c.Expect(len(complete.After), Equals, 3)
+    c.Expect(string(complete.Before[2]), Equals, "b2")
+    c.Expect(len(g.Before), Equals, 4)
+
+    started := game.CompleteTurns(&mrgnet.Game{Before: [][]byte{[]byte("b0")}})
+    c.Expect(len(started.Before), Equals, 0)
+  })
+
+  rc := game.MakeReplayControl(game.CompleteTurns(g))
+
+  c.Specify("Seeking stays within the game.", func() {
+    rc.Seek(-2)
+    turn, _ := rc.Status()
+    c.Expect(turn, Equals, 0)
+    rc.Seek(2)
+    turn, _ = rc.Status()
+    c.Expect(turn, Equals, 2)
+    rc.Seek(10)
+    turn, _ = rc.Status()
+    c.Expect(turn, Equals, 3)
+  })
+
+  c.Specify("Seeking skips whatever is left of the current turn.", func() {
+    rc.TogglePlay()
+    c.Expect(rc.WaitForExec(), Equals, true)
+    rc.Seek(1)
+    c.Expect(rc.WaitForExec(), Equals, false)
+  })
+
+  c.Specify("Stepping runs one exec and pauses.", func() {
+    rc.TogglePlay()
+    rc.Step()
+    _, playing := rc.Status()
+    c.Expect(playing, Equals, false)
+    c.Expect(rc.WaitForExec(), Equals, true)
+
+    // The next exec waits for another step, seeking wakes it up without
+    // running it.
+    ran := make(chan bool)
+    go func() {
+      ran <- rc.WaitForExec()
+    }()
+    rc.Seek(0)
+    c.Expect(<-ran, Equals, false)
+  })
+
+  c.Specify("Playing after the end of the game starts again.", func() {
+    rc.SetTurn(3)
+    rc.TogglePlay()
+    turn, playing := rc.Status()
+    c.Expect(turn, Equals, 0)
+    c.Expect(playing, Equals, true)
+  })
+}
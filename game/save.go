@@ -0,0 +1,235 @@
+package game
+
+import (
+  "bytes"
+  "encoding/base64"
+  "encoding/gob"
+  "github.com/mik3cap/haunts/base"
+  lua "github.com/xenith-studios/golua"
+  "io"
+  "io/ioutil"
+)
+
+// Game states, as made by Script.SaveGameState(), and player files are both
+// wrapped in a base.SaveFormat so that old saves and online games can be
+// loaded after the structs that they're made of have changed.
+//
+// Payloads are made with base.EncodeSaveTree(), so they are json that
+// describes itself and a migration can read and change an old payload
+// without the types that made it.
+//
+// A game state is a base.SaveFormat of kind "game" whose payload is a
+// totalState, the whole thing is then base64 encoded so that it can be
+// passed around as a lua string.  These same strings are what get sent to
+// the server as a game's Before and After states.  The Game in a totalState
+// is saved as a gameSaveTree, the gameDataGobbable and the
+// sprite.SpriteState of each entity.
+//
+// A player file is a base.SaveFormat of kind "player" whose payload is the
+// Player.
+//
+// A save slot is a base.SaveFormat of kind "slot" whose payload is a
+// saveSlotPayload, so a change to Player means bumping both
+// player_save_version and slot_save_version.
+//
+// Before these formats existed game states and player files were the same
+// payloads with no header, those are loaded as version 0.  Up to game
+// version 5, player version 1 and slot version 1 payloads were gobs, those
+// are decoded and saved again as trees by the next version's migration.
+//
+// Whenever anything in a payload changes the version must be bumped and a
+// migration from the previous version added below, otherwise saves made with
+// the previous code will refuse to load.  Fields that are missing from a
+// payload are left at their zero value and fields that aren't in the type
+// anymore are ignored, so adding or removing a field needs a migration that
+// does nothing.  Anything else, like renaming a field, needs a migration
+// made with AddTreeMigration() that moves the old data to where it goes now.
+const (
+  game_save_version   = 7
+  player_save_version = 2
+  slot_save_version   = 2
+)
+
+var game_save_format = base.MakeSaveFormat("game", game_save_version,
+  totalState{}, gameSaveTree{}, gameDataGobbable{})
+
+var player_save_format = base.MakeSaveFormat("player", player_save_version,
+  Player{})
+
+var slot_save_format = base.MakeSaveFormat("slot", slot_save_version,
+  saveSlotPayload{})
+
+func init() {
+  // Version 0 saves had no header but the payload was the same.
+  game_save_format.AddMigration(0, sameSavePayload)
+  player_save_format.AddMigration(0, sameSavePayload)
+
+  // Version 2 added Game.Difficulty.
+  game_save_format.AddMigration(1, sameSavePayload)
+
+  // Version 3 added Game.Blackboards.
+  game_save_format.AddMigration(2, sameSavePayload)
+
+  // Version 4 added Entity.Readied.
+  game_save_format.AddMigration(3, sameSavePayload)
+
+  // Version 5 added Game.Combat_log.
+  game_save_format.AddMigration(4, sameSavePayload)
+
+  // These versions switched from gob to save trees.
+  game_save_format.AddMigration(5, gameSaveFromGob)
+  player_save_format.AddMigration(1, playerSaveFromGob)
+  slot_save_format.AddMigration(1, slotSaveFromGob)
+
+  // Version 7 added Game.Winner.
+  game_save_format.AddMigration(6, sameSavePayload)
+}
+
+func sameSavePayload(payload []byte) ([]byte, error) {
+  return payload, nil
+}
+
+// Holds something that did its own gob encoding without decoding it, so
+// that the old gob payloads can be taken apart without running
+// Game.GobDecode().
+type rawGob []byte
+
+func (r *rawGob) GobDecode(data []byte) error {
+  *r = append(rawGob(nil), data...)
+  return nil
+}
+
+// Turns a gobbed totalState into a tree of one.  Decoding straight into a
+// Game would load the house and start its ais, so the game is taken apart
+// by hand into what Game.GobEncode() wrote.
+func gameSaveFromGob(payload []byte) ([]byte, error) {
+  var old struct {
+    Game  rawGob
+    Store []byte
+  }
+  err := gob.NewDecoder(bytes.NewBuffer(payload)).Decode(&old)
+  if err != nil {
+    return nil, err
+  }
+  var tree struct {
+    Game  *gameSaveTree
+    Store []byte
+  }
+  tree.Store = old.Store
+  if old.Game != nil {
+    tree.Game = &gameSaveTree{Data: &gameDataGobbable{}}
+    dec := gob.NewDecoder(bytes.NewBuffer(old.Game))
+    err = dec.Decode(tree.Game.Data)
+    if err != nil {
+      return nil, err
+    }
+    err = dec.Decode(&tree.Game.Sprites)
+    if err != nil {
+      return nil, err
+    }
+  }
+  return base.EncodeSaveTree(tree)
+}
+
+// Turns a player's gobbed name followed by the gobbed player into a tree of
+// the player.
+func playerSaveFromGob(payload []byte) ([]byte, error) {
+  dec := gob.NewDecoder(bytes.NewBuffer(payload))
+  var p Player
+  err := dec.Decode(&p.Name)
+  if err != nil {
+    return nil, err
+  }
+  err = dec.Decode(&p)
+  if err != nil {
+    return nil, err
+  }
+  return base.EncodeSaveTree(p)
+}
+
+// Turns a gobbed SaveSlot followed by the gobbed Player into a tree of a
+// saveSlotPayload.
+func slotSaveFromGob(payload []byte) ([]byte, error) {
+  dec := gob.NewDecoder(bytes.NewBuffer(payload))
+  var sp saveSlotPayload
+  err := dec.Decode(&sp.Slot)
+  if err != nil {
+    return nil, err
+  }
+  err = dec.Decode(&sp.Player)
+  if err != nil {
+    return nil, err
+  }
+  return base.EncodeSaveTree(sp)
+}
+
+// Encodes ts in the current game save format.
+func encodeGameState(ts totalState) (string, error) {
+  payload, err := base.EncodeSaveTree(ts)
+  if err != nil {
+    return "", err
+  }
+  data, err := game_save_format.Encode(payload)
+  if err != nil {
+    return "", err
+  }
+  return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Returns the current state of the game and the lua store, in the same form
+// as Script.SaveGameState().  The caller is responsible for syncing with the
+// script.
+func currentGameState(gp *GamePanel, L *lua.State) (string, error) {
+  buf := bytes.NewBuffer(nil)
+  L.GetGlobal("store")
+  LuaEncodeValue(buf, L, -1)
+  L.Pop(1)
+  return encodeGameState(totalState{Game: &gp.game, Store: buf.Bytes()})
+}
+
+// Decodes a state made by encodeGameState, or by any older version of it,
+// into ts.
+func decodeGameState(state string, ts *totalState) error {
+  data, err := base64.StdEncoding.DecodeString(state)
+  if err != nil {
+    return err
+  }
+  payload, err := game_save_format.Decode(data)
+  if err != nil {
+    return err
+  }
+  return base.DecodeSaveTree(payload, ts)
+}
+
+func EncodePlayer(w io.Writer, p *Player) error {
+  payload, err := base.EncodeSaveTree(p)
+  if err != nil {
+    return err
+  }
+  data, err := player_save_format.Encode(payload)
+  if err != nil {
+    return err
+  }
+  _, err = w.Write(data)
+  return err
+}
+
+// Decodes a player file into p, which can be a *Player or a pointer to
+// anything with just the fields that are wanted, like the Name.
+func decodePlayerInto(r io.Reader, p interface{}) error {
+  data, err := ioutil.ReadAll(r)
+  if err != nil {
+    return err
+  }
+  payload, err := player_save_format.Decode(data)
+  if err != nil {
+    return err
+  }
+  return base.DecodeSaveTree(payload, p)
+}
+
+func DecodePlayer(r io.Reader) (*Player, error) {
+  var p Player
+  err := decodePlayerInto(r, &p)
+  return &p, err
+}
@@ -0,0 +1,354 @@
+package game
+
+import (
+  "errors"
+  "fmt"
+  "hash/fnv"
+  "image"
+  "image/png"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/opengl/gl"
+)
+
+// A SaveSlot describes one of a player's named saves.  Each player can have
+// any number of slots, they are stored in datadir/saves/, one directory per
+// player, and each slot is a .save file with an optional .png thumbnail next
+// to it.
+type SaveSlot struct {
+  // Name of the slot, as specified by the player.  Saving to a slot with the
+  // same name as an existing slot of the same player overwrites it.
+  Name string
+
+  // Name of the player that made this save.
+  Player string
+
+  // When this slot was last saved.
+  Time time.Time
+
+  // The level script that was running, this is the player's Script_path.
+  Script string
+
+  // The game's Turn and Side when it was saved.
+  Turn int
+  Side Side
+
+  // Filename of the thumbnail, relative to the slot's directory, or "" if
+  // the slot doesn't have one.  This changes every time the slot is saved so
+  // that an old thumbnail is never picked up from the texture cache.
+  Thumbnail string
+
+  // Path to the .save file, set when the slot is read or written.
+  path string
+}
+
+func saveSlotDir(player string) string {
+  hash := fnv.New64()
+  hash.Write([]byte(player))
+  return filepath.Join(base.GetDataDir(), "saves", fmt.Sprintf("%x", hash.Sum64()))
+}
+
+// Returns the path of the .save file for this slot.
+func (s *SaveSlot) Path() string {
+  if s.path == "" {
+    hash := fnv.New64()
+    hash.Write([]byte(s.Name))
+    s.path = filepath.Join(saveSlotDir(s.Player), fmt.Sprintf("%x.save", hash.Sum64()))
+  }
+  return s.path
+}
+
+// Returns the path of this slot's thumbnail, or "" if it doesn't have one.
+func (s *SaveSlot) ThumbnailPath() string {
+  if s.Thumbnail == "" {
+    return ""
+  }
+  return filepath.Join(filepath.Dir(s.Path()), s.Thumbnail)
+}
+
+// The round that this slot was saved in, as shown to the player.
+func (s *SaveSlot) Round() int {
+  return (s.Turn + 1) / 2
+}
+
+func (s *SaveSlot) SideName() string {
+  switch s.Side {
+  case SideHaunt:
+    return "Denizens"
+  case SideExplorers:
+    return "Intruders"
+  }
+  return "Nobody"
+}
+
+// What is saved in a .save file.
+type saveSlotPayload struct {
+  Slot   SaveSlot
+  Player Player
+}
+
+// Reads a .save file.
+func readSaveSlot(r io.Reader) (*saveSlotPayload, error) {
+  data, err := ioutil.ReadAll(r)
+  if err != nil {
+    return nil, err
+  }
+  payload, err := slot_save_format.Decode(data)
+  if err != nil {
+    return nil, err
+  }
+  var sp saveSlotPayload
+  err = base.DecodeSaveTree(payload, &sp)
+  if err != nil {
+    return nil, err
+  }
+  return &sp, nil
+}
+
+// Returns every save slot of every player.  Slots that can't be read are
+// logged and skipped.
+func GetAllSaveSlots() []*SaveSlot {
+  root := filepath.Join(base.GetDataDir(), "saves")
+  var slots []*SaveSlot
+  filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+    if err != nil || info.IsDir() || filepath.Ext(path) != ".save" {
+      return nil
+    }
+    f, err := os.Open(path)
+    if err != nil {
+      base.Warn().Printf("Unable to open save file: %s.", path)
+      return nil
+    }
+    defer f.Close()
+    sp, err := readSaveSlot(f)
+    if err != nil {
+      base.Warn().Printf("Unable to read save file: %s: %v", path, err)
+      return nil
+    }
+    sp.Slot.path = path
+    slots = append(slots, &sp.Slot)
+    return nil
+  })
+  return slots
+}
+
+// Returns all of the named player's save slots.
+func GetSaveSlots(player string) []*SaveSlot {
+  var slots []*SaveSlot
+  for _, slot := range GetAllSaveSlots() {
+    if slot.Player == player {
+      slots = append(slots, slot)
+    }
+  }
+  return slots
+}
+
+// Ways that save slots can be sorted, for SortSaveSlots.
+const (
+  SortSlotsByTime   = "time"
+  SortSlotsByName   = "name"
+  SortSlotsByPlayer = "player"
+  SortSlotsByTurn   = "turn"
+)
+
+type saveSlotSorter struct {
+  slots []*SaveSlot
+  less  func(a, b *SaveSlot) bool
+}
+
+func (s saveSlotSorter) Len() int      { return len(s.slots) }
+func (s saveSlotSorter) Swap(i, j int) { s.slots[i], s.slots[j] = s.slots[j], s.slots[i] }
+func (s saveSlotSorter) Less(i, j int) bool {
+  return s.less(s.slots[i], s.slots[j])
+}
+
+// Sorts slots by one of the SortSlotsBy values.  Times and turns are sorted
+// most recent first, names alphabetically.  Ties are broken by time.
+func SortSaveSlots(slots []*SaveSlot, by string) {
+  newer := func(a, b *SaveSlot) bool {
+    return a.Time.After(b.Time)
+  }
+  var less func(a, b *SaveSlot) bool
+  switch by {
+  case SortSlotsByName:
+    less = func(a, b *SaveSlot) bool {
+      an, bn := strings.ToLower(a.Name), strings.ToLower(b.Name)
+      if an != bn {
+        return an < bn
+      }
+      return newer(a, b)
+    }
+  case SortSlotsByPlayer:
+    less = func(a, b *SaveSlot) bool {
+      if a.Player != b.Player {
+        return a.Player < b.Player
+      }
+      return newer(a, b)
+    }
+  case SortSlotsByTurn:
+    less = func(a, b *SaveSlot) bool {
+      if a.Turn != b.Turn {
+        return a.Turn > b.Turn
+      }
+      return newer(a, b)
+    }
+  default:
+    less = newer
+  }
+  sort.Stable(saveSlotSorter{slots, less})
+}
+
+// Writes p to slot, along with a thumbnail if thumb is not nil.  The slot's
+// Time is set to now and any thumbnail from a previous save to the same slot
+// is removed.
+func WriteSaveSlot(slot *SaveSlot, p *Player, thumb image.Image) error {
+  if slot.Name == "" {
+    return errors.New("Save slots must have a name.")
+  }
+  dir := filepath.Dir(slot.Path())
+  err := os.MkdirAll(dir, 0755)
+  if err != nil {
+    return err
+  }
+  old := ""
+  if f, err := os.Open(slot.Path()); err == nil {
+    prev, err := readSaveSlot(f)
+    f.Close()
+    if err == nil && prev.Slot.Thumbnail != "" {
+      old = filepath.Join(dir, prev.Slot.Thumbnail)
+    }
+  }
+
+  slot.Time = time.Now()
+  slot.Thumbnail = ""
+  if thumb != nil {
+    name := strings.TrimSuffix(filepath.Base(slot.Path()), ".save")
+    slot.Thumbnail = fmt.Sprintf("%s-%x.png", name, slot.Time.UnixNano())
+    f, err := os.Create(slot.ThumbnailPath())
+    if err != nil {
+      return err
+    }
+    err = png.Encode(f, thumb)
+    f.Close()
+    if err != nil {
+      return err
+    }
+  }
+
+  payload, err := base.EncodeSaveTree(saveSlotPayload{Slot: *slot, Player: *p})
+  if err != nil {
+    return err
+  }
+  data, err := slot_save_format.Encode(payload)
+  if err != nil {
+    return err
+  }
+  err = ioutil.WriteFile(slot.Path(), data, 0644)
+  if err != nil {
+    return err
+  }
+  if old != "" && old != slot.ThumbnailPath() {
+    os.Remove(old)
+  }
+  return nil
+}
+
+// Reads the player saved in slot, the player is ready to be passed to
+// MakeGamePanel().
+func LoadSaveSlot(slot *SaveSlot) (*Player, error) {
+  f, err := os.Open(slot.Path())
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  sp, err := readSaveSlot(f)
+  if err != nil {
+    return nil, err
+  }
+  return &sp.Player, nil
+}
+
+// Removes slot and its thumbnail.
+func DeleteSaveSlot(slot *SaveSlot) error {
+  if slot.Thumbnail != "" {
+    os.Remove(slot.ThumbnailPath())
+  }
+  return os.Remove(slot.Path())
+}
+
+// Saves the game in gp to the named slot of player.  The thumbnail is taken
+// the next time gp is drawn, so the slot isn't written until then, done is
+// called on the ui thread once it has been.
+func saveGameToSlot(gp *GamePanel, player *Player, name string, done func(*SaveSlot, error)) {
+  UpdatePlayer(player, gp.script.L)
+  str, err := currentGameState(gp, gp.script.L)
+  if err != nil {
+    done(nil, err)
+    return
+  }
+  player.Game_state = str
+  player.No_init = true
+
+  // The player can keep playing before the thumbnail is taken, so this is
+  // what gets saved.
+  saved := *player
+  slot := &SaveSlot{
+    Name:   name,
+    Player: player.Name,
+    Script: player.Script_path,
+    Turn:   gp.game.Turn,
+    Side:   gp.game.Side,
+  }
+  gp.requestThumbnail(func(thumb image.Image) {
+    done(slot, WriteSaveSlot(slot, &saved, thumb))
+  })
+}
+
+// Width of save slot thumbnails, the height is whatever keeps the viewer's
+// aspect ratio.
+const thumbnail_dx = 256
+
+// Calls f with a thumbnail of the house viewer the next time gp is drawn, or
+// with nil right away if there is nothing to draw.
+func (gp *GamePanel) requestThumbnail(f func(image.Image)) {
+  if base.Headless() || !gp.Active() {
+    f(nil)
+    return
+  }
+  gp.thumbnail = f
+}
+
+// Draws the viewer on its own and reads it back, scaled down to
+// thumbnail_dx wide.  Must be called from Draw, before anything else is
+// drawn, since it leaves the viewer on the screen.
+func (gp *GamePanel) takeThumbnail() image.Image {
+  region := gp.game.viewer.Rendered()
+  if region.Dx <= 0 || region.Dy <= 0 {
+    return nil
+  }
+  gp.game.viewer.Draw(region)
+  pix := make([]byte, 4*region.Dx*region.Dy)
+  gl.ReadPixels(region.X, region.Y, region.Dx, region.Dy, gl.RGBA, gl.UNSIGNED_BYTE, pix)
+
+  dx := thumbnail_dx
+  dy := region.Dy * dx / region.Dx
+  thumb := image.NewRGBA(image.Rect(0, 0, dx, dy))
+  for y := 0; y < dy; y++ {
+    // gl reads from the bottom up
+    sy := (dy - 1 - y) * region.Dy / dy
+    for x := 0; x < dx; x++ {
+      sx := x * region.Dx / dx
+      src := pix[4*(sy*region.Dx+sx):]
+      dst := thumb.Pix[thumb.PixOffset(x, y):]
+      copy(dst[0:3], src[0:3])
+      dst[3] = 255
+    }
+  }
+  return thumb
+}
@@ -0,0 +1,73 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "os"
+  "path/filepath"
+  "time"
+)
+
+func SaveSlotSpec(c gospec.Context) {
+  c.Specify("Slots round trip through disk.", func() {
+    slot := &game.SaveSlot{
+      Name:   "Test Slot",
+      Player: "save slot spec",
+      Script: "versus/basic.lua",
+      Turn:   3,
+      Side:   game.SideExplorers,
+    }
+    defer os.RemoveAll(filepath.Dir(slot.Path()))
+    p := &game.Player{Name: "save slot spec", Script_path: "versus/basic.lua", No_init: true}
+    err := game.WriteSaveSlot(slot, p, nil)
+    c.Assume(err, Equals, nil)
+
+    slots := game.GetSaveSlots("save slot spec")
+    c.Assume(len(slots), Equals, 1)
+    c.Expect(slots[0].Name, Equals, "Test Slot")
+    c.Expect(slots[0].Turn, Equals, 3)
+    c.Expect(slots[0].Round(), Equals, 2)
+    c.Expect(slots[0].Side, Equals, game.SideExplorers)
+    c.Expect(slots[0].Path(), Equals, slot.Path())
+
+    loaded, err := game.LoadSaveSlot(slots[0])
+    c.Assume(err, Equals, nil)
+    c.Expect(loaded.Script_path, Equals, "versus/basic.lua")
+    c.Expect(loaded.No_init, Equals, true)
+
+    c.Expect(game.WriteSaveSlot(&game.SaveSlot{Name: "Other", Player: "save slot spec"}, p, nil), Equals, nil)
+    c.Expect(len(game.GetSaveSlots("save slot spec")), Equals, 2)
+    c.Expect(game.WriteSaveSlot(slot, p, nil), Equals, nil)
+    c.Expect(len(game.GetSaveSlots("save slot spec")), Equals, 2)
+
+    c.Expect(game.DeleteSaveSlot(slots[0]), Equals, nil)
+    slots = game.GetSaveSlots("save slot spec")
+    c.Assume(len(slots), Equals, 1)
+    c.Expect(slots[0].Name, Equals, "Other")
+  })
+
+  c.Specify("Slots can be sorted.", func() {
+    now := time.Now()
+    a := &game.SaveSlot{Name: "b", Player: "x", Turn: 1, Time: now}
+    b := &game.SaveSlot{Name: "A", Player: "z", Turn: 5, Time: now.Add(-time.Hour)}
+    d := &game.SaveSlot{Name: "c", Player: "y", Turn: 3, Time: now.Add(time.Hour)}
+    slots := []*game.SaveSlot{a, b, d}
+
+    game.SortSaveSlots(slots, game.SortSlotsByTime)
+    c.Expect(slots[0], Equals, d)
+    c.Expect(slots[2], Equals, b)
+
+    game.SortSaveSlots(slots, game.SortSlotsByName)
+    c.Expect(slots[0], Equals, b)
+    c.Expect(slots[1], Equals, a)
+
+    game.SortSaveSlots(slots, game.SortSlotsByPlayer)
+    c.Expect(slots[0], Equals, a)
+    c.Expect(slots[1], Equals, d)
+
+    game.SortSaveSlots(slots, game.SortSlotsByTurn)
+    c.Expect(slots[0], Equals, b)
+    c.Expect(slots[2], Equals, a)
+  })
+}
@@ -17,6 +17,8 @@ import (
   "io/ioutil"
   "path/filepath"
   "regexp"
+  "sort"
+  "sync"
   "time"
 )
 
@@ -26,18 +28,76 @@ type gameScript struct {
   // Since the scripts can do anything they want sometimes we want make sure
   // certain things only run when the game is ready for them.
   sync chan struct{}
+
+  // Closed by stop().  Everything that the script waits on gives up once
+  // this is closed, and aborts whatever lua the script is running.
+  done chan struct{}
+
+  // Goroutines that run the script, stop() waits for them before closing L.
+  running sync.WaitGroup
 }
 
 func (gs *gameScript) syncStart() {
-  <-gs.sync
+  select {
+  case <-gs.sync:
+  case <-gs.done:
+    gs.L.SetExecutionLimit(1)
+  }
 }
 func (gs *gameScript) syncEnd() {
-  gs.sync <- struct{}{}
+  select {
+  case gs.sync <- struct{}{}:
+  case <-gs.done:
+    gs.L.SetExecutionLimit(1)
+  }
+}
+
+// Sends v to the game, unless the script has been stopped.
+func (gs *gameScript) send(ch chan interface{}, v interface{}) {
+  select {
+  case ch <- v:
+  case <-gs.done:
+    gs.L.SetExecutionLimit(1)
+  }
+}
+
+// Waits for the game to send something, returns nil if the script has been
+// stopped.
+func (gs *gameScript) receive(ch chan interface{}) interface{} {
+  select {
+  case v := <-ch:
+    return v
+  case <-gs.done:
+    gs.L.SetExecutionLimit(1)
+    return nil
+  }
+}
+
+// Stops the script for good and closes its lua state.  Whatever the script
+// is waiting on returns right away and the script is aborted at its next
+// instruction, so this only waits for its goroutines to wind down.  The game
+// must not call into the script again.
+func (gs *gameScript) stop() {
+  close(gs.done)
+  gs.running.Wait()
+  gs.L.Close()
 }
 
 func startGameScript(gp *GamePanel, path string, player *Player, data map[string]string, game_key mrgnet.GameKey) {
   // Clear out the panel, now the script can do whatever it wants
   player.Script_path = path
+  // StartScript() comes through here without any data, the difficulty that
+  // the game started with should carry over to the next script.
+  if gp.difficulty == "" {
+    gp.difficulty = DifficultyNormal
+  }
+  if d, ok := data["difficulty"]; ok {
+    var err error
+    gp.difficulty, err = ParseDifficulty(d)
+    if err != nil {
+      base.Warn().Printf("%v", err)
+    }
+  }
   gp.AnchorBox = gui.MakeAnchorBox(gui.Dims{1024, 768})
   base.Log().Printf("startGameScript")
   if path != "" && !filepath.IsAbs(path) {
@@ -56,81 +116,7 @@ func startGameScript(gp *GamePanel, path string, player *Player, data map[string
       return
     }
   }
-  gp.script = &gameScript{}
-  base.Log().Printf("script = %p", gp.script)
-
-  gp.script.L = lua.NewState()
-  gp.script.L.OpenLibs()
-  gp.script.L.SetExecutionLimit(25000)
-  gp.script.L.NewTable()
-  LuaPushSmartFunctionTable(gp.script.L, FunctionTable{
-    "ChooserFromFile":                   func() { gp.script.L.PushGoFunctionAsCFunction(chooserFromFile(gp)) },
-    "StartScript":                       func() { gp.script.L.PushGoFunctionAsCFunction(startScript(gp, player)) },
-    "GameOnRound":                       func() { gp.script.L.PushGoFunctionAsCFunction(doGameOnRound(gp)) },
-    "SaveGameState":                     func() { gp.script.L.PushGoFunctionAsCFunction(saveGameState(gp)) },
-    "LoadGameState":                     func() { gp.script.L.PushGoFunctionAsCFunction(loadGameState(gp)) },
-    "DoExec":                            func() { gp.script.L.PushGoFunctionAsCFunction(doExec(gp)) },
-    "SelectEnt":                         func() { gp.script.L.PushGoFunctionAsCFunction(selectEnt(gp)) },
-    "FocusPos":                          func() { gp.script.L.PushGoFunctionAsCFunction(focusPos(gp)) },
-    "FocusZoom":                         func() { gp.script.L.PushGoFunctionAsCFunction(focusZoom(gp)) },
-    "SelectHouse":                       func() { gp.script.L.PushGoFunctionAsCFunction(selectHouse(gp)) },
-    "LoadHouse":                         func() { gp.script.L.PushGoFunctionAsCFunction(loadHouse(gp)) },
-    "SaveStore":                         func() { gp.script.L.PushGoFunctionAsCFunction(saveStore(gp, player)) },
-    "ShowMainBar":                       func() { gp.script.L.PushGoFunctionAsCFunction(showMainBar(gp, player)) },
-    "SpawnEntityAtPosition":             func() { gp.script.L.PushGoFunctionAsCFunction(spawnEntityAtPosition(gp)) },
-    "GetSpawnPointsMatching":            func() { gp.script.L.PushGoFunctionAsCFunction(getSpawnPointsMatching(gp)) },
-    "SpawnEntitySomewhereInSpawnPoints": func() { gp.script.L.PushGoFunctionAsCFunction(spawnEntitySomewhereInSpawnPoints(gp)) },
-    "IsSpawnPointInLos":                 func() { gp.script.L.PushGoFunctionAsCFunction(isSpawnPointInLos(gp)) },
-    "PlaceEntities":                     func() { gp.script.L.PushGoFunctionAsCFunction(placeEntities(gp)) },
-    "RoomAtPos":                         func() { gp.script.L.PushGoFunctionAsCFunction(roomAtPos(gp)) },
-    "SetLosMode":                        func() { gp.script.L.PushGoFunctionAsCFunction(setLosMode(gp)) },
-    "GetAllEnts":                        func() { gp.script.L.PushGoFunctionAsCFunction(getAllEnts(gp)) },
-    "DialogBox":                         func() { gp.script.L.PushGoFunctionAsCFunction(dialogBox(gp)) },
-    "PickFromN":                         func() { gp.script.L.PushGoFunctionAsCFunction(pickFromN(gp)) },
-    "SetGear":                           func() { gp.script.L.PushGoFunctionAsCFunction(setGear(gp)) },
-    "BindAi":                            func() { gp.script.L.PushGoFunctionAsCFunction(bindAi(gp)) },
-    "SetVisibility":                     func() { gp.script.L.PushGoFunctionAsCFunction(setVisibility(gp)) },
-    "EndPlayerInteraction":              func() { gp.script.L.PushGoFunctionAsCFunction(endPlayerInteraction(gp)) },
-    "GetLos":                            func() { gp.script.L.PushGoFunctionAsCFunction(getLos(gp)) },
-    "SetVisibleSpawnPoints":             func() { gp.script.L.PushGoFunctionAsCFunction(setVisibleSpawnPoints(gp)) },
-    "SetCondition":                      func() { gp.script.L.PushGoFunctionAsCFunction(setCondition(gp)) },
-    "SetPosition":                       func() { gp.script.L.PushGoFunctionAsCFunction(setPosition(gp)) },
-    "SetHp":                             func() { gp.script.L.PushGoFunctionAsCFunction(setHp(gp)) },
-    "SetAp":                             func() { gp.script.L.PushGoFunctionAsCFunction(setAp(gp)) },
-    "RemoveEnt":                         func() { gp.script.L.PushGoFunctionAsCFunction(removeEnt(gp)) },
-    "PlayAnimations":                    func() { gp.script.L.PushGoFunctionAsCFunction(playAnimations(gp)) },
-    "PlayMusic":                         func() { gp.script.L.PushGoFunctionAsCFunction(playMusic(gp)) },
-    "StopMusic":                         func() { gp.script.L.PushGoFunctionAsCFunction(stopMusic(gp)) },
-    "SetMusicParam":                     func() { gp.script.L.PushGoFunctionAsCFunction(setMusicParam(gp)) },
-    "PlaySound":                         func() { gp.script.L.PushGoFunctionAsCFunction(playSound(gp)) },
-    "SetWaypoint":                       func() { gp.script.L.PushGoFunctionAsCFunction(setWaypoint(gp)) },
-    "RemoveWaypoint":                    func() { gp.script.L.PushGoFunctionAsCFunction(removeWaypoint(gp)) },
-    "Rand":                              func() { gp.script.L.PushGoFunctionAsCFunction(randFunc(gp)) },
-    "Sleep":                             func() { gp.script.L.PushGoFunctionAsCFunction(sleepFunc(gp)) },
-    "EndGame":                           func() { gp.script.L.PushGoFunctionAsCFunction(endGameFunc(gp)) },
-  })
-  gp.script.L.SetMetaTable(-2)
-  gp.script.L.SetGlobal("Script")
-
-  gp.script.L.NewTable()
-  LuaPushSmartFunctionTable(gp.script.L, FunctionTable{
-    "Active": func() {
-      gp.script.L.PushGoFunctionAsCFunction(
-        func(L *lua.State) int {
-          L.PushBoolean(game_key != "")
-          return 1
-        })
-    },
-    "Side":                func() { gp.script.L.PushGoFunctionAsCFunction(netSideFunc(gp)) },
-    "UpdateState":         func() { gp.script.L.PushGoFunctionAsCFunction(updateStateFunc(gp)) },
-    "UpdateExecs":         func() { gp.script.L.PushGoFunctionAsCFunction(updateExecsFunc(gp)) },
-    "Wait":                func() { gp.script.L.PushGoFunctionAsCFunction(netWaitFunc(gp)) },
-    "LatestStateAndExecs": func() { gp.script.L.PushGoFunctionAsCFunction(netLatestStateAndExecsFunc(gp)) },
-  })
-  gp.script.L.SetMetaTable(-2)
-  gp.script.L.SetGlobal("Net")
-
-  registerUtilityFunctions(gp.script.L)
+  makeGameScript(gp, player, game_key)
   if player.Lua_store != nil {
     loadGameStateRaw(gp, gp.script.L, player.Game_state)
     err := LuaDecodeTable(bytes.NewBuffer(player.Lua_store), gp.script.L, gp.game)
@@ -154,7 +140,10 @@ func startGameScript(gp *GamePanel, path string, player *Player, data map[string
   base.Log().Printf("Sync: %p", gp.script.sync)
 
   // if resp.Game.Denizens_id == 
+  gs := gp.script
+  gs.running.Add(1)
   go func() {
+    defer gs.running.Done()
     if game_key != "" {
       var net_id mrgnet.NetId
       fmt.Sscanf(base.GetStoreVal("netid"), "%d", &net_id)
@@ -162,7 +151,10 @@ func startGameScript(gp *GamePanel, path string, player *Player, data map[string
       req.Game_key = game_key
       req.Id = net_id
       var resp mrgnet.StatusResponse
-      mrgnet.DoAction("status", req, &resp)
+      if err := mrgnet.DoAction("status", req, &resp); err != nil {
+        base.Error().Printf("Unable to get game status: %v", err)
+        return
+      }
       if resp.Err != "" {
         base.Error().Printf("%s", resp.Err)
         return
@@ -263,15 +255,102 @@ func startGameScript(gp *GamePanel, path string, player *Player, data map[string
       base.Error().Printf("Script failed to load a house during Init().")
     } else {
       gp.game.net.key = game_key
-      gp.game.comm.script_to_game <- nil
+      gs.send(gp.game.comm.script_to_game, nil)
     }
   }()
 }
 
+// Makes a fresh lua state for gp with the Script and Net tables that every
+// game script expects.
+func makeGameScript(gp *GamePanel, player *Player, game_key mrgnet.GameKey) {
+  gp.script = &gameScript{done: make(chan struct{})}
+  base.Log().Printf("script = %p", gp.script)
+
+  gp.script.L = lua.NewState()
+  gp.script.L.OpenLibs()
+  gp.script.L.SetExecutionLimit(25000)
+  gp.script.L.NewTable()
+  LuaPushSmartFunctionTable(gp.script.L, FunctionTable{
+    "ChooserFromFile":                   func() { gp.script.L.PushGoFunctionAsCFunction(chooserFromFile(gp)) },
+    "StartScript":                       func() { gp.script.L.PushGoFunctionAsCFunction(startScript(gp, player)) },
+    "GameOnRound":                       func() { gp.script.L.PushGoFunctionAsCFunction(doGameOnRound(gp)) },
+    "SaveGameState":                     func() { gp.script.L.PushGoFunctionAsCFunction(saveGameState(gp)) },
+    "LoadGameState":                     func() { gp.script.L.PushGoFunctionAsCFunction(loadGameState(gp)) },
+    "DoExec":                            func() { gp.script.L.PushGoFunctionAsCFunction(doExec(gp)) },
+    "SelectEnt":                         func() { gp.script.L.PushGoFunctionAsCFunction(selectEnt(gp)) },
+    "FocusPos":                          func() { gp.script.L.PushGoFunctionAsCFunction(focusPos(gp)) },
+    "FocusZoom":                         func() { gp.script.L.PushGoFunctionAsCFunction(focusZoom(gp)) },
+    "SelectHouse":                       func() { gp.script.L.PushGoFunctionAsCFunction(selectHouse(gp)) },
+    "LoadHouse":                         func() { gp.script.L.PushGoFunctionAsCFunction(loadHouse(gp)) },
+    "SaveStore":                         func() { gp.script.L.PushGoFunctionAsCFunction(saveStore(gp, player)) },
+    "ShowMainBar":                       func() { gp.script.L.PushGoFunctionAsCFunction(showMainBar(gp, player)) },
+    "SpawnEntityAtPosition":             func() { gp.script.L.PushGoFunctionAsCFunction(spawnEntityAtPosition(gp)) },
+    "GetSpawnPointsMatching":            func() { gp.script.L.PushGoFunctionAsCFunction(getSpawnPointsMatching(gp)) },
+    "SpawnEntitySomewhereInSpawnPoints": func() { gp.script.L.PushGoFunctionAsCFunction(spawnEntitySomewhereInSpawnPoints(gp)) },
+    "IsSpawnPointInLos":                 func() { gp.script.L.PushGoFunctionAsCFunction(isSpawnPointInLos(gp)) },
+    "PlaceEntities":                     func() { gp.script.L.PushGoFunctionAsCFunction(placeEntities(gp)) },
+    "RoomAtPos":                         func() { gp.script.L.PushGoFunctionAsCFunction(roomAtPos(gp)) },
+    "SetLosMode":                        func() { gp.script.L.PushGoFunctionAsCFunction(setLosMode(gp)) },
+    "GetAllEnts":                        func() { gp.script.L.PushGoFunctionAsCFunction(getAllEnts(gp)) },
+    "CombatLog":                         func() { gp.script.L.PushGoFunctionAsCFunction(combatLog(gp)) },
+    "DialogBox":                         func() { gp.script.L.PushGoFunctionAsCFunction(dialogBox(gp)) },
+    "PickFromN":                         func() { gp.script.L.PushGoFunctionAsCFunction(pickFromN(gp)) },
+    "SetGear":                           func() { gp.script.L.PushGoFunctionAsCFunction(setGear(gp)) },
+    "BindAi":                            func() { gp.script.L.PushGoFunctionAsCFunction(bindAi(gp)) },
+    "SetVisibility":                     func() { gp.script.L.PushGoFunctionAsCFunction(setVisibility(gp)) },
+    "EndPlayerInteraction":              func() { gp.script.L.PushGoFunctionAsCFunction(endPlayerInteraction(gp)) },
+    "GetLos":                            func() { gp.script.L.PushGoFunctionAsCFunction(getLos(gp)) },
+    "SetVisibleSpawnPoints":             func() { gp.script.L.PushGoFunctionAsCFunction(setVisibleSpawnPoints(gp)) },
+    "SetCondition":                      func() { gp.script.L.PushGoFunctionAsCFunction(setCondition(gp)) },
+    "SetPosition":                       func() { gp.script.L.PushGoFunctionAsCFunction(setPosition(gp)) },
+    "SetHp":                             func() { gp.script.L.PushGoFunctionAsCFunction(setHp(gp)) },
+    "SetAp":                             func() { gp.script.L.PushGoFunctionAsCFunction(setAp(gp)) },
+    "RemoveEnt":                         func() { gp.script.L.PushGoFunctionAsCFunction(removeEnt(gp)) },
+    "PlayAnimations":                    func() { gp.script.L.PushGoFunctionAsCFunction(playAnimations(gp)) },
+    "PlayMusic":                         func() { gp.script.L.PushGoFunctionAsCFunction(playMusic(gp)) },
+    "StopMusic":                         func() { gp.script.L.PushGoFunctionAsCFunction(stopMusic(gp)) },
+    "SetMusicParam":                     func() { gp.script.L.PushGoFunctionAsCFunction(setMusicParam(gp)) },
+    "PlaySound":                         func() { gp.script.L.PushGoFunctionAsCFunction(playSound(gp)) },
+    "SetWaypoint":                       func() { gp.script.L.PushGoFunctionAsCFunction(setWaypoint(gp)) },
+    "RemoveWaypoint":                    func() { gp.script.L.PushGoFunctionAsCFunction(removeWaypoint(gp)) },
+    "Rand":                              func() { gp.script.L.PushGoFunctionAsCFunction(randFunc(gp)) },
+    "Sleep":                             func() { gp.script.L.PushGoFunctionAsCFunction(sleepFunc(gp)) },
+    "EndGame":                           func() { gp.script.L.PushGoFunctionAsCFunction(endGameFunc(gp)) },
+    "SetWinner":                         func() { gp.script.L.PushGoFunctionAsCFunction(setWinner(gp)) },
+    "GetDifficulty":                     func() { gp.script.L.PushGoFunctionAsCFunction(getDifficulty(gp)) },
+    "SetDifficulty":                     func() { gp.script.L.PushGoFunctionAsCFunction(setDifficulty(gp)) },
+    "MinionPoints":                      func() { gp.script.L.PushGoFunctionAsCFunction(minionPoints(gp)) },
+  })
+  gp.script.L.SetMetaTable(-2)
+  gp.script.L.SetGlobal("Script")
+
+  gp.script.L.NewTable()
+  LuaPushSmartFunctionTable(gp.script.L, FunctionTable{
+    "Active": func() {
+      gp.script.L.PushGoFunctionAsCFunction(
+        func(L *lua.State) int {
+          L.PushBoolean(game_key != "")
+          return 1
+        })
+    },
+    "Side":                func() { gp.script.L.PushGoFunctionAsCFunction(netSideFunc(gp)) },
+    "UpdateState":         func() { gp.script.L.PushGoFunctionAsCFunction(updateStateFunc(gp)) },
+    "UpdateExecs":         func() { gp.script.L.PushGoFunctionAsCFunction(updateExecsFunc(gp)) },
+    "Wait":                func() { gp.script.L.PushGoFunctionAsCFunction(netWaitFunc(gp)) },
+    "LatestStateAndExecs": func() { gp.script.L.PushGoFunctionAsCFunction(netLatestStateAndExecsFunc(gp)) },
+  })
+  gp.script.L.SetMetaTable(-2)
+  gp.script.L.SetGlobal("Net")
+
+  registerUtilityFunctions(gp.script.L)
+}
+
 func (gs *gameScript) OnRoundWaiting(g *Game) {
   g.Side = g.net.side
   g.Turn--
+  gs.running.Add(1)
   go func() {
+    defer gs.running.Done()
     // // round begins automatically
     // <-round_middle
     // for
@@ -286,11 +365,11 @@ func (gs *gameScript) OnRoundWaiting(g *Game) {
     // gs.L.DoString(cmd)
 
     // signals to the game that we're done with the startup stuff
-    g.comm.script_to_game <- nil
+    gs.send(g.comm.script_to_game, nil)
     // base.Log().Printf("ScriptComm: Done with RoundStart")
 
     g.player_inactive = true
-    _exec := <-g.comm.game_to_script
+    _exec := gs.receive(g.comm.game_to_script)
     if _exec != nil {
       panic("Got an exec when we shouldn't have gotten one.")
     }
@@ -300,12 +379,12 @@ func (gs *gameScript) OnRoundWaiting(g *Game) {
     gs.L.DoString(fmt.Sprintf("RoundEnd(%t, %d)", g.Side == SideExplorers, (g.Turn+1)/2))
 
     base.Log().Printf("ScriptComm: Starting the RoundEnd phase out")
-    g.comm.script_to_game <- nil
+    gs.send(g.comm.script_to_game, nil)
     base.Log().Printf("ScriptComm: Starting the RoundEnd phase in")
 
     // Signal that we're done with the round end
     base.Log().Printf("ScriptComm: Done with the RoundEnd phase in")
-    g.comm.script_to_game <- nil
+    gs.send(g.comm.script_to_game, nil)
     base.Log().Printf("ScriptComm: Done with the RoundEnd phase out")
   }()
 }
@@ -320,7 +399,9 @@ func (gs *gameScript) OnRound(g *Game) {
     gs.OnRoundWaiting(g)
     return
   }
+  gs.running.Add(1)
   go func() {
+    defer gs.running.Done()
     // // round begins automatically
     // <-round_middle
     // for
@@ -335,12 +416,12 @@ func (gs *gameScript) OnRound(g *Game) {
     gs.L.DoString(cmd)
 
     // signals to the game that we're done with the startup stuff
-    g.comm.script_to_game <- nil
+    gs.send(g.comm.script_to_game, nil)
     base.Log().Printf("ScriptComm: Done with RoundStart")
 
     for {
       base.Log().Printf("ScriptComm: Waiting to verify action")
-      _exec := <-g.comm.game_to_script
+      _exec := gs.receive(g.comm.game_to_script)
       base.Log().Printf("ScriptComm: Got exec: %v", _exec)
       if _exec == nil {
         base.Log().Printf("ScriptComm: No more exec: bailing")
@@ -380,12 +461,12 @@ func (gs *gameScript) OnRound(g *Game) {
         }()
       }
 
-      g.comm.script_to_game <- nil
+      gs.send(g.comm.script_to_game, nil)
 
       // The action is sent when it happens, and a nil is sent when it is done
       // being executed, we want to wait until then so that the game is in a
       // stable state before we do anything.
-      <-g.comm.game_to_script
+      gs.receive(g.comm.game_to_script)
       base.Log().Printf("ScriptComm: Got action secondary")
       // Run OnAction here
       gs.L.SetExecutionLimit(250000)
@@ -404,7 +485,7 @@ func (gs *gameScript) OnRound(g *Game) {
       cmd = fmt.Sprintf("OnAction(%t, %d, %s)", g.Side == SideExplorers, (g.Turn+1)/2, "__exec")
       base.Log().Printf("cmd: '%s'", cmd)
       gs.L.DoString(cmd)
-      g.comm.script_to_game <- nil
+      gs.send(g.comm.script_to_game, nil)
       base.Log().Printf("ScriptComm: Done with OnAction")
     }
 
@@ -412,12 +493,12 @@ func (gs *gameScript) OnRound(g *Game) {
     gs.L.DoString(fmt.Sprintf("RoundEnd(%t, %d)", g.Side == SideExplorers, (g.Turn+1)/2))
 
     base.Log().Printf("ScriptComm: Starting the RoundEnd phase out")
-    g.comm.script_to_game <- nil
+    gs.send(g.comm.script_to_game, nil)
     base.Log().Printf("ScriptComm: Starting the RoundEnd phase in")
 
     // Signal that we're done with the round end
     base.Log().Printf("ScriptComm: Done with the RoundEnd phase in")
-    g.comm.script_to_game <- nil
+    gs.send(g.comm.script_to_game, nil)
     base.Log().Printf("ScriptComm: Done with the RoundEnd phase out")
   }()
 }
@@ -471,6 +552,10 @@ func selectHouse(gp *GamePanel) lua.GoFunction {
     }
     gp.script.syncStart()
     defer gp.script.syncEnd()
+    if gp.match != nil {
+      base.Error().Printf("SelectHouse() can't be used in a match, use LoadHouse() instead.")
+      return 0
+    }
     selector, output, err := MakeUiSelectMap(gp)
     if err != nil {
       base.Error().Printf("Error selecting map: %v", err)
@@ -502,21 +587,12 @@ func saveGameState(gp *GamePanel) lua.GoFunction {
     gp.script.syncStart()
     defer gp.script.syncEnd()
 
-    buf := bytes.NewBuffer(nil)
-    L.GetGlobal("store")
-    LuaEncodeValue(buf, L, -1)
-    L.Pop(1)
-    base.Log().Printf("SaveGameState-1: %d", buf.Len())
-    ts := totalState{
-      Game:  &gp.game,
-      Store: buf.Bytes(),
-    }
-    str, err := base.ToGobToBase64(ts)
+    str, err := currentGameState(gp, L)
     if err != nil {
-      base.Error().Printf("Error gobbing game state: %v", err)
+      base.Error().Printf("Error encoding game state: %v", err)
       return 0
     }
-    base.Log().Printf("SaveGameState-2: %d", len(str)-buf.Len())
+    base.Log().Printf("SaveGameState: %d", len(str))
 
     L.PushString(str)
     return 1
@@ -544,7 +620,7 @@ func loadGameStateRaw(gp *GamePanel, L *lua.State, state string) {
   }
   var ts totalState
   ts.Game = &gp.game
-  err := base.FromBase64FromGob(&ts, state)
+  err := decodeGameState(state, &ts)
   if err != nil {
     base.Error().Printf("Error decoding game state: %v", err)
     return
@@ -592,6 +668,9 @@ func loadGameStateRaw(gp *GamePanel, L *lua.State, state string) {
   }
   gp.AnchorBox.AddChild(gp.game.viewer, gui.Anchor{0.5, 0.5, 0.5, 0.5})
   gp.AnchorBox.AddChild(MakeOverlay(gp.game), gui.Anchor{0.5, 0.5, 0.5, 0.5})
+  if gp.replay != nil {
+    gp.replay.stateLoaded(gp)
+  }
 }
 
 func loadGameState(gp *GamePanel) lua.GoFunction {
@@ -611,6 +690,11 @@ func doExec(gp *GamePanel) lua.GoFunction {
     if !LuaCheckParamsOk(L, "DoExec", LuaTable) {
       return 0
     }
+    if gp.replay != nil && !gp.replay.waitForExec() {
+      // The replay is seeking somewhere else, so the rest of this turn's
+      // execs don't matter.
+      return 0
+    }
     base.Log().Printf("DEBUG: Listing Entities named 'Teen'...")
     for _, ent := range gp.game.Ents {
       if ent.Name == "Teen" {
@@ -635,25 +719,34 @@ func doExec(gp *GamePanel) lua.GoFunction {
       base.Error().Printf("Error decoding exec: Found %d execs instead of exactly 1.", len(execs))
       return 0
     }
-    base.Log().Printf("ScriptComm: Exec: %v", execs[0])
-    gp.game.comm.script_to_game <- execs[0]
-    base.Log().Printf("ScriptComm: Sent exec")
-    <-gp.game.comm.game_to_script
-    base.Log().Printf("ScriptComm: exec done")
-    done := make(chan bool)
-    gp.script.syncStart()
-    go func() {
-      for i := range gp.game.Ents {
-        gp.game.Ents[i].Sprite().Wait([]string{"ready", "killed"})
-      }
-      done <- true
-    }()
-    gp.script.syncEnd()
-    <-done
+    runExec(gp, execs[0])
     return 0
   }
 }
 
+// Hands exec to the game and waits until it has finished and every entity's
+// sprite has settled down.  The game must be in turnStateMainPhaseOver.
+func runExec(gp *GamePanel, exec ActionExec) {
+  base.Log().Printf("ScriptComm: Exec: %v", exec)
+  gp.script.send(gp.game.comm.script_to_game, exec)
+  base.Log().Printf("ScriptComm: Sent exec")
+  gp.script.receive(gp.game.comm.game_to_script)
+  base.Log().Printf("ScriptComm: exec done")
+  done := make(chan bool, 1)
+  gp.script.syncStart()
+  go func() {
+    for i := range gp.game.Ents {
+      gp.game.Ents[i].Sprite().Wait([]string{"ready", "killed"})
+    }
+    done <- true
+  }()
+  gp.script.syncEnd()
+  select {
+  case <-done:
+  case <-gp.script.done:
+  }
+}
+
 func selectEnt(gp *GamePanel) lua.GoFunction {
   return func(L *lua.State) int {
     if !LuaCheckParamsOk(L, "SelectEnt", LuaEntity) {
@@ -707,6 +800,20 @@ func chooserFromFile(gp *GamePanel) lua.GoFunction {
     }
     gp.script.syncStart()
     defer gp.script.syncEnd()
+    if gp.match != nil {
+      res, err := gp.match.choose(L.ToString(-1))
+      if err != nil {
+        base.Error().Printf("Error making chooser: %v", err)
+        return 0
+      }
+      L.NewTable()
+      for i, s := range res {
+        L.PushInteger(i + 1)
+        L.PushString(s)
+        L.SetTable(-3)
+      }
+      return 1
+    }
     path := filepath.Join(base.GetDataDir(), L.ToString(-1))
     chooser, done, err := makeChooserFromOptionBasicsFile(path)
     if err != nil {
@@ -744,6 +851,10 @@ func loadHouse(gp *GamePanel) lua.GoFunction {
       return 0
     }
     gp.game = makeGame(def)
+    gp.game.Difficulty = gp.difficulty
+    if gp.match != nil {
+      gp.game.Rand.Seed(gp.match.config.Seed)
+    }
     gp.game.viewer.Edit_mode = true
     gp.game.script = gp.script
     base.Log().Printf("script = %p", gp.game.script)
@@ -764,13 +875,15 @@ func showMainBar(gp *GamePanel, player *Player) lua.GoFunction {
     }
     gp.script.syncStart()
     defer gp.script.syncEnd()
+    if gp.match != nil {
+      return 0
+    }
     show := L.ToBoolean(-1)
 
     // Remove it regardless of whether or not we want to hide it
     for _, child := range gp.AnchorBox.GetChildren() {
-      if child == gp.main_bar {
+      if child == gp.main_bar || child == gp.combat_log {
         gp.AnchorBox.RemoveChild(child)
-        break
       }
     }
 
@@ -782,6 +895,8 @@ func showMainBar(gp *GamePanel, player *Player) lua.GoFunction {
         return 0
       }
       gp.AnchorBox.AddChild(gp.main_bar, gui.Anchor{0.5, 0, 0.5, 0})
+      gp.combat_log = MakeCombatLogPanel(gp.game)
+      gp.AnchorBox.AddChild(gp.combat_log, gui.Anchor{0, 1, 0, 1})
       system, err := MakeSystemMenu(gp, player)
       if err != nil {
         LuaDoError(L, err.Error())
@@ -947,6 +1062,16 @@ func placeEntities(gp *GamePanel) lua.GoFunction {
       costs = append(costs, L.ToInteger(-1))
       L.Pop(2)
     }
+    if gp.match != nil {
+      ents := gp.game.autoPlaceEntities(names, costs, L.ToInteger(-2), L.ToInteger(-1), L.ToString(-4))
+      L.NewTable()
+      for i := range ents {
+        L.PushInteger(i + 1)
+        LuaPushEntity(L, ents[i])
+        L.SetTable(-3)
+      }
+      return 1
+    }
     ep, done, err := MakeEntityPlacer(gp.game, names, costs, L.ToInteger(-2), L.ToInteger(-1), L.ToString(-4))
     if err != nil {
       base.Error().Printf("Unable to make entity placer: %v", err)
@@ -1007,6 +1132,30 @@ func getAllEnts(gp *GamePanel) lua.GoFunction {
   }
 }
 
+// Returns the last n attacks from the combat log, or all of them if n isn't
+// given.
+func combatLog(gp *GamePanel) lua.GoFunction {
+  return func(L *lua.State) int {
+    n := 0
+    if L.GetTop() == 1 {
+      if !LuaCheckParamsOk(L, "CombatLog", LuaInteger) {
+        return 0
+      }
+      n = L.ToInteger(-1)
+    } else if !LuaCheckParamsOk(L, "CombatLog") {
+      return 0
+    }
+    gp.script.syncStart()
+    defer gp.script.syncEnd()
+    if gp.game == nil {
+      L.NewTable()
+      return 1
+    }
+    LuaPushCombatLog(L, gp.game, n)
+    return 1
+  }
+}
+
 func dialogBox(gp *GamePanel) lua.GoFunction {
   return func(L *lua.State) int {
     if L.GetTop() == 1 {
@@ -1032,6 +1181,11 @@ func dialogBox(gp *GamePanel) lua.GoFunction {
       }
       L.Pop(1)
     }
+    if gp.match != nil {
+      base.Log().Printf("Skipping dialog box in a match: %s %v", path, args)
+      L.NewTable()
+      return 1
+    }
     box, output, err := MakeDialogBox(filepath.ToSlash(path), args)
     if err != nil {
       base.Error().Printf("Error making dialog: %v", err)
@@ -1130,6 +1284,16 @@ func pickFromN(gp *GamePanel) lua.GoFunction {
       options = append(options, &option)
       L.Pop(1)
     }
+    if gp.match != nil {
+      sort.Strings(option_names)
+      L.NewTable()
+      for i := 0; i < min && i < len(option_names); i++ {
+        L.PushInteger(i + 1)
+        L.PushString(option_names[i])
+        L.SetTable(-3)
+      }
+      return 1
+    }
     var selector hui.Selector
     if min == 1 && max == 1 {
       selector = hui.SelectExactlyOne
@@ -1209,6 +1373,11 @@ func bindAi(gp *GamePanel) lua.GoFunction {
       return 0
     }
     target := L.ToString(-2)
+    if gp.match != nil && source == "human" {
+      if path := gp.match.aiFor(target); path != "" {
+        source = path
+      }
+    }
     switch target {
     case "denizen":
       switch source {
@@ -1221,7 +1390,7 @@ func bindAi(gp *GamePanel) lua.GoFunction {
         gp.game.Ai.denizens = nil
         path := filepath.Join(base.GetDataDir(), "ais", source)
         gp.game.Ai.Path.Denizens = path
-        ai_maker(path, gp.game, nil, &gp.game.Ai.denizens, DenizensAi)
+        makeAi(path, gp.game, nil, &gp.game.Ai.denizens, DenizensAi)
         if gp.game.Ai.denizens == nil {
           gp.game.Ai.denizens = inactiveAi{}
         }
@@ -1237,7 +1406,7 @@ func bindAi(gp *GamePanel) lua.GoFunction {
         gp.game.Ai.intruders = nil
         path := filepath.Join(base.GetDataDir(), "ais", source)
         gp.game.Ai.Path.Intruders = path
-        ai_maker(path, gp.game, nil, &gp.game.Ai.intruders, IntrudersAi)
+        makeAi(path, gp.game, nil, &gp.game.Ai.intruders, IntrudersAi)
         if gp.game.Ai.intruders == nil {
           gp.game.Ai.intruders = inactiveAi{}
         }
@@ -1246,7 +1415,7 @@ func bindAi(gp *GamePanel) lua.GoFunction {
       gp.game.Ai.minions = nil
       path := filepath.Join(base.GetDataDir(), "ais", source)
       gp.game.Ai.Path.Minions = path
-      ai_maker(path, gp.game, nil, &gp.game.Ai.minions, MinionsAi)
+      makeAi(path, gp.game, nil, &gp.game.Ai.minions, MinionsAi)
       if gp.game.Ai.minions == nil {
         gp.game.Ai.minions = inactiveAi{}
       }
@@ -1302,10 +1471,14 @@ func saveStore(gp *GamePanel, player *Player) lua.GoFunction {
     }
     gp.script.syncStart()
     defer gp.script.syncEnd()
+    // Matches shouldn't overwrite the player's autosave.
+    if gp.match != nil {
+      return 0
+    }
     UpdatePlayer(player, gp.script.L)
-    str, err := base.ToGobToBase64(gp.game)
+    str, err := currentGameState(gp, gp.script.L)
     if err != nil {
-      base.Error().Printf("Error gobbing game state: %v", err)
+      base.Error().Printf("Error encoding game state: %v", err)
       return 0
     }
     player.Game_state = str
@@ -1680,6 +1853,9 @@ func sleepFunc(gp *GamePanel) lua.GoFunction {
     if !LuaCheckParamsOk(L, "Sleep", LuaFloat) {
       return 0
     }
+    if gp.match != nil {
+      return 1
+    }
     seconds := L.ToNumber(-1)
     time.Sleep(time.Microsecond * time.Duration(1000000*seconds))
     return 1
@@ -1691,6 +1867,12 @@ func endGameFunc(gp *GamePanel) lua.GoFunction {
     if !LuaCheckParamsOk(L, "EndGame") {
       return 0
     }
+    if gp.match != nil {
+      gp.script.syncStart()
+      gp.match.over = true
+      gp.script.syncEnd()
+      return 0
+    }
     gp.game.Ents = nil
     gp.game.Think(1) // This should clean things up
     Restart()
@@ -1698,6 +1880,123 @@ func endGameFunc(gp *GamePanel) lua.GoFunction {
   }
 }
 
+// Declares that a side has won the game.  Games with players go on until the
+// script ends them, but headless matches end right away.  In online games
+// the server is told if the local player lost, if they won it is told once
+// the state that says so has been sent with Net.UpdateExecs().
+//    Format
+//    SetWinner(side)
+//
+//    Input:
+//    side - string - Either "denizens" or "intruders".
+func setWinner(gp *GamePanel) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !LuaCheckParamsOk(L, "SetWinner", LuaString) {
+      return 0
+    }
+    var side Side
+    switch L.ToString(-1) {
+    case "denizens":
+      side = SideHaunt
+    case "intruders":
+      side = SideExplorers
+    default:
+      base.Error().Printf("Cannot pass '%s' as first parameter of SetWinner()", L.ToString(-1))
+      return 0
+    }
+    base.Log().Printf("%s won", sideName(side))
+    gp.script.syncStart()
+    defer gp.script.syncEnd()
+    if gp.match != nil {
+      gp.match.winner = sideName(side)
+      gp.match.over = true
+    }
+    if gp.game == nil {
+      return 0
+    }
+    gp.game.Winner = side
+    if gp.game.net.key != "" && gp.game.net.game != nil {
+      mine := gp.netGame().Side(gp.game.net.game)
+      if mine != "" && mine != sideName(side) {
+        if err := gp.netGame().SetWinner(side); err != nil {
+          base.Error().Printf("Unable to tell the server who won: %v", err)
+        }
+      }
+    }
+    return 0
+  }
+}
+
+// Returns the difficulty of the current game, or the difficulty that the
+// game will have if a house hasn't been loaded yet.
+//    Format
+//    d = GetDifficulty()
+//
+//    Output:
+//    d - string - One of "Easy", "Normal" or "Hard".
+func getDifficulty(gp *GamePanel) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !LuaCheckParamsOk(L, "GetDifficulty") {
+      return 0
+    }
+    if gp.game != nil {
+      L.PushString(gp.game.Difficulty.String())
+    } else {
+      L.PushString(gp.difficulty.String())
+    }
+    return 1
+  }
+}
+
+// Changes the difficulty.  Stats and minion budgets are only adjusted when
+// entities are made, so this should be called before any are spawned.
+//    Format
+//    SetDifficulty(d)
+//
+//    Input:
+//    d - string - One of "Easy", "Normal" or "Hard".
+func setDifficulty(gp *GamePanel) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !LuaCheckParamsOk(L, "SetDifficulty", LuaString) {
+      return 0
+    }
+    d, err := ParseDifficulty(L.ToString(-1))
+    if err != nil {
+      base.Error().Printf("SetDifficulty: %v", err)
+      return 0
+    }
+    gp.difficulty = d
+    if gp.game != nil {
+      gp.game.Difficulty = d
+    }
+    return 0
+  }
+}
+
+// Adjusts a minion budget for the difficulty.  Levels should pass the
+// budgets they give the denizens through this so that the computer gets more
+// or fewer minions on harder or easier difficulties.
+//    Format
+//    n = MinionPoints(points)
+//
+//    Input:
+//    points - integer - The budget the level was balanced for.
+//
+//    Output:
+//    n - integer - points, adjusted for the difficulty if the denizens are
+//                  played by an ai.
+func minionPoints(gp *GamePanel) lua.GoFunction {
+  return func(L *lua.State) int {
+    if !LuaCheckParamsOk(L, "MinionPoints", LuaInteger) {
+      return 0
+    }
+    gp.script.syncStart()
+    defer gp.script.syncEnd()
+    L.PushInteger(gp.game.MinionPoints(L.ToInteger(-1)))
+    return 1
+  }
+}
+
 func netSideFunc(gp *GamePanel) lua.GoFunction {
   return func(L *lua.State) int {
     if !LuaCheckParamsOk(L, "Side") {
@@ -1709,17 +2008,12 @@ func netSideFunc(gp *GamePanel) lua.GoFunction {
       L.PushString("Denizens")
       return 1
     }
-    var net_id mrgnet.NetId
-    fmt.Sscanf(base.GetStoreVal("netid"), "%d", &net_id)
-    switch {
-    case gp.game.net.game.Denizens_id == net_id:
-      L.PushString("Denizens")
-    case gp.game.net.game.Intruders_id == net_id:
-      L.PushString("Intruders")
-    default:
+    side := gp.netGame().Side(gp.game.net.game)
+    if side == "" {
       base.Error().Printf("Asked for a net side, but don't know the side.")
-      L.PushString("Unknown")
+      side = "Unknown"
     }
+    L.PushString(side)
     return 1
   }
 }
@@ -1735,18 +2029,9 @@ func updateStateFunc(gp *GamePanel) lua.GoFunction {
     }
     gp.script.syncStart()
     defer gp.script.syncEnd()
-    var net_id mrgnet.NetId
-    fmt.Sscanf(base.GetStoreVal("netid"), "%d", &net_id)
-    var req mrgnet.UpdateGameRequest
-    req.Id = net_id
-    req.Game_key = gp.game.net.key
-    req.Round = (gp.game.Turn+1)/2 - 1 // Server is base-0, lua is base-1
-    req.Intruders = gp.game.Side == SideExplorers
-    req.Before = []byte(L.ToString(-1))
-    var resp mrgnet.UpdateGameResponse
-    mrgnet.DoAction("update", req, &resp)
-    if resp.Err != "" {
-      base.Error().Printf("Error updating game state: %v", resp.Err)
+    err := gp.netGame().UpdateState(gp.game.Turn, gp.game.Side, []byte(L.ToString(-1)))
+    if err != nil {
+      base.Error().Printf("Error updating game state: %v", err)
       return 0
     }
     base.Log().Printf("UpdateState: Turn = %d, Side = %d", gp.game.Turn, gp.game.Side)
@@ -1771,22 +2056,18 @@ func updateExecsFunc(gp *GamePanel) lua.GoFunction {
     }
     gp.script.syncStart()
     defer gp.script.syncEnd()
-    var net_id mrgnet.NetId
-    fmt.Sscanf(base.GetStoreVal("netid"), "%d", &net_id)
-    var req mrgnet.UpdateGameRequest
-    req.Id = net_id
-    req.Game_key = gp.game.net.key
-    req.Round = (gp.game.Turn+1)/2 - 1 // Server is base-0, lua is base-1
-    req.Intruders = gp.game.Side == SideExplorers
-    req.Execs = buf.Bytes()
-    req.After = []byte(L.ToString(-2))
-    var resp mrgnet.UpdateGameResponse
-    mrgnet.DoAction("update", req, &resp)
-    if resp.Err != "" {
-      base.Error().Printf("Error updating game execs: %v", resp.Err)
+    err = gp.netGame().UpdateExecs(gp.game.Turn, gp.game.Side, []byte(L.ToString(-2)), buf.Bytes())
+    if err != nil {
+      base.Error().Printf("Error updating game execs: %v", err)
       return 0
     }
     base.Log().Printf("Successfully update game execs: %v", gp.game.net.key)
+    if gp.game.Winner != SideNone {
+      err = gp.netGame().SetWinner(gp.game.Winner)
+      if err != nil {
+        base.Error().Printf("Unable to tell the server who won: %v", err)
+      }
+    }
     return 0
   }
 }
@@ -1800,32 +2081,8 @@ func netWaitFunc(gp *GamePanel) lua.GoFunction {
       base.Error().Printf("Tried to Wait in a non-net game.")
       return 0
     }
-    var net_id mrgnet.NetId
-    fmt.Sscanf(base.GetStoreVal("netid"), "%d", &net_id)
-    var req mrgnet.StatusRequest
-    req.Game_key = gp.game.net.key
-    req.Id = net_id
-    req.Sizes_only = true
-    for {
-      var resp mrgnet.StatusResponse
-      mrgnet.DoAction("status", req, &resp)
-      if resp.Err != "" {
-        base.Error().Printf("%s", resp.Err)
-        return 0
-      }
-      expect := gp.game.Turn + 1
-      if len(resp.Game.Before) == len(resp.Game.Execs) && len(resp.Game.Before) == expect {
-        base.Log().Printf("Found the expected %d states", expect)
-        req.Sizes_only = false
-        mrgnet.DoAction("status", req, &resp)
-        if resp.Err != "" {
-          base.Error().Printf("%s", resp.Err)
-          return 0
-        }
-        break
-      }
-      base.Log().Printf("Found %d instead of %d states", len(resp.Game.Execs), expect)
-      time.Sleep(time.Second * 5)
+    if err := gp.netGame().Wait(gp.game.Turn + 1); err != nil {
+      base.Error().Printf("%v", err)
     }
     return 0
   }
@@ -1840,24 +2097,13 @@ func netLatestStateAndExecsFunc(gp *GamePanel) lua.GoFunction {
       base.Error().Printf("Tried to get LatestStateAndExecs in a non-net game.")
       return 0
     }
-    var net_id mrgnet.NetId
-    fmt.Sscanf(base.GetStoreVal("netid"), "%d", &net_id)
-    var req mrgnet.StatusRequest
-    req.Game_key = gp.game.net.key
-    req.Id = net_id
-    var resp mrgnet.StatusResponse
-    mrgnet.DoAction("status", req, &resp)
-    if resp.Err != "" {
-      base.Error().Printf("%s", resp.Err)
-      return 0
-    }
-    if len(resp.Game.Before) != len(resp.Game.Execs) {
-      base.Error().Printf("Not the same number of States and Execss")
+    state, execs, err := gp.netGame().Latest()
+    if err != nil {
+      base.Error().Printf("Unable to get the latest state and execs: %v", err)
       return 0
     }
-    state := resp.Game.Before[len(resp.Game.Before)-1]
     L.PushString(string(state))
-    buf := bytes.NewBuffer(resp.Game.Execs[len(resp.Game.Execs)-1])
+    buf := bytes.NewBuffer(execs)
     gp.script.syncStart()
     LuaDecodeValue(buf, L, gp.game)
     gp.script.syncEnd()
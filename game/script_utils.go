@@ -344,6 +344,10 @@ func LuaPushEntity(L *lua.State, _ent *Entity) {
       ent := _ent.Game().EntityById(id)
       L.PushInteger(ent.Stats.ApMax())
     },
+    "Minions": func() {
+      ent := _ent.Game().EntityById(id)
+      L.PushInteger(ent.MinionBudget())
+    },
     "Info": func() {
       ent := _ent.Game().EntityById(id)
       L.NewTable()
@@ -0,0 +1,129 @@
+package game
+
+import (
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/game/status"
+  "github.com/mik3cap/haunts/house"
+  "path/filepath"
+)
+
+// Loads all of the furniture, rooms, houses, gear, actions, native ais and
+// conditions in the data directory.  Entities are loaded separately by
+// LoadAllEntities().
+func LoadAllRegistries() {
+  datadir := base.GetDataDir()
+  house.LoadAllFurnitureInDir(filepath.Join(datadir, "furniture"))
+  house.LoadAllWallTexturesInDir(filepath.Join(datadir, "textures"))
+  house.LoadAllRoomsInDir(filepath.Join(datadir, "rooms"))
+  house.LoadAllDoorsInDir(filepath.Join(datadir, "doors"))
+  house.LoadAllHousesInDir(filepath.Join(datadir, "houses"))
+  LoadAllGearInDir(filepath.Join(datadir, "gear"))
+  RegisterActions()
+  RegisterNativeAis()
+  status.RegisterAllConditions()
+}
+
+// Gets everything ready to run games from datadir without a window.  This
+// must be called before making any Sims, and before anything else has been
+// loaded.
+func SetupHeadless(datadir string) error {
+  base.SetHeadless(true)
+  base.SetDatadir(datadir)
+  err := house.SetDatadir(datadir)
+  if err != nil {
+    return err
+  }
+  LoadAllRegistries()
+  LoadAllEntities()
+  return nil
+}
+
+// A Sim runs a game without a window, script or ais, so that tests can set
+// up a situation, run some actions, and check what happened.  Ais also use
+// them, through Forks, to try out actions before doing them for real.  Everything
+// happens on the calling goroutine and with a seeded random number generator
+// so the same calls always give the same results.  Note that spawnEnts()
+// still uses math/rand, so anything that places entities randomly should
+// seed that as well.
+type Sim struct {
+  Game *Game
+}
+
+// Makes a Sim in the named house.  It is the denizens' turn in the first
+// round, the same as a newly started game.
+func MakeSim(house_name string, seed int64) (*Sim, error) {
+  if !base.Headless() {
+    return nil, errors.New("SetupHeadless() must be called before making a Sim.")
+  }
+  def := house.MakeHouseFromName(house_name)
+  if def == nil || len(def.Floors) == 0 {
+    return nil, errors.New(fmt.Sprintf("No house exists with the name '%s'.", house_name))
+  }
+  var s Sim
+  s.Game = makeGame(def)
+  s.Game.Rand.Seed(seed)
+  return &s, nil
+}
+
+// Spawns the named entity at x, y, the same as Script.SpawnEntityAtPosition.
+func (s *Sim) Spawn(name string, x, y int) (*Entity, error) {
+  ent := MakeEntity(name, s.Game)
+  if ent.Name == "" {
+    return nil, errors.New(fmt.Sprintf("No entity exists with the name '%s'.", name))
+  }
+  if !s.Game.SpawnEntity(ent, x, y) {
+    return nil, errors.New(fmt.Sprintf("Unable to spawn '%s' at (%d, %d).", name, x, y))
+  }
+  return ent, nil
+}
+
+// Runs exec until its action completes.  The entity running it must be on
+// the side whose turn it is.
+func (s *Sim) Exec(exec ActionExec) error {
+  return replayExec(s.Game, s.Game.Side, exec)
+}
+
+// Ends the current side's turn and starts the other side's, the same as when
+// a player ends their turn.
+func (s *Sim) EndTurn() {
+  s.Game.OnRound(true)
+}
+
+// Advances every entity by dt milliseconds without running any actions.
+func (s *Sim) Think(dt int64) {
+  for _, ent := range s.Game.Ents {
+    ent.Think(dt)
+  }
+}
+
+// A Fork is a snapshot of a game that any number of Sims can be made from,
+// so that an ai can see what might happen if it did something without
+// touching the real game.
+type Fork struct {
+  data []byte
+}
+
+func (g *Game) Fork() (*Fork, error) {
+  data, err := g.GobEncode()
+  if err != nil {
+    return nil, err
+  }
+  return &Fork{data}, nil
+}
+
+// Makes a Sim that starts from the snapshot, seed is used instead of the
+// game's random numbers so that an ai can try the same thing several times
+// with different results.  Games made this way have no ais and don't make
+// any sounds.
+func (f *Fork) Sim(seed int64) (*Sim, error) {
+  var g Game
+  g.forked = true
+  err := g.GobDecode(f.data)
+  if err != nil {
+    return nil, err
+  }
+  g.Rand.Seed(seed)
+  return &Sim{Game: &g}, nil
+}
@@ -0,0 +1,106 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/game/actions"
+  _ "github.com/mik3cap/haunts/game/ai"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "path/filepath"
+)
+
+func init() {
+  datadir, _ := filepath.Abs("../data")
+  err := game.SetupHeadless(datadir)
+  if err != nil {
+    panic(err)
+  }
+}
+
+func SimSpec(c gospec.Context) {
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+
+  c.Specify("Houses load without a window.", func() {
+    c.Expect(s.Game.House, Not(Equals), nil)
+    c.Expect(s.Game.Side, Equals, game.SideHaunt)
+  })
+
+  c.Specify("Unknown houses are reported.", func() {
+    _, err := game.MakeSim("not a house", 1)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Entities can be spawned and moved.", func() {
+    ent, err := s.Spawn("Technician", 47, 25)
+    c.Assume(err, Equals, nil)
+    c.Expect(len(s.Game.Ents), Equals, 1)
+    ap := ent.Stats.ApCur()
+
+    move, ok := ent.Actions[0].(*actions.Move)
+    c.Assume(ok, Equals, true)
+    adj, _ := s.Game.Graph(game.SideHaunt, false, nil).Adjacent(s.Game.ToVertex(ent.Pos()))
+    c.Assume(len(adj), Not(Equals), 0)
+    exec := move.AiMoveToPos(ent, adj[0:1], 10)
+    c.Assume(exec, Not(Equals), nil)
+    c.Expect(s.Exec(exec), Equals, nil)
+
+    _, x, y := s.Game.FromVertex(adj[0])
+    ex, ey := ent.Pos()
+    c.Expect(ex, Equals, x)
+    c.Expect(ey, Equals, y)
+    c.Expect(ent.Stats.ApCur() < ap, Equals, true)
+  })
+
+  c.Specify("Entities can't act on the other side's turn.", func() {
+    ent, err := s.Spawn("Technician", 47, 25)
+    c.Assume(err, Equals, nil)
+    s.EndTurn()
+    move := ent.Actions[0].(*actions.Move)
+    adj, _ := s.Game.Graph(game.SideHaunt, false, nil).Adjacent(s.Game.ToVertex(ent.Pos()))
+    c.Assume(len(adj), Not(Equals), 0)
+    exec := move.AiMoveToPos(ent, adj[0:1], 10)
+    c.Assume(exec, Not(Equals), nil)
+    c.Expect(s.Exec(exec), Not(Equals), nil)
+  })
+
+  c.Specify("Turns alternate between the sides.", func() {
+    s.EndTurn()
+    c.Expect(s.Game.Side, Equals, game.SideExplorers)
+    c.Expect(s.Game.Turn, Equals, 2)
+    s.EndTurn()
+    c.Expect(s.Game.Side, Equals, game.SideHaunt)
+    c.Expect(s.Game.Turn, Equals, 3)
+  })
+
+  c.Specify("Sims made from a fork don't change the real game.", func() {
+    ent, err := s.Spawn("Technician", 47, 25)
+    c.Assume(err, Equals, nil)
+    fork, err := s.Game.Fork()
+    c.Assume(err, Equals, nil)
+    other, err := fork.Sim(1)
+    c.Assume(err, Equals, nil)
+    twin := other.Game.EntityById(ent.Id)
+    c.Assume(twin, Not(Equals), nil)
+    c.Expect(twin == ent, Equals, false)
+
+    move := twin.Actions[0].(*actions.Move)
+    adj, _ := other.Game.Graph(game.SideHaunt, false, nil).Adjacent(other.Game.ToVertex(twin.Pos()))
+    c.Assume(len(adj), Not(Equals), 0)
+    exec := move.AiMoveToPos(twin, adj[0:1], 10)
+    c.Assume(exec, Not(Equals), nil)
+    c.Expect(other.Exec(exec), Equals, nil)
+    c.Expect(twin.Stats.ApCur() < ent.Stats.ApCur(), Equals, true)
+    x, y := ent.Pos()
+    c.Expect(x, Equals, 47)
+    c.Expect(y, Equals, 25)
+  })
+
+  c.Specify("Sims with the same seed roll the same numbers.", func() {
+    other, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+    c.Assume(err, Equals, nil)
+    for i := 0; i < 10; i++ {
+      c.Expect(other.Game.Rand.Int63(), Equals, s.Game.Rand.Int63())
+    }
+  })
+}
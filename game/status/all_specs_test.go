@@ -10,5 +10,7 @@ func TestAllSpecs(t *testing.T) {
   status.RegisterAllConditions()
   r := gospec.NewRunner()
   r.AddSpec(ConditionsSpec)
+  r.AddSpec(ScriptedConditionsSpec)
+  r.AddSpec(TriggersSpec)
   gospec.MainGoTest(r, t)
 }
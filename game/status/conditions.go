@@ -1,7 +1,6 @@
 package status
 
 import (
-  "encoding/gob"
   "path/filepath"
   "github.com/mik3cap/haunts/base"
 )
@@ -31,6 +30,94 @@ type Condition interface {
   OnRound() (dmg *Damage, complete bool)
 }
 
+// How a condition gets along with copies of itself on the same entity.
+type Stacking string
+
+const (
+  // Only one condition of each Kind, the strongest, is kept.  This is the
+  // default.
+  StackReplace Stacking = "Replace"
+
+  // Reapplying the condition starts its duration over, otherwise it is the
+  // same as StackReplace.
+  StackRefresh Stacking = "Refresh"
+
+  // Up to Max_stacks copies of the condition are kept.  Once there are that
+  // many, reapplying it starts the oldest copy's duration over instead.
+  // Stacking conditions don't displace, and aren't displaced by, other
+  // conditions of the same Kind.
+  StackStack Stacking = "Stack"
+)
+
+// The rules that Inst.ApplyCondition follows for a condition.  Immunities
+// and Cleanses list names and tags of conditions: while a condition is on an
+// entity any condition it is immune to is ignored, and when a condition is
+// applied every condition it cleanses is removed.
+type ConditionRules struct {
+  Stacking Stacking
+
+  // Only used by StackStack, 0 means there is no limit.
+  Max_stacks int
+
+  Tags       []string
+  Immunities []string
+  Cleanses   []string
+}
+
+// Warns about rules that can't be followed, they are treated as if they
+// weren't there.
+func (r *ConditionRules) check(name string) {
+  switch r.Stacking {
+  case "", StackReplace, StackRefresh, StackStack:
+  default:
+    base.Warn().Printf("Condition '%s' has unknown Stacking '%s'.", name, r.Stacking)
+  }
+  if r.Max_stacks < 0 {
+    base.Warn().Printf("Condition '%s' has a negative Max_stacks.", name)
+  }
+}
+
+// Conditions that implement this follow their ConditionRules, others are
+// only kept or displaced by Kind and Strength.
+type RuledCondition interface {
+  Condition
+
+  Rules() ConditionRules
+
+  // Starts the condition's duration over.
+  Refresh()
+
+  // Returns how many rounds the condition has been on for since it was
+  // applied or last refreshed.
+  Elapsed() int
+}
+
+// Returns true if c is named, or has a tag, in list.
+func conditionMatches(c Condition, list []string) bool {
+  var tags []string
+  if rc, ok := c.(RuledCondition); ok {
+    tags = rc.Rules().Tags
+  }
+  for _, s := range list {
+    if s == c.Name() {
+      return true
+    }
+    for _, tag := range tags {
+      if s == tag {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+func conditionRules(c Condition) ConditionRules {
+  if rc, ok := c.(RuledCondition); ok {
+    return rc.Rules()
+  }
+  return ConditionRules{}
+}
+
 var condition_registerers []func()
 var condition_makers map[string]func() Condition
 
@@ -53,8 +140,12 @@ func MakeCondition(name string) Condition {
 func registerBasicConditions() {
   registry_name := "conditions-basic_conditions"
   base.RemoveRegistry(registry_name)
-  base.RegisterRegistry(registry_name, make(map[string]*BasicConditionDef))
+  registry := make(map[string]*BasicConditionDef)
+  base.RegisterRegistry(registry_name, registry)
   base.RegisterAllObjectsInDir(registry_name, filepath.Join(base.GetDataDir(), "conditions", "basic_conditions"), ".json", "json")
+  for name, def := range registry {
+    def.ConditionRules.check(name)
+  }
   names := base.GetAllNamesInRegistry(registry_name)
   for _, name := range names {
     cname := name
@@ -69,7 +160,7 @@ func registerBasicConditions() {
 
 func init() {
   condition_registerers = append(condition_registerers, registerBasicConditions)
-  gob.Register(&BasicCondition{})
+  base.RegisterSaveType(&BasicCondition{})
 }
 
 type BasicCondition struct {
@@ -97,6 +188,13 @@ type BasicConditionDef struct {
   // The strength of this condition
   Strength int
 
+  // Stacking, immunities, cleanses and tags.
+  ConditionRules
+
+  // What this Condition does when things happen to its target, see
+  // TriggeredCondition.
+  Triggers []ConditionTrigger
+
   // This Condition will OnRound() exactly Duration + 1 times.
   // If Duration < 0 then it will OnRound() forever.
   Duration int
@@ -120,6 +218,18 @@ func (bc *BasicCondition) Kind() Kind {
   return bc.BasicConditionDef.Kind
 }
 
+func (bc *BasicConditionDef) Rules() ConditionRules {
+  return bc.ConditionRules
+}
+
+func (bc *BasicCondition) Refresh() {
+  bc.Time = 0
+}
+
+func (bc *BasicCondition) Elapsed() int {
+  return bc.Time
+}
+
 func (bc *BasicConditionDef) ModifyDamage(dmg Damage) Damage {
   return dmg
 }
@@ -17,6 +17,22 @@ func init() {
   base.SetDatadir(datadir)
 }
 
+func makeTestInst() status.Inst {
+  var s status.Inst
+  s.UnmarshalJSON([]byte(`
+    {
+      "Base": {
+        "Hp_max": 100,
+        "Ap_max": 10,
+        "Sight": 10
+      },
+      "Dynamic": {
+        "Hp": 100
+      }
+    }`))
+  return s
+}
+
 func ConditionsSpec(c gospec.Context) {
   c.Specify("Conditions are loaded properly.", func() {
     basic := status.MakeCondition("Basic Test")
@@ -79,6 +95,9 @@ func ConditionsSpec(c gospec.Context) {
     c.Expect(s.CorpusVs("Fire"), Equals, s.CorpusVs("Unspecified")+3)
     c.Expect(s.CorpusVs("Panic"), Equals, s.CorpusVs("Unspecified"))
     c.Expect(s.CorpusVs("Brutal"), Equals, s.CorpusVs("Unspecified"))
+    // The weaker resistance is of the same Kind, so it has no effect.
+    c.Expect(s.ApplyCondition(fr1), Equals, false)
+    c.Expect(s.CorpusVs("Fire"), Equals, s.CorpusVs("Unspecified")+3)
   })
 
   c.Specify("Basic conditions last the appropriate amount of time", func() {
@@ -154,4 +173,92 @@ func ConditionsSpec(c gospec.Context) {
     s.OnRound()
     c.Expect(s.HpCur(), Equals, 75)
   })
+  c.Specify("Stacking conditions stack up to Max_stacks.", func() {
+    var s status.Inst
+    for i := 0; i < 4; i++ {
+      s.ApplyCondition(status.MakeCondition("Stacking Poison"))
+    }
+    c.Expect(len(s.ConditionNames()), Equals, 3)
+    c.Expect(s.AttackBonusWith(status.Unspecified), Equals, -3)
+
+    // Other conditions of the same Kind don't displace them.
+    s.ApplyCondition(status.MakeCondition("Poison Debuff Attack"))
+    c.Expect(len(s.ConditionNames()), Equals, 4)
+    c.Expect(s.AttackBonusWith(status.Unspecified), Equals, -4)
+  })
+
+  c.Specify("Stacking past Max_stacks refreshes the oldest copy.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Stacking Poison"))
+    s.OnRound()
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 98)
+    s.ApplyCondition(status.MakeCondition("Stacking Poison"))
+    s.ApplyCondition(status.MakeCondition("Stacking Poison"))
+    s.ApplyCondition(status.MakeCondition("Stacking Poison"))
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 95)
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 92)
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 89)
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 89)
+  })
+
+  c.Specify("Stacking past Max_stacks refreshes the copy that has been on the longest.", func() {
+    s := makeTestInst()
+    apply := func() {
+      s.ApplyCondition(status.MakeCondition("Stacking Poison"))
+    }
+    apply()
+    s.OnRound()
+    apply()
+    s.OnRound()
+    apply()
+
+    // Refreshes the first copy, which leaves the second as the oldest.
+    apply()
+    apply()
+    s.OnRound()
+    s.OnRound()
+    c.Expect(len(s.ConditionNames()), Equals, 3)
+    s.OnRound()
+    c.Expect(len(s.ConditionNames()), Equals, 0)
+  })
+
+  c.Specify("Refreshing conditions start their duration over.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Refreshing Buff"))
+    s.OnRound()
+    s.ApplyCondition(status.MakeCondition("Refreshing Buff"))
+    c.Expect(len(s.ConditionNames()), Equals, 1)
+    s.OnRound()
+    c.Expect(s.AttackBonusWith(status.Unspecified), Equals, 1)
+    s.OnRound()
+    c.Expect(s.AttackBonusWith(status.Unspecified), Equals, 0)
+  })
+
+  c.Specify("Immunities keep conditions from being applied.", func() {
+    s := makeTestInst()
+    c.Expect(s.ApplyCondition(status.MakeCondition("Light")), Equals, true)
+    c.Expect(s.ApplyCondition(status.MakeCondition("Darkness")), Equals, false)
+    c.Expect(s.ConditionNames(), ContainsExactly, Values("Light"))
+    c.Expect(s.Sight(), Equals, 11)
+  })
+
+  c.Specify("Cleanses remove conditions by name and by tag.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Darkness"))
+    c.Expect(s.Sight(), Equals, 8)
+    s.ApplyCondition(status.MakeCondition("Light"))
+    c.Expect(s.ConditionNames(), ContainsExactly, Values("Light"))
+    c.Expect(s.Sight(), Equals, 11)
+
+    s.ApplyCondition(status.MakeCondition("Stacking Poison"))
+    s.ApplyCondition(status.MakeCondition("Stacking Poison"))
+    s.ApplyCondition(status.MakeCondition("Poison Debuff Attack"))
+    s.ApplyCondition(status.MakeCondition("Antidote"))
+    c.Expect(s.ConditionNames(), ContainsExactly, Values("Light", "Antidote"))
+  })
 }
@@ -0,0 +1,499 @@
+package status
+
+import (
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  lua "github.com/xenith-studios/golua"
+  "io/ioutil"
+  "path/filepath"
+  "sync"
+)
+
+// Scripted conditions are loaded from data/conditions/scripted.  Each one is
+// a json file with the same fields as a basic condition plus Script, the
+// name of a lua file in the same directory, e.g.
+//   {
+//     "Name": "Shield",
+//     "Kind": "Brutal",
+//     "Strength": 3,
+//     "Duration": 3,
+//     "Script": "shield.lua"
+//   }
+// The script can define any of these functions, conditions behave like a
+// basic condition for any that it leaves out:
+//   ModifyDamage(self, dmg)     - returns the damage that should actually be
+//                                 done, or nil to leave it alone.
+//   ModifyBase(self, base, kind) - returns the modified base stats, or nil to
+//                                 leave them alone.  Called after Base and
+//                                 Resistances have been applied.
+//   OnRound(self)               - returns the damage to do this round, or
+//                                 nil, and optionally whether the condition
+//                                 is complete.  If complete isn't returned
+//                                 the condition lasts for Duration.
+//   OnEvent(self, event)        - returns a Reaction, or nil, see
+//                                 triggers.go.  Called after Triggers.
+// dmg is a table with Hp, Ap and Kind, base a table with the same fields as
+// Base.  event and the Reaction have the same fields as Event and Reaction,
+// with Damage, Self, Other and Area as tables like dmg.  self has the condition's Name, Kind, Strength, Duration, Time and
+// State.  State is a table that ModifyDamage, OnRound and OnEvent can keep numbers,
+// strings and booleans in, it is saved along with the condition.  Changes
+// ModifyBase makes to State are thrown away since it is called whenever a
+// stat is looked at.
+
+func init() {
+  condition_registerers = append(condition_registerers, registerScriptedConditions)
+  base.RegisterSaveType(&ScriptedCondition{})
+}
+
+// Scripts have to finish in this many instructions.
+const condition_instruction_limit = 100000
+
+type ScriptedCondition struct {
+  Defname string
+  *ScriptedConditionDef
+  Time int
+
+  // Kept between rounds and saved with the condition, see the comment at
+  // the top of this file.  Values are float64, string or bool.
+  State map[string]interface{}
+}
+
+type ScriptedConditionDef struct {
+  BasicConditionDef
+
+  // The lua file, relative to data/conditions/scripted.
+  Script string
+}
+
+// The lua state for one scripted condition.  Every instance of the
+// condition shares it, so calls into it are serialized.
+type conditionScript struct {
+  sync.Mutex
+  L *lua.State
+
+  // Which of the hooks the script defined.
+  modify_damage, modify_base, on_round, on_event bool
+}
+
+var scripted_conditions struct {
+  sync.Mutex
+  scripts map[string]*conditionScript
+}
+
+const scripted_registry_name = "conditions-scripted_conditions"
+
+func registerScriptedConditions() {
+  scripted_conditions.Lock()
+  defer scripted_conditions.Unlock()
+  for _, script := range scripted_conditions.scripts {
+    script.Lock()
+    script.L.Close()
+    script.Unlock()
+  }
+  scripted_conditions.scripts = make(map[string]*conditionScript)
+
+  dir := filepath.Join(base.GetDataDir(), "conditions", "scripted")
+  base.RemoveRegistry(scripted_registry_name)
+  registry := make(map[string]*ScriptedConditionDef)
+  base.RegisterRegistry(scripted_registry_name, registry)
+  base.RegisterAllObjectsInDir(scripted_registry_name, dir, ".json", "json")
+  for name, def := range registry {
+    def.ConditionRules.check(name)
+    script, err := loadConditionScript(filepath.Join(dir, def.Script))
+    if err != nil {
+      base.Error().Printf("Unable to load scripted condition '%s': %v", name, err)
+      continue
+    }
+    scripted_conditions.scripts[name] = script
+    cname := name
+    condition_makers[name] = func() Condition {
+      c := ScriptedCondition{Defname: cname}
+      base.GetObject(scripted_registry_name, &c)
+      return &c
+    }
+  }
+}
+
+// Loaded before the condition's script.  Hooks are called through
+// __condition_call so that errors are caught, and whatever they return is
+// left in globals for Go to pick up.
+const condition_prelude = `
+do
+  local pcall = pcall
+  local tostring = tostring
+  local G = _G
+
+  function __condition_call(name)
+    local ok, r1, r2 = pcall(G[name], __condition_self, __condition_arg1, __condition_arg2)
+    if ok then
+      __condition_error = nil
+      __condition_res1 = r1
+      __condition_res2 = r2
+    else
+      __condition_error = tostring(r1)
+      __condition_res1 = nil
+      __condition_res2 = nil
+    end
+  end
+end
+os = nil
+io = nil
+debug = nil
+package = nil
+require = nil
+module = nil
+dofile = nil
+loadfile = nil
+load = nil
+`
+
+func loadConditionScript(path string) (*conditionScript, error) {
+  base.CheckPathCasing(path)
+  prog, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  var script conditionScript
+  script.L = lua.NewState()
+  script.L.OpenLibs()
+  script.L.DoString(condition_prelude)
+  if !script.L.DoString(string(prog)) {
+    script.L.Close()
+    return nil, errors.New(fmt.Sprintf("Error running %s.", path))
+  }
+  has := func(name string) bool {
+    script.L.GetGlobal(name)
+    defer script.L.Pop(1)
+    return script.L.IsFunction(-1)
+  }
+  script.modify_damage = has("ModifyDamage")
+  script.modify_base = has("ModifyBase")
+  script.on_round = has("OnRound")
+  script.on_event = has("OnEvent")
+  return &script, nil
+}
+
+func (sc *ScriptedCondition) script() *conditionScript {
+  scripted_conditions.Lock()
+  defer scripted_conditions.Unlock()
+  return scripted_conditions.scripts[sc.Defname]
+}
+
+func (sc *ScriptedCondition) Name() string {
+  return sc.ScriptedConditionDef.Name
+}
+
+func (sc *ScriptedCondition) Strength() int {
+  return sc.ScriptedConditionDef.Strength
+}
+
+func (sc *ScriptedCondition) Kind() Kind {
+  return sc.ScriptedConditionDef.Kind
+}
+
+// Starts the duration over, State is kept.
+func (sc *ScriptedCondition) Refresh() {
+  sc.Time = 0
+}
+
+func (sc *ScriptedCondition) Elapsed() int {
+  return sc.Time
+}
+
+// Calls the hook called name with self and args already set, returns false
+// if it failed.  The results are left in __condition_res1 and
+// __condition_res2.
+func (sc *ScriptedCondition) call(script *conditionScript, name string) bool {
+  L := script.L
+  L.SetExecutionLimit(condition_instruction_limit)
+  L.DoString(fmt.Sprintf("__condition_call(%q)", name))
+  L.GetGlobal("__condition_error")
+  defer L.Pop(1)
+  if L.IsString(-1) {
+    base.Error().Printf("Condition '%s' failed in %s: %s", sc.Defname, name, L.ToString(-1))
+    return false
+  }
+  return true
+}
+
+func (sc *ScriptedCondition) pushSelf(L *lua.State) {
+  L.NewTable()
+  setTableString(L, "Name", sc.Name())
+  setTableString(L, "Kind", string(sc.Kind()))
+  setTableNumber(L, "Strength", float64(sc.Strength()))
+  setTableNumber(L, "Duration", float64(sc.Duration))
+  setTableNumber(L, "Time", float64(sc.Time))
+  L.PushString("State")
+  L.NewTable()
+  for key, val := range sc.State {
+    L.PushString(key)
+    switch v := val.(type) {
+    case float64:
+      L.PushNumber(v)
+    case string:
+      L.PushString(v)
+    case bool:
+      L.PushBoolean(v)
+    default:
+      L.PushNil()
+    }
+    L.SetTable(-3)
+  }
+  L.SetTable(-3)
+  L.SetGlobal("__condition_self")
+}
+
+// Reads self.State back after a hook that is allowed to change it.
+func (sc *ScriptedCondition) readState(L *lua.State) {
+  L.GetGlobal("__condition_self")
+  defer L.Pop(1)
+  L.PushString("State")
+  L.GetTable(-2)
+  defer L.Pop(1)
+  state := make(map[string]interface{})
+  if L.IsTable(-1) {
+    L.PushNil()
+    for L.Next(-2) != 0 {
+      if L.IsString(-2) && !L.IsNumber(-2) {
+        key := L.ToString(-2)
+        switch {
+        case L.IsBoolean(-1):
+          state[key] = L.ToBoolean(-1)
+        case L.IsNumber(-1):
+          state[key] = L.ToNumber(-1)
+        case L.IsString(-1):
+          state[key] = L.ToString(-1)
+        }
+      }
+      L.Pop(1)
+    }
+  }
+  sc.State = state
+}
+
+func setTableNumber(L *lua.State, key string, val float64) {
+  L.PushString(key)
+  L.PushNumber(val)
+  L.SetTable(-3)
+}
+
+func setTableString(L *lua.State, key string, val string) {
+  L.PushString(key)
+  L.PushString(val)
+  L.SetTable(-3)
+}
+
+// If the table at index has a number in key sets *dst to it.
+func getTableInt(L *lua.State, index int, key string, dst *int) {
+  L.PushString(key)
+  L.GetTable(index - 1)
+  if L.IsNumber(-1) {
+    *dst = L.ToInteger(-1)
+  }
+  L.Pop(1)
+}
+
+func pushDamage(L *lua.State, dmg Damage) {
+  L.NewTable()
+  setTableNumber(L, "Hp", float64(dmg.Dynamic.Hp))
+  setTableNumber(L, "Ap", float64(dmg.Dynamic.Ap))
+  setTableString(L, "Kind", string(dmg.Kind))
+}
+
+// Reads the damage table at index, anything missing from it is taken from
+// dmg.
+func toDamage(L *lua.State, index int, dmg Damage) Damage {
+  getTableInt(L, index, "Hp", &dmg.Dynamic.Hp)
+  getTableInt(L, index, "Ap", &dmg.Dynamic.Ap)
+  L.PushString("Kind")
+  L.GetTable(index - 1)
+  if L.IsString(-1) && !L.IsNumber(-1) {
+    dmg.Kind = Kind(L.ToString(-1))
+  }
+  L.Pop(1)
+  return dmg
+}
+
+func pushBase(L *lua.State, b Base) {
+  L.NewTable()
+  setTableNumber(L, "Ap_max", float64(b.Ap_max))
+  setTableNumber(L, "Hp_max", float64(b.Hp_max))
+  setTableNumber(L, "Corpus", float64(b.Corpus))
+  setTableNumber(L, "Ego", float64(b.Ego))
+  setTableNumber(L, "Sight", float64(b.Sight))
+  setTableNumber(L, "Attack", float64(b.Attack))
+}
+
+func toBase(L *lua.State, index int, b Base) Base {
+  getTableInt(L, index, "Ap_max", &b.Ap_max)
+  getTableInt(L, index, "Hp_max", &b.Hp_max)
+  getTableInt(L, index, "Corpus", &b.Corpus)
+  getTableInt(L, index, "Ego", &b.Ego)
+  getTableInt(L, index, "Sight", &b.Sight)
+  getTableInt(L, index, "Attack", &b.Attack)
+  return b
+}
+
+func (sc *ScriptedCondition) ModifyDamage(dmg Damage) Damage {
+  script := sc.script()
+  if script == nil || !script.modify_damage {
+    return dmg
+  }
+  script.Lock()
+  defer script.Unlock()
+  L := script.L
+  sc.pushSelf(L)
+  pushDamage(L, dmg)
+  L.SetGlobal("__condition_arg1")
+  if !sc.call(script, "ModifyDamage") {
+    return dmg
+  }
+  sc.readState(L)
+  L.GetGlobal("__condition_res1")
+  defer L.Pop(1)
+  if !L.IsTable(-1) {
+    return dmg
+  }
+  return toDamage(L, -1, dmg)
+}
+
+func (sc *ScriptedCondition) ModifyBase(b Base, kind Kind) Base {
+  b = sc.BasicConditionDef.ModifyBase(b, kind)
+  script := sc.script()
+  if script == nil || !script.modify_base {
+    return b
+  }
+  script.Lock()
+  defer script.Unlock()
+  L := script.L
+  sc.pushSelf(L)
+  pushBase(L, b)
+  L.SetGlobal("__condition_arg1")
+  L.PushString(string(kind))
+  L.SetGlobal("__condition_arg2")
+  if !sc.call(script, "ModifyBase") {
+    return b
+  }
+  L.GetGlobal("__condition_res1")
+  defer L.Pop(1)
+  if !L.IsTable(-1) {
+    return b
+  }
+  return toBase(L, -1, b)
+}
+
+func (sc *ScriptedCondition) OnRound() (dmg *Damage, complete bool) {
+  script := sc.script()
+  if script == nil || !script.on_round {
+    var d Dynamic
+    if sc.Dynamic != d {
+      dmg = &Damage{Dynamic: sc.Dynamic, Kind: sc.Kind()}
+    }
+    sc.Time++
+    complete = (sc.Time == sc.Duration)
+    return
+  }
+  script.Lock()
+  defer script.Unlock()
+  L := script.L
+  sc.pushSelf(L)
+  ok := sc.call(script, "OnRound")
+  sc.Time++
+  complete = (sc.Time == sc.Duration)
+  if !ok {
+    return
+  }
+  sc.readState(L)
+  L.GetGlobal("__condition_res1")
+  if L.IsTable(-1) {
+    d := toDamage(L, -1, Damage{Kind: sc.Kind()})
+    dmg = &d
+  }
+  L.Pop(1)
+  L.GetGlobal("__condition_res2")
+  if L.IsBoolean(-1) {
+    complete = L.ToBoolean(-1)
+  }
+  L.Pop(1)
+  return
+}
+
+func pushEvent(L *lua.State, e Event) {
+  L.NewTable()
+  setTableString(L, "Trigger", string(e.Trigger))
+  L.PushString("Hit")
+  L.PushBoolean(e.Hit)
+  L.SetTable(-3)
+  L.PushString("Damage")
+  pushDamage(L, e.Damage)
+  L.SetTable(-3)
+  L.PushString("Killed")
+  L.PushBoolean(e.Killed)
+  L.SetTable(-3)
+  setTableNumber(L, "Cells", float64(e.Cells))
+  setTableString(L, "Room", e.Room)
+}
+
+// Reads the Dynamic in the table at index[key], if there is one.
+func getTableDynamic(L *lua.State, index int, key string, dst *Dynamic) {
+  L.PushString(key)
+  L.GetTable(index - 1)
+  if L.IsTable(-1) {
+    dmg := toDamage(L, -1, Damage{})
+    *dst = dmg.Dynamic
+  }
+  L.Pop(1)
+}
+
+func toReaction(L *lua.State, index int) Reaction {
+  var r Reaction
+  getTableDynamic(L, index, "Self", &r.Self)
+  getTableDynamic(L, index, "Other", &r.Other)
+  getTableDynamic(L, index, "Area", &r.Area)
+  getTableInt(L, index, "Radius", &r.Radius)
+  L.PushString("Kind")
+  L.GetTable(index - 1)
+  if L.IsString(-1) && !L.IsNumber(-1) {
+    r.Kind = Kind(L.ToString(-1))
+  }
+  L.Pop(1)
+  L.PushString("Conditions")
+  L.GetTable(index - 1)
+  if L.IsTable(-1) {
+    for i := 1; i <= int(L.ObjLen(-1)); i++ {
+      L.PushInteger(i)
+      L.GetTable(-2)
+      if L.IsString(-1) {
+        r.Conditions = append(r.Conditions, L.ToString(-1))
+      }
+      L.Pop(1)
+    }
+  }
+  L.Pop(1)
+  return r
+}
+
+func (sc *ScriptedCondition) OnEvent(e Event) []Reaction {
+  reactions := sc.BasicConditionDef.OnEvent(e)
+  script := sc.script()
+  if script == nil || !script.on_event {
+    return reactions
+  }
+  script.Lock()
+  defer script.Unlock()
+  L := script.L
+  sc.pushSelf(L)
+  pushEvent(L, e)
+  L.SetGlobal("__condition_arg1")
+  if !sc.call(script, "OnEvent") {
+    return reactions
+  }
+  sc.readState(L)
+  L.GetGlobal("__condition_res1")
+  defer L.Pop(1)
+  if L.IsTable(-1) {
+    reactions = append(reactions, toReaction(L, -1))
+  }
+  return reactions
+}
@@ -0,0 +1,63 @@
+package status_test
+
+import (
+  "bytes"
+  "encoding/gob"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "github.com/mik3cap/haunts/game/status"
+)
+
+func ScriptedConditionsSpec(c gospec.Context) {
+  c.Specify("Scripted conditions are loaded properly.", func() {
+    shield := status.MakeCondition("Scripted Shield")
+    _, ok := shield.(*status.ScriptedCondition)
+    c.Expect(ok, Equals, true)
+    c.Expect(shield.Strength(), Equals, 3)
+    c.Expect(shield.Kind(), Equals, status.Brutal)
+  })
+
+  c.Specify("ModifyBase is called after Base is applied.", func() {
+    var s status.Inst
+    s.ApplyCondition(status.MakeCondition("Scripted Shield"))
+    c.Expect(s.Corpus(), Equals, 1)
+    c.Expect(s.CorpusVs("Fire"), Equals, 3)
+  })
+
+  c.Specify("ModifyDamage can keep track of things in State.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Scripted Shield"))
+    s.ApplyDamage(0, -2, status.Brutal)
+    c.Expect(s.HpCur(), Equals, 100)
+    s.ApplyDamage(0, -2, status.Brutal)
+    c.Expect(s.HpCur(), Equals, 99)
+    s.ApplyDamage(0, -2, status.Brutal)
+    c.Expect(s.HpCur(), Equals, 97)
+  })
+
+  c.Specify("State is saved along with the condition.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Scripted Shield"))
+    s.ApplyDamage(0, -2, status.Brutal)
+    buf := bytes.NewBuffer(nil)
+    c.Assume(gob.NewEncoder(buf).Encode(s), Equals, nil)
+    var s2 status.Inst
+    c.Assume(gob.NewDecoder(buf).Decode(&s2), Equals, nil)
+    s2.ApplyDamage(0, -2, status.Brutal)
+    c.Expect(s2.HpCur(), Equals, 99)
+  })
+
+  c.Specify("OnRound can do damage and decide when it is complete.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Scripted Venom"))
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 99)
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 97)
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 94)
+    c.Expect(len(s.ConditionNames()), Equals, 0)
+    s.OnRound()
+    c.Expect(s.HpCur(), Equals, 94)
+  })
+}
@@ -195,23 +195,83 @@ func (s *Inst) ConditionNames() []string {
   return names
 }
 
-func (s *Inst) ApplyCondition(c Condition) {
+// Applies c, following the rules of both c and the conditions that are
+// already on this Inst, see ConditionRules.  Returns false if c had no
+// effect, because this Inst is immune to it or already has a stronger
+// condition of the same Kind.
+func (s *Inst) ApplyCondition(c Condition) bool {
+  for _, e := range s.inst.Conditions {
+    if conditionMatches(c, conditionRules(e).Immunities) {
+      return false
+    }
+  }
+
+  rules := conditionRules(c)
+  if len(rules.Cleanses) > 0 {
+    algorithm.Choose2(&s.inst.Conditions, func(e Condition) bool {
+      return !conditionMatches(e, rules.Cleanses)
+    })
+  }
+
+  switch rules.Stacking {
+  case StackStack:
+    // Once there are Max_stacks copies the one that has been on the longest
+    // is refreshed, which isn't necessarily the first one applied since
+    // copies can already have been refreshed.
+    var oldest RuledCondition
+    count := 0
+    for _, e := range s.inst.Conditions {
+      if e.Name() != c.Name() {
+        continue
+      }
+      count++
+      if rc, ok := e.(RuledCondition); ok && (oldest == nil || rc.Elapsed() > oldest.Elapsed()) {
+        oldest = rc
+      }
+    }
+    if rules.Max_stacks > 0 && count >= rules.Max_stacks {
+      if oldest == nil {
+        return false
+      }
+      oldest.Refresh()
+      return true
+    }
+    s.inst.Conditions = append(s.inst.Conditions, c)
+    return true
+
+  case StackRefresh:
+    for _, e := range s.inst.Conditions {
+      if e.Name() == c.Name() {
+        rc, ok := e.(RuledCondition)
+        if ok {
+          rc.Refresh()
+        }
+        return ok
+      }
+    }
+  }
+
   for i := range s.inst.Conditions {
+    if conditionRules(s.inst.Conditions[i]).Stacking == StackStack {
+      continue
+    }
     if s.inst.Conditions[i].Kind() == c.Kind() {
-      if s.inst.Conditions[i].Strength() <= c.Strength() {
+      displaced := s.inst.Conditions[i].Strength() <= c.Strength()
+      if displaced {
         s.inst.Conditions[i] = c
       }
 
       // Regardless of whether it was displaced or not we don't need to keep
       // checking Conditions.  We can only have one condition of each type
       // and buff pair, and this one is it.
-      return
+      return displaced
     }
   }
 
   // If we didn't find an existing condition of this kind then we can safely
   // add it.
   s.inst.Conditions = append(s.inst.Conditions, c)
+  return true
 }
 
 func (s *Inst) RemoveCondition(name string) {
@@ -285,7 +345,7 @@ func (s *Inst) OnRound() {
   s.inst.Conditions = s.inst.Conditions[0 : len(s.inst.Conditions)-num_complete]
 }
 
-// Encoding routines - only support json and gob right now
+// Encoding routines - only support json, gob and save trees right now
 
 func (si Inst) MarshalJSON() ([]byte, error) {
   return json.Marshal(si.inst)
@@ -307,3 +367,11 @@ func (si *Inst) GobDecode(data []byte) error {
   err := dec.Decode(&si.inst)
   return err
 }
+
+func (si Inst) MarshalSaveTree() (interface{}, error) {
+  return si.inst, nil
+}
+
+func (si *Inst) UnmarshalSaveTree(decode func(interface{}) error) error {
+  return decode(&si.inst)
+}
@@ -0,0 +1,147 @@
+package status
+
+// Besides OnRound, conditions can react to things that happen to the entity
+// they are on.  The game sends an Event to every TriggeredCondition on the
+// entity and carries out the Reactions that come back.  The only Event that
+// damage done by a Reaction can cause is Killed, when it takes an entity to
+// 0 Hp, and Reactions don't do anything to entities that are already dead.
+// Each entity can only die once, so a chain of Reactions, like Volatile
+// entities blowing each other up, ends after at most one Killed for every
+// entity in the game.
+
+type Trigger string
+
+const (
+  // The entity was the target of an attack, whether or not it hit.
+  TriggerAttacked Trigger = "Attacked"
+
+  // The entity hit something with an attack.
+  TriggerDealtDamage Trigger = "DealtDamage"
+
+  // The entity finished moving.
+  TriggerMoved Trigger = "Moved"
+
+  // The entity's Hp reached 0.
+  TriggerKilled Trigger = "Killed"
+
+  // The entity walked into a room.
+  TriggerEnteredRoom Trigger = "EnteredRoom"
+)
+
+type Event struct {
+  Trigger Trigger
+
+  // For Attacked and DealtDamage, whether the attack hit and what it did.
+  Hit    bool
+  Damage Damage
+
+  // For DealtDamage, whether the attack took the target to 0 Hp.
+  Killed bool
+
+  // For Moved, how many cells the entity moved.
+  Cells int
+
+  // For EnteredRoom, the name of the room.
+  Room string
+}
+
+// What a condition does in response to an Event.  The other entity is the
+// attacker for Attacked and the target for DealtDamage, there isn't one for
+// the other triggers.
+type Reaction struct {
+  // Done to the entity that the condition is on, e.g. regeneration.
+  Self Dynamic
+
+  // Done to the other entity, e.g. thorns.
+  Other Dynamic
+
+  // Done to every other entity within Radius of the entity that the
+  // condition is on, e.g. an explosion.
+  Area   Dynamic
+  Radius int
+
+  // The Kind of any damage that the Reaction does, Unspecified if it isn't
+  // set.
+  Kind Kind
+
+  // Applied to the other entity.
+  Conditions []string
+}
+
+// Conditions that react to Events implement this.
+type TriggeredCondition interface {
+  Condition
+
+  // Returns what the condition does in response to e, if anything.
+  OnEvent(e Event) []Reaction
+}
+
+// A trigger in a BasicConditionDef, e.g.
+//   "Triggers": [
+//     { "On": "Attacked", "Only_hits": true, "Other": { "Hp": -1 }, "Kind": "Brutal" }
+//   ]
+type ConditionTrigger struct {
+  On Trigger
+
+  // For Attacked and DealtDamage, only react to attacks that hit.
+  Only_hits bool
+
+  // For DealtDamage, only react to attacks that killed their target.
+  Only_kills bool
+
+  // For Moved, only react to moves of at least this many cells.
+  Cells int
+
+  // For EnteredRoom, only react to entering the room with this name.
+  Room string
+
+  Reaction
+}
+
+func (t *ConditionTrigger) matches(e Event) bool {
+  if t.On != e.Trigger {
+    return false
+  }
+  switch e.Trigger {
+  case TriggerAttacked, TriggerDealtDamage:
+    if t.Only_hits && !e.Hit {
+      return false
+    }
+    if t.Only_kills && !e.Killed {
+      return false
+    }
+  case TriggerMoved:
+    if e.Cells < t.Cells {
+      return false
+    }
+  case TriggerEnteredRoom:
+    if t.Room != "" && t.Room != e.Room {
+      return false
+    }
+  }
+  return true
+}
+
+func (bc *BasicConditionDef) OnEvent(e Event) []Reaction {
+  var reactions []Reaction
+  for i := range bc.Triggers {
+    if bc.Triggers[i].matches(e) {
+      reactions = append(reactions, bc.Triggers[i].Reaction)
+    }
+  }
+  return reactions
+}
+
+// Sends e to every condition that reacts to events and returns all of their
+// Reactions.
+func (s *Inst) Trigger(e Event) []Reaction {
+  var reactions []Reaction
+  // Reactions can apply conditions, so work from a copy.
+  conditions := append([]Condition{}, s.inst.Conditions...)
+  for _, c := range conditions {
+    if tc, ok := c.(TriggeredCondition); ok {
+      reactions = append(reactions, tc.OnEvent(e)...)
+    }
+  }
+  return reactions
+}
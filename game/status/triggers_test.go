@@ -0,0 +1,67 @@
+package status_test
+
+import (
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "github.com/mik3cap/haunts/game/status"
+)
+
+func TriggersSpec(c gospec.Context) {
+  hit := status.Event{
+    Trigger: status.TriggerAttacked,
+    Hit:     true,
+    Damage:  status.Damage{Dynamic: status.Dynamic{Hp: -2}, Kind: status.Brutal},
+  }
+
+  c.Specify("Conditions without triggers don't react.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Basic Test"))
+    c.Expect(len(s.Trigger(hit)), Equals, 0)
+  })
+
+  c.Specify("Triggers only react to the events they are for.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Thorns"))
+    reactions := s.Trigger(hit)
+    c.Assume(len(reactions), Equals, 1)
+    c.Expect(reactions[0].Other.Hp, Equals, -1)
+    c.Expect(reactions[0].Kind, Equals, status.Brutal)
+
+    miss := hit
+    miss.Hit = false
+    c.Expect(len(s.Trigger(miss)), Equals, 0)
+    c.Expect(len(s.Trigger(status.Event{Trigger: status.TriggerKilled})), Equals, 0)
+  })
+
+  c.Specify("Triggers can require kills and long moves.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Bloodthirst"))
+    s.ApplyCondition(status.MakeCondition("Volatile"))
+    dealt := status.Event{Trigger: status.TriggerDealtDamage, Hit: true}
+    c.Expect(len(s.Trigger(dealt)), Equals, 0)
+    dealt.Killed = true
+    reactions := s.Trigger(dealt)
+    c.Assume(len(reactions), Equals, 1)
+    c.Expect(reactions[0].Self.Hp, Equals, 2)
+
+    c.Expect(len(s.Trigger(status.Event{Trigger: status.TriggerMoved, Cells: 2})), Equals, 0)
+    c.Expect(len(s.Trigger(status.Event{Trigger: status.TriggerMoved, Cells: 3})), Equals, 1)
+
+    reactions = s.Trigger(status.Event{Trigger: status.TriggerKilled})
+    c.Assume(len(reactions), Equals, 1)
+    c.Expect(reactions[0].Area.Hp, Equals, -3)
+    c.Expect(reactions[0].Radius, Equals, 2)
+  })
+
+  c.Specify("Scripted conditions react through OnEvent.", func() {
+    s := makeTestInst()
+    s.ApplyCondition(status.MakeCondition("Scripted Reflect"))
+    reactions := s.Trigger(hit)
+    c.Assume(len(reactions), Equals, 1)
+    c.Expect(reactions[0].Other.Hp, Equals, -2)
+    c.Expect(reactions[0].Conditions, ContainsExactly, Values("Thorns"))
+    reactions = s.Trigger(hit)
+    c.Assume(len(reactions), Equals, 1)
+    c.Expect(reactions[0].Other.Hp, Equals, -4)
+  })
+}
@@ -0,0 +1,588 @@
+package game
+
+import (
+  "encoding/csv"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "io"
+  "math/rand"
+  "path/filepath"
+  "regexp"
+  "runtime"
+  "sort"
+  "time"
+)
+
+// Tournaments play the same level script over and over with ais on both
+// sides and without a window, so that designers can see how changes to the
+// data affect balance without playing the games themselves.  Anything that a
+// script would normally ask a player is answered automatically instead:
+//   BindAi(side, "human") - binds the ai from the MatchConfig instead.
+//   ChooserFromFile       - picks the option from Choices, or the first one.
+//   PlaceEntities         - places random entities until the points run out.
+//   PickFromN             - picks the first min options.
+//   DialogBox             - is skipped.
+// A match ends when the script calls SetWinner() or EndGame(), when one side
+// has nobody left alive, or after Max_turns turns.
+
+// Everything needed to play one match.
+type MatchConfig struct {
+  // Level script, relative to data/scripts, e.g. "versus/Lvl02.lua".
+  Script string
+
+  // Ais that replace "human" when the script binds ais, relative to
+  // data/ais.  These can also be native ais, e.g. "go:denizens".
+  Denizens, Minions, Intruders string
+
+  // Answers for ChooserFromFile, keyed by the path that the script passes
+  // to it.  Choosers that aren't listed here get their first option.
+  Choices map[string]string
+
+  Difficulty Difficulty
+
+  // Seeds the game's random numbers, as well as math/rand, which some ais
+  // use.  Ais run on their own goroutines, so matches are only as
+  // repeatable as the ais are.
+  Seed int64
+
+  // If nobody has won after this many turns the match is a draw, 0 means
+  // that there is no limit.
+  Max_turns int
+
+  // If the match takes longer than this it is abandoned, 0 means that there
+  // is no limit.
+  Time_limit time.Duration
+}
+
+type MatchResult struct {
+  Seed int64
+
+  // "Denizens" or "Intruders", or empty if the match was a draw.
+  Winner string
+
+  Turns int
+
+  // Set if the match couldn't be finished, these matches only count
+  // towards TournamentReport.Errors.
+  Error string
+
+  Actions  []ActionDamage
+  Entities []EntityOutcome
+}
+
+// How much damage an entity type did with one of its actions.
+type ActionDamage struct {
+  Entity string
+  Action string
+  Uses   int
+
+  // Hp lost by entities that aren't on the same side as the one using the
+  // action.
+  Damage int
+}
+
+type EntityOutcome struct {
+  Name     string
+  Side     string
+  Survived bool
+}
+
+// Each frame of a match is simulated as this many milliseconds.
+const match_dt = 16
+
+// State for the match being played by a GamePanel, only set for panels made
+// by PlayMatch.
+type match struct {
+  config MatchConfig
+
+  // Set by the script, and only read by PlayMatch between calls to
+  // scriptThinkOnce(), so the script's sync is all the locking needed.
+  winner string
+  over   bool
+}
+
+// Keeps track of what happens during a match.
+type matchTracker struct {
+  // Hp of every entity when the current action started.
+  hp map[EntityId]int
+
+  action Action
+  actor  *Entity
+
+  actions  map[[2]string]*ActionDamage
+  entities map[EntityId]EntityOutcome
+}
+
+func sideName(side Side) string {
+  switch side {
+  case SideHaunt:
+    return "Denizens"
+  case SideExplorers:
+    return "Intruders"
+  }
+  return ""
+}
+
+func (t *matchTracker) before(g *Game) {
+  if g.current_action != nil {
+    return
+  }
+  t.hp = make(map[EntityId]int)
+  for _, ent := range g.Ents {
+    if ent.Stats != nil {
+      t.hp[ent.Id] = ent.Stats.HpCur()
+    }
+  }
+}
+
+func (t *matchTracker) after(g *Game) {
+  for _, ent := range g.Ents {
+    if ent.Stats == nil || sideName(ent.Side()) == "" {
+      continue
+    }
+    if _, ok := t.entities[ent.Id]; !ok {
+      t.entities[ent.Id] = EntityOutcome{Name: ent.Name, Side: sideName(ent.Side())}
+    }
+  }
+
+  // Actions that the script runs after the main phase are replays of ones
+  // that were already counted.
+  if t.action == nil && g.current_action != nil && g.Turn_state != turnStateMainPhaseOver {
+    t.action = g.current_action
+    t.actor = nil
+    for _, ent := range g.Ents {
+      for _, action := range ent.Actions {
+        if action == t.action {
+          t.actor = ent
+        }
+      }
+    }
+  }
+  if t.action == nil || g.current_action != nil {
+    return
+  }
+  if t.actor != nil {
+    damage := 0
+    for id, before := range t.hp {
+      ent := g.EntityById(id)
+      if ent == nil || ent.Side() == t.actor.Side() {
+        continue
+      }
+      after := ent.Stats.HpCur()
+      if after < 0 {
+        after = 0
+      }
+      if after < before {
+        damage += before - after
+      }
+    }
+    key := [2]string{t.actor.Name, t.action.String()}
+    ad, ok := t.actions[key]
+    if !ok {
+      ad = &ActionDamage{Entity: key[0], Action: key[1]}
+      t.actions[key] = ad
+    }
+    ad.Uses++
+    ad.Damage += damage
+  }
+  t.action = nil
+  t.actor = nil
+}
+
+// Returns true if side has entities but none of them are alive.
+func (g *Game) sideDefeated(side Side) bool {
+  for _, ent := range g.Ents {
+    if ent.Side() == side && ent.Stats != nil && ent.Stats.HpCur() > 0 {
+      return false
+    }
+  }
+  return true
+}
+
+// Terminates every ai in the game.
+func (g *Game) terminateAis() {
+  ents := make(map[*Entity]bool)
+  for ent := range g.all_ents_in_memory {
+    ents[ent] = true
+  }
+  for _, ent := range g.Ents {
+    ents[ent] = true
+  }
+  for ent := range ents {
+    ent.Release()
+  }
+  for _, ai := range []Ai{g.Ai.minions, g.Ai.denizens, g.Ai.intruders} {
+    if ai != nil {
+      ai.Terminate()
+    }
+  }
+}
+
+// Plays a whole match without a window.  SetupHeadless() must be called
+// first.  The level script and the ais are stopped once the match is over.
+func PlayMatch(config MatchConfig) MatchResult {
+  result := MatchResult{Seed: config.Seed}
+  if !base.Headless() {
+    result.Error = "SetupHeadless() must be called before playing a match."
+    return result
+  }
+  rand.Seed(config.Seed)
+
+  var gp GamePanel
+  gp.match = &match{config: config}
+  data := make(map[string]string)
+  if config.Difficulty != "" {
+    data["difficulty"] = string(config.Difficulty)
+  }
+  startGameScript(&gp, config.Script, &Player{}, data, "")
+  if gp.script == nil {
+    result.Error = fmt.Sprintf("Unable to load script '%s'.", config.Script)
+    return result
+  }
+
+  t := matchTracker{
+    actions:  make(map[[2]string]*ActionDamage),
+    entities: make(map[EntityId]EntityOutcome),
+  }
+  start := time.Now()
+  for {
+    if config.Time_limit > 0 && time.Since(start) > config.Time_limit {
+      result.Error = fmt.Sprintf("Ran out of time after %v.", config.Time_limit)
+      break
+    }
+    gp.scriptThinkOnce()
+    if gp.match.over {
+      result.Winner = gp.match.winner
+      break
+    }
+    if !gp.Active() {
+      runtime.Gosched()
+      continue
+    }
+    g := gp.game
+    t.before(g)
+    g.Think(match_dt)
+    t.after(g)
+    result.Turns = g.Turn
+
+    // Nobody is spawned until their side's first turn is over.
+    if g.Turn > 2 {
+      if g.sideDefeated(SideExplorers) {
+        result.Winner = sideName(SideHaunt)
+        break
+      }
+      if g.sideDefeated(SideHaunt) {
+        result.Winner = sideName(SideExplorers)
+        break
+      }
+    }
+    if config.Max_turns > 0 && g.Turn > config.Max_turns {
+      break
+    }
+    runtime.Gosched()
+  }
+
+  if gp.game != nil {
+    for id, outcome := range t.entities {
+      ent := gp.game.EntityById(id)
+      outcome.Survived = ent != nil && ent.Stats.HpCur() > 0
+      result.Entities = append(result.Entities, outcome)
+    }
+    gp.game.terminateAis()
+  }
+  gp.script.stop()
+  for _, ad := range t.actions {
+    result.Actions = append(result.Actions, *ad)
+  }
+  return result
+}
+
+// Returns the choice that a match makes for the chooser at path, which is
+// relative to the data directory.
+func (m *match) choose(path string) ([]string, error) {
+  if choice, ok := m.config.Choices[path]; ok {
+    return []string{choice}, nil
+  }
+  var bops []OptionBasic
+  err := base.LoadAndProcessObject(filepath.Join(base.GetDataDir(), path), "json", &bops)
+  if err != nil {
+    return nil, err
+  }
+  if len(bops) == 0 {
+    return nil, errors.New(fmt.Sprintf("Chooser '%s' has no options.", path))
+  }
+  return []string{bops[0].Id}, nil
+}
+
+// Returns the path of the ai that a match binds to target instead of a
+// human, or "" if there isn't one.
+func (m *match) aiFor(target string) string {
+  switch target {
+  case "denizen":
+    return m.config.Denizens
+  case "intruder":
+    return m.config.Intruders
+  case "minions":
+    return m.config.Minions
+  }
+  return ""
+}
+
+// Places entities like a player would with an EntityPlacer, choosing randomly
+// from the roster until the points run out or there is nowhere left to put
+// anything.
+func (g *Game) autoPlaceEntities(names []string, costs []int, min, max int, pattern string) []*Entity {
+  re, err := regexp.Compile(pattern)
+  if err != nil {
+    base.Error().Printf("Failed to compile regexp: '%s': %v", pattern, err)
+    return nil
+  }
+  var ents []*Entity
+  points := max
+  full := make(map[string]bool)
+  for {
+    var choices []int
+    for i := range names {
+      if costs[i] > 0 && costs[i] <= points && !full[names[i]] {
+        choices = append(choices, i)
+      }
+    }
+    if len(choices) == 0 {
+      break
+    }
+    i := choices[g.Rand.Int63()%int64(len(choices))]
+    ent := g.autoPlaceEntity(names[i], re, pattern)
+    if ent == nil {
+      full[names[i]] = true
+      continue
+    }
+    points -= costs[i]
+    ents = append(ents, ent)
+  }
+  if max-points < min {
+    base.Warn().Printf("Only able to place %d of the %d points required in '%s'.", max-points, min, pattern)
+  }
+  return ents
+}
+
+// Places the named entity somewhere random in the spawn points matching re,
+// returns nil if there isn't room for it.
+func (g *Game) autoPlaceEntity(name string, re *regexp.Regexp, pattern string) *Entity {
+  var cells [][2]int
+  for _, spawn := range g.House.Floors[0].Spawns {
+    if !re.MatchString(spawn.Name) {
+      continue
+    }
+    sx, sy := spawn.Pos()
+    sdx, sdy := spawn.Dims()
+    for x := sx; x < sx+sdx; x++ {
+      for y := sy; y < sy+sdy; y++ {
+        if !g.IsCellOccupied(x, y) {
+          cells = append(cells, [2]int{x, y})
+        }
+      }
+    }
+  }
+  ent := MakeEntity(name, g)
+  if ent.Name == "" {
+    base.Error().Printf("Cannot make an entity named '%s', no such thing.", name)
+    g.viewer.RemoveDrawable(ent)
+    return nil
+  }
+  for len(cells) > 0 {
+    i := int(g.Rand.Int63() % int64(len(cells)))
+    ent.X, ent.Y = float64(cells[i][0]), float64(cells[i][1])
+    g.new_ent = ent
+    if g.placeEntity(pattern) {
+      return ent
+    }
+    cells[i] = cells[len(cells)-1]
+    cells = cells[0 : len(cells)-1]
+  }
+  g.new_ent = nil
+  g.viewer.RemoveDrawable(ent)
+  return nil
+}
+
+// Everything a designer needs to know about how a batch of matches went.
+type TournamentReport struct {
+  Script string
+  Games  int
+
+  // Matches that couldn't be finished, none of the other values include
+  // them.
+  Errors int
+
+  // Number of wins by "Denizens", "Intruders" and "Draw".
+  Wins map[string]int
+
+  // The fraction of finished matches that each of the values in Wins is.
+  Win_rates map[string]float64
+
+  Average_turns float64
+
+  Actions  []ActionSummary
+  Entities []EntitySummary
+  Matches  []MatchResult
+}
+
+type ActionSummary struct {
+  Entity         string
+  Action         string
+  Uses           int
+  Damage         int
+  Average_damage float64
+}
+
+type EntitySummary struct {
+  Name          string
+  Side          string
+  Fielded       int
+  Survived      int
+  Survival_rate float64
+}
+
+// Plays a number of matches, each seeded with the next seed after
+// config.Seed, and reports on how they went.
+func PlayTournament(config MatchConfig, games int) *TournamentReport {
+  var results []MatchResult
+  for i := 0; i < games; i++ {
+    mc := config
+    mc.Seed = config.Seed + int64(i)
+    base.Log().Printf("Tournament: starting match %d of %d, seed %d", i+1, games, mc.Seed)
+    results = append(results, PlayMatch(mc))
+  }
+  return MakeTournamentReport(config.Script, results)
+}
+
+func MakeTournamentReport(script string, results []MatchResult) *TournamentReport {
+  r := TournamentReport{
+    Script:    script,
+    Games:     len(results),
+    Wins:      map[string]int{"Denizens": 0, "Intruders": 0, "Draw": 0},
+    Win_rates: make(map[string]float64),
+    Matches:   results,
+  }
+  actions := make(map[[2]string]*ActionSummary)
+  entities := make(map[[2]string]*EntitySummary)
+  turns := 0
+  for _, result := range results {
+    if result.Error != "" {
+      r.Errors++
+      continue
+    }
+    if result.Winner == "" {
+      r.Wins["Draw"]++
+    } else {
+      r.Wins[result.Winner]++
+    }
+    turns += result.Turns
+    for _, ad := range result.Actions {
+      key := [2]string{ad.Entity, ad.Action}
+      as, ok := actions[key]
+      if !ok {
+        as = &ActionSummary{Entity: ad.Entity, Action: ad.Action}
+        actions[key] = as
+      }
+      as.Uses += ad.Uses
+      as.Damage += ad.Damage
+    }
+    for _, eo := range result.Entities {
+      key := [2]string{eo.Name, eo.Side}
+      es, ok := entities[key]
+      if !ok {
+        es = &EntitySummary{Name: eo.Name, Side: eo.Side}
+        entities[key] = es
+      }
+      es.Fielded++
+      if eo.Survived {
+        es.Survived++
+      }
+    }
+  }
+
+  finished := r.Games - r.Errors
+  if finished > 0 {
+    for k, v := range r.Wins {
+      r.Win_rates[k] = float64(v) / float64(finished)
+    }
+    r.Average_turns = float64(turns) / float64(finished)
+  }
+  for _, as := range actions {
+    as.Average_damage = float64(as.Damage) / float64(as.Uses)
+    r.Actions = append(r.Actions, *as)
+  }
+  sort.Sort(actionSummaries(r.Actions))
+  for _, es := range entities {
+    es.Survival_rate = float64(es.Survived) / float64(es.Fielded)
+    r.Entities = append(r.Entities, *es)
+  }
+  sort.Sort(entitySummaries(r.Entities))
+  return &r
+}
+
+type actionSummaries []ActionSummary
+
+func (a actionSummaries) Len() int      { return len(a) }
+func (a actionSummaries) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a actionSummaries) Less(i, j int) bool {
+  if a[i].Entity != a[j].Entity {
+    return a[i].Entity < a[j].Entity
+  }
+  return a[i].Action < a[j].Action
+}
+
+type entitySummaries []EntitySummary
+
+func (e entitySummaries) Len() int      { return len(e) }
+func (e entitySummaries) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e entitySummaries) Less(i, j int) bool {
+  if e[i].Side != e[j].Side {
+    return e[i].Side < e[j].Side
+  }
+  return e[i].Name < e[j].Name
+}
+
+func (r *TournamentReport) WriteJSON(w io.Writer) error {
+  data, err := json.MarshalIndent(r, "", "  ")
+  if err != nil {
+    return err
+  }
+  _, err = w.Write(data)
+  return err
+}
+
+// Writes the summary, actions and entities as three tables, each with its
+// own header and separated by blank lines.  Individual matches are only
+// included in the json.
+func (r *TournamentReport) WriteCSV(w io.Writer) error {
+  cw := csv.NewWriter(w)
+  f := func(v float64) string {
+    return fmt.Sprintf("%.3f", v)
+  }
+  d := func(v int) string {
+    return fmt.Sprintf("%d", v)
+  }
+  records := [][]string{
+    {"script", "games", "errors", "denizen_wins", "intruder_wins", "draws", "denizen_win_rate", "intruder_win_rate", "draw_rate", "average_turns"},
+    {r.Script, d(r.Games), d(r.Errors), d(r.Wins["Denizens"]), d(r.Wins["Intruders"]), d(r.Wins["Draw"]), f(r.Win_rates["Denizens"]), f(r.Win_rates["Intruders"]), f(r.Win_rates["Draw"]), f(r.Average_turns)},
+    {},
+    {"entity", "action", "uses", "damage", "average_damage"},
+  }
+  for _, as := range r.Actions {
+    records = append(records, []string{as.Entity, as.Action, d(as.Uses), d(as.Damage), f(as.Average_damage)})
+  }
+  records = append(records, []string{}, []string{"entity", "side", "fielded", "survived", "survival_rate"})
+  for _, es := range r.Entities {
+    records = append(records, []string{es.Name, es.Side, d(es.Fielded), d(es.Survived), f(es.Survival_rate)})
+  }
+  for _, record := range records {
+    if err := cw.Write(record); err != nil {
+      return err
+    }
+  }
+  cw.Flush()
+  return cw.Error()
+}
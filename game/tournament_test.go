@@ -0,0 +1,83 @@
+package game_test
+
+import (
+  "bytes"
+  "github.com/mik3cap/haunts/game"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "strings"
+  "time"
+)
+
+func TournamentSpec(c gospec.Context) {
+  results := []game.MatchResult{
+    {
+      Seed:    1,
+      Winner:  "Denizens",
+      Turns:   10,
+      Actions: []game.ActionDamage{{Entity: "Bosch", Action: "Claw", Uses: 2, Damage: 6}},
+      Entities: []game.EntityOutcome{
+        {Name: "Bosch", Side: "Denizens", Survived: true},
+        {Name: "Teen", Side: "Intruders", Survived: false},
+      },
+    },
+    {
+      Seed:    2,
+      Winner:  "Intruders",
+      Turns:   20,
+      Actions: []game.ActionDamage{{Entity: "Bosch", Action: "Claw", Uses: 1, Damage: 0}},
+      Entities: []game.EntityOutcome{
+        {Name: "Bosch", Side: "Denizens", Survived: false},
+        {Name: "Teen", Side: "Intruders", Survived: true},
+      },
+    },
+    {Seed: 3, Turns: 60},
+    {Seed: 4, Error: "Ran out of time after 10m0s."},
+  }
+  r := game.MakeTournamentReport("versus/Lvl02.lua", results)
+
+  c.Specify("Matches with errors are left out of the rates.", func() {
+    c.Expect(r.Games, Equals, 4)
+    c.Expect(r.Errors, Equals, 1)
+    c.Expect(r.Wins["Denizens"], Equals, 1)
+    c.Expect(r.Wins["Intruders"], Equals, 1)
+    c.Expect(r.Wins["Draw"], Equals, 1)
+    c.Expect(r.Average_turns, IsWithin(1e-9), 30.0)
+    c.Expect(r.Win_rates["Draw"], IsWithin(1e-9), 1.0/3)
+  })
+
+  c.Specify("Damage and survival are totalled across matches.", func() {
+    c.Assume(len(r.Actions), Equals, 1)
+    c.Expect(r.Actions[0].Uses, Equals, 3)
+    c.Expect(r.Actions[0].Damage, Equals, 6)
+    c.Expect(r.Actions[0].Average_damage, IsWithin(1e-9), 2.0)
+    c.Assume(len(r.Entities), Equals, 2)
+    c.Expect(r.Entities[0].Name, Equals, "Bosch")
+    c.Expect(r.Entities[0].Fielded, Equals, 2)
+    c.Expect(r.Entities[0].Survival_rate, IsWithin(1e-9), 0.5)
+  })
+
+  c.Specify("Reports can be written as csv.", func() {
+    var buf bytes.Buffer
+    c.Expect(r.WriteCSV(&buf), Equals, nil)
+    lines := strings.Split(buf.String(), "\n")
+    c.Expect(lines[1], Equals, "versus/Lvl02.lua,4,1,1,1,1,0.333,0.333,0.333,30.000")
+    c.Expect(strings.Contains(buf.String(), "Bosch,Claw,3,6,2.000"), Equals, true)
+    c.Expect(strings.Contains(buf.String(), "Teen,Intruders,2,1,0.500"), Equals, true)
+  })
+
+  c.Specify("Stopping a script doesn't wait on the game.", func() {
+    stop := game.StartWaitingScript()
+    done := make(chan bool, 1)
+    go func() {
+      stop()
+      done <- true
+    }()
+    stopped := false
+    select {
+    case stopped = <-done:
+    case <-time.After(5 * time.Second):
+    }
+    c.Expect(stopped, Equals, true)
+  })
+}
@@ -0,0 +1,99 @@
+package game
+
+import (
+  "github.com/mik3cap/haunts/game/status"
+)
+
+// Sends e to ent's conditions and carries out their Reactions.  other is
+// the other entity involved in e, the attacker or the target, and may be
+// nil.  See status.TriggeredCondition.
+func (g *Game) TriggerConditions(ent, other *Entity, e status.Event) {
+  if ent == nil || ent.Stats == nil {
+    return
+  }
+  for _, r := range ent.Stats.Trigger(e) {
+    kind := r.Kind
+    if kind == "" {
+      kind = status.Unspecified
+    }
+    var none status.Dynamic
+    if r.Self != none {
+      g.reactionDamage(ent, r.Self, kind)
+    }
+    if other != nil && other.Stats != nil {
+      if r.Other != none {
+        g.reactionDamage(other, r.Other, kind)
+      }
+      for _, name := range r.Conditions {
+        other.Stats.ApplyCondition(status.MakeCondition(name))
+      }
+    }
+    if r.Area != none && r.Radius > 0 {
+      x, y := ent.Pos()
+      for _, target := range g.Ents {
+        if target == ent || target.Stats == nil || target.Stats.HpCur() <= 0 {
+          continue
+        }
+        tx, ty := target.Pos()
+        if Chebyshev(x, y, tx, ty) <= r.Radius {
+          g.reactionDamage(target, r.Area, kind)
+        }
+      }
+    }
+  }
+}
+
+// Sends the events for an attack by attacker on defender.  hp is the
+// defender's Hp before the attack.
+func (g *Game) TriggerAttack(attacker, defender *Entity, hit bool, dmg status.Damage, hp int) {
+  killed := hp > 0 && defender.Stats.HpCur() <= 0
+  g.TriggerConditions(defender, attacker, status.Event{
+    Trigger: status.TriggerAttacked,
+    Hit:     hit,
+    Damage:  dmg,
+  })
+  if hit {
+    g.TriggerConditions(attacker, defender, status.Event{
+      Trigger: status.TriggerDealtDamage,
+      Hit:     true,
+      Damage:  dmg,
+      Killed:  killed,
+    })
+  }
+  if killed {
+    g.TriggerConditions(defender, nil, status.Event{Trigger: status.TriggerKilled})
+  }
+}
+
+// Applies damage from a Reaction to ent.  If that kills ent it dies the same
+// way it would to an attack, it plays its killed animation and its own
+// conditions get TriggerKilled.  Entities that are already dead are left
+// alone, so a Reaction can't bring one back to be killed again.
+func (g *Game) reactionDamage(ent *Entity, dmg status.Dynamic, kind status.Kind) {
+  hp := ent.Stats.HpCur()
+  if hp <= 0 {
+    return
+  }
+  ent.Stats.ApplyDamage(dmg.Ap, dmg.Hp, kind)
+  if ent.Stats.HpCur() <= 0 {
+    ent.Sprite().CommandN([]string{"defend", "killed"})
+    g.TriggerConditions(ent, nil, status.Event{Trigger: status.TriggerKilled})
+  }
+}
+
+// Returns the distance between two cells when moving diagonally costs the
+// same as moving straight.
+func Chebyshev(x1, y1, x2, y2 int) int {
+  dx := x1 - x2
+  if dx < 0 {
+    dx = -dx
+  }
+  dy := y1 - y2
+  if dy < 0 {
+    dy = -dy
+  }
+  if dx > dy {
+    return dx
+  }
+  return dy
+}
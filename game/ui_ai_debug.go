@@ -0,0 +1,310 @@
+package game
+
+import (
+  "fmt"
+  "github.com/mik3cap/glop/gin"
+  "github.com/mik3cap/glop/gui"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/opengl/gl"
+  "strings"
+)
+
+type aiDebugView int
+
+const (
+  aiDebugErrors aiDebugView = iota
+  aiDebugBreak
+  aiDebugTrace
+  numAiDebugViews
+)
+
+// Shows what the ais are up to.  Works like the console: the 'ai debug' key
+// toggles it, and tab switches between its views:
+//   Errors - errors that ais have run into, newest first, along with the
+//            traceback of the selected one.  Delete clears them.
+//   Break  - where an ai is stopped, its locals and its entity.  b turns
+//            the debugger on and off, c continues, s steps, n steps over,
+//            o steps out and x toggles a breakpoint on the current line.
+//   Trace  - every Do* call made by one entity, left and right choose the
+//            entity, d dumps the traces of every entity to a file and t
+//            dumps the entity's last turn so that it can be replayed.  Turns
+//            are only recorded while the debugger is on, or if the game was
+//            started with -ai-record.
+// Whenever an ai stops the panel takes focus and switches to Break.
+type AiDebugPanel struct {
+  gui.BasicZone
+  view     aiDebugView
+  errs     []AiError
+  selected int
+  entity   int
+  message  string
+  dict     *gui.Dictionary
+}
+
+func MakeAiDebugPanel() *AiDebugPanel {
+  var p AiDebugPanel
+  p.BasicZone.Ex = true
+  p.BasicZone.Ey = true
+  p.BasicZone.Request_dims = gui.Dims{1000, 1000}
+  p.dict = base.GetDictionary(12)
+  return &p
+}
+
+func (p *AiDebugPanel) String() string {
+  return "ai debug panel"
+}
+
+func (p *AiDebugPanel) Think(ui *gui.Gui, dt int64) {
+  errs := AiErrors()
+  // Reverse so that the newest error is first, and keep the same error
+  // selected as new ones come in.
+  for i, j := 0, len(errs)-1; i < j; i, j = i+1, j-1 {
+    errs[i], errs[j] = errs[j], errs[i]
+  }
+  if len(p.errs) > 0 && len(errs) > len(p.errs) {
+    p.selected += len(errs) - len(p.errs)
+  }
+  p.errs = errs
+  if p.selected >= len(p.errs) {
+    p.selected = len(p.errs) - 1
+  }
+  if p.selected < 0 {
+    p.selected = 0
+  }
+
+  if PausedAi() != nil && ui.FocusWidget() != p {
+    ui.TakeFocus(p)
+    p.view = aiDebugBreak
+  }
+}
+
+func (p *AiDebugPanel) Respond(ui *gui.Gui, group gui.EventGroup) bool {
+  pressed := func(k gin.KeyId) bool {
+    found, event := group.FindEvent(k)
+    return found && event.Type == gin.Press
+  }
+  if pressed(base.GetDefaultKeyMap()["ai debug"].Id()) {
+    if group.Focus {
+      ui.DropFocus()
+    } else {
+      ui.TakeFocus(p)
+    }
+    return true
+  }
+  if !group.Focus {
+    return false
+  }
+  if pressed(gin.Tab) {
+    p.view = (p.view + 1) % numAiDebugViews
+    p.message = ""
+  }
+  switch p.view {
+  case aiDebugErrors:
+    if pressed(gin.Up) {
+      p.selected--
+    }
+    if pressed(gin.Down) {
+      p.selected++
+    }
+    if pressed(gin.DeleteOrBackspace) {
+      ClearAiErrors()
+      p.selected = 0
+    }
+
+  case aiDebugBreak:
+    if pressed(gin.KeyId('b')) {
+      SetAiDebugging(!AiDebugging())
+    }
+    if b := PausedAi(); b != nil {
+      if pressed(gin.KeyId('x')) {
+        on := true
+        for _, line := range AiBreakpoints()[b.File] {
+          if line == b.Line {
+            on = false
+          }
+        }
+        SetAiBreakpoint(b.File, b.Line, on)
+      }
+      for key, cmd := range map[byte]AiDebugCommand{
+        'c': AiContinue,
+        's': AiStep,
+        'n': AiStepOver,
+        'o': AiStepOut,
+      } {
+        if pressed(gin.KeyId(key)) {
+          ResumeAi(cmd)
+          break
+        }
+      }
+    }
+
+  case aiDebugTrace:
+    if pressed(gin.Left) {
+      p.entity--
+    }
+    if pressed(gin.Right) {
+      p.entity++
+    }
+    if pressed(gin.KeyId('d')) {
+      path, err := DumpAiTraces()
+      if err != nil {
+        p.message = err.Error()
+      } else {
+        p.message = fmt.Sprintf("Wrote %s", path)
+      }
+    }
+    if names := AiTracedEntities(); pressed(gin.KeyId('t')) && p.entity >= 0 && p.entity < len(names) {
+      path, err := DumpAiTurn(names[p.entity])
+      if err != nil {
+        p.message = err.Error()
+      } else {
+        p.message = fmt.Sprintf("Wrote %s, replay it with -ai-replay", path)
+      }
+    }
+  }
+  return true
+}
+
+func (p *AiDebugPanel) Draw(region gui.Region) {
+}
+
+// Draws lines of text from the top of the panel down, stopping when it runs
+// out of room.
+type aiDebugLines struct {
+  dict *gui.Dictionary
+  x, y float64
+  min  float64
+}
+
+func (l *aiDebugLines) line(indent int, format string, args ...interface{}) {
+  l.y -= l.dict.MaxHeight()
+  if l.y < l.min {
+    return
+  }
+  str := strings.Replace(fmt.Sprintf(format, args...), "\t", "    ", -1)
+  l.dict.RenderString(str, l.x+float64(20*indent), l.y, 0, l.dict.MaxHeight(), gui.Left)
+}
+
+func (p *AiDebugPanel) DrawFocused(region gui.Region) {
+  gl.Color4d(0.1, 0.1, 0.1, 0.85)
+  gl.Disable(gl.TEXTURE_2D)
+  gl.Begin(gl.QUADS)
+  gl.Vertex2i(region.X, region.Y)
+  gl.Vertex2i(region.X, region.Y+region.Dy)
+  gl.Vertex2i(region.X+region.Dx, region.Y+region.Dy)
+  gl.Vertex2i(region.X+region.Dx, region.Y)
+  gl.End()
+
+  l := aiDebugLines{
+    dict: p.dict,
+    x:    float64(region.X + 10),
+    y:    float64(region.Y + region.Dy),
+    min:  float64(region.Y),
+  }
+  gl.Color4d(1, 1, 1, 1)
+  names := []string{"Errors", "Break", "Trace"}
+  var tabs []string
+  for i, name := range names {
+    if aiDebugView(i) == p.view {
+      name = "[" + name + "]"
+    }
+    tabs = append(tabs, name)
+  }
+  debugging := "off"
+  if AiDebugging() {
+    debugging = "on"
+  }
+  l.line(0, "%s    (tab to switch, debugger is %s)", strings.Join(tabs, " "), debugging)
+  if p.message != "" {
+    l.line(0, "%s", p.message)
+  }
+  l.line(0, "")
+
+  switch p.view {
+  case aiDebugErrors:
+    p.drawErrors(&l)
+  case aiDebugBreak:
+    p.drawBreak(&l)
+  case aiDebugTrace:
+    p.drawTrace(&l)
+  }
+}
+
+func (p *AiDebugPanel) drawErrors(l *aiDebugLines) {
+  if len(p.errs) == 0 {
+    l.line(0, "No ai errors.")
+    return
+  }
+  l.line(0, "%d ai errors (up/down to select, delete to clear)", len(p.errs))
+  for i := range p.errs {
+    err := &p.errs[i]
+    if i == p.selected {
+      gl.Color4d(1, 0.3, 0.3, 1)
+    } else {
+      gl.Color4d(0.7, 0.2, 0.2, 1)
+    }
+    l.line(0, "%s %v", err.Time.Format("15:04:05"), err)
+    if i != p.selected || err.Traceback == "" {
+      continue
+    }
+    gl.Color4d(1, 1, 1, 1)
+    for _, line := range strings.Split(err.Traceback, "\n") {
+      l.line(1, "%s", line)
+    }
+  }
+}
+
+func (p *AiDebugPanel) drawBreak(l *aiDebugLines) {
+  b := PausedAi()
+  if b == nil {
+    if AiDebugging() {
+      l.line(0, "No ai is stopped.")
+    } else {
+      l.line(0, "The debugger is off, press b to turn it on.")
+    }
+    bps := AiBreakpoints()
+    if len(bps) > 0 {
+      l.line(0, "Breakpoints:")
+      for file, lines := range bps {
+        l.line(1, "%s: %v", file, lines)
+      }
+    }
+    return
+  }
+  gl.Color4d(1, 1, 0.3, 1)
+  who := b.Path
+  if b.Entity != "" {
+    who = b.Entity
+  }
+  l.line(0, "%s stopped in %s at %s:%d", who, b.Function, b.File, b.Line)
+  l.line(0, "c: continue  s: step  n: step over  o: step out  x: toggle breakpoint")
+  gl.Color4d(1, 1, 1, 1)
+  l.line(0, "Locals:")
+  for _, v := range b.Locals {
+    l.line(1, "%s = %s", v.Name, v.Value)
+  }
+  if len(b.Context) > 0 {
+    l.line(0, "Me:")
+    for _, v := range b.Context {
+      l.line(1, "%s = %s", v.Name, v.Value)
+    }
+  }
+}
+
+func (p *AiDebugPanel) drawTrace(l *aiDebugLines) {
+  names := AiTracedEntities()
+  if len(names) == 0 {
+    l.line(0, "No ais have done anything yet.")
+    return
+  }
+  if p.entity < 0 {
+    p.entity = len(names) - 1
+  }
+  p.entity = p.entity % len(names)
+  l.line(0, "%s (%d of %d, left/right to choose, d to dump all to a file, t to dump its last turn)", names[p.entity], p.entity+1, len(names))
+  trace := AiTrace(names[p.entity])
+  // Newest first, since that's what is most likely to be interesting.
+  for i := len(trace) - 1; i >= 0; i-- {
+    l.line(1, "%v", trace[i])
+  }
+}
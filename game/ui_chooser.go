@@ -207,11 +207,35 @@ type Chooser struct {
 }
 
 func InsertMapChooser(ui gui.WidgetParent, chosen func(string), resert func(ui gui.WidgetParent) error) error {
+  return insertChooserFromFile(ui, "MapChooser", filepath.Join("ui", "start", "versus", "map_select.json"), chosen, resert)
+}
+
+// Lets the player pick how hard the ais will be, the options are in
+// ui/start/versus/difficulty.json and their ids are the difficulties.
+func InsertDifficultyChooser(ui gui.WidgetParent, chosen func(Difficulty), resert func(ui gui.WidgetParent) error) error {
+  return insertChooserFromFile(
+    ui,
+    "DifficultyChooser",
+    filepath.Join("ui", "start", "versus", "difficulty.json"),
+    func(id string) {
+      d, err := ParseDifficulty(id)
+      if err != nil {
+        base.Warn().Printf("%v", err)
+      }
+      chosen(d)
+    },
+    resert)
+}
+
+// Inserts a chooser for picking exactly one of the OptionBasics listed in
+// path, which is relative to the data directory.  name is only used for
+// reporting errors.
+func insertChooserFromFile(ui gui.WidgetParent, name, path string, chosen func(string), resert func(ui gui.WidgetParent) error) error {
   var bops []OptionBasic
   datadir := base.GetDataDir()
-  err := base.LoadAndProcessObject(filepath.Join(datadir, "ui", "start", "versus", "map_select.json"), "json", &bops)
+  err := base.LoadAndProcessObject(filepath.Join(datadir, path), "json", &bops)
   if err != nil {
-    base.Error().Printf("Unable to insert MapChooser: %v", err)
+    base.Error().Printf("Unable to insert %s: %v", name, err)
     return err
   }
   var opts []Option
@@ -223,7 +247,7 @@ func InsertMapChooser(ui gui.WidgetParent, chosen func(string), resert func(ui g
   var ch Chooser
   err = base.LoadAndProcessObject(filepath.Join(datadir, "ui", "chooser", "layout.json"), "json", &ch.layout)
   if err != nil {
-    base.Error().Printf("Unable to insert MapChooser: %v", err)
+    base.Error().Printf("Unable to insert %s: %v", name, err)
     return err
   }
   ch.options = opts
@@ -0,0 +1,116 @@
+package game
+
+import (
+  "fmt"
+  "github.com/mik3cap/glop/gin"
+  "github.com/mik3cap/glop/gui"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/opengl/gl"
+)
+
+// Shows the game's combat log, newest attack first, with the numbers that
+// decided whether each attack hit.  The 'combat log' key shows and hides it
+// and the mouse wheel scrolls it.
+type CombatLogPanel struct {
+  region gui.Region
+  game   *Game
+  dict   *gui.Dictionary
+  scroll ScrollingRegion
+  shown  bool
+  mx, my int
+
+  // CombatLog.Added() the last time the panel thought, so that it can go
+  // back to the newest attack whenever there is a new one.
+  added int
+}
+
+func MakeCombatLogPanel(g *Game) *CombatLogPanel {
+  var p CombatLogPanel
+  p.game = g
+  p.dict = base.GetDictionary(12)
+  return &p
+}
+
+func (p *CombatLogPanel) Requested() gui.Dims {
+  return gui.Dims{600, 200}
+}
+func (p *CombatLogPanel) Expandable() (bool, bool) {
+  return false, false
+}
+func (p *CombatLogPanel) Rendered() gui.Region {
+  return p.region
+}
+func (p *CombatLogPanel) Respond(ui *gui.Gui, group gui.EventGroup) bool {
+  cursor := group.Events[0].Key.Cursor()
+  if cursor != nil {
+    p.mx, p.my = cursor.Point()
+  }
+  if found, event := group.FindEvent(base.GetDefaultKeyMap()["combat log"].Id()); found && event.Type == gin.Press {
+    p.shown = !p.shown
+    return true
+  }
+  if !p.shown {
+    return false
+  }
+  r := p.region
+  if p.mx < r.X || p.my < r.Y || p.mx >= r.X+r.Dx || p.my >= r.Y+r.Dy {
+    return false
+  }
+  if found, event := group.FindEvent(gin.MouseWheelVertical); found {
+    p.scroll.target -= event.Key.FramePressAmt() * p.dict.MaxHeight()
+    return true
+  }
+  return false
+}
+func (p *CombatLogPanel) Think(ui *gui.Gui, dt int64) {
+  if n := p.game.CombatLog().Added(); n != p.added {
+    p.added = n
+    p.scroll.target = 0
+  }
+  p.scroll.Think(dt)
+}
+func (p *CombatLogPanel) Draw(region gui.Region) {
+  p.region = region
+  if !p.shown {
+    return
+  }
+  gl.Disable(gl.TEXTURE_2D)
+  gl.Color4d(0.1, 0.1, 0.1, 0.85)
+  gl.Begin(gl.QUADS)
+  gl.Vertex2i(region.X, region.Y)
+  gl.Vertex2i(region.X, region.Y+region.Dy)
+  gl.Vertex2i(region.X+region.Dx, region.Y+region.Dy)
+  gl.Vertex2i(region.X+region.Dx, region.Y)
+  gl.End()
+
+  entries := p.game.CombatLog().Last(0)
+  p.scroll.X = region.X + 10
+  p.scroll.Y = region.Y
+  p.scroll.Dx = region.Dx - 20
+  p.scroll.Dy = region.Dy
+  p.scroll.Height = len(entries) * int(p.dict.MaxHeight())
+  sy := p.scroll.Top()
+  p.scroll.Region().PushClipPlanes()
+  if len(entries) == 0 {
+    gl.Color4d(1, 1, 1, 1)
+    sy -= int(p.dict.MaxHeight())
+    p.dict.RenderString("No attacks yet.", float64(p.scroll.X), float64(sy), 0, p.dict.MaxHeight(), gui.Left)
+  }
+  for i := len(entries) - 1; i >= 0; i-- {
+    e := &entries[i]
+    if e.Hit {
+      gl.Color4d(1, 0.5, 0.5, 1)
+    } else {
+      gl.Color4d(0.7, 0.7, 0.7, 1)
+    }
+    sy -= int(p.dict.MaxHeight())
+    str := fmt.Sprintf("Round %d: %v", e.Round, e)
+    p.dict.RenderString(str, float64(p.scroll.X), float64(sy), 0, p.dict.MaxHeight(), gui.Left)
+  }
+  p.scroll.Region().PopClipPlanes()
+}
+func (p *CombatLogPanel) DrawFocused(region gui.Region) {
+}
+func (p *CombatLogPanel) String() string {
+  return "combat log panel"
+}
@@ -0,0 +1,318 @@
+package game
+
+import (
+  "fmt"
+  "github.com/mik3cap/glop/gin"
+  "github.com/mik3cap/glop/gui"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/texture"
+  "github.com/mik3cap/opengl/gl"
+  "path/filepath"
+)
+
+type loadLayout struct {
+  Background texture.Object
+  Back       Button
+  Up, Down   Button
+  Scroll     ScrollingRegion
+
+  // Each of these sorts the list of slots, see SortSaveSlots().
+  Sort struct {
+    Time, Name, Player, Turn Button
+  }
+
+  // Region where the thumbnail and details of the hovered slot are drawn.
+  Info struct {
+    X, Y, Dx, Dy int
+    Size         int
+  }
+
+  Text struct {
+    Size int
+  }
+
+  Error struct {
+    X, Y int
+    Size int
+    err  string
+  }
+}
+
+type slotField struct {
+  load, delete *Button
+  slot         *SaveSlot
+}
+
+type LoadMenu struct {
+  layout  loadLayout
+  region  gui.Region
+  buttons []ButtonLike
+  mx, my  int
+  last_t  int64
+
+  slots   []slotField
+  sort_by string
+
+  // The slot whose details are shown, this stays set after the mouse leaves
+  // the slot so that the details don't flicker while moving to its buttons.
+  shown *SaveSlot
+
+  ui gui.WidgetParent
+}
+
+func InsertLoadMenu(ui gui.WidgetParent) error {
+  var lm LoadMenu
+  datadir := base.GetDataDir()
+  err := base.LoadAndProcessObject(filepath.Join(datadir, "ui", "start", "load", "layout.json"), "json", &lm.layout)
+  if err != nil {
+    return err
+  }
+  lm.ui = ui
+  lm.buttons = []ButtonLike{
+    &lm.layout.Back,
+    &lm.layout.Up,
+    &lm.layout.Down,
+    &lm.layout.Sort.Time,
+    &lm.layout.Sort.Name,
+    &lm.layout.Sort.Player,
+    &lm.layout.Sort.Turn,
+  }
+  lm.layout.Back.f = func(interface{}) {
+    ui.RemoveChild(&lm)
+    InsertStartMenu(ui)
+  }
+  lm.layout.Up.f = func(interface{}) {
+    lm.layout.Scroll.Up()
+  }
+  lm.layout.Up.valid_func = func() bool {
+    return lm.layout.Scroll.Height > lm.layout.Scroll.Dy
+  }
+  lm.layout.Down.f = func(interface{}) {
+    lm.layout.Scroll.Down()
+  }
+  lm.layout.Down.valid_func = func() bool {
+    return lm.layout.Scroll.Height > lm.layout.Scroll.Dy
+  }
+  sorts := map[*Button]string{
+    &lm.layout.Sort.Time:   SortSlotsByTime,
+    &lm.layout.Sort.Name:   SortSlotsByName,
+    &lm.layout.Sort.Player: SortSlotsByPlayer,
+    &lm.layout.Sort.Turn:   SortSlotsByTurn,
+  }
+  for _b, _by := range sorts {
+    b, by := _b, _by
+    b.f = func(interface{}) {
+      lm.sort_by = by
+      lm.refresh()
+    }
+    b.valid_func = func() bool {
+      return lm.sort_by != by
+    }
+  }
+  lm.sort_by = SortSlotsByTime
+  lm.refresh()
+  ui.AddChild(&lm)
+  return nil
+}
+
+// Re-reads all of the save slots from disk and rebuilds the list.
+func (lm *LoadMenu) refresh() {
+  slots := GetAllSaveSlots()
+  SortSaveSlots(slots, lm.sort_by)
+  lm.slots = lm.slots[0:0]
+  lm.shown = nil
+  for _, _slot := range slots {
+    slot := _slot
+    var field slotField
+    field.slot = slot
+    field.load = &Button{}
+    field.load.Text.String = "Load!"
+    field.load.Text.Justification = "left"
+    field.load.Text.Size = lm.layout.Text.Size
+    field.load.f = func(interface{}) {
+      p, err := LoadSaveSlot(slot)
+      if err != nil {
+        lm.layout.Error.err = err.Error()
+        base.Error().Printf("Unable to load slot '%s': %v", slot.Name, err)
+        return
+      }
+      lm.ui.RemoveChild(lm)
+      lm.ui.AddChild(MakeGamePanel("", p, nil, ""))
+    }
+    field.delete = &Button{}
+    field.delete.Text.String = "Delete!"
+    field.delete.Text.Justification = "right"
+    field.delete.Text.Size = lm.layout.Text.Size
+    field.delete.f = func(interface{}) {
+      err := DeleteSaveSlot(slot)
+      if err != nil {
+        lm.layout.Error.err = err.Error()
+        base.Error().Printf("Unable to delete slot '%s': %v", slot.Name, err)
+      }
+      lm.refresh()
+    }
+    lm.slots = append(lm.slots, field)
+  }
+  lm.layout.Scroll.Height = int(base.GetDictionary(lm.layout.Text.Size).MaxHeight() * float64(len(lm.slots)))
+}
+
+func (lm *LoadMenu) Requested() gui.Dims {
+  return gui.Dims{1024, 768}
+}
+
+func (lm *LoadMenu) Expandable() (bool, bool) {
+  return false, false
+}
+
+func (lm *LoadMenu) Rendered() gui.Region {
+  return lm.region
+}
+
+func (lm *LoadMenu) Think(g *gui.Gui, t int64) {
+  if lm.last_t == 0 {
+    lm.last_t = t
+    return
+  }
+  dt := t - lm.last_t
+  lm.last_t = t
+  if lm.mx == 0 && lm.my == 0 {
+    lm.mx, lm.my = gin.In().GetCursor("Mouse").Point()
+  }
+
+  inside := gui.Point{lm.mx, lm.my}.Inside(lm.layout.Scroll.Region())
+  row_dy := int(base.GetDictionary(lm.layout.Text.Size).MaxHeight())
+  for i := range lm.slots {
+    field := &lm.slots[i]
+    mx, my := 0, 0
+    if inside {
+      mx, my = lm.mx, lm.my
+      var region gui.Region
+      region.X = lm.layout.Scroll.X
+      region.Y = field.load.bounds.y
+      region.Dx = lm.layout.Scroll.Dx
+      region.Dy = row_dy
+      if (gui.Point{lm.mx, lm.my}.Inside(region)) {
+        lm.shown = field.slot
+      }
+    }
+    field.load.Think(lm.region.X, lm.region.Y, mx, my, dt)
+    field.delete.Think(lm.region.X, lm.region.Y, mx, my, dt)
+  }
+  lm.layout.Scroll.Think(dt)
+
+  for _, button := range lm.buttons {
+    button.Think(lm.region.X, lm.region.Y, lm.mx, lm.my, dt)
+  }
+}
+
+func (lm *LoadMenu) Respond(g *gui.Gui, group gui.EventGroup) bool {
+  cursor := group.Events[0].Key.Cursor()
+  if cursor != nil {
+    lm.mx, lm.my = cursor.Point()
+  }
+  if found, event := group.FindEvent(gin.MouseLButton); found && event.Type == gin.Press {
+    for _, button := range lm.buttons {
+      if button.handleClick(lm.mx, lm.my, nil) {
+        return true
+      }
+    }
+    if (gui.Point{lm.mx, lm.my}.Inside(lm.layout.Scroll.Region())) {
+      // Both of these can change lm.slots, so stop as soon as one is hit.
+      for _, field := range lm.slots {
+        if field.load.handleClick(lm.mx, lm.my, nil) {
+          return true
+        }
+        if field.delete.handleClick(lm.mx, lm.my, nil) {
+          return true
+        }
+      }
+    }
+  }
+
+  hit := false
+  for _, button := range lm.buttons {
+    if button.Respond(group, nil) {
+      hit = true
+    }
+  }
+  return hit
+}
+
+func (lm *LoadMenu) Draw(region gui.Region) {
+  lm.region = region
+  gl.Color4ub(255, 255, 255, 255)
+  lm.layout.Background.Data().RenderNatural(region.X, region.Y)
+  for _, button := range lm.buttons {
+    button.RenderAt(lm.region.X, lm.region.Y)
+  }
+
+  d := base.GetDictionary(lm.layout.Text.Size)
+  scroll := lm.layout.Scroll
+  sx := scroll.X
+  sy := scroll.Top()
+  scroll.Region().PushClipPlanes()
+  for _, field := range lm.slots {
+    sy -= int(d.MaxHeight())
+    field.load.RenderAt(sx, sy)
+    gl.Disable(gl.TEXTURE_2D)
+    if field.slot == lm.shown {
+      gl.Color4ub(255, 255, 0, 255)
+    } else {
+      gl.Color4ub(255, 255, 255, 255)
+    }
+    d.RenderString(field.slot.Name, float64(sx+50), float64(sy), 0, d.MaxHeight(), gui.Left)
+    gl.Color4ub(255, 255, 255, 255)
+    d.RenderString(field.slot.Time.Format("Jan 2 15:04"), float64(sx+scroll.Dx-100), float64(sy), 0, d.MaxHeight(), gui.Right)
+    field.delete.RenderAt(sx+scroll.Dx, sy)
+  }
+  scroll.Region().PopClipPlanes()
+
+  if lm.shown != nil {
+    lm.drawInfo(lm.shown)
+  }
+
+  if lm.layout.Error.err != "" {
+    gl.Color4ub(255, 0, 0, 255)
+    l := lm.layout.Error
+    d := base.GetDictionary(l.Size)
+    d.RenderString(fmt.Sprintf("ERROR: %s", l.err), float64(l.X), float64(l.Y), 0, d.MaxHeight(), gui.Left)
+  }
+}
+
+// Draws the thumbnail of slot at the top of the info region and its details
+// below that.
+func (lm *LoadMenu) drawInfo(slot *SaveSlot) {
+  info := lm.layout.Info
+  y := float64(info.Y + info.Dy)
+  if path := slot.ThumbnailPath(); path != "" {
+    thumb := texture.LoadFromPath(path)
+    if thumb.Dx() > 0 {
+      dy := info.Dx * thumb.Dy() / thumb.Dx()
+      y -= float64(dy)
+      gl.Enable(gl.TEXTURE_2D)
+      gl.Color4ub(255, 255, 255, 255)
+      thumb.Render(float64(info.X), y, float64(info.Dx), float64(dy))
+    }
+  }
+  gl.Disable(gl.TEXTURE_2D)
+  gl.Color4ub(255, 255, 255, 255)
+  d := base.GetDictionary(info.Size)
+  x := float64(info.X + info.Dx/2)
+  for _, line := range []string{
+    slot.Name,
+    fmt.Sprintf("Player: %s", slot.Player),
+    slot.Time.Format("Mon Jan 2 15:04 2006"),
+    slot.Script,
+    fmt.Sprintf("Round %d, %s' turn", slot.Round(), slot.SideName()),
+  } {
+    y -= d.MaxHeight()
+    d.RenderString(line, x, y, 0, d.MaxHeight(), gui.Center)
+  }
+}
+
+func (lm *LoadMenu) DrawFocused(region gui.Region) {
+}
+
+func (lm *LoadMenu) String() string {
+  return "load menu"
+}
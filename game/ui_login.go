@@ -0,0 +1,231 @@
+package game
+
+import (
+  "fmt"
+  "github.com/mik3cap/glop/gin"
+  "github.com/mik3cap/glop/gui"
+  "github.com/mik3cap/haunts/base"
+  "github.com/mik3cap/haunts/mrgnet"
+  "github.com/mik3cap/haunts/texture"
+  "github.com/mik3cap/opengl/gl"
+  "path/filepath"
+)
+
+type loginLayout struct {
+  Background texture.Object
+  Back       Button
+
+  Name   TextEntry
+  Secret TextEntry
+
+  Login    Button
+  Register Button
+
+  Error struct {
+    X, Y int
+    Size int
+    err  string
+  }
+}
+
+// Lets the player log in to, or register, an account before they can play
+// online.
+type LoginMenu struct {
+  layout  loginLayout
+  region  gui.Region
+  buttons []ButtonLike
+  mx, my  int
+  last_t  int64
+
+  control struct {
+    in  chan struct{}
+    out chan struct{}
+  }
+
+  ui gui.WidgetParent
+
+  // True while waiting on the server.
+  busy bool
+}
+
+// Returns true if the player has a session token, meaning that they can go
+// straight to the online menu.
+func loggedIn() bool {
+  var id mrgnet.NetId
+  fmt.Sscanf(base.GetStoreVal("netid"), "%d", &id)
+  return id != 0 && base.GetStoreVal("session token") != ""
+}
+
+// Forgets the player's session, they'll have to log in again to play online.
+func logOut() {
+  base.SetStoreVal("session token", "")
+  mrgnet.SetToken("")
+}
+
+func InsertLoginMenu(ui gui.WidgetParent) error {
+  var lm LoginMenu
+  datadir := base.GetDataDir()
+  err := base.LoadAndProcessObject(filepath.Join(datadir, "ui", "start", "online", "login.json"), "json", &lm.layout)
+  if err != nil {
+    return err
+  }
+  lm.buttons = []ButtonLike{
+    &lm.layout.Back,
+    &lm.layout.Name,
+    &lm.layout.Secret,
+    &lm.layout.Login,
+    &lm.layout.Register,
+  }
+  lm.control.in = make(chan struct{})
+  lm.control.out = make(chan struct{})
+  lm.ui = ui
+  lm.layout.Back.f = func(interface{}) {
+    ui.RemoveChild(&lm)
+    InsertStartMenu(ui)
+  }
+  lm.layout.Login.f = func(interface{}) {
+    lm.submit("login")
+  }
+  lm.layout.Register.f = func(interface{}) {
+    lm.submit("register")
+  }
+
+  // The labels on the text entries don't do anything when clicked.
+  lm.layout.Name.Button.f = func(interface{}) {}
+  lm.layout.Secret.Button.f = func(interface{}) {}
+  lm.layout.Name.SetText(base.GetStoreVal("account name"))
+
+  ui.AddChild(&lm)
+  return nil
+}
+
+// Sends the name and secret to the server as either a "login" or "register"
+// action.  If it works the session is stored and the player moves on to the
+// online menu.
+func (lm *LoginMenu) submit(action string) {
+  if lm.busy {
+    return
+  }
+  name := lm.layout.Name.Text()
+  secret := lm.layout.Secret.Text()
+  if name == "" || secret == "" {
+    lm.layout.Error.err = "Enter a name and a secret."
+    return
+  }
+  lm.busy = true
+  go func() {
+    var resp mrgnet.LoginResponse
+    var msg string
+    if action == "register" {
+      var reg mrgnet.RegisterResponse
+      msg = doOnlineAction(action, mrgnet.RegisterRequest{Name: name, Secret: secret}, &reg)
+      resp = mrgnet.LoginResponse(reg)
+    } else {
+      msg = doOnlineAction(action, mrgnet.LoginRequest{Name: name, Secret: secret}, &resp)
+    }
+    if msg != "" {
+      resp.Err = msg
+    }
+    <-lm.control.in
+    defer func() {
+      lm.busy = false
+      lm.control.out <- struct{}{}
+    }()
+    if resp.Err != "" {
+      lm.layout.Error.err = resp.Err
+      base.Warn().Printf("Couldn't %s: %v", action, resp.Err)
+      return
+    }
+    base.SetStoreVal("account name", name)
+    base.SetStoreVal("netid", fmt.Sprintf("%d", resp.Id))
+    base.SetStoreVal("session token", resp.Token)
+    lm.ui.RemoveChild(lm)
+    err := InsertOnlineMenu(lm.ui)
+    if err != nil {
+      base.Error().Printf("Unable to make Online Menu: %v", err)
+    }
+  }()
+}
+
+func (lm *LoginMenu) Requested() gui.Dims {
+  return gui.Dims{1024, 768}
+}
+
+func (lm *LoginMenu) Expandable() (bool, bool) {
+  return false, false
+}
+
+func (lm *LoginMenu) Rendered() gui.Region {
+  return lm.region
+}
+
+func (lm *LoginMenu) Think(g *gui.Gui, t int64) {
+  if lm.last_t == 0 {
+    lm.last_t = t
+    return
+  }
+  dt := t - lm.last_t
+  lm.last_t = t
+  if lm.mx == 0 && lm.my == 0 {
+    lm.mx, lm.my = gin.In().GetCursor("Mouse").Point()
+  }
+
+  done := false
+  for !done {
+    select {
+    case lm.control.in <- struct{}{}:
+      <-lm.control.out
+    default:
+      done = true
+    }
+  }
+
+  for _, button := range lm.buttons {
+    button.Think(lm.region.X, lm.region.Y, lm.mx, lm.my, dt)
+  }
+}
+
+func (lm *LoginMenu) Respond(g *gui.Gui, group gui.EventGroup) bool {
+  cursor := group.Events[0].Key.Cursor()
+  if cursor != nil {
+    lm.mx, lm.my = cursor.Point()
+  }
+  if found, event := group.FindEvent(gin.MouseLButton); found && event.Type == gin.Press {
+    for _, button := range lm.buttons {
+      if button.handleClick(lm.mx, lm.my, nil) {
+        return true
+      }
+    }
+  }
+
+  hit := false
+  for _, button := range lm.buttons {
+    if button.Respond(group, nil) {
+      hit = true
+    }
+  }
+  return hit
+}
+
+func (lm *LoginMenu) Draw(region gui.Region) {
+  lm.region = region
+  gl.Color4ub(255, 255, 255, 255)
+  lm.layout.Background.Data().RenderNatural(region.X, region.Y)
+  for _, button := range lm.buttons {
+    button.RenderAt(lm.region.X, lm.region.Y)
+  }
+
+  if lm.layout.Error.err != "" {
+    gl.Color4ub(255, 0, 0, 255)
+    l := lm.layout.Error
+    d := base.GetDictionary(l.Size)
+    d.RenderString(fmt.Sprintf("ERROR: %s", l.err), float64(l.X), float64(l.Y), 0, d.MaxHeight(), gui.Left)
+  }
+}
+
+func (lm *LoginMenu) DrawFocused(region gui.Region) {
+}
+
+func (lm *LoginMenu) String() string {
+  return "login menu"
+}
@@ -1,6 +1,7 @@
 package game
 
 import (
+  "context"
   "fmt"
   "github.com/mik3cap/glop/gin"
   "github.com/mik3cap/glop/gui"
@@ -28,10 +29,10 @@ type gameListBox struct {
 }
 
 type gameField struct {
-  join, delete ButtonLike
-  name         string
-  key          mrgnet.GameKey
-  game         mrgnet.Game
+  join, delete, replay ButtonLike
+  name                 string
+  key                  mrgnet.GameKey
+  game                 mrgnet.Game
 }
 
 type onlineLayout struct {
@@ -41,6 +42,7 @@ type onlineLayout struct {
   }
   Background texture.Object
   Back       Button
+  Logout     Button
 
   User    TextEntry
   NewGame Button
@@ -84,11 +86,17 @@ type OnlineMenu struct {
   ui gui.WidgetParent
 
   hover_game *gameField
+
+  // Stops everything that is waiting on the server on behalf of this menu.
+  cancel context.CancelFunc
 }
 
 var net_id mrgnet.NetId
 
 func InsertOnlineMenu(ui gui.WidgetParent) error {
+  if !loggedIn() {
+    return InsertLoginMenu(ui)
+  }
   var sm OnlineMenu
   datadir := base.GetDataDir()
   err := base.LoadAndProcessObject(filepath.Join(datadir, "ui", "start", "online", "layout.json"), "json", &sm.layout)
@@ -97,6 +105,7 @@ func InsertOnlineMenu(ui gui.WidgetParent) error {
   }
   sm.buttons = []ButtonLike{
     &sm.layout.Back,
+    &sm.layout.Logout,
     &sm.layout.Unstarted.Up,
     &sm.layout.Unstarted.Down,
     &sm.layout.Active.Up,
@@ -107,16 +116,20 @@ func InsertOnlineMenu(ui gui.WidgetParent) error {
   sm.control.in = make(chan struct{})
   sm.control.out = make(chan struct{})
   sm.layout.Back.f = func(interface{}) {
-    ui.RemoveChild(&sm)
+    sm.remove()
     InsertStartMenu(ui)
   }
+  sm.layout.Logout.f = func(interface{}) {
+    logOut()
+    sm.remove()
+    InsertLoginMenu(ui)
+  }
   sm.ui = ui
+  var ctx context.Context
+  ctx, sm.cancel = context.WithCancel(context.Background())
 
   fmt.Sscanf(base.GetStoreVal("netid"), "%d", &net_id)
-  if net_id == 0 {
-    net_id = mrgnet.NetId(mrgnet.RandomId())
-    base.SetStoreVal("netid", fmt.Sprintf("%d", net_id))
-  }
+  mrgnet.SetToken(base.GetStoreVal("session token"))
 
   in_newgame := false
   sm.layout.NewGame.f = func(interface{}) {
@@ -128,15 +141,8 @@ func InsertOnlineMenu(ui gui.WidgetParent) error {
       var req mrgnet.NewGameRequest
       req.Id = net_id
       var resp mrgnet.NewGameResponse
-      done := make(chan bool, 1)
-      go func() {
-        mrgnet.DoAction("new", req, &resp)
-        done <- true
-      }()
-      select {
-      case <-done:
-      case <-time.After(5 * time.Second):
-        resp.Err = "Couldn't connect to server."
+      if msg := doOnlineAction("new", req, &resp); msg != "" {
+        resp.Err = msg
       }
       <-sm.control.in
       defer func() {
@@ -148,7 +154,7 @@ func InsertOnlineMenu(ui gui.WidgetParent) error {
         base.Error().Printf("Couldn't make new game: %v", resp.Err)
         return
       }
-      ui.RemoveChild(&sm)
+      sm.remove()
       err := InsertMapChooser(
         ui,
         func(name string) {
@@ -179,16 +185,9 @@ func InsertOnlineMenu(ui gui.WidgetParent) error {
 
     glb.update = make(chan mrgnet.ListGamesResponse)
   }
-  go func() {
-    var resp mrgnet.ListGamesResponse
-    mrgnet.DoAction("list", mrgnet.ListGamesRequest{Id: net_id, Unstarted: true}, &resp)
-    sm.layout.Unstarted.update <- resp
-  }()
-  go func() {
-    var resp mrgnet.ListGamesResponse
-    mrgnet.DoAction("list", mrgnet.ListGamesRequest{Id: net_id, Unstarted: false}, &resp)
-    sm.layout.Active.update <- resp
-  }()
+  go sm.listGames(ctx, &sm.layout.Unstarted, true)
+  go sm.listGames(ctx, &sm.layout.Active, false)
+  go sm.watchGames(ctx)
 
   sm.layout.User.Button.f = func(interface{}) {
     var req mrgnet.UpdateUserRequest
@@ -196,21 +195,21 @@ func InsertOnlineMenu(ui gui.WidgetParent) error {
     req.Id = net_id
     var resp mrgnet.UpdateUserResponse
     go func() {
-      mrgnet.DoAction("user", req, &resp)
+      if msg := doOnlineAction("user", req, &resp); msg != "" {
+        resp.Err = msg
+      }
       <-sm.control.in
-      sm.layout.User.SetText(resp.Name)
-      sm.update_alpha = 1.0
-      sm.update_time = time.Now()
+      sm.updateUser(resp)
       sm.control.out <- struct{}{}
     }()
   }
   go func() {
     var resp mrgnet.UpdateUserResponse
-    mrgnet.DoAction("user", mrgnet.UpdateUserRequest{Id: net_id}, &resp)
+    if msg := doOnlineAction("user", mrgnet.UpdateUserRequest{Id: net_id}, &resp); msg != "" {
+      resp.Err = msg
+    }
     <-sm.control.in
-    sm.layout.User.SetText(resp.Name)
-    sm.update_alpha = 1.0
-    sm.update_time = time.Now()
+    sm.updateUser(resp)
     sm.control.out <- struct{}{}
   }()
 
@@ -218,6 +217,123 @@ func InsertOnlineMenu(ui gui.WidgetParent) error {
   return nil
 }
 
+// How long the online menu will wait on the server before telling the user
+// that something went wrong.
+const online_timeout = 5 * time.Second
+
+// Runs the named action against the server.  If it fails the error is logged
+// and a message suitable for showing the user is returned, otherwise returns
+// "".
+func doOnlineAction(name string, req, resp interface{}) string {
+  ctx, cancel := context.WithTimeout(context.Background(), online_timeout)
+  defer cancel()
+  err := mrgnet.DoActionContext(ctx, name, req, resp)
+  if err != nil {
+    base.Warn().Printf("%v", err)
+    return mrgnet.ErrorMessage(err)
+  }
+  return ""
+}
+
+// Removes the menu from the ui.  This should be used instead of calling
+// RemoveChild directly so that the menu stops waiting on the server.
+func (sm *OnlineMenu) remove() {
+  sm.cancel()
+  sm.ui.RemoveChild(sm)
+}
+
+// Lists either the unstarted games or the active games and sends the result
+// to glb, where Think() will pick it up.
+func (sm *OnlineMenu) listGames(ctx context.Context, glb *gameListBox, unstarted bool) {
+  var resp mrgnet.ListGamesResponse
+  if msg := doOnlineAction("list", mrgnet.ListGamesRequest{Id: net_id, Unstarted: unstarted}, &resp); msg != "" {
+    resp.Err = msg
+  }
+  select {
+  case glb.update <- resp:
+  case <-ctx.Done():
+  }
+}
+
+// Re-lists the games whenever the server says that one of ours has changed,
+// so that the menu doesn't have to keep polling the server.  Runs until ctx
+// is done.
+func (sm *OnlineMenu) watchGames(ctx context.Context) {
+  var since int64
+  for ctx.Err() == nil {
+    var resp mrgnet.WaitResponse
+    err := mrgnet.Wait(ctx, mrgnet.WaitRequest{Id: net_id, Since: since}, &resp)
+    if err != nil || resp.Err != "" {
+      if ctx.Err() != nil {
+        return
+      }
+      // Not worth bothering the user about, the lists just won't update on
+      // their own until the server can be reached again.
+      if err != nil {
+        base.Warn().Printf("Unable to wait on games: %v", err)
+      } else {
+        base.Warn().Printf("Unable to wait on games: %s", resp.Err)
+      }
+      select {
+      case <-time.After(30 * time.Second):
+      case <-ctx.Done():
+      }
+      continue
+    }
+    if since != 0 && len(resp.Game_keys) > 0 {
+      go sm.listGames(ctx, &sm.layout.Unstarted, true)
+      go sm.listGames(ctx, &sm.layout.Active, false)
+    }
+    since = resp.Seq
+  }
+}
+
+// Fetches the whole game from the server and replaces the menu with a replay
+// of it.
+func (sm *OnlineMenu) replayGame(game_key mrgnet.GameKey) {
+  go func() {
+    var req mrgnet.StatusRequest
+    req.Id = net_id
+    req.Game_key = game_key
+    var resp mrgnet.StatusResponse
+    if msg := doOnlineAction("status", req, &resp); msg != "" {
+      resp.Err = msg
+    }
+    <-sm.control.in
+    defer func() {
+      sm.control.out <- struct{}{}
+    }()
+    if resp.Err == "" && resp.Game == nil {
+      resp.Err = "No such game."
+    }
+    if resp.Err != "" {
+      sm.layout.Error.err = resp.Err
+      base.Error().Printf("Couldn't replay game: %v", resp.Err)
+      return
+    }
+    gp, err := MakeReplayPanel(resp.Game, string(game_key))
+    if err != nil {
+      sm.layout.Error.err = err.Error()
+      base.Error().Printf("Couldn't replay game: %v", err)
+      return
+    }
+    sm.remove()
+    sm.ui.AddChild(gp)
+  }()
+}
+
+// Must only be called while synced with Think() through sm.control.
+func (sm *OnlineMenu) updateUser(resp mrgnet.UpdateUserResponse) {
+  if resp.Err != "" {
+    sm.layout.Error.err = resp.Err
+    base.Error().Printf("Couldn't update user: %v", resp.Err)
+    return
+  }
+  sm.layout.User.SetText(resp.Name)
+  sm.update_alpha = 1.0
+  sm.update_time = time.Now()
+}
+
 func (sm *OnlineMenu) Requested() gui.Dims {
   return gui.Dims{1024, 768}
 }
@@ -265,6 +381,10 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
     glb := []*gameListBox{&sm.layout.Active, &sm.layout.Unstarted}[i]
     select {
     case list := <-glb.update:
+      if list.Err != "" {
+        sm.layout.Error.err = list.Err
+        base.Error().Printf("Couldn't list games: %v", list.Err)
+      }
       glb.games = glb.games[0:0]
       for j := range list.Games {
         var b Button
@@ -292,15 +412,8 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
               req.Id = net_id
               req.Game_key = game_key
               var resp mrgnet.StatusResponse
-              done := make(chan bool, 1)
-              go func() {
-                mrgnet.DoAction("status", req, &resp)
-                done <- true
-              }()
-              select {
-              case <-done:
-              case <-time.After(5 * time.Second):
-                resp.Err = "Couldn't connect to server."
+              if msg := doOnlineAction("status", req, &resp); msg != "" {
+                resp.Err = msg
               }
               <-sm.control.in
               defer func() {
@@ -312,7 +425,7 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
                 base.Error().Printf("Couldn't join game: %v", resp.Err)
                 return
               }
-              sm.ui.RemoveChild(sm)
+              sm.remove()
               sm.ui.AddChild(MakeGamePanel("", nil, nil, game_key))
             }()
           } else {
@@ -321,15 +434,8 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
               req.Id = net_id
               req.Game_key = game_key
               var resp mrgnet.JoinGameResponse
-              done := make(chan bool, 1)
-              go func() {
-                mrgnet.DoAction("join", req, &resp)
-                done <- true
-              }()
-              select {
-              case <-done:
-              case <-time.After(5 * time.Second):
-                resp.Err = "Couldn't connect to server."
+              if msg := doOnlineAction("join", req, &resp); msg != "" {
+                resp.Err = msg
               }
               <-sm.control.in
               defer func() {
@@ -341,7 +447,7 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
                 base.Error().Printf("Couldn't join game: %v", resp.Err)
                 return
               }
-              sm.ui.RemoveChild(sm)
+              sm.remove()
               sm.ui.AddChild(MakeGamePanel("", nil, nil, game_key))
             }()
           }
@@ -357,15 +463,8 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
               req.Id = net_id
               req.Game_key = game_key
               var resp mrgnet.KillResponse
-              done := make(chan bool, 1)
-              go func() {
-                mrgnet.DoAction("kill", req, &resp)
-                done <- true
-              }()
-              select {
-              case <-done:
-              case <-time.After(5 * time.Second):
-                resp.Err = "Couldn't connect to server."
+              if msg := doOnlineAction("kill", req, &resp); msg != "" {
+                resp.Err = msg
               }
               <-sm.control.in
               if resp.Err != "" {
@@ -379,9 +478,16 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
               sm.control.out <- struct{}{}
             }()
           }
-          glb.games = append(glb.games, gameField{&b, &d, name, list.Game_keys[j], list.Games[j]})
+          r := Button{}
+          r.Text.String = "Replay!"
+          r.Text.Justification = "right"
+          r.Text.Size = sm.layout.Text.Size
+          r.f = func(interface{}) {
+            sm.replayGame(game_key)
+          }
+          glb.games = append(glb.games, gameField{&b, &d, &r, name, list.Game_keys[j], list.Games[j]})
         } else {
-          glb.games = append(glb.games, gameField{&b, nil, name, list.Game_keys[j], list.Games[j]})
+          glb.games = append(glb.games, gameField{&b, nil, nil, name, list.Game_keys[j], list.Games[j]})
         }
       }
       glb.Scroll.Height = int(base.GetDictionary(sm.layout.Text.Size).MaxHeight() * float64(len(list.Games)))
@@ -405,6 +511,9 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
         if game.delete != nil {
           game.delete.Think(sm.region.X, sm.region.Y, sm.mx, sm.my, dt)
         }
+        if game.replay != nil {
+          game.replay.Think(sm.region.X, sm.region.Y, sm.mx, sm.my, dt)
+        }
       }
     } else {
       for _, game := range glb.games {
@@ -412,6 +521,9 @@ func (sm *OnlineMenu) Think(g *gui.Gui, t int64) {
         if game.delete != nil {
           game.delete.Think(sm.region.X, sm.region.Y, 0, 0, dt)
         }
+        if game.replay != nil {
+          game.replay.Think(sm.region.X, sm.region.Y, 0, 0, dt)
+        }
       }
     }
     glb.Scroll.Think(dt)
@@ -447,6 +559,9 @@ func (sm *OnlineMenu) Respond(g *gui.Gui, group gui.EventGroup) bool {
           if game.delete != nil && game.delete.handleClick(sm.mx, sm.my, nil) {
             return true
           }
+          if game.replay != nil && game.replay.handleClick(sm.mx, sm.my, nil) {
+            return true
+          }
         }
       }
     }
@@ -468,6 +583,9 @@ func (sm *OnlineMenu) Respond(g *gui.Gui, group gui.EventGroup) bool {
         if game.delete != nil && game.delete.Respond(group, nil) {
           hit = true
         }
+        if game.replay != nil && game.replay.Respond(group, nil) {
+          hit = true
+        }
       }
     }
   }
@@ -508,6 +626,9 @@ func (sm *OnlineMenu) Draw(region gui.Region) {
       if game.delete != nil {
         game.delete.RenderAt(sx+50+glb.Scroll.Dx-100, sy)
       }
+      if game.replay != nil {
+        game.replay.RenderAt(sx+50+glb.Scroll.Dx-200, sy)
+      }
     }
     glb.Scroll.Region().PopClipPlanes()
   }
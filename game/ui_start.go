@@ -14,6 +14,7 @@ type startLayout struct {
     X, Y     int
     Texture  texture.Object
     Credits  Button
+    Load     Button
     Versus   Button
     Online   Button
     Settings Button
@@ -38,6 +39,7 @@ func InsertStartMenu(ui gui.WidgetParent) error {
   }
   sm.buttons = []ButtonLike{
     &sm.layout.Menu.Credits,
+    &sm.layout.Menu.Load,
     &sm.layout.Menu.Versus,
     &sm.layout.Menu.Online,
     &sm.layout.Menu.Settings,
@@ -50,12 +52,30 @@ func InsertStartMenu(ui gui.WidgetParent) error {
       return
     }
   }
+  sm.layout.Menu.Load.f = func(interface{}) {
+    ui.RemoveChild(&sm)
+    err := InsertLoadMenu(ui)
+    if err != nil {
+      base.Error().Printf("Unable to make Load Menu: %v", err)
+      return
+    }
+  }
   sm.layout.Menu.Versus.f = func(interface{}) {
     ui.RemoveChild(&sm)
     err := InsertMapChooser(
       ui,
       func(name string) {
-        ui.AddChild(MakeGamePanel(name, nil, nil, ""))
+        err := InsertDifficultyChooser(
+          ui,
+          func(d Difficulty) {
+            data := map[string]string{"difficulty": string(d)}
+            ui.AddChild(MakeGamePanel(name, nil, data, ""))
+          },
+          InsertStartMenu,
+        )
+        if err != nil {
+          base.Error().Printf("Unable to make Difficulty Chooser: %v", err)
+        }
       },
       InsertStartMenu,
     )
@@ -55,25 +55,17 @@ func MakeSystemMenu(gp *GamePanel, player *Player) (gui.Widget, error) {
     Restart()
   }
 
-  sm.layout.Sub.Save.Entry.text = player.Name
   sm.layout.Sub.Save.Button.f = func(interface{}) {
-    UpdatePlayer(player, gp.script.L)
-    str, err := base.ToGobToBase64(gp.game)
-    if err != nil {
-      base.Error().Printf("Error gobbing game state: %v", err)
-      return
-    }
-    player.Game_state = str
-    player.Name = sm.layout.Sub.Save.Text()
-    player.No_init = true
-    base.Log().Printf("Saving player: %v", player)
-    err = SavePlayer(player)
-    if err != nil {
-      base.Warn().Printf("Unable to save player: %v", err)
-      return
-    }
-    sm.saved_time = time.Now()
-    sm.saved_alpha = 1.0
+    name := sm.layout.Sub.Save.Text()
+    saveGameToSlot(gp, player, name, func(slot *SaveSlot, err error) {
+      if err != nil {
+        base.Warn().Printf("Unable to save to slot '%s': %v", name, err)
+        return
+      }
+      base.Log().Printf("Saved slot '%s' to %s", slot.Name, slot.Path())
+      sm.saved_time = time.Now()
+      sm.saved_alpha = 1.0
+    })
   }
 
   return &sm, nil
@@ -5,6 +5,7 @@ import (
   "github.com/mik3cap/glop/gui"
   "github.com/mik3cap/haunts/base"
   "github.com/mik3cap/opengl/gl"
+  "strings"
 )
 
 var valid_keys map[byte]bool
@@ -34,6 +35,9 @@ type TextEntry struct {
     // Width of the text entry.
     Dx int
 
+    // If true the text is drawn as asterisks, for entering secrets.
+    Hidden bool
+
     bounds struct {
       x, y, dx, dy int
     }
@@ -58,6 +62,14 @@ func (te *TextEntry) Text() string {
   return te.Entry.text
 }
 
+// Returns the text as it should be drawn.
+func (te *TextEntry) shown() string {
+  if te.Entry.Hidden {
+    return strings.Repeat("*", len(te.Entry.text))
+  }
+  return te.Entry.text
+}
+
 func (te *TextEntry) SetText(text string) {
   te.Entry.text = text
   te.Entry.prev = text
@@ -115,8 +127,9 @@ func (te *TextEntry) setCursor(mx, my int) bool {
   d := base.GetDictionary(te.Button.Text.Size)
   last_dx := 0
   te.Entry.ghost.index = -1
-  for i := range te.Entry.text {
-    w := int(d.StringWidth(te.Entry.text[0 : i+1]))
+  shown := te.shown()
+  for i := range shown {
+    w := int(d.StringWidth(shown[0 : i+1]))
     avg := (last_dx + w) / 2
     if pointInsideRect(mx, my, te.Entry.bounds.x, te.Entry.bounds.y, avg, te.Entry.bounds.dy) {
       te.Entry.ghost.offset = last_dx
@@ -126,7 +139,7 @@ func (te *TextEntry) setCursor(mx, my int) bool {
     last_dx = w
   }
   if te.Entry.ghost.index < 0 {
-    te.Entry.ghost.offset = int(d.StringWidth(te.Entry.text))
+    te.Entry.ghost.offset = int(d.StringWidth(shown))
     te.Entry.ghost.index = len(te.Entry.text)
   }
   return true
@@ -179,7 +192,7 @@ func (te *TextEntry) Respond(group gui.EventGroup, data interface{}) bool {
         te.Entry.cursor.index = 0
       }
       d := base.GetDictionary(te.Button.Text.Size)
-      te.Entry.cursor.offset = int(d.StringWidth(te.Entry.text[0:te.Entry.cursor.index]))
+      te.Entry.cursor.offset = int(d.StringWidth(te.shown()[0:te.Entry.cursor.index]))
     }
   }
   return false
@@ -226,7 +239,7 @@ func (te *TextEntry) RenderAt(x, y int) {
   gl.End()
 
   gl.Color4ub(255, 255, 255, 255)
-  d.RenderString(te.Entry.text, float64(x), float64(y), 0, d.MaxHeight(), gui.Left)
+  d.RenderString(te.shown(), float64(x), float64(y), 0, d.MaxHeight(), gui.Left)
 
   if te.Entry.ghost.offset >= 0 {
     gl.Disable(gl.TEXTURE_2D)
@@ -0,0 +1,357 @@
+package game
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/base"
+  "io"
+  "sort"
+  "strings"
+)
+
+// Each frame of a replayed action is simulated as this many milliseconds.
+const verify_dt = 16
+
+// If an action hasn't completed after this many frames it is assumed that it
+// never will.
+const verify_max_frames = 100000
+
+// Replays the execs that a player sent with Net.UpdateExecs against the state
+// they sent with Net.UpdateState at the start of the same turn, and returns an
+// error if the result doesn't match the state they sent for the end of the
+// turn.  The actions do all of the real checking, such as making sure that an
+// entity has enough Ap for a move, this just runs them and compares the
+// outcome.
+//
+// If start is set this checks the start of a turn instead, before is the
+// state the previous turn ended in, after is the state the player sent with
+// Net.UpdateState, and execs is ignored.  The turn is started the same way
+// the game starts it, and level scripts can only take hp and ap away from
+// what that leaves.
+//
+// Entities can only disappear if they died.  Level scripts can spawn
+// entities whenever they like, but they can't be run here since they keep
+// state between turns that isn't saved with the game, so new entities are
+// accepted as long as they look like they were just made, see checkSpawn().
+// Anything that the player sent along with their execs about what the
+// script did is ignored.
+//
+// This needs all of the registries and entities to be loaded, but it doesn't
+// draw anything so it can be run headless, see SetupHeadless().
+func VerifyTurn(before, execs, after []byte, intruders, start bool) error {
+  g, err := decodeVerifyState(before)
+  if err != nil {
+    return errors.New(fmt.Sprintf("Unable to decode state before the turn: %v", err))
+  }
+  side := SideHaunt
+  if intruders {
+    side = SideExplorers
+  }
+  if start {
+    g.Side = side
+    g.startEntsTurn()
+  } else {
+    if len(execs) == 0 {
+      return errors.New("Got a turn without any execs.")
+    }
+    encoded, err := decodeEncodedExecs(execs)
+    if err != nil {
+      return errors.New(fmt.Sprintf("Unable to decode execs: %v", err))
+    }
+    for i, str := range encoded {
+      var list []ActionExec
+      err := base.FromBase64FromGob(&list, str)
+      if err != nil {
+        return errors.New(fmt.Sprintf("Unable to decode exec %d: %v", i, err))
+      }
+      for _, exec := range list {
+        err := replayExec(g, side, exec)
+        if err != nil {
+          return errors.New(fmt.Sprintf("Exec %d: %v", i, err))
+        }
+      }
+    }
+  }
+  expected, err := decodeVerifyState(after)
+  if err != nil {
+    return errors.New(fmt.Sprintf("Unable to decode state after the turn: %v", err))
+  }
+  return compareVerifyStates(g, expected, start)
+}
+
+// Checks that state, the latest state of a game, says that the intruders won
+// if intruders is set or the denizens won otherwise.  Who won is up to the
+// level script, which can't be run here, so this can only make sure that the
+// player who says they won sent a state that agrees, and VerifyTurn() makes
+// sure that nobody changes the winner once there is one.
+func VerifyWinner(state []byte, intruders bool) error {
+  g, err := decodeVerifyState(state)
+  if err != nil {
+    return errors.New(fmt.Sprintf("Unable to decode state: %v", err))
+  }
+  side := SideHaunt
+  if intruders {
+    side = SideExplorers
+  }
+  if g.Winner != side {
+    return errors.New(fmt.Sprintf("The %s haven't won this game.", strings.ToLower(sideName(side))))
+  }
+  return nil
+}
+
+// Decodes a state made by Script.SaveGameState().
+func decodeVerifyState(state []byte) (*Game, error) {
+  var g *Game
+  var ts totalState
+  ts.Game = &g
+  err := decodeGameState(string(state), &ts)
+  if err != nil {
+    return nil, err
+  }
+  if g == nil {
+    return nil, errors.New("State didn't contain a game.")
+  }
+  return g, nil
+}
+
+// Runs a single exec to completion the same way that Game.Think() would.
+func replayExec(g *Game, side Side, exec ActionExec) error {
+  ent := g.EntityById(exec.EntityId())
+  if ent == nil {
+    return errors.New(fmt.Sprintf("There is no entity with id %d.", exec.EntityId()))
+  }
+  if ent.Side() != side {
+    return errors.New(fmt.Sprintf("%s is not on the side that is playing.", ent.Name))
+  }
+  index := exec.ActionIndex()
+  if index < 0 || index >= len(ent.Actions) {
+    return errors.New(fmt.Sprintf("%s doesn't have an action %d.", ent.Name, index))
+  }
+  action := ent.actionFor(exec)
+  res := action.Maintain(verify_dt, g, exec)
+  for frames := 0; res != Complete; frames++ {
+    if frames >= verify_max_frames {
+      return errors.New(fmt.Sprintf("%s's %s never finished.", ent.Name, action))
+    }
+    for _, ent := range g.Ents {
+      ent.Think(verify_dt)
+    }
+    res = action.Maintain(verify_dt, g, nil)
+  }
+  action.Cancel()
+  return nil
+}
+
+// Returns an error if expected doesn't match got, the state that replaying a
+// turn ended up in.  If start is set expected may have less hp and ap than
+// got, since level scripts can take them away when a turn starts.  Entities
+// that are only in expected must pass checkSpawn().
+func compareVerifyStates(got, expected *Game, start bool) error {
+  if got.Winner != SideNone && expected.Winner != got.Winner {
+    return errors.New(fmt.Sprintf("The %s already won.", strings.ToLower(sideName(got.Winner))))
+  }
+  for _, ent := range got.Ents {
+    other := expected.EntityById(ent.Id)
+    if other == nil {
+      if ent.Stats != nil && ent.Stats.HpCur() <= 0 {
+        continue
+      }
+      return errors.New(fmt.Sprintf("%s disappeared.", ent.Name))
+    }
+    if ent.Name != other.Name {
+      return errors.New(fmt.Sprintf("%s turned into %s.", ent.Name, other.Name))
+    }
+    x, y := ent.Pos()
+    ox, oy := other.Pos()
+    if x != ox || y != oy {
+      return errors.New(fmt.Sprintf("%s should be at (%d, %d), not (%d, %d).", ent.Name, x, y, ox, oy))
+    }
+    if (ent.Stats == nil) != (other.Stats == nil) {
+      return errors.New(fmt.Sprintf("%s's stats don't match.", ent.Name))
+    }
+    if ent.Stats == nil {
+      continue
+    }
+    hp, ap := ent.Stats.HpCur(), ent.Stats.ApCur()
+    ohp, oap := other.Stats.HpCur(), other.Stats.ApCur()
+    if start && ohp <= hp && oap <= ap {
+      hp, ap = ohp, oap
+    }
+    if hp != ohp || ap != oap {
+      return errors.New(fmt.Sprintf("%s should have %d hp and %d ap, not %d hp and %d ap.",
+        ent.Name, hp, ap, ohp, oap))
+    }
+    if err := compareConditions(ent, other); err != nil {
+      return err
+    }
+  }
+  for _, ent := range expected.Ents {
+    if got.EntityById(ent.Id) != nil {
+      continue
+    }
+    if err := checkSpawn(got, expected, ent); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Returns an error unless other has the same conditions as ent.
+func compareConditions(ent, other *Entity) error {
+  conditions := ent.Stats.ConditionNames()
+  other_conditions := other.Stats.ConditionNames()
+  sort.Strings(conditions)
+  sort.Strings(other_conditions)
+  if strings.Join(conditions, ", ") != strings.Join(other_conditions, ", ") {
+    return errors.New(fmt.Sprintf("%s should have conditions [%s], not [%s].",
+      ent.Name, strings.Join(conditions, ", "), strings.Join(other_conditions, ", ")))
+  }
+  return nil
+}
+
+// Returns an error unless ent, which is in expected but not in got, could
+// have just been spawned by a level script.  It has to be an entity that
+// exists, it can't have more hp or ap or different conditions than a newly
+// made one, and it can't be on top of another entity.
+func checkSpawn(got, expected *Game, ent *Entity) error {
+  fresh := MakeEntity(ent.Name, got)
+  if fresh.Name == "" {
+    return errors.New(fmt.Sprintf("%s appeared out of nowhere.", ent.Name))
+  }
+  if (ent.Stats == nil) != (fresh.Stats == nil) {
+    return errors.New(fmt.Sprintf("%s appeared with the wrong stats.", ent.Name))
+  }
+  if ent.Stats != nil {
+    if ent.Stats.HpCur() > fresh.Stats.HpCur() || ent.Stats.ApCur() > fresh.Stats.ApCur() {
+      return errors.New(fmt.Sprintf("%s appeared with %d hp and %d ap, but can only have %d hp and %d ap.",
+        ent.Name, ent.Stats.HpCur(), ent.Stats.ApCur(), fresh.Stats.HpCur(), fresh.Stats.ApCur()))
+    }
+    if err := compareConditions(fresh, ent); err != nil {
+      return err
+    }
+  }
+  x, y := ent.Pos()
+  for _, other := range expected.Ents {
+    if ox, oy := other.Pos(); other != ent && ox == x && oy == y {
+      return errors.New(fmt.Sprintf("%s appeared on top of %s.", ent.Name, other.Name))
+    }
+  }
+  return nil
+}
+
+// Net.UpdateExecs() sends a lua array of execs encoded with LuaEncodeValue.
+// This decodes it without needing a lua state and returns each exec's table,
+// in order.
+func decodeExecTables(data []byte) ([]map[interface{}]interface{}, error) {
+  v, err := decodeRawLuaValue(bytes.NewBuffer(data))
+  if err != nil {
+    return nil, err
+  }
+  execs, ok := v.(map[interface{}]interface{})
+  if !ok {
+    return nil, errors.New("Execs were not a table.")
+  }
+  var indices []float64
+  for key := range execs {
+    index, ok := key.(float64)
+    if !ok {
+      return nil, errors.New(fmt.Sprintf("Execs had a non-numeric key: %v", key))
+    }
+    indices = append(indices, index)
+  }
+  sort.Float64s(indices)
+  var tables []map[interface{}]interface{}
+  for _, index := range indices {
+    exec, ok := execs[index].(map[interface{}]interface{})
+    if !ok {
+      return nil, errors.New(fmt.Sprintf("Exec %v was not a table.", index))
+    }
+    tables = append(tables, exec)
+  }
+  return tables, nil
+}
+
+// Each exec that a player did has an "__encoded" field with the actual
+// ActionExecs in it.  Level scripts also record their own events, like
+// script_spawn, in the same array; those don't have an "__encoded" field and
+// are skipped, since they are only the player's word for what happened.
+func encodedExecs(tables []map[interface{}]interface{}) ([]string, error) {
+  var encoded []string
+  for i, exec := range tables {
+    if _, ok := exec["__encoded"]; !ok {
+      continue
+    }
+    str, ok := exec["__encoded"].(string)
+    if !ok {
+      return nil, errors.New(fmt.Sprintf("Exec %d had bad encoded data.", i+1))
+    }
+    encoded = append(encoded, str)
+  }
+  return encoded, nil
+}
+
+// Returns the ActionExecs encoded in data, which was sent with
+// Net.UpdateExecs(), see encodedExecs().
+func decodeEncodedExecs(data []byte) ([]string, error) {
+  tables, err := decodeExecTables(data)
+  if err != nil {
+    return nil, err
+  }
+  return encodedExecs(tables)
+}
+
+// Reads a single value written by LuaEncodeValue.  Tables are returned as
+// map[interface{}]interface{}, entities as their EntityId.
+func decodeRawLuaValue(r io.Reader) (interface{}, error) {
+  var le luaEncodable
+  err := binary.Read(r, binary.LittleEndian, &le)
+  if err != nil {
+    return nil, err
+  }
+  switch le {
+  case luaEncBool:
+    var v byte
+    err = binary.Read(r, binary.LittleEndian, &v)
+    return v == 1, err
+  case luaEncNumber:
+    var f float64
+    err = binary.Read(r, binary.LittleEndian, &f)
+    return f, err
+  case luaEncNil:
+    return nil, nil
+  case luaEncEntity:
+    var id uint64
+    err = binary.Read(r, binary.LittleEndian, &id)
+    return EntityId(id), err
+  case luaEncTable:
+    table := make(map[interface{}]interface{})
+    for {
+      var cont byte
+      err = binary.Read(r, binary.LittleEndian, &cont)
+      if err != nil || cont == 0 {
+        return table, err
+      }
+      key, err := decodeRawLuaValue(r)
+      if err != nil {
+        return nil, err
+      }
+      val, err := decodeRawLuaValue(r)
+      if err != nil {
+        return nil, err
+      }
+      table[key] = val
+    }
+  case luaEncString:
+    var length uint32
+    err = binary.Read(r, binary.LittleEndian, &length)
+    if err != nil {
+      return nil, err
+    }
+    sb := make([]byte, length)
+    _, err = io.ReadFull(r, sb)
+    return string(sb), err
+  }
+  return nil, errors.New(fmt.Sprintf("Unknown lua value id == %d.", le))
+}
@@ -0,0 +1,85 @@
+package game_test
+
+import (
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/game/actions"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+)
+
+func VerifySpec(c gospec.Context) {
+  s, err := game.MakeSim("Lvl_01_Haunted_House", 1)
+  c.Assume(err, Equals, nil)
+  ent, err := s.Spawn("Technician", 47, 25)
+  c.Assume(err, Equals, nil)
+  state := func() []byte {
+    data, err := game.EncodeVerifyState(s.Game)
+    c.Assume(err, Equals, nil)
+    return data
+  }
+  before := state()
+
+  // Moves ent one cell and returns the execs that did it.
+  move := func() []byte {
+    move := ent.Actions[0].(*actions.Move)
+    exec := move.AiMoveToPos(ent, []int{s.Game.ToVertex(47, 24)}, 10)
+    c.Assume(exec, Not(Equals), nil)
+    execs, err := game.EncodeVerifyExecs(exec)
+    c.Assume(err, Equals, nil)
+    c.Assume(s.Exec(exec), Equals, nil)
+    return execs
+  }
+
+  c.Specify("Execs that end up in the state that was sent are accepted.", func() {
+    execs := move()
+    c.Expect(game.VerifyTurn(before, execs, state(), false, false), Equals, nil)
+  })
+
+  c.Specify("States that the execs don't end up in are rejected.", func() {
+    execs := move()
+    ent.Stats.SetAp(ent.Stats.ApCur() + 1)
+    c.Expect(game.VerifyTurn(before, execs, state(), false, false), Not(Equals), nil)
+  })
+
+  c.Specify("Turns have to have execs.", func() {
+    c.Expect(game.VerifyTurn(before, nil, before, false, false), Not(Equals), nil)
+  })
+
+  c.Specify("Turns start the same way the game starts them.", func() {
+    move()
+    end := state()
+    s.EndTurn()
+    s.EndTurn()
+    c.Assume(ent.Stats.ApCur(), Equals, ent.Stats.ApMax())
+    c.Expect(game.VerifyTurn(end, nil, state(), false, true), Equals, nil)
+
+    // Level scripts can take Ap away, but nothing can give any more.
+    ent.Stats.SetAp(0)
+    c.Expect(game.VerifyTurn(end, nil, state(), false, true), Equals, nil)
+    ent.Stats.SetHp(ent.Stats.HpCur() + 1)
+    c.Expect(game.VerifyTurn(end, nil, state(), false, true), Not(Equals), nil)
+  })
+
+  c.Specify("Entities can only appear as they would be spawned.", func() {
+    execs := move()
+    shade, err := s.Spawn("Angry Shade", 47, 22)
+    c.Assume(err, Equals, nil)
+    c.Expect(game.VerifyTurn(before, execs, state(), false, false), Equals, nil)
+    shade.Stats.SetHp(shade.Stats.HpCur() + 1)
+    c.Expect(game.VerifyTurn(before, execs, state(), false, false), Not(Equals), nil)
+  })
+
+  c.Specify("A side has only won if the state says so, and then it stays won.", func() {
+    c.Expect(game.VerifyWinner(before, false), Not(Equals), nil)
+    s.Game.Winner = game.SideHaunt
+    won := state()
+    c.Expect(game.VerifyWinner(won, false), Equals, nil)
+    c.Expect(game.VerifyWinner(won, true), Not(Equals), nil)
+
+    execs := move()
+    s.Game.Winner = game.SideExplorers
+    c.Expect(game.VerifyTurn(won, execs, state(), false, false), Not(Equals), nil)
+    s.Game.Winner = game.SideHaunt
+    c.Expect(game.VerifyTurn(won, execs, state(), false, false), Equals, nil)
+  })
+}
@@ -3,6 +3,7 @@ package house
 import (
   "runtime"
   "github.com/mik3cap/glop/render"
+  "github.com/mik3cap/haunts/base"
   "github.com/mik3cap/opengl/gl"
 )
 
@@ -35,12 +36,12 @@ func losTextureFinalize(lt *LosTexture) {
 
 // Creates a LosTexture with the specified size, which must be a power of two.
 func MakeLosTexture() *LosTexture {
-  var lt LosTexture
-  lt.pix = make([]byte, LosTextureSizeSquared)
-  lt.p2d = make([][]byte, LosTextureSize)
-  lt.rec = make(chan gl.Texture, 1)
-  for i := 0; i < LosTextureSize; i++ {
-    lt.p2d[i] = lt.pix[i*LosTextureSize : (i+1)*LosTextureSize]
+  lt := MakeUndrawnLosTexture()
+
+  // The pixels are all that the game logic cares about, the texture is only
+  // used for drawing.
+  if base.Headless() {
+    return lt
   }
 
   render.Queue(func() {
@@ -54,9 +55,22 @@ func MakeLosTexture() *LosTexture {
     gl.TexParameterf(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.REPEAT)
     gl.TexImage2D(gl.TEXTURE_2D, 0, gl.ALPHA, len(lt.p2d), len(lt.p2d), 0, gl.ALPHA, gl.BYTE, lt.pix)
     lt.rec <- tex
-    runtime.SetFinalizer(&lt, losTextureFinalize)
+    runtime.SetFinalizer(lt, losTextureFinalize)
   })
 
+  return lt
+}
+
+// Creates a LosTexture that is never drawn, so only its pixels are made and
+// nothing is sent to OpenGl.  Games that only exist to be simulated use these.
+func MakeUndrawnLosTexture() *LosTexture {
+  var lt LosTexture
+  lt.pix = make([]byte, LosTextureSizeSquared)
+  lt.p2d = make([][]byte, LosTextureSize)
+  lt.rec = make(chan gl.Texture, 1)
+  for i := 0; i < LosTextureSize; i++ {
+    lt.p2d[i] = lt.pix[i*LosTextureSize : (i+1)*LosTextureSize]
+  }
   return &lt
 }
 
@@ -89,6 +103,9 @@ func (lt *LosTexture) Remap() {
 
 // Binds the texture, not run on the render thread
 func (lt *LosTexture) Bind() {
+  if base.Headless() {
+    return
+  }
   lt.ready()
   lt.tex.Bind(gl.TEXTURE_2D)
 }
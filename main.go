@@ -7,6 +7,7 @@ import (
   "runtime"
   "runtime/debug"
   "runtime/pprof"
+  "strings"
   "math/rand"
   gl "github.com/chsc/gogl/gl21"
   "github.com/mik3cap/glop/gin"
@@ -19,13 +20,12 @@ import (
   "github.com/mik3cap/haunts/game"
   "github.com/mik3cap/haunts/sound"
   "github.com/mik3cap/haunts/house"
+  "github.com/mik3cap/haunts/mrgnet"
 
   // Need to pull in all of the actions we define here and not in
   // haunts/game because haunts/game/actions depends on it
   _ "github.com/mik3cap/haunts/game/actions"
   _ "github.com/mik3cap/haunts/game/ai"
-
-  "github.com/mik3cap/haunts/game/status"
 )
 
 var (
@@ -44,17 +44,6 @@ var (
   zooming, dragging, hiding bool
 )
 
-func loadAllRegistries() {
-  house.LoadAllFurnitureInDir(filepath.Join(datadir, "furniture"))
-  house.LoadAllWallTexturesInDir(filepath.Join(datadir, "textures"))
-  house.LoadAllRoomsInDir(filepath.Join(datadir, "rooms"))
-  house.LoadAllDoorsInDir(filepath.Join(datadir, "doors"))
-  house.LoadAllHousesInDir(filepath.Join(datadir, "houses"))
-  game.LoadAllGearInDir(filepath.Join(datadir, "gear"))
-  game.RegisterActions()
-  status.RegisterAllConditions()
-}
-
 func init() {
   runtime.LockOSThread()
   sys = system.Make(gos.GetSystemInterface())
@@ -69,6 +58,16 @@ func init() {
     panic(err.Error())
   }
 
+  // The server for online games is picked by -host-url, then by the
+  // environment variable, then by "host url" in the store.
+  if host_url := hostUrlFlag(); host_url != "" {
+    mrgnet.SetHostUrl(host_url)
+  } else if os.Getenv(mrgnet.Host_url_env) == "" {
+    if host_url := base.GetStoreVal("host url"); host_url != "" {
+      mrgnet.SetHostUrl(host_url)
+    }
+  }
+
   var key_binds base.KeyBinds
   base.LoadJson(filepath.Join(datadir, "key_binds.json"), &key_binds)
   key_map = key_binds.MakeKeyMap()
@@ -78,6 +77,27 @@ func init() {
   wdy = 750
 }
 
+// Returns the url given with -host-url, e.g.
+//   haunts -host-url http://localhost:8080
+// or "" if there wasn't one.  The other modes have flags of their own, so
+// this is picked out of os.Args by hand like -ai-record.
+func hostUrlFlag() string {
+  args := os.Args[1:]
+  for i, arg := range args {
+    // Like the flag package, take either one dash or two.
+    if strings.HasPrefix(arg, "--") {
+      arg = arg[1:]
+    }
+    switch {
+    case arg == "-host-url" && i+1 < len(args):
+      return args[i+1]
+    case strings.HasPrefix(arg, "-host-url="):
+      return strings.TrimPrefix(arg, "-host-url=")
+    }
+  }
+  return ""
+}
+
 type draggerZoomer interface {
   Drag(float64, float64)
   Zoom(float64)
@@ -134,7 +154,7 @@ func editMode() {
         ui.RemoveChild(editor)
         editor_name = name
         editor = editors[editor_name]
-        loadAllRegistries()
+        game.LoadAllRegistries()
         editor.Reload()
         ui.AddChild(editor)
       }
@@ -207,6 +227,34 @@ func main() {
     }
   }()
   base.Log().Printf("Version %s", Version())
+  if isVerifying() {
+    // Nothing is drawn while verifying, so there's no need for a window.
+    base.SetHeadless(true)
+    game.LoadAllRegistries()
+    game.LoadAllEntities()
+    status := verifyTurn()
+    base.CloseLog()
+    os.Exit(status)
+  }
+  if isTournament() {
+    base.SetHeadless(true)
+    game.LoadAllRegistries()
+    game.LoadAllEntities()
+    status := runTournament()
+    base.CloseLog()
+    os.Exit(status)
+  }
+  if isAiReplay() {
+    base.SetHeadless(true)
+    game.LoadAllRegistries()
+    game.LoadAllEntities()
+    status := replayAiTurn()
+    base.CloseLog()
+    os.Exit(status)
+  }
+  if isAiRecording() {
+    game.SetAiRecording(true)
+  }
   sys.Startup()
   err := gl.Init()
   if err != nil {
@@ -231,7 +279,7 @@ func main() {
   if err != nil {
     panic(err.Error())
   }
-  loadAllRegistries()
+  game.LoadAllRegistries()
 
   // TODO: Might want to be able to reload stuff, but this is sensitive because it
   // is loading textures.  We should probably redo the sprite system so that this
@@ -266,9 +314,21 @@ func main() {
     base.Log().Printf("Restarted")
   }
   game.Restart()
+  if path := replayPath(); path != "" {
+    ui.RemoveChild(game_box)
+    game_box = &lowerLeftTable{gui.MakeAnchorBox(gui.Dims{1024, 768})}
+    err = game.InsertReplayFromFile(game_box, path)
+    if err != nil {
+      base.Error().Printf("%v", err)
+      game.Restart()
+    } else {
+      ui.AddChild(game_box)
+    }
+  }
 
   if base.IsDevel() {
     ui.AddChild(base.MakeConsole())
+    ui.AddChild(game.MakeAiDebugPanel())
   }
   sys.Think()
   // Wait until now to create the dictionary because the render thread needs
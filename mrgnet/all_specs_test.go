@@ -0,0 +1,13 @@
+package mrgnet_test
+
+import (
+  "github.com/orfjackal/gospec/src/gospec"
+  "testing"
+)
+
+func TestAllSpecs(t *testing.T) {
+  r := gospec.NewRunner()
+  r.AddSpec(DoActionSpec)
+  r.AddSpec(GameFileSpec)
+  gospec.MainGoTest(r, t)
+}
@@ -0,0 +1,71 @@
+package mrgnet
+
+import (
+  "reflect"
+  "sync"
+)
+
+// Creates a new account.  The response's Id and Token should be used for all
+// future requests.
+type RegisterRequest struct {
+  Name   string
+  Secret string
+}
+
+type RegisterResponse struct {
+  Err   string
+  Id    NetId
+  Token string
+}
+
+// Starts a new session for an existing account.
+type LoginRequest struct {
+  Name   string
+  Secret string
+}
+
+type LoginResponse struct {
+  Err   string
+  Id    NetId
+  Token string
+}
+
+var session struct {
+  sync.Mutex
+  token string
+}
+
+// Sets the session token that DoAction sends along with every request.
+func SetToken(token string) {
+  session.Lock()
+  defer session.Unlock()
+  session.token = token
+}
+
+func GetToken() string {
+  session.Lock()
+  defer session.Unlock()
+  return session.token
+}
+
+// If input is a request with an empty Token field this returns a copy of it
+// with Token set to the current session token, otherwise input is returned
+// unchanged.
+func addToken(input interface{}) interface{} {
+  token := GetToken()
+  if token == "" {
+    return input
+  }
+  v := reflect.Indirect(reflect.ValueOf(input))
+  if v.Kind() != reflect.Struct {
+    return input
+  }
+  field := v.FieldByName("Token")
+  if !field.IsValid() || field.Kind() != reflect.String || field.String() != "" {
+    return input
+  }
+  cp := reflect.New(v.Type()).Elem()
+  cp.Set(v)
+  cp.FieldByName("Token").SetString(token)
+  return cp.Interface()
+}
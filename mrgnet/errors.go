@@ -0,0 +1,112 @@
+package mrgnet
+
+import (
+  "context"
+  "fmt"
+)
+
+type ErrorKind int
+
+const (
+  // Couldn't reach the server at all.
+  ErrorNetwork ErrorKind = iota
+
+  // The request didn't finish before its deadline.
+  ErrorTimeout
+
+  // The request was cancelled before it finished.
+  ErrorCancelled
+
+  // The server responded, but with a non-200 status code.
+  ErrorStatus
+
+  // The server responded with something that couldn't be decoded, usually
+  // because the response was truncated.
+  ErrorDecode
+
+  // The request couldn't be encoded, this indicates a bug in the client.
+  ErrorEncode
+)
+
+// All errors returned from DoAction are of this type.  Note that a request can
+// succeed as far as DoAction is concerned and still have the server report an
+// error in the response's Err field.
+type Error struct {
+  Action string
+  Kind   ErrorKind
+
+  // Only set if Kind == ErrorStatus
+  Status int
+
+  // The underlying error, if there was one.
+  Err error
+}
+
+func (e *Error) Error() string {
+  var what string
+  switch e.Kind {
+  case ErrorNetwork:
+    what = "network error"
+  case ErrorTimeout:
+    what = "timed out"
+  case ErrorCancelled:
+    what = "cancelled"
+  case ErrorStatus:
+    what = fmt.Sprintf("server returned status %d", e.Status)
+  case ErrorDecode:
+    what = "unable to decode response"
+  case ErrorEncode:
+    what = "unable to encode request"
+  }
+  if e.Err != nil {
+    return fmt.Sprintf("mrgnet: %s: %s: %v", e.Action, what, e.Err)
+  }
+  return fmt.Sprintf("mrgnet: %s: %s", e.Action, what)
+}
+
+// Returns true if trying the same request again might work.
+func (e *Error) Temporary() bool {
+  switch e.Kind {
+  case ErrorNetwork, ErrorTimeout, ErrorDecode:
+    return true
+  case ErrorStatus:
+    return e.Status >= 500
+  }
+  return false
+}
+
+// Returns a short message that is suitable for showing to the user.
+func ErrorMessage(err error) string {
+  e, ok := err.(*Error)
+  if !ok {
+    return err.Error()
+  }
+  switch e.Kind {
+  case ErrorNetwork:
+    return "Couldn't connect to server."
+  case ErrorTimeout:
+    return "Timed out waiting for the server."
+  case ErrorCancelled:
+    return "Cancelled."
+  case ErrorStatus:
+    return fmt.Sprintf("The server had a problem (%d).", e.Status)
+  case ErrorDecode:
+    return "Got a garbled response from the server."
+  }
+  return "Something went wrong talking to the server."
+}
+
+// Wraps err in an *Error, using ctx to tell timeouts and cancellations apart
+// from other network errors.
+func makeError(ctx context.Context, action string, kind ErrorKind, err error) *Error {
+  if e, ok := err.(*Error); ok {
+    return e
+  }
+  switch ctx.Err() {
+  case context.DeadlineExceeded:
+    kind = ErrorTimeout
+  case context.Canceled:
+    kind = ErrorCancelled
+  }
+  return &Error{Action: action, Kind: kind, Err: err}
+}
@@ -3,64 +3,122 @@ package mrgnet
 import (
   "bytes"
   "compress/gzip"
+  "context"
   "crypto/rand"
   "encoding/gob"
-  "fmt"
-  "io"
-  "io/ioutil"
   "math/big"
-  "net/http"
-  "net/url"
   "time"
 )
 
 type NetId int64
 type GameKey string
 
-const Host_url = "http://mobrulesgames.appspot.com/"
+// How long DoAction will wait, including retries, before giving up.
+const Default_timeout = 10 * time.Second
 
-// const Host_url = "http://localhost:8080"
+// Idempotent actions are retried at most this many times if they fail in a
+// way that might be temporary.  The wait between retries starts at
+// Initial_backoff and doubles each time, up to Max_backoff.
+const Max_retries = 3
+const Initial_backoff = 250 * time.Millisecond
+const Max_backoff = 2 * time.Second
 
+// How long a server will hold on to a "wait" request before responding that
+// nothing has changed.
+const Wait_timeout = 25 * time.Second
+
+// Actions that can be safely repeated if we don't know whether or not the
+// server got them.
+var idempotent = map[string]bool{
+  "list":   true,
+  "status": true,
+  "wait":   true,
+}
+
+// Calls DoActionContext with a timeout of Default_timeout.
 func DoAction(name string, input, output interface{}) error {
-  zipit := true
-  buf := bytes.NewBuffer(nil)
-  var gzw io.Writer
-  if zipit {
-    gzw = gzip.NewWriter(buf)
-  } else {
-    gzw = buf
-  }
-  err := gob.NewEncoder(gzw).Encode(input)
+  ctx, cancel := context.WithTimeout(context.Background(), Default_timeout)
+  defer cancel()
+  return DoActionContext(ctx, name, input, output)
+}
+
+// Sends input to the server as the named action and decodes the server's
+// response into output.  The request goes through whatever Transport was last
+// passed to SetTransport, and carries the token last passed to SetToken.  Any
+// error returned will be an *Error.
+func DoActionContext(ctx context.Context, name string, input, output interface{}) error {
+  data, err := EncodeData(addToken(input))
   if err != nil {
-    return err
+    return &Error{Action: name, Kind: ErrorEncode, Err: err}
+  }
+  // fmt.Printf("Sending %d bytes\n", len(data))
+  backoff := Initial_backoff
+  for attempt := 0; ; attempt++ {
+    err := doActionOnce(ctx, name, data, output)
+    if err == nil {
+      return nil
+    }
+    if !idempotent[name] || !err.Temporary() || attempt >= Max_retries {
+      return err
+    }
+    select {
+    case <-time.After(backoff):
+    case <-ctx.Done():
+      return makeError(ctx, name, ErrorNetwork, ctx.Err())
+    }
+    backoff *= 2
+    if backoff > Max_backoff {
+      backoff = Max_backoff
+    }
   }
-  if zipit {
-    gzw.(*gzip.Writer).Close()
+}
+
+func doActionOnce(ctx context.Context, name string, data []byte, output interface{}) *Error {
+  data, err := GetTransport().RoundTrip(ctx, name, data)
+  if err != nil {
+    return makeError(ctx, name, ErrorNetwork, err)
   }
-  host_url := fmt.Sprintf("%s/%s", Host_url, name)
-  // fmt.Printf("Sending %d bytes\n", buf.Len())
-  r, err := http.PostForm(host_url, url.Values{"data": []string{string(buf.Bytes())}})
+  err = DecodeData(data, output)
   if err != nil {
-    return err
+    return makeError(ctx, name, ErrorDecode, err)
   }
+  return nil
+}
 
-  var gzr io.Reader
-  if zipit {
-    gzr, err = gzip.NewReader(r.Body)
-    if err != nil {
-      panic(err.Error())
-      return nil
-    }
-  } else {
-    gzr = r.Body
+// Blocks until one of the games that req.Id is playing in has changed since
+// req.Since, or until the server gives up after about Wait_timeout, whichever
+// comes first.  Call this in a loop, passing back resp.Seq each time, to get
+// notified of every change without having to poll.
+func Wait(ctx context.Context, req WaitRequest, resp *WaitResponse) error {
+  ctx, cancel := context.WithTimeout(ctx, Wait_timeout+Default_timeout)
+  defer cancel()
+  return DoActionContext(ctx, "wait", req, resp)
+}
+
+// Gobs and then gzips v.  This is the format that both requests and
+// responses use on the wire.
+func EncodeData(v interface{}) ([]byte, error) {
+  buf := bytes.NewBuffer(nil)
+  gzw := gzip.NewWriter(buf)
+  err := gob.NewEncoder(gzw).Encode(v)
+  if err != nil {
+    return nil, err
   }
-  data, err := ioutil.ReadAll(gzr)
+  err = gzw.Close()
   if err != nil {
-    panic(err.Error())
-    return nil
+    return nil, err
   }
-  dec := gob.NewDecoder(bytes.NewBuffer(data))
-  return dec.Decode(output)
+  return buf.Bytes(), nil
+}
+
+// Reverses EncodeData, decoding data into v.
+func DecodeData(data []byte, v interface{}) error {
+  gzr, err := gzip.NewReader(bytes.NewBuffer(data))
+  if err != nil {
+    return err
+  }
+  defer gzr.Close()
+  return gob.NewDecoder(gzr).Decode(v)
 }
 
 // Creates a random id that will be unique among all other engines with high
@@ -80,14 +138,23 @@ type User struct {
   Name string
 }
 
-type UpdateUserRequest User
+// Every request that acts on behalf of a user carries the Token that the
+// server handed out when that user registered or logged in.  DoAction fills
+// it in automatically.
+type UpdateUserRequest struct {
+  Id    NetId
+  Token string
+  Name  string
+}
+
 type UpdateUserResponse struct {
   User
   Err string
 }
 
 type NewGameRequest struct {
-  Id NetId
+  Id    NetId
+  Token string
 }
 
 type NewGameResponse struct {
@@ -98,6 +165,7 @@ type NewGameResponse struct {
 
 type ListGamesRequest struct {
   Id        NetId
+  Token     string
   Unstarted bool
 }
 
@@ -111,6 +179,7 @@ type ListGamesResponse struct {
 // playback, with either new State or new Execs
 type UpdateGameRequest struct {
   Id        NetId
+  Token     string
   Game_key  GameKey
   Round     int
   Intruders bool
@@ -128,6 +197,7 @@ type UpdateGameResponse struct {
 
 type JoinGameRequest struct {
   Id       NetId
+  Token    string
   Game_key GameKey
 }
 
@@ -138,6 +208,7 @@ type JoinGameResponse struct {
 
 type StatusRequest struct {
   Id         NetId
+  Token      string
   Game_key   GameKey
   Sizes_only bool
 }
@@ -147,8 +218,35 @@ type StatusResponse struct {
   Game *Game
 }
 
+// Asks the server to respond as soon as any game that Id is playing in is
+// updated, joined or killed.
+type WaitRequest struct {
+  Id    NetId
+  Token string
+
+  // If set only changes to this game are reported.
+  Game_key GameKey
+
+  // Only changes that happened after Since are reported.  If this is zero the
+  // server responds immediately with the current Seq, which can be used as
+  // Since in the next request.
+  Since int64
+}
+
+type WaitResponse struct {
+  Err string
+
+  // Pass this back as Since in the next WaitRequest.
+  Seq int64
+
+  // The games that changed, if this is empty then the server timed out
+  // waiting for something to happen.
+  Game_keys []GameKey
+}
+
 type KillRequest struct {
   Id       NetId
+  Token    string
   Game_key GameKey
 }
 
@@ -156,6 +254,20 @@ type KillResponse struct {
   Err string
 }
 
+// Records which side won a game.  A player that says the other side won is
+// always believed, a player that says their own side won is only believed if
+// the last state that the server has for the game agrees.
+type SetWinnerRequest struct {
+  Id        NetId
+  Token     string
+  Game_key  GameKey
+  Intruders bool
+}
+
+type SetWinnerResponse struct {
+  Err string
+}
+
 type Game struct {
   Name string
 
@@ -0,0 +1,139 @@
+package mrgnet_test
+
+import (
+  "bytes"
+  "context"
+  "errors"
+  "github.com/mik3cap/haunts/mrgnet"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "time"
+)
+
+// Fails the first failures requests, then echoes back whatever it was sent.
+type flakyTransport struct {
+  failures int
+  calls    int
+}
+
+func (ft *flakyTransport) RoundTrip(ctx context.Context, name string, data []byte) ([]byte, error) {
+  ft.calls++
+  if ft.calls <= ft.failures {
+    return nil, errors.New("connection reset")
+  }
+  return data, nil
+}
+
+// Never responds until ctx is done.
+type stuckTransport struct{}
+
+func (stuckTransport) RoundTrip(ctx context.Context, name string, data []byte) ([]byte, error) {
+  <-ctx.Done()
+  return nil, ctx.Err()
+}
+
+func DoActionSpec(c gospec.Context) {
+  old := mrgnet.GetTransport()
+  defer mrgnet.SetTransport(old)
+  req := mrgnet.StatusRequest{Id: 10, Game_key: "foo"}
+
+  c.Specify("Idempotent actions are retried.", func() {
+    ft := &flakyTransport{failures: 2}
+    mrgnet.SetTransport(ft)
+    var resp mrgnet.StatusRequest
+    err := mrgnet.DoAction("status", req, &resp)
+    c.Expect(err, Equals, nil)
+    c.Expect(ft.calls, Equals, 3)
+    c.Expect(resp, Equals, req)
+  })
+
+  c.Specify("Retries are bounded.", func() {
+    ft := &flakyTransport{failures: 100}
+    mrgnet.SetTransport(ft)
+    var resp mrgnet.StatusRequest
+    err := mrgnet.DoAction("list", req, &resp)
+    c.Assume(err, Not(Equals), nil)
+    c.Expect(ft.calls, Equals, mrgnet.Max_retries+1)
+    c.Expect(err.(*mrgnet.Error).Kind, Equals, mrgnet.ErrorNetwork)
+  })
+
+  c.Specify("Other actions are not retried.", func() {
+    ft := &flakyTransport{failures: 1}
+    mrgnet.SetTransport(ft)
+    var resp mrgnet.StatusRequest
+    err := mrgnet.DoAction("update", req, &resp)
+    c.Expect(err, Not(Equals), nil)
+    c.Expect(ft.calls, Equals, 1)
+  })
+
+  c.Specify("Garbage responses are reported rather than panicking.", func() {
+    mrgnet.SetTransport(garbageTransport{})
+    var resp mrgnet.StatusRequest
+    err := mrgnet.DoAction("update", req, &resp)
+    c.Assume(err, Not(Equals), nil)
+    c.Expect(err.(*mrgnet.Error).Kind, Equals, mrgnet.ErrorDecode)
+  })
+
+  c.Specify("Requests time out.", func() {
+    mrgnet.SetTransport(stuckTransport{})
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    var resp mrgnet.StatusRequest
+    err := mrgnet.DoActionContext(ctx, "status", req, &resp)
+    c.Assume(err, Not(Equals), nil)
+    c.Expect(err.(*mrgnet.Error).Kind, Equals, mrgnet.ErrorTimeout)
+  })
+}
+
+type garbageTransport struct{}
+
+func (garbageTransport) RoundTrip(ctx context.Context, name string, data []byte) ([]byte, error) {
+  return []byte("this is not gzip"), nil
+}
+
+func GameFileSpec(c gospec.Context) {
+  game := mrgnet.Game{
+    Name:   "foo",
+    Before: [][]byte{[]byte("b0"), []byte("b1")},
+    Execs:  [][]byte{[]byte("e0"), []byte("e1")},
+    After:  [][]byte{[]byte("a0"), []byte("a1")},
+    Script: []byte("script"),
+  }
+
+  c.Specify("Exported games can be read back.", func() {
+    buf := bytes.NewBuffer(nil)
+    c.Assume(mrgnet.WriteGame(buf, &game), Equals, nil)
+    read, err := mrgnet.ReadGame(buf)
+    c.Assume(err, Equals, nil)
+    c.Expect(read.Name, Equals, "foo")
+    c.Expect(string(read.Script), Equals, "script")
+    c.Assume(len(read.Execs), Equals, 2)
+    c.Expect(string(read.Before[1]), Equals, "b1")
+    c.Expect(string(read.Execs[1]), Equals, "e1")
+    c.Expect(string(read.After[1]), Equals, "a1")
+  })
+
+  c.Specify("Other files are rejected.", func() {
+    data, err := mrgnet.EncodeData(game)
+    c.Assume(err, Equals, nil)
+    _, err = mrgnet.ReadGame(bytes.NewBuffer(data))
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Games with missing states are rejected.", func() {
+    game.After = game.After[0:1]
+    buf := bytes.NewBuffer(nil)
+    c.Assume(mrgnet.WriteGame(buf, &game), Equals, nil)
+    _, err := mrgnet.ReadGame(buf)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Turns alternate between the denizens and the intruders.", func() {
+    round, intruders := mrgnet.TurnRound(0)
+    c.Expect(round, Equals, 0)
+    c.Expect(intruders, Equals, false)
+    round, intruders = mrgnet.TurnRound(3)
+    c.Expect(round, Equals, 1)
+    c.Expect(intruders, Equals, true)
+  })
+}
@@ -0,0 +1,54 @@
+package mrgnet
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+)
+
+// Every exported game starts with this so that other files can be rejected
+// before trying to decode them.
+const replay_magic = "haunts replay\n"
+
+// Writes game in a form that ReadGame can load, so that a game can be
+// replayed without the server.
+func WriteGame(w io.Writer, game *Game) error {
+  data, err := EncodeData(game)
+  if err != nil {
+    return err
+  }
+  _, err = io.WriteString(w, replay_magic)
+  if err != nil {
+    return err
+  }
+  _, err = w.Write(data)
+  return err
+}
+
+// Reads a game written by WriteGame.
+func ReadGame(r io.Reader) (*Game, error) {
+  data, err := ioutil.ReadAll(r)
+  if err != nil {
+    return nil, err
+  }
+  if len(data) < len(replay_magic) || string(data[0:len(replay_magic)]) != replay_magic {
+    return nil, errors.New("Not an exported game.")
+  }
+  var game Game
+  err = DecodeData(data[len(replay_magic):], &game)
+  if err != nil {
+    return nil, err
+  }
+  if len(game.Before) != len(game.Execs) || len(game.After) != len(game.Execs) {
+    return nil, errors.New(fmt.Sprintf("Game has %d befores and %d afters for %d execs.", len(game.Before), len(game.After), len(game.Execs)))
+  }
+  return &game, nil
+}
+
+// Returns the round that the turn with the specified index was played in,
+// and whether it was the intruders' turn.  This is the inverse of how the
+// server indexes turns.
+func TurnRound(turn int) (round int, intruders bool) {
+  return turn / 2, turn%2 == 1
+}
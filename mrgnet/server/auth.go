@@ -0,0 +1,157 @@
+package server
+
+import (
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/subtle"
+  "encoding/hex"
+  "errors"
+  "github.com/mik3cap/haunts/mrgnet"
+  "strings"
+)
+
+// An Account lets a player prove that they own a NetId.  Secrets are never
+// stored, only a salted hash of them.
+type Account struct {
+  Name string
+  Id   mrgnet.NetId
+  Salt []byte
+  Hash []byte
+}
+
+var errNotLoggedIn = errors.New("Not logged in.")
+var errBadLogin = errors.New("Unknown name or wrong secret.")
+
+const min_secret_length = 6
+const max_name_length = 32
+
+// Number of times the secret is run through sha256.  Makes brute forcing a
+// stolen account file more expensive.
+const hash_rounds = 4096
+
+// Account names are case-insensitive.
+func accountName(name string) string {
+  return strings.ToLower(strings.TrimSpace(name))
+}
+
+func hashSecret(salt []byte, secret string) []byte {
+  sum := sha256.Sum256(append(append([]byte{}, salt...), secret...))
+  for i := 1; i < hash_rounds; i++ {
+    sum = sha256.Sum256(append(append([]byte{}, salt...), sum[:]...))
+  }
+  return sum[:]
+}
+
+func randomBytes(n int) ([]byte, error) {
+  b := make([]byte, n)
+  _, err := rand.Read(b)
+  return b, err
+}
+
+// Makes a new session token for id.
+func (s *Server) newSession(id mrgnet.NetId) (string, error) {
+  b, err := randomBytes(32)
+  if err != nil {
+    return "", err
+  }
+  token := hex.EncodeToString(b)
+  err = s.store.PutSession(token, id)
+  if err != nil {
+    return "", err
+  }
+  return token, nil
+}
+
+// Returns an error unless token is a session token for id.
+func (s *Server) authenticate(id mrgnet.NetId, token string) error {
+  if id == 0 || token == "" {
+    return errNotLoggedIn
+  }
+  owner, err := s.store.GetSession(token)
+  if err == ErrNotFound {
+    return errNotLoggedIn
+  }
+  if err != nil {
+    return err
+  }
+  if owner != id {
+    return errNotLoggedIn
+  }
+  return nil
+}
+
+func (s *Server) register(req *mrgnet.RegisterRequest) *mrgnet.RegisterResponse {
+  var resp mrgnet.RegisterResponse
+  name := accountName(req.Name)
+  if name == "" || len(name) > max_name_length {
+    resp.Err = "Names must be between 1 and 32 characters long."
+    return &resp
+  }
+  if len(req.Secret) < min_secret_length {
+    resp.Err = "Secrets must be at least 6 characters long."
+    return &resp
+  }
+  _, err := s.store.GetAccount(name)
+  if err == nil {
+    resp.Err = "That name is already taken."
+    return &resp
+  }
+  if err != ErrNotFound {
+    resp.Err = err.Error()
+    return &resp
+  }
+
+  // Make sure we don't hand out an id that already belongs to someone.
+  var id mrgnet.NetId
+  for id == 0 {
+    id = mrgnet.RandomId()
+    if _, err := s.store.GetUser(id); err != ErrNotFound {
+      id = 0
+    }
+  }
+  account := Account{Name: name, Id: id}
+  account.Salt, err = randomBytes(16)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  account.Hash = hashSecret(account.Salt, req.Secret)
+  err = s.store.PutAccount(account)
+  if err == nil {
+    err = s.store.PutUser(mrgnet.User{Id: id, Name: strings.TrimSpace(req.Name)})
+  }
+  if err == nil {
+    resp.Token, err = s.newSession(id)
+  }
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  resp.Id = id
+  return &resp
+}
+
+func (s *Server) login(req *mrgnet.LoginRequest) *mrgnet.LoginResponse {
+  var resp mrgnet.LoginResponse
+  account, err := s.store.GetAccount(accountName(req.Name))
+  if err == ErrNotFound {
+    resp.Err = errBadLogin.Error()
+    return &resp
+  }
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  hash := hashSecret(account.Salt, req.Secret)
+  if subtle.ConstantTimeCompare(hash, account.Hash) != 1 {
+    resp.Err = errBadLogin.Error()
+    return &resp
+  }
+  resp.Token, err = s.newSession(account.Id)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  resp.Id = account.Id
+  return &resp
+}
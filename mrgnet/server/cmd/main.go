@@ -0,0 +1,53 @@
+// Runs a standalone server for online games.  Point clients at it by starting
+// them with -host-url, or by setting the HAUNTS_HOST_URL environment
+// variable or "host url" in their store, to http://<addr>/.
+//
+// If -verify is set every turn is checked by running that command, for
+// example "haunts -verify", before it is accepted.
+package main
+
+import (
+  "flag"
+  "fmt"
+  "github.com/mik3cap/haunts/mrgnet/server"
+  "log"
+  "net/http"
+  "os"
+  "strings"
+  "time"
+)
+
+var addr = flag.String("addr", ":8080", "Address to listen on.")
+var dir = flag.String("dir", "", "Directory to store users and games in.  If not set everything is kept in memory.")
+var verify = flag.String("verify", "", "Command to verify turns with.  If not set turns are not verified.")
+var verify_timeout = flag.Duration("verify_timeout", time.Minute, "How long to let the verify command run before rejecting a turn.")
+
+func main() {
+  flag.Parse()
+  var store server.Store
+  if *dir == "" {
+    store = server.MakeMemoryStore()
+  } else {
+    var err error
+    store, err = server.MakeFileStore(*dir)
+    if err != nil {
+      fmt.Printf("Unable to open store in %s: %v\n", *dir, err)
+      os.Exit(1)
+    }
+  }
+  s := server.MakeServer(store)
+  s.Log = log.New(os.Stderr, "mrgnet: ", log.LstdFlags)
+  if fields := strings.Fields(*verify); len(fields) > 0 {
+    s.Verifier = server.CommandVerifier{
+      Path:    fields[0],
+      Args:    fields[1:],
+      Timeout: *verify_timeout,
+    }
+  }
+  s.Log.Printf("Listening on %s", *addr)
+  err := http.ListenAndServe(*addr, s)
+  if err != nil {
+    fmt.Printf("Server failed: %v\n", err)
+    os.Exit(1)
+  }
+}
@@ -0,0 +1,8 @@
+package server
+
+// Returns how many games have changes remembered for wait requests.
+func (s *Server) NumChanges() int {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  return len(s.changes)
+}
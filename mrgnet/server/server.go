@@ -0,0 +1,772 @@
+// Package server implements the server side of the protocol that
+// mrgnet.DoAction speaks, so that online games can be hosted without the
+// original appspot host.
+package server
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/mrgnet"
+  "log"
+  "net/http"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+type Server struct {
+  store Store
+
+  // All access to the store goes through this lock.
+  mutex sync.Mutex
+
+  // If non-nil, errors that happen while handling requests are logged here.
+  Log *log.Logger
+
+  // How long a wait request is held before responding that nothing changed.
+  Wait_timeout time.Duration
+
+  // How long changes to games are remembered for wait requests.  Anyone
+  // waiting on changes since before then is told about all of their games
+  // instead.
+  Change_ttl time.Duration
+
+  // If non-nil, every turn's execs are checked with this before they are
+  // accepted.
+  Verifier Verifier
+
+  // Everything below is protected by mutex.
+
+  // Incremented every time a game changes.  This starts at the current time
+  // so that it keeps increasing across restarts.
+  seq int64
+
+  // Changes up to and including this seq have been forgotten, either because
+  // they happened before the server started or because they were older than
+  // Change_ttl.
+  first_seq int64
+
+  // The last change to each game within the last Change_ttl.
+  changes map[mrgnet.GameKey]gameChange
+
+  // Closed and replaced every time seq changes, to wake up anyone waiting.
+  wake chan struct{}
+}
+
+type gameChange struct {
+  seq     int64
+  time    time.Time
+  players [2]mrgnet.NetId
+}
+
+// Default for Server.Change_ttl.  Clients that are paying attention wait
+// again well within this, so they never notice changes being forgotten.
+const Default_change_ttl = time.Hour
+
+func MakeServer(store Store) *Server {
+  s := Server{
+    store:        store,
+    Wait_timeout: mrgnet.Wait_timeout,
+    Change_ttl:   Default_change_ttl,
+    seq:          time.Now().UnixNano(),
+    changes:      make(map[mrgnet.GameKey]gameChange),
+    wake:         make(chan struct{}),
+  }
+  s.first_seq = s.seq
+  return &s
+}
+
+// The request and response types for each action, indexed by the name that
+// is passed to mrgnet.DoAction.
+var actions = map[string]struct {
+  request func() interface{}
+  handle  func(s *Server, req interface{}) interface{}
+}{
+  "register": {
+    func() interface{} { return &mrgnet.RegisterRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.register(req.(*mrgnet.RegisterRequest)) },
+  },
+  "login": {
+    func() interface{} { return &mrgnet.LoginRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.login(req.(*mrgnet.LoginRequest)) },
+  },
+  "user": {
+    func() interface{} { return &mrgnet.UpdateUserRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.updateUser(req.(*mrgnet.UpdateUserRequest)) },
+  },
+  "new": {
+    func() interface{} { return &mrgnet.NewGameRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.newGame(req.(*mrgnet.NewGameRequest)) },
+  },
+  "list": {
+    func() interface{} { return &mrgnet.ListGamesRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.listGames(req.(*mrgnet.ListGamesRequest)) },
+  },
+  "update": {
+    func() interface{} { return &mrgnet.UpdateGameRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.updateGame(req.(*mrgnet.UpdateGameRequest)) },
+  },
+  "join": {
+    func() interface{} { return &mrgnet.JoinGameRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.joinGame(req.(*mrgnet.JoinGameRequest)) },
+  },
+  "status": {
+    func() interface{} { return &mrgnet.StatusRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.status(req.(*mrgnet.StatusRequest)) },
+  },
+  "kill": {
+    func() interface{} { return &mrgnet.KillRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.kill(req.(*mrgnet.KillRequest)) },
+  },
+  "wait": {
+    func() interface{} { return &mrgnet.WaitRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.wait(req.(*mrgnet.WaitRequest)) },
+  },
+  "winner": {
+    func() interface{} { return &mrgnet.SetWinnerRequest{} },
+    func(s *Server, req interface{}) interface{} { return s.setWinner(req.(*mrgnet.SetWinnerRequest)) },
+  },
+}
+
+// These actions can block for a long time, so they take care of locking the
+// server themselves.
+var unlocked_actions = map[string]bool{
+  "update": true,
+  "wait":   true,
+  "winner": true,
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+  if s.Log != nil {
+    s.Log.Printf(format, args...)
+  }
+}
+
+// Decodes data, which should be the encoded request for the named action,
+// runs the action, and returns the encoded response.
+func (s *Server) Handle(name string, data []byte) ([]byte, error) {
+  action, ok := actions[name]
+  if !ok {
+    return nil, errors.New(fmt.Sprintf("Unknown action '%s'.", name))
+  }
+  req := action.request()
+  err := mrgnet.DecodeData(data, req)
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("Unable to decode '%s' request: %v", name, err))
+  }
+  var resp interface{}
+  if unlocked_actions[name] {
+    resp = action.handle(s, req)
+  } else {
+    s.mutex.Lock()
+    resp = action.handle(s, req)
+    s.mutex.Unlock()
+  }
+  return mrgnet.EncodeData(resp)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    http.Error(w, "Only POST is supported.", http.StatusMethodNotAllowed)
+    return
+  }
+  // Clients build their urls as Host_url + "/" + name, so there may be more
+  // than one leading slash.
+  name := strings.Trim(r.URL.Path, "/")
+  data, err := s.Handle(name, []byte(r.FormValue("data")))
+  if err != nil {
+    s.logf("%s: %v", name, err)
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  w.Header().Set("Content-Type", "application/octet-stream")
+  w.Write(data)
+}
+
+// Returns the user with the specified id, creating one with a default name
+// if necessary.
+func (s *Server) getUser(id mrgnet.NetId) (mrgnet.User, error) {
+  user, err := s.store.GetUser(id)
+  if err == ErrNotFound {
+    user = mrgnet.User{Id: id, Name: fmt.Sprintf("Player %04d", int64(id)%10000)}
+    err = s.store.PutUser(user)
+  }
+  return user, err
+}
+
+func (s *Server) updateUser(req *mrgnet.UpdateUserRequest) *mrgnet.UpdateUserResponse {
+  var resp mrgnet.UpdateUserResponse
+  if err := s.authenticate(req.Id, req.Token); err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  user, err := s.getUser(req.Id)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  if req.Name != "" && req.Name != user.Name {
+    user.Name = req.Name
+    err = s.store.PutUser(user)
+    if err != nil {
+      resp.Err = err.Error()
+      return &resp
+    }
+  }
+  resp.User = user
+  return &resp
+}
+
+func (s *Server) newGame(req *mrgnet.NewGameRequest) *mrgnet.NewGameResponse {
+  var resp mrgnet.NewGameResponse
+  if err := s.authenticate(req.Id, req.Token); err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  user, err := s.getUser(req.Id)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  var game mrgnet.Game
+  game.Name = fmt.Sprintf("%s's game", user.Name)
+  game.Created = time.Now()
+  game.Denizens_id = user.Id
+  game.Denizens_name = user.Name
+  key := mrgnet.GameKey(fmt.Sprintf("%x", int64(mrgnet.RandomId())))
+  err = s.store.PutGame(key, &game)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  resp.Name = game.Name
+  resp.Game_key = key
+  return &resp
+}
+
+func isPlayer(game *mrgnet.Game, id mrgnet.NetId) bool {
+  return id != 0 && (game.Denizens_id == id || game.Intruders_id == id)
+}
+
+// If req.Unstarted is true this lists all games that are waiting for an
+// opponent and that the requester could join, otherwise it lists all games
+// that the requester is playing in that haven't finished yet.
+func (s *Server) listGames(req *mrgnet.ListGamesRequest) *mrgnet.ListGamesResponse {
+  var resp mrgnet.ListGamesResponse
+  if err := s.authenticate(req.Id, req.Token); err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  keys, err := s.store.GameKeys()
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  for _, key := range keys {
+    game, err := s.store.GetGame(key)
+    if err != nil {
+      s.logf("Unable to load game '%s': %v", key, err)
+      continue
+    }
+    if game.Winner != 0 {
+      continue
+    }
+    if req.Unstarted {
+      if game.Intruders_id != 0 || game.Denizens_id == req.Id {
+        continue
+      }
+    } else {
+      if !isPlayer(game, req.Id) {
+        continue
+      }
+    }
+    // Nobody needs the full state of every game just to list them, but the
+    // menu uses the number of execs to figure out whose turn it is.
+    stripPlaybacks(game)
+    resp.Games = append(resp.Games, *game)
+    resp.Game_keys = append(resp.Game_keys, key)
+  }
+  return &resp
+}
+
+func (s *Server) joinGame(req *mrgnet.JoinGameRequest) *mrgnet.JoinGameResponse {
+  var resp mrgnet.JoinGameResponse
+  if err := s.authenticate(req.Id, req.Token); err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  game, err := s.store.GetGame(req.Game_key)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  if game.Denizens_id == req.Id {
+    resp.Err = "You can't join your own game."
+    return &resp
+  }
+  if game.Intruders_id != 0 && game.Intruders_id != req.Id {
+    resp.Err = "That game already has two players."
+    return &resp
+  }
+  user, err := s.getUser(req.Id)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  game.Intruders_id = user.Id
+  game.Intruders_name = user.Name
+  err = s.store.PutGame(req.Game_key, game)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  s.notify(req.Game_key, game)
+  resp.Successful = true
+  return &resp
+}
+
+func (s *Server) status(req *mrgnet.StatusRequest) *mrgnet.StatusResponse {
+  var resp mrgnet.StatusResponse
+  if err := s.authenticate(req.Id, req.Token); err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  game, err := s.store.GetGame(req.Game_key)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  if !isPlayer(game, req.Id) {
+    resp.Err = "You are not a player in that game."
+    return &resp
+  }
+  if req.Sizes_only {
+    stripPlaybacks(game)
+  }
+  resp.Game = game
+  return &resp
+}
+
+// Drops all of the data from game's playbacks but leaves their lengths intact.
+func stripPlaybacks(game *mrgnet.Game) {
+  game.Before = make([][]byte, len(game.Before))
+  game.Execs = make([][]byte, len(game.Execs))
+  game.After = make([][]byte, len(game.After))
+  game.Script = nil
+}
+
+// Places data at index in *list.  Only the last element may be replaced, or a
+// new element may be appended, anything else indicates that the client is out
+// of sync with the server.
+func setPlayback(list *[][]byte, index int, data []byte) error {
+  switch {
+  case index == len(*list):
+    *list = append(*list, data)
+  case index == len(*list)-1:
+    (*list)[index] = data
+  default:
+    return errors.New(fmt.Sprintf("Tried to update playback %d, but there are %d.", index, len(*list)))
+  }
+  return nil
+}
+
+// Each round has two turns, Denizens go first.  This matches the client's
+// turn numbering where Game.Turn == index + 1.
+func turnIndex(req *mrgnet.UpdateGameRequest) int {
+  index := 2 * req.Round
+  if req.Intruders {
+    index++
+  }
+  return index
+}
+
+func isExecsUpdate(req *mrgnet.UpdateGameRequest) bool {
+  return req.Script == nil && req.Before == nil && req.Execs != nil
+}
+
+func isStateUpdate(req *mrgnet.UpdateGameRequest) bool {
+  return req.Script == nil && req.Before != nil
+}
+
+// Verification can take a while, so it is done without holding the lock.
+func (s *Server) updateGame(req *mrgnet.UpdateGameRequest) *mrgnet.UpdateGameResponse {
+  var before []byte
+  verified := false
+  if s.Verifier != nil && (isExecsUpdate(req) || isStateUpdate(req)) {
+    var err error
+    before, verified, err = s.verifyUpdate(req)
+    if err != nil {
+      return &mrgnet.UpdateGameResponse{Err: err.Error()}
+    }
+  }
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  return s.applyUpdate(req, before, verified)
+}
+
+// Runs s.Verifier on the turn that req is submitting execs for, or if req
+// is submitting the state that a turn starts from, on the start of that turn
+// from the end of the previous one.  If the turn was verified this returns
+// the state that it was verified against.  If the request is bad in some
+// other way nothing is verified, and applyUpdate is left to reject it.
+func (s *Server) verifyUpdate(req *mrgnet.UpdateGameRequest) ([]byte, bool, error) {
+  s.mutex.Lock()
+  err := s.authenticate(req.Id, req.Token)
+  var game *mrgnet.Game
+  if err == nil {
+    game, err = s.store.GetGame(req.Game_key)
+  }
+  s.mutex.Unlock()
+  if err != nil {
+    return nil, false, nil
+  }
+  index := turnIndex(req)
+  if req.Round < 0 || !isPlayer(game, req.Id) {
+    return nil, false, nil
+  }
+  turn := Turn{
+    Script:    game.Script,
+    Round:     req.Round,
+    Intruders: req.Intruders,
+    Execs:     req.Execs,
+    After:     req.After,
+  }
+  if isStateUpdate(req) {
+    // The first turn starts from whatever the level script set up, so
+    // there is nothing to check it against.
+    if index == 0 || index > len(game.After) {
+      return nil, false, nil
+    }
+    turn.Before = game.After[index-1]
+    turn.After = req.Before
+    turn.Start = true
+  } else {
+    if index >= len(game.Before) {
+      return nil, false, nil
+    }
+    turn.Before = game.Before[index]
+  }
+  err = s.Verifier.VerifyTurn(turn)
+  if err != nil {
+    s.logf("Rejected turn %d of game '%s': %v", index, req.Game_key, err)
+    return nil, false, errors.New(fmt.Sprintf("Turn failed verification: %v", err))
+  }
+  return turn.Before, true, nil
+}
+
+// Must be called with s.mutex held.  If there is a Verifier then execs, and
+// the states that turns after the first start from, are only accepted if
+// verified is true and the game hasn't changed from before since.
+func (s *Server) applyUpdate(req *mrgnet.UpdateGameRequest, before []byte, verified bool) *mrgnet.UpdateGameResponse {
+  var resp mrgnet.UpdateGameResponse
+  if err := s.authenticate(req.Id, req.Token); err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  game, err := s.store.GetGame(req.Game_key)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  if !isPlayer(game, req.Id) {
+    resp.Err = "You are not a player in that game."
+    return &resp
+  }
+  if req.Script == nil {
+    // Players can only update their own side's turns.
+    owner := game.Denizens_id
+    if req.Intruders {
+      owner = game.Intruders_id
+    }
+    if owner != req.Id {
+      resp.Err = "You can't update the other player's turn."
+      return &resp
+    }
+  }
+
+  if req.Round < 0 {
+    resp.Err = fmt.Sprintf("Invalid round %d.", req.Round)
+    return &resp
+  }
+  index := turnIndex(req)
+
+  switch {
+  case req.Script != nil:
+    if game.Script == nil {
+      game.Script = req.Script
+    }
+
+  case req.Before != nil:
+    // Once a turn has been played the state it started from is what its
+    // execs were checked against, so it can't change, and each turn has to
+    // start from where the last one left off.
+    switch {
+    case index < len(game.Execs):
+      err = errors.New(fmt.Sprintf("Turn %d has already been played.", index))
+    case index > len(game.After):
+      err = errors.New(fmt.Sprintf("Got the state for turn %d before turn %d finished.", index, index-1))
+    case s.Verifier != nil && index > 0 && (!verified || !bytes.Equal(before, game.After[index-1])):
+      err = errors.New("The previous turn changed while this one was being verified.")
+    default:
+      err = setPlayback(&game.Before, index, req.Before)
+    }
+
+  case req.Execs != nil || req.After != nil:
+    if req.Execs == nil {
+      err = errors.New(fmt.Sprintf("Got the state turn %d ended in without its execs.", index))
+      break
+    }
+    if len(game.Before) <= index {
+      err = errors.New(fmt.Sprintf("Got execs for turn %d before its state.", index))
+      break
+    }
+    if s.Verifier != nil && (!verified || !bytes.Equal(before, game.Before[index])) {
+      err = errors.New("The turn changed while it was being verified.")
+      break
+    }
+    err = setPlayback(&game.Execs, index, req.Execs)
+    if err == nil {
+      err = setPlayback(&game.After, index, req.After)
+    }
+
+  default:
+    err = errors.New("Update request didn't specify anything to update.")
+  }
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+
+  err = s.store.PutGame(req.Game_key, game)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  s.notify(req.Game_key, game)
+  return &resp
+}
+
+// Returns the most recent state of game, the state its last turn started
+// from or ended in, or nil if nothing has happened yet.
+func latestState(game *mrgnet.Game) []byte {
+  if len(game.Before) > len(game.After) {
+    return game.Before[len(game.Before)-1]
+  }
+  if len(game.After) > 0 {
+    return game.After[len(game.After)-1]
+  }
+  return nil
+}
+
+// Returns the game that req is for and the player on the side that req says
+// won it.  Must be called with s.mutex held.
+func (s *Server) checkWinner(req *mrgnet.SetWinnerRequest) (*mrgnet.Game, mrgnet.NetId, error) {
+  if err := s.authenticate(req.Id, req.Token); err != nil {
+    return nil, 0, err
+  }
+  game, err := s.store.GetGame(req.Game_key)
+  if err != nil {
+    return nil, 0, err
+  }
+  if !isPlayer(game, req.Id) {
+    return nil, 0, errors.New("You are not a player in that game.")
+  }
+  winner := game.Denizens_id
+  if req.Intruders {
+    winner = game.Intruders_id
+  }
+  if winner == 0 {
+    return nil, 0, errors.New("Nobody is playing that side yet.")
+  }
+  if game.Winner != 0 && game.Winner != winner {
+    return nil, 0, errors.New("That game has already been won by the other side.")
+  }
+  return game, winner, nil
+}
+
+// Verification can take a while, so like updateGame it is done without
+// holding the lock.
+func (s *Server) setWinner(req *mrgnet.SetWinnerRequest) *mrgnet.SetWinnerResponse {
+  var resp mrgnet.SetWinnerResponse
+  s.mutex.Lock()
+  game, winner, err := s.checkWinner(req)
+  s.mutex.Unlock()
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  if game.Winner != 0 {
+    return &resp
+  }
+
+  // Nobody claims to have lost unless they did, but a claim to have won is
+  // checked against the last state this player sent.
+  var state []byte
+  if winner == req.Id && s.Verifier != nil {
+    state = latestState(game)
+    if state == nil {
+      resp.Err = "Nothing has happened in that game yet."
+      return &resp
+    }
+    err = s.Verifier.VerifyTurn(Turn{
+      Script:    game.Script,
+      Intruders: req.Intruders,
+      After:     state,
+      Winner:    true,
+    })
+    if err != nil {
+      s.logf("Rejected the winner of game '%s': %v", req.Game_key, err)
+      resp.Err = fmt.Sprintf("The winner failed verification: %v", err)
+      return &resp
+    }
+  }
+
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  game, winner, err = s.checkWinner(req)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  if game.Winner != 0 {
+    return &resp
+  }
+  if state != nil && !bytes.Equal(state, latestState(game)) {
+    resp.Err = "The game changed while its winner was being verified."
+    return &resp
+  }
+  game.Winner = winner
+  err = s.store.PutGame(req.Game_key, game)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  s.notify(req.Game_key, game)
+  return &resp
+}
+
+func (s *Server) kill(req *mrgnet.KillRequest) *mrgnet.KillResponse {
+  var resp mrgnet.KillResponse
+  if err := s.authenticate(req.Id, req.Token); err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  game, err := s.store.GetGame(req.Game_key)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  if !isPlayer(game, req.Id) {
+    resp.Err = "You are not a player in that game."
+    return &resp
+  }
+  err = s.store.DeleteGame(req.Game_key)
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  s.notify(req.Game_key, game)
+  return &resp
+}
+
+// Records that the game with the specified key has changed and wakes up
+// anyone waiting on it.  Must be called with s.mutex held.
+func (s *Server) notify(key mrgnet.GameKey, game *mrgnet.Game) {
+  now := time.Now()
+  s.pruneChanges(now)
+  s.seq++
+  s.changes[key] = gameChange{
+    seq:     s.seq,
+    time:    now,
+    players: [2]mrgnet.NetId{game.Denizens_id, game.Intruders_id},
+  }
+  close(s.wake)
+  s.wake = make(chan struct{})
+}
+
+// Forgets changes older than s.Change_ttl, so that changes doesn't keep
+// growing with every game ever played.  Must be called with s.mutex held.
+func (s *Server) pruneChanges(now time.Time) {
+  for key, change := range s.changes {
+    if now.Sub(change.time) < s.Change_ttl {
+      continue
+    }
+    delete(s.changes, key)
+    if change.seq > s.first_seq {
+      s.first_seq = change.seq
+    }
+  }
+}
+
+// Fills out resp with the games req.Id is interested in that have changed
+// since req.Since.  Must be called with s.mutex held.
+func (s *Server) changedGames(req *mrgnet.WaitRequest, resp *mrgnet.WaitResponse) {
+  resp.Seq = s.seq
+  resp.Game_keys = nil
+  if req.Since == 0 {
+    return
+  }
+  if req.Since < s.first_seq {
+    // The client last heard from a previous run of the server, or so long ago
+    // that the changes since then have been forgotten, so we can't know what
+    // it missed.  Just tell it about everything.
+    keys, err := s.store.GameKeys()
+    if err != nil {
+      resp.Err = err.Error()
+      return
+    }
+    for _, key := range keys {
+      if req.Game_key != "" && key != req.Game_key {
+        continue
+      }
+      game, err := s.store.GetGame(key)
+      if err == nil && isPlayer(game, req.Id) {
+        resp.Game_keys = append(resp.Game_keys, key)
+      }
+    }
+    return
+  }
+  for key, change := range s.changes {
+    if change.seq <= req.Since {
+      continue
+    }
+    if req.Game_key != "" && key != req.Game_key {
+      continue
+    }
+    if req.Id == 0 || (change.players[0] != req.Id && change.players[1] != req.Id) {
+      continue
+    }
+    resp.Game_keys = append(resp.Game_keys, key)
+  }
+  sort.Sort(gameKeySlice(resp.Game_keys))
+}
+
+// Holds on to the request until something it is interested in changes, or
+// until s.Wait_timeout has passed.
+func (s *Server) wait(req *mrgnet.WaitRequest) *mrgnet.WaitResponse {
+  var resp mrgnet.WaitResponse
+  s.mutex.Lock()
+  err := s.authenticate(req.Id, req.Token)
+  s.mutex.Unlock()
+  if err != nil {
+    resp.Err = err.Error()
+    return &resp
+  }
+  timeout := time.After(s.Wait_timeout)
+  for {
+    s.mutex.Lock()
+    s.changedGames(req, &resp)
+    wake := s.wake
+    s.mutex.Unlock()
+    if resp.Err != "" || len(resp.Game_keys) > 0 || req.Since == 0 {
+      return &resp
+    }
+    select {
+    case <-wake:
+    case <-timeout:
+      return &resp
+    }
+  }
+}
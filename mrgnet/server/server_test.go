@@ -0,0 +1,479 @@
+package server_test
+
+import (
+  "errors"
+  "github.com/mik3cap/haunts/mrgnet"
+  "github.com/mik3cap/haunts/mrgnet/server"
+  "github.com/orfjackal/gospec/src/gospec"
+  . "github.com/orfjackal/gospec/src/gospec"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "strings"
+  "time"
+)
+
+func do(s *server.Server, name string, req, resp interface{}) error {
+  data, err := mrgnet.EncodeData(req)
+  if err != nil {
+    return err
+  }
+  data, err = s.Handle(name, data)
+  if err != nil {
+    return err
+  }
+  return mrgnet.DecodeData(data, resp)
+}
+
+func register(s *server.Server, name string) mrgnet.RegisterResponse {
+  var resp mrgnet.RegisterResponse
+  do(s, "register", mrgnet.RegisterRequest{Name: name, Secret: "secret " + name}, &resp)
+  return resp
+}
+
+// Accepts a turn only if After is Before and Execs joined with a '+', the
+// start of a turn has no Execs so its After must be Before with a '+'.  A
+// side has won if the state ends with "denizens won" or "intruders won".
+type afterVerifier struct{}
+
+func (afterVerifier) VerifyTurn(turn server.Turn) error {
+  if turn.Winner {
+    side := "denizens"
+    if turn.Intruders {
+      side = "intruders"
+    }
+    if !strings.HasSuffix(string(turn.After), side+" won") {
+      return errors.New("That side hasn't won.")
+    }
+    return nil
+  }
+  if turn.Start != (turn.Execs == nil) {
+    return errors.New("Only the start of a turn has no execs.")
+  }
+  if string(turn.After) != string(turn.Before)+"+"+string(turn.Execs) {
+    return errors.New("After doesn't match.")
+  }
+  return nil
+}
+
+func ServerSpec(c gospec.Context) {
+  c.Specify("Users can set their names.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    alice := register(s, "alice")
+    c.Assume(alice.Err, Equals, "")
+    var resp mrgnet.UpdateUserResponse
+    c.Assume(do(s, "user", mrgnet.UpdateUserRequest{Id: alice.Id, Token: alice.Token, Name: "Alice"}, &resp), Equals, nil)
+    c.Expect(resp.Err, Equals, "")
+    c.Expect(resp.Name, Equals, "Alice")
+    resp = mrgnet.UpdateUserResponse{}
+    c.Assume(do(s, "user", mrgnet.UpdateUserRequest{Id: alice.Id, Token: alice.Token}, &resp), Equals, nil)
+    c.Expect(resp.Name, Equals, "Alice")
+  })
+
+  c.Specify("Accounts are protected by their secret.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    alice := register(s, "Alice")
+    c.Assume(alice.Err, Equals, "")
+    c.Expect(register(s, "alice").Err, Not(Equals), "")
+
+    var login mrgnet.LoginResponse
+    do(s, "login", mrgnet.LoginRequest{Name: "alice", Secret: "secret Alice"}, &login)
+    c.Expect(login.Err, Equals, "")
+    c.Expect(login.Id, Equals, alice.Id)
+    c.Expect(login.Token, Not(Equals), "")
+
+    login = mrgnet.LoginResponse{}
+    do(s, "login", mrgnet.LoginRequest{Name: "alice", Secret: "wrong"}, &login)
+    c.Expect(login.Err, Not(Equals), "")
+    c.Expect(login.Token, Equals, "")
+
+    // Knowing someone's id isn't enough to act as them.
+    bob := register(s, "bob")
+    var resp mrgnet.UpdateUserResponse
+    do(s, "user", mrgnet.UpdateUserRequest{Id: alice.Id, Name: "Mallory"}, &resp)
+    c.Expect(resp.Err, Not(Equals), "")
+    resp = mrgnet.UpdateUserResponse{}
+    do(s, "user", mrgnet.UpdateUserRequest{Id: alice.Id, Token: bob.Token, Name: "Mallory"}, &resp)
+    c.Expect(resp.Err, Not(Equals), "")
+  })
+
+  c.Specify("A game can be played through the server.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    alice := register(s, "Alice")
+    bob := register(s, "Bob")
+
+    var new_resp mrgnet.NewGameResponse
+    c.Assume(do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp), Equals, nil)
+    c.Assume(new_resp.Err, Equals, "")
+    key := new_resp.Game_key
+
+    var list mrgnet.ListGamesResponse
+    do(s, "list", mrgnet.ListGamesRequest{Id: bob.Id, Token: bob.Token, Unstarted: true}, &list)
+    c.Expect(len(list.Games), Equals, 1)
+    list = mrgnet.ListGamesResponse{}
+    do(s, "list", mrgnet.ListGamesRequest{Id: alice.Id, Token: alice.Token, Unstarted: true}, &list)
+    c.Expect(len(list.Games), Equals, 0)
+
+    var join mrgnet.JoinGameResponse
+    do(s, "join", mrgnet.JoinGameRequest{Id: bob.Id, Token: bob.Token, Game_key: key}, &join)
+    c.Expect(join.Successful, Equals, true)
+    list = mrgnet.ListGamesResponse{}
+    do(s, "list", mrgnet.ListGamesRequest{Id: bob.Id, Token: bob.Token, Unstarted: false}, &list)
+    c.Expect(len(list.Games), Equals, 1)
+    c.Expect(list.Games[0].Intruders_name, Equals, "Bob")
+
+    update := func(player mrgnet.RegisterResponse, req mrgnet.UpdateGameRequest) string {
+      req.Id = player.Id
+      req.Token = player.Token
+      req.Game_key = key
+      var resp mrgnet.UpdateGameResponse
+      do(s, "update", req, &resp)
+      return resp.Err
+    }
+    c.Expect(update(alice, mrgnet.UpdateGameRequest{Script: []byte("script")}), Equals, "")
+    c.Expect(update(alice, mrgnet.UpdateGameRequest{Round: 0, Before: []byte("b0")}), Equals, "")
+
+    // Bob can't play Alice's turn for her.
+    c.Expect(update(bob, mrgnet.UpdateGameRequest{Round: 0, Execs: []byte("e0"), After: []byte("a0")}), Not(Equals), "")
+    c.Expect(update(alice, mrgnet.UpdateGameRequest{Round: 0, Execs: []byte("e0"), After: []byte("a0")}), Equals, "")
+
+    // Can't skip ahead
+    c.Expect(update(bob, mrgnet.UpdateGameRequest{Round: 1, Intruders: true, Before: []byte("b2")}), Not(Equals), "")
+    c.Expect(update(bob, mrgnet.UpdateGameRequest{Round: 0, Intruders: true, Before: []byte("b1")}), Equals, "")
+
+    var status mrgnet.StatusResponse
+    do(s, "status", mrgnet.StatusRequest{Id: bob.Id, Token: bob.Token, Game_key: key, Sizes_only: true}, &status)
+    c.Assume(status.Game, Not(IsNil))
+    c.Expect(len(status.Game.Before), Equals, 2)
+    c.Expect(len(status.Game.Execs), Equals, 1)
+    c.Expect(len(status.Game.Before[0]), Equals, 0)
+
+    status = mrgnet.StatusResponse{}
+    do(s, "status", mrgnet.StatusRequest{Id: bob.Id, Token: bob.Token, Game_key: key}, &status)
+    c.Assume(status.Game, Not(IsNil))
+    c.Expect(string(status.Game.Script), Equals, "script")
+    c.Expect(string(status.Game.After[0]), Equals, "a0")
+
+    mallory := register(s, "Mallory")
+    var kill mrgnet.KillResponse
+    do(s, "kill", mrgnet.KillRequest{Id: mallory.Id, Token: mallory.Token, Game_key: key}, &kill)
+    c.Expect(kill.Err, Not(Equals), "")
+    kill = mrgnet.KillResponse{}
+    do(s, "kill", mrgnet.KillRequest{Id: alice.Id, Token: alice.Token, Game_key: key}, &kill)
+    c.Expect(kill.Err, Equals, "")
+    status = mrgnet.StatusResponse{}
+    do(s, "status", mrgnet.StatusRequest{Id: bob.Id, Token: bob.Token, Game_key: key}, &status)
+    c.Expect(status.Err, Not(Equals), "")
+  })
+
+  c.Specify("Turns that fail verification are rejected.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    s.Verifier = afterVerifier{}
+    alice := register(s, "Alice")
+    var new_resp mrgnet.NewGameResponse
+    do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp)
+    key := new_resp.Game_key
+    update := func(req mrgnet.UpdateGameRequest) string {
+      req.Id = alice.Id
+      req.Token = alice.Token
+      req.Game_key = key
+      var resp mrgnet.UpdateGameResponse
+      do(s, "update", req, &resp)
+      return resp.Err
+    }
+    c.Assume(update(mrgnet.UpdateGameRequest{Before: []byte("b0")}), Equals, "")
+    c.Expect(update(mrgnet.UpdateGameRequest{Execs: []byte("e0"), After: []byte("b0+cheating")}), Not(Equals), "")
+    c.Expect(update(mrgnet.UpdateGameRequest{Execs: []byte("e0"), After: []byte("b0+e0")}), Equals, "")
+
+    var status mrgnet.StatusResponse
+    do(s, "status", mrgnet.StatusRequest{Id: alice.Id, Token: alice.Token, Game_key: key}, &status)
+    c.Assume(status.Game, Not(IsNil))
+    c.Assume(len(status.Game.After), Equals, 1)
+    c.Expect(string(status.Game.After[0]), Equals, "b0+e0")
+  })
+
+  c.Specify("The end of a turn isn't accepted without its execs.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    s.Verifier = afterVerifier{}
+    alice := register(s, "Alice")
+    var new_resp mrgnet.NewGameResponse
+    do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp)
+    key := new_resp.Game_key
+    update := func(req mrgnet.UpdateGameRequest) string {
+      req.Id = alice.Id
+      req.Token = alice.Token
+      req.Game_key = key
+      var resp mrgnet.UpdateGameResponse
+      do(s, "update", req, &resp)
+      return resp.Err
+    }
+    c.Assume(update(mrgnet.UpdateGameRequest{Before: []byte("b0")}), Equals, "")
+    c.Expect(update(mrgnet.UpdateGameRequest{After: []byte("b0+")}), Not(Equals), "")
+
+    var status mrgnet.StatusResponse
+    do(s, "status", mrgnet.StatusRequest{Id: alice.Id, Token: alice.Token, Game_key: key}, &status)
+    c.Assume(status.Game, Not(IsNil))
+    c.Expect(len(status.Game.After), Equals, 0)
+  })
+
+  c.Specify("Players can record who won, but only verified wins of their own.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    s.Verifier = afterVerifier{}
+    alice := register(s, "Alice")
+    bob := register(s, "Bob")
+    var new_resp mrgnet.NewGameResponse
+    do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp)
+    key := new_resp.Game_key
+    do(s, "join", mrgnet.JoinGameRequest{Id: bob.Id, Token: bob.Token, Game_key: key}, &mrgnet.JoinGameResponse{})
+    update := func(req mrgnet.UpdateGameRequest) string {
+      req.Id = alice.Id
+      req.Token = alice.Token
+      req.Game_key = key
+      var resp mrgnet.UpdateGameResponse
+      do(s, "update", req, &resp)
+      return resp.Err
+    }
+    winner := func(player mrgnet.RegisterResponse, intruders bool) string {
+      var resp mrgnet.SetWinnerResponse
+      do(s, "winner", mrgnet.SetWinnerRequest{Id: player.Id, Token: player.Token, Game_key: key, Intruders: intruders}, &resp)
+      return resp.Err
+    }
+    games := func(player mrgnet.RegisterResponse) int {
+      var list mrgnet.ListGamesResponse
+      do(s, "list", mrgnet.ListGamesRequest{Id: player.Id, Token: player.Token}, &list)
+      return len(list.Games)
+    }
+
+    // Nothing has happened yet, so nobody can have won.
+    c.Expect(winner(alice, false), Not(Equals), "")
+
+    c.Assume(update(mrgnet.UpdateGameRequest{Before: []byte("b0")}), Equals, "")
+    c.Assume(update(mrgnet.UpdateGameRequest{Execs: []byte("e0 denizens won"), After: []byte("b0+e0 denizens won")}), Equals, "")
+    c.Expect(winner(bob, true), Not(Equals), "")
+    c.Expect(games(alice), Equals, 1)
+
+    c.Expect(winner(alice, false), Equals, "")
+    c.Expect(games(alice), Equals, 0)
+    c.Expect(games(bob), Equals, 0)
+    var status mrgnet.StatusResponse
+    do(s, "status", mrgnet.StatusRequest{Id: bob.Id, Token: bob.Token, Game_key: key, Sizes_only: true}, &status)
+    c.Assume(status.Game, Not(IsNil))
+    c.Expect(status.Game.Winner, Equals, alice.Id)
+
+    // Bob's client finds out too, but the game can't change hands.
+    c.Expect(winner(bob, false), Equals, "")
+    c.Expect(winner(bob, true), Not(Equals), "")
+  })
+
+  c.Specify("Players can always say that the other side won.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    s.Verifier = afterVerifier{}
+    alice := register(s, "Alice")
+    bob := register(s, "Bob")
+    var new_resp mrgnet.NewGameResponse
+    do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp)
+    key := new_resp.Game_key
+
+    // Nobody can win against a side that nobody is playing.
+    var resp mrgnet.SetWinnerResponse
+    do(s, "winner", mrgnet.SetWinnerRequest{Id: alice.Id, Token: alice.Token, Game_key: key, Intruders: true}, &resp)
+    c.Expect(resp.Err, Not(Equals), "")
+
+    do(s, "join", mrgnet.JoinGameRequest{Id: bob.Id, Token: bob.Token, Game_key: key}, &mrgnet.JoinGameResponse{})
+    resp = mrgnet.SetWinnerResponse{}
+    do(s, "winner", mrgnet.SetWinnerRequest{Id: alice.Id, Token: alice.Token, Game_key: key, Intruders: true}, &resp)
+    c.Expect(resp.Err, Equals, "")
+    var status mrgnet.StatusResponse
+    do(s, "status", mrgnet.StatusRequest{Id: alice.Id, Token: alice.Token, Game_key: key, Sizes_only: true}, &status)
+    c.Assume(status.Game, Not(IsNil))
+    c.Expect(status.Game.Winner, Equals, bob.Id)
+  })
+
+  c.Specify("Turns have to start from where the last one ended.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    alice := register(s, "Alice")
+    bob := register(s, "Bob")
+    var new_resp mrgnet.NewGameResponse
+    do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp)
+    key := new_resp.Game_key
+    do(s, "join", mrgnet.JoinGameRequest{Id: bob.Id, Token: bob.Token, Game_key: key}, &mrgnet.JoinGameResponse{})
+    update := func(player mrgnet.RegisterResponse, req mrgnet.UpdateGameRequest) string {
+      req.Id = player.Id
+      req.Token = player.Token
+      req.Game_key = key
+      var resp mrgnet.UpdateGameResponse
+      do(s, "update", req, &resp)
+      return resp.Err
+    }
+    c.Assume(update(alice, mrgnet.UpdateGameRequest{Before: []byte("b0")}), Equals, "")
+
+    // Bob can't start his turn until Alice has finished hers.
+    c.Expect(update(bob, mrgnet.UpdateGameRequest{Intruders: true, Before: []byte("b1")}), Not(Equals), "")
+
+    c.Assume(update(alice, mrgnet.UpdateGameRequest{Execs: []byte("e0"), After: []byte("b0+e0")}), Equals, "")
+    c.Expect(update(alice, mrgnet.UpdateGameRequest{Before: []byte("better b0")}), Not(Equals), "")
+
+    s.Verifier = afterVerifier{}
+    c.Expect(update(bob, mrgnet.UpdateGameRequest{Intruders: true, Before: []byte("b1")}), Not(Equals), "")
+    c.Expect(update(bob, mrgnet.UpdateGameRequest{Intruders: true, Before: []byte("b0+e0+")}), Equals, "")
+
+    var status mrgnet.StatusResponse
+    do(s, "status", mrgnet.StatusRequest{Id: alice.Id, Token: alice.Token, Game_key: key}, &status)
+    c.Assume(status.Game, Not(IsNil))
+    c.Assume(len(status.Game.Before), Equals, 2)
+    c.Expect(string(status.Game.Before[0]), Equals, "b0")
+    c.Expect(string(status.Game.Before[1]), Equals, "b0+e0+")
+  })
+
+  c.Specify("CommandVerifiers reject turns when the command fails.", func() {
+    if _, err := exec.LookPath("sh"); err != nil {
+      return
+    }
+    cv := server.CommandVerifier{Path: "sh", Args: []string{"-c", "cat > /dev/null"}}
+    c.Expect(cv.VerifyTurn(server.Turn{Execs: []byte("e0")}), Equals, nil)
+    cv.Args = []string{"-c", "cat > /dev/null; echo bad turn >&2; exit 1"}
+    err := cv.VerifyTurn(server.Turn{Execs: []byte("e0")})
+    c.Assume(err, Not(Equals), nil)
+    c.Expect(err.Error(), Equals, "bad turn")
+  })
+
+  c.Specify("DoAction can talk to a server through a LoopbackTransport.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    old := mrgnet.GetTransport()
+    mrgnet.SetTransport(mrgnet.LoopbackTransport{Handler: s})
+    defer mrgnet.SetTransport(old)
+    var alice mrgnet.RegisterResponse
+    err := mrgnet.DoAction("register", mrgnet.RegisterRequest{Name: "alice", Secret: "password"}, &alice)
+    c.Assume(err, Equals, nil)
+    c.Assume(alice.Err, Equals, "")
+
+    // The session token is filled in automatically.
+    mrgnet.SetToken(alice.Token)
+    defer mrgnet.SetToken("")
+    var resp mrgnet.UpdateUserResponse
+    err = mrgnet.DoAction("user", mrgnet.UpdateUserRequest{Id: alice.Id, Name: "Alice"}, &resp)
+    c.Assume(err, Equals, nil)
+    c.Expect(resp.Err, Equals, "")
+    c.Expect(resp.Name, Equals, "Alice")
+    err = mrgnet.DoAction("bogus", mrgnet.UpdateUserRequest{Id: alice.Id}, &resp)
+    c.Expect(err, Not(Equals), nil)
+  })
+
+  c.Specify("Waiting players are told when their games change.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    s.Wait_timeout = 10 * time.Millisecond
+    alice := register(s, "alice")
+    bob := register(s, "bob")
+    mallory := register(s, "mallory")
+    var new_resp mrgnet.NewGameResponse
+    do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp)
+    key := new_resp.Game_key
+
+    var wait mrgnet.WaitResponse
+    c.Assume(do(s, "wait", mrgnet.WaitRequest{Id: alice.Id, Token: alice.Token}, &wait), Equals, nil)
+    since := wait.Seq
+    c.Expect(since, Not(Equals), int64(0))
+
+    // Nothing has happened yet, so this should time out.
+    wait = mrgnet.WaitResponse{}
+    do(s, "wait", mrgnet.WaitRequest{Id: alice.Id, Token: alice.Token, Since: since}, &wait)
+    c.Expect(len(wait.Game_keys), Equals, 0)
+    c.Expect(wait.Seq, Equals, since)
+
+    s.Wait_timeout = time.Minute
+    done := make(chan mrgnet.WaitResponse)
+    go func() {
+      var wait mrgnet.WaitResponse
+      do(s, "wait", mrgnet.WaitRequest{Id: alice.Id, Token: alice.Token, Since: since}, &wait)
+      done <- wait
+    }()
+    do(s, "join", mrgnet.JoinGameRequest{Id: bob.Id, Token: bob.Token, Game_key: key}, &mrgnet.JoinGameResponse{})
+    select {
+    case wait = <-done:
+      c.Assume(len(wait.Game_keys), Equals, 1)
+      c.Expect(wait.Game_keys[0], Equals, key)
+      c.Expect(wait.Seq > since, IsTrue)
+    case <-time.After(5 * time.Second):
+      c.Expect("wait returned", Equals, "wait never returned")
+    }
+
+    // Players aren't told about other people's games.
+    s.Wait_timeout = 10 * time.Millisecond
+    wait = mrgnet.WaitResponse{}
+    do(s, "wait", mrgnet.WaitRequest{Id: mallory.Id, Token: mallory.Token, Since: since}, &wait)
+    c.Expect(wait.Err, Equals, "")
+    c.Expect(len(wait.Game_keys), Equals, 0)
+  })
+
+  c.Specify("Old changes are forgotten, but waiting players still hear about them.", func() {
+    s := server.MakeServer(server.MakeMemoryStore())
+    s.Wait_timeout = 10 * time.Millisecond
+    s.Change_ttl = 10 * time.Millisecond
+    alice := register(s, "alice")
+    bob := register(s, "bob")
+    var wait mrgnet.WaitResponse
+    c.Assume(do(s, "wait", mrgnet.WaitRequest{Id: alice.Id, Token: alice.Token}, &wait), Equals, nil)
+    since := wait.Seq
+
+    var keys []mrgnet.GameKey
+    for i := 0; i < 2; i++ {
+      var new_resp mrgnet.NewGameResponse
+      do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp)
+      c.Assume(new_resp.Err, Equals, "")
+      do(s, "join", mrgnet.JoinGameRequest{Id: bob.Id, Token: bob.Token, Game_key: new_resp.Game_key}, &mrgnet.JoinGameResponse{})
+      keys = append(keys, new_resp.Game_key)
+      time.Sleep(20 * time.Millisecond)
+    }
+    c.Expect(s.NumChanges(), Equals, 1)
+
+    wait = mrgnet.WaitResponse{}
+    do(s, "wait", mrgnet.WaitRequest{Id: alice.Id, Token: alice.Token, Since: since}, &wait)
+    c.Expect(wait.Err, Equals, "")
+    c.Expect(wait.Game_keys, ContainsExactly, Values(keys[0], keys[1]))
+  })
+
+  c.Specify("File stores persist games and accounts.", func() {
+    dir, err := ioutil.TempDir("", "mrgnet")
+    c.Assume(err, Equals, nil)
+    defer os.RemoveAll(dir)
+    store, err := server.MakeFileStore(dir)
+    c.Assume(err, Equals, nil)
+    s := server.MakeServer(store)
+    alice := register(s, "Alice Smith")
+    c.Assume(alice.Err, Equals, "")
+    var new_resp mrgnet.NewGameResponse
+    do(s, "new", mrgnet.NewGameRequest{Id: alice.Id, Token: alice.Token}, &new_resp)
+    c.Assume(new_resp.Err, Equals, "")
+
+    store, err = server.MakeFileStore(dir)
+    c.Assume(err, Equals, nil)
+    s = server.MakeServer(store)
+    var status mrgnet.StatusResponse
+    do(s, "status", mrgnet.StatusRequest{Id: alice.Id, Token: alice.Token, Game_key: new_resp.Game_key}, &status)
+    c.Expect(status.Err, Equals, "")
+    var login mrgnet.LoginResponse
+    do(s, "login", mrgnet.LoginRequest{Name: "alice smith", Secret: "secret Alice Smith"}, &login)
+    c.Expect(login.Err, Equals, "")
+    c.Expect(login.Id, Equals, alice.Id)
+  })
+
+  c.Specify("Memory stores don't share games with their callers.", func() {
+    store := server.MakeMemoryStore()
+    game := &mrgnet.Game{Name: "game", Execs: [][]byte{[]byte("execs")}, Script: []byte("script")}
+    c.Assume(store.PutGame("key", game), Equals, nil)
+    game.Execs[0][0] = 'X'
+    game.Script[0] = 'X'
+
+    got, err := store.GetGame("key")
+    c.Assume(err, Equals, nil)
+    c.Expect(string(got.Execs[0]), Equals, "execs")
+    c.Expect(string(got.Script), Equals, "script")
+    got.Execs[0][0] = 'X'
+    got.Execs = append(got.Execs, []byte("more"))
+
+    got, err = store.GetGame("key")
+    c.Assume(err, Equals, nil)
+    c.Expect(len(got.Execs), Equals, 1)
+    c.Expect(string(got.Execs[0]), Equals, "execs")
+  })
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+  "encoding/gob"
+  "errors"
+  "fmt"
+  "github.com/mik3cap/haunts/mrgnet"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// A Store keeps track of all users and games known to a Server.  A Store does
+// not need to be safe for concurrent use, the Server serializes all access to
+// it.
+type Store interface {
+  // Returns ErrNotFound if there is no user with the specified id.
+  GetUser(id mrgnet.NetId) (mrgnet.User, error)
+  PutUser(user mrgnet.User) error
+
+  // Returns ErrNotFound if there is no game with the specified key.
+  GetGame(key mrgnet.GameKey) (*mrgnet.Game, error)
+  PutGame(key mrgnet.GameKey, game *mrgnet.Game) error
+  DeleteGame(key mrgnet.GameKey) error
+
+  // Returns the keys of every game in the store, in sorted order.
+  GameKeys() ([]mrgnet.GameKey, error)
+
+  // Accounts are keyed by name.  Returns ErrNotFound if there is no account
+  // with the specified name.
+  GetAccount(name string) (Account, error)
+  PutAccount(account Account) error
+
+  // Returns ErrNotFound if token isn't a session token that was handed out.
+  GetSession(token string) (mrgnet.NetId, error)
+  PutSession(token string, id mrgnet.NetId) error
+}
+
+type memoryStore struct {
+  users    map[mrgnet.NetId]mrgnet.User
+  games    map[mrgnet.GameKey]*mrgnet.Game
+  accounts map[string]Account
+  sessions map[string]mrgnet.NetId
+}
+
+// Makes a Store that only keeps things in memory, everything is lost when the
+// process exits.  Useful for tests and for quick games on a LAN.
+func MakeMemoryStore() Store {
+  return &memoryStore{
+    users:    make(map[mrgnet.NetId]mrgnet.User),
+    games:    make(map[mrgnet.GameKey]*mrgnet.Game),
+    accounts: make(map[string]Account),
+    sessions: make(map[string]mrgnet.NetId),
+  }
+}
+
+func (ms *memoryStore) GetUser(id mrgnet.NetId) (mrgnet.User, error) {
+  user, ok := ms.users[id]
+  if !ok {
+    return mrgnet.User{}, ErrNotFound
+  }
+  return user, nil
+}
+
+func (ms *memoryStore) PutUser(user mrgnet.User) error {
+  ms.users[user.Id] = user
+  return nil
+}
+
+func (ms *memoryStore) GetGame(key mrgnet.GameKey) (*mrgnet.Game, error) {
+  game, ok := ms.games[key]
+  if !ok {
+    return nil, ErrNotFound
+  }
+  // Hand out a copy so that callers can't modify what's in the store without
+  // calling PutGame.
+  return copyGame(game), nil
+}
+
+func (ms *memoryStore) PutGame(key mrgnet.GameKey, game *mrgnet.Game) error {
+  ms.games[key] = copyGame(game)
+  return nil
+}
+
+// Copies game all the way down, so that the copy doesn't share any slices
+// with it.
+func copyGame(game *mrgnet.Game) *mrgnet.Game {
+  cp := *game
+  cp.Before = copyBlobs(game.Before)
+  cp.Execs = copyBlobs(game.Execs)
+  cp.After = copyBlobs(game.After)
+  cp.Script = copyBlob(game.Script)
+  return &cp
+}
+
+func copyBlobs(blobs [][]byte) [][]byte {
+  if blobs == nil {
+    return nil
+  }
+  cp := make([][]byte, len(blobs))
+  for i := range blobs {
+    cp[i] = copyBlob(blobs[i])
+  }
+  return cp
+}
+
+func copyBlob(blob []byte) []byte {
+  if blob == nil {
+    return nil
+  }
+  return append([]byte{}, blob...)
+}
+
+func (ms *memoryStore) DeleteGame(key mrgnet.GameKey) error {
+  if _, ok := ms.games[key]; !ok {
+    return ErrNotFound
+  }
+  delete(ms.games, key)
+  return nil
+}
+
+func (ms *memoryStore) GameKeys() ([]mrgnet.GameKey, error) {
+  var keys []mrgnet.GameKey
+  for key := range ms.games {
+    keys = append(keys, key)
+  }
+  sort.Sort(gameKeySlice(keys))
+  return keys, nil
+}
+
+func (ms *memoryStore) GetAccount(name string) (Account, error) {
+  account, ok := ms.accounts[name]
+  if !ok {
+    return Account{}, ErrNotFound
+  }
+  return account, nil
+}
+
+func (ms *memoryStore) PutAccount(account Account) error {
+  ms.accounts[account.Name] = account
+  return nil
+}
+
+func (ms *memoryStore) GetSession(token string) (mrgnet.NetId, error) {
+  id, ok := ms.sessions[token]
+  if !ok {
+    return 0, ErrNotFound
+  }
+  return id, nil
+}
+
+func (ms *memoryStore) PutSession(token string, id mrgnet.NetId) error {
+  ms.sessions[token] = id
+  return nil
+}
+
+type gameKeySlice []mrgnet.GameKey
+
+func (g gameKeySlice) Len() int           { return len(g) }
+func (g gameKeySlice) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g gameKeySlice) Less(i, j int) bool { return g[i] < g[j] }
+
+// Stores each user and game as its own gob file under a root directory:
+//   root/users/<id>.user
+//   root/games/<key>.game
+//   root/accounts/<hex encoded name>.account
+//   root/sessions/<token>.session
+type fileStore struct {
+  root string
+}
+
+// Makes a Store that persists everything under the directory root, creating
+// it if necessary.
+func MakeFileStore(root string) (Store, error) {
+  for _, dir := range []string{"users", "games", "accounts", "sessions"} {
+    err := os.MkdirAll(filepath.Join(root, dir), 0755)
+    if err != nil {
+      return nil, err
+    }
+  }
+  return &fileStore{root: root}, nil
+}
+
+func (fs *fileStore) userPath(id mrgnet.NetId) string {
+  return filepath.Join(fs.root, "users", fmt.Sprintf("%d.user", id))
+}
+
+func (fs *fileStore) gamePath(key mrgnet.GameKey) string {
+  return filepath.Join(fs.root, "games", fmt.Sprintf("%s.game", key))
+}
+
+// Account names can contain anything, so they're hex encoded to make them
+// safe to use as filenames.
+func (fs *fileStore) accountPath(name string) string {
+  return filepath.Join(fs.root, "accounts", fmt.Sprintf("%x.account", name))
+}
+
+func (fs *fileStore) sessionPath(token string) string {
+  return filepath.Join(fs.root, "sessions", fmt.Sprintf("%s.session", token))
+}
+
+func loadGob(path string, target interface{}) error {
+  f, err := os.Open(path)
+  if os.IsNotExist(err) {
+    return ErrNotFound
+  }
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  return gob.NewDecoder(f).Decode(target)
+}
+
+// Writes to a temporary file first so that a crash halfway through a write
+// can't leave a truncated file behind.
+func saveGob(path string, source interface{}) error {
+  tmp := path + ".tmp"
+  f, err := os.Create(tmp)
+  if err != nil {
+    return err
+  }
+  err = gob.NewEncoder(f).Encode(source)
+  f.Close()
+  if err != nil {
+    os.Remove(tmp)
+    return err
+  }
+  return os.Rename(tmp, path)
+}
+
+func (fs *fileStore) GetUser(id mrgnet.NetId) (mrgnet.User, error) {
+  var user mrgnet.User
+  err := loadGob(fs.userPath(id), &user)
+  return user, err
+}
+
+func (fs *fileStore) PutUser(user mrgnet.User) error {
+  return saveGob(fs.userPath(user.Id), user)
+}
+
+func (fs *fileStore) GetGame(key mrgnet.GameKey) (*mrgnet.Game, error) {
+  if !validKey(key) {
+    return nil, ErrNotFound
+  }
+  var game mrgnet.Game
+  err := loadGob(fs.gamePath(key), &game)
+  if err != nil {
+    return nil, err
+  }
+  return &game, nil
+}
+
+func (fs *fileStore) PutGame(key mrgnet.GameKey, game *mrgnet.Game) error {
+  if !validKey(key) {
+    return errors.New(fmt.Sprintf("Invalid game key '%s'.", key))
+  }
+  return saveGob(fs.gamePath(key), game)
+}
+
+func (fs *fileStore) DeleteGame(key mrgnet.GameKey) error {
+  if !validKey(key) {
+    return ErrNotFound
+  }
+  err := os.Remove(fs.gamePath(key))
+  if os.IsNotExist(err) {
+    return ErrNotFound
+  }
+  return err
+}
+
+func (fs *fileStore) GameKeys() ([]mrgnet.GameKey, error) {
+  matches, err := filepath.Glob(filepath.Join(fs.root, "games", "*.game"))
+  if err != nil {
+    return nil, err
+  }
+  var keys []mrgnet.GameKey
+  for _, match := range matches {
+    keys = append(keys, mrgnet.GameKey(strings.TrimSuffix(filepath.Base(match), ".game")))
+  }
+  sort.Sort(gameKeySlice(keys))
+  return keys, nil
+}
+
+func (fs *fileStore) GetAccount(name string) (Account, error) {
+  var account Account
+  err := loadGob(fs.accountPath(name), &account)
+  return account, err
+}
+
+func (fs *fileStore) PutAccount(account Account) error {
+  return saveGob(fs.accountPath(account.Name), account)
+}
+
+func (fs *fileStore) GetSession(token string) (mrgnet.NetId, error) {
+  if !validKey(mrgnet.GameKey(token)) {
+    return 0, ErrNotFound
+  }
+  var id mrgnet.NetId
+  err := loadGob(fs.sessionPath(token), &id)
+  return id, err
+}
+
+func (fs *fileStore) PutSession(token string, id mrgnet.NetId) error {
+  if !validKey(mrgnet.GameKey(token)) {
+    return errors.New("Invalid session token.")
+  }
+  return saveGob(fs.sessionPath(token), id)
+}
+
+// Game keys and session tokens come from clients, so make sure they can't be used to reach
+// outside of the store's directory.
+func validKey(key mrgnet.GameKey) bool {
+  if key == "" {
+    return false
+  }
+  for _, c := range key {
+    if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+      return false
+    }
+  }
+  return true
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+  "bytes"
+  "context"
+  "encoding/gob"
+  "errors"
+  "fmt"
+  "os/exec"
+  "strings"
+  "time"
+)
+
+// Everything a Verifier needs to check one player's turn.
+type Turn struct {
+  Script    []byte
+  Round     int
+  Intruders bool
+
+  // The state at the start of the turn, the execs the player submitted, and
+  // the state they claim to have ended up in.  If Start is set this is the
+  // start of a turn instead, Before is the state the previous turn ended in,
+  // After is the state the player says this turn starts from, and Execs is
+  // nil.
+  Before []byte
+  Execs  []byte
+  After  []byte
+  Start  bool
+
+  // If Winner is set this isn't a turn at all, After is the latest state of
+  // a game that a player says their side won, Intruders is that side, and
+  // Round, Before and Execs are unset.
+  Winner bool
+}
+
+// A Verifier checks that a turn's Execs, replayed against its Before state,
+// actually produce its After state, or for the start of a turn that starting
+// it from Before produces After, or for a Winner that After says the side
+// has won.  Returning an error rejects the turn.
+type Verifier interface {
+  VerifyTurn(turn Turn) error
+}
+
+// Verifies turns by running a separate program, such as the game started with
+// -verify, since the server itself doesn't know how to run a game.  The Turn
+// is gobbed to the program's stdin, and if it exits with a non-zero status the
+// turn is rejected with whatever it wrote to stderr as the reason.
+type CommandVerifier struct {
+  Path string
+  Args []string
+
+  // If the program takes longer than this the turn is rejected.  Zero means
+  // no limit.
+  Timeout time.Duration
+}
+
+func (cv CommandVerifier) VerifyTurn(turn Turn) error {
+  ctx := context.Background()
+  if cv.Timeout > 0 {
+    var cancel context.CancelFunc
+    ctx, cancel = context.WithTimeout(ctx, cv.Timeout)
+    defer cancel()
+  }
+  var stdin, stderr bytes.Buffer
+  err := gob.NewEncoder(&stdin).Encode(turn)
+  if err != nil {
+    return err
+  }
+  cmd := exec.CommandContext(ctx, cv.Path, cv.Args...)
+  cmd.Stdin = &stdin
+  cmd.Stderr = &stderr
+  err = cmd.Run()
+  if ctx.Err() == context.DeadlineExceeded {
+    return errors.New("Timed out verifying turn.")
+  }
+  if err != nil {
+    if msg := strings.TrimSpace(stderr.String()); msg != "" {
+      return errors.New(msg)
+    }
+    return errors.New(fmt.Sprintf("Verifier failed: %v", err))
+  }
+  return nil
+}
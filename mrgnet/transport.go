@@ -0,0 +1,118 @@
+package mrgnet
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "os"
+  "strings"
+  "sync"
+)
+
+const Default_host_url = "http://mobrulesgames.appspot.com/"
+
+// If this environment variable is set it is used as the host url instead of
+// Default_host_url.
+const Host_url_env = "HAUNTS_HOST_URL"
+
+// A Transport carries an encoded request for the named action to a server and
+// returns the server's encoded response.  Encoding and decoding is done by
+// DoAction, so a Transport only needs to move bytes around.  A Transport
+// should give up as soon as ctx is done.
+type Transport interface {
+  RoundTrip(ctx context.Context, name string, data []byte) ([]byte, error)
+}
+
+// Posts requests to a server over http, this is how the game normally talks
+// to the server.
+type HttpTransport struct {
+  Host_url string
+
+  // If nil, http.DefaultClient is used.
+  Client *http.Client
+}
+
+func (ht HttpTransport) RoundTrip(ctx context.Context, name string, data []byte) ([]byte, error) {
+  host_url := fmt.Sprintf("%s/%s", strings.TrimRight(ht.Host_url, "/"), name)
+  form := url.Values{"data": []string{string(data)}}
+  req, err := http.NewRequest("POST", host_url, strings.NewReader(form.Encode()))
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  client := ht.Client
+  if client == nil {
+    client = http.DefaultClient
+  }
+  r, err := client.Do(req.WithContext(ctx))
+  if err != nil {
+    return nil, err
+  }
+  defer r.Body.Close()
+  body, err := ioutil.ReadAll(r.Body)
+  if r.StatusCode != http.StatusOK {
+    return nil, &Error{
+      Action: name,
+      Kind:   ErrorStatus,
+      Status: r.StatusCode,
+      Err:    errors.New(strings.TrimSpace(string(body))),
+    }
+  }
+  if err != nil {
+    return nil, err
+  }
+  return body, nil
+}
+
+// Anything that can answer encoded requests directly, such as a
+// server.Server.
+type Handler interface {
+  Handle(name string, data []byte) ([]byte, error)
+}
+
+// Hands requests straight to a Handler in the same process.  This lets the
+// client code be run against an in-memory server without any networking.
+type LoopbackTransport struct {
+  Handler Handler
+}
+
+func (lt LoopbackTransport) RoundTrip(ctx context.Context, name string, data []byte) ([]byte, error) {
+  if err := ctx.Err(); err != nil {
+    return nil, err
+  }
+  return lt.Handler.Handle(name, data)
+}
+
+var transport struct {
+  sync.Mutex
+  t Transport
+}
+
+func init() {
+  host_url := os.Getenv(Host_url_env)
+  if host_url == "" {
+    host_url = Default_host_url
+  }
+  transport.t = HttpTransport{Host_url: host_url}
+}
+
+// Sets the Transport that all future calls to DoAction will use.
+func SetTransport(t Transport) {
+  transport.Lock()
+  defer transport.Unlock()
+  transport.t = t
+}
+
+func GetTransport() Transport {
+  transport.Lock()
+  defer transport.Unlock()
+  return transport.t
+}
+
+// Convenience function for pointing DoAction at a different server.
+func SetHostUrl(host_url string) {
+  SetTransport(HttpTransport{Host_url: host_url})
+}
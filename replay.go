@@ -0,0 +1,14 @@
+package main
+
+import (
+  "os"
+)
+
+// When started with -replay <path> the game skips the start menu and plays
+// back a game that was exported from a replay.
+func replayPath() string {
+  if len(os.Args) > 2 && os.Args[1] == "-replay" {
+    return os.Args[2]
+  }
+  return ""
+}
@@ -135,6 +135,9 @@ func RenderAdvanced(x, y, dx, dy, rot float64, flip bool) {
 }
 
 func (d *Data) Bind() {
+  if base.Headless() {
+    return
+  }
   if d.texture == 0 {
     if error_texture == 0 {
       makeErrorTexture()
@@ -201,6 +204,10 @@ func (m *Manager) Scavenger() {
       m.deleted[s] = m.registry[s]
       delete(m.registry, s)
     }
+    if base.Headless() {
+      m.mutex.Unlock()
+      continue
+    }
     render.Queue(func() {
       for _, d := range unused_data {
         d.texture.Delete()
@@ -340,7 +347,9 @@ func handleLoadRequest(req loadRequest) {
 }
 
 func (m *Manager) LoadFromPath(path string) *Data {
-  setupTextureList()
+  if !base.Headless() {
+    setupTextureList()
+  }
   m.mutex.RLock()
   var data *Data
   var ok bool
@@ -371,6 +380,11 @@ func (m *Manager) LoadFromPath(path string) *Data {
   data.dx = config.Width
   data.dy = config.Height
 
+  // Without a render thread there is nowhere to put the texture, but the
+  // dimensions are still useful.
+  if base.Headless() {
+    return data
+  }
   load_requests <- loadRequest{path, data}
   return data
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+  "errors"
+  "flag"
+  "fmt"
+  "github.com/mik3cap/haunts/game"
+  "io"
+  "os"
+  "strings"
+  "time"
+)
+
+// When started with -tournament the game plays a batch of matches between
+// ais without a window, writes a report on them and exits, e.g.
+//   haunts -tournament -script versus/Lvl02.lua -games 100 -format csv
+// Run with -tournament -help for all of the options.
+func isTournament() bool {
+  return len(os.Args) > 1 && os.Args[1] == "-tournament"
+}
+
+// Lets -choose be given more than once.
+type choiceFlags map[string]string
+
+func (c choiceFlags) String() string {
+  var strs []string
+  for path, id := range c {
+    strs = append(strs, path+"="+id)
+  }
+  return strings.Join(strs, ",")
+}
+
+func (c choiceFlags) Set(s string) error {
+  parts := strings.SplitN(s, "=", 2)
+  if len(parts) != 2 {
+    return errors.New(fmt.Sprintf("expected path=id, got '%s'", s))
+  }
+  c[parts[0]] = parts[1]
+  return nil
+}
+
+// Returns the exit status for the process.
+func runTournament() int {
+  var config game.MatchConfig
+  config.Choices = make(map[string]string)
+  fs := flag.NewFlagSet("tournament", flag.ContinueOnError)
+  fs.StringVar(&config.Script, "script", "versus/Lvl02.lua", "level script to play, relative to data/scripts")
+  fs.StringVar(&config.Denizens, "denizens", "denizens.lua", "ai for the denizens, relative to data/ais")
+  fs.StringVar(&config.Minions, "minions", "minions.lua", "ai for the minions, relative to data/ais")
+  fs.StringVar(&config.Intruders, "intruders", "intruders.lua", "ai for the intruders, relative to data/ais")
+  fs.Var(choiceFlags(config.Choices), "choose", "answer for a chooser as path=id, may be given more than once")
+  difficulty := fs.String("difficulty", "Normal", "Easy, Normal or Hard")
+  fs.Int64Var(&config.Seed, "seed", 1, "seed for the first match, each match after it uses the next seed")
+  fs.IntVar(&config.Max_turns, "turns", 60, "turns after which a match is a draw, 0 for no limit")
+  fs.DurationVar(&config.Time_limit, "time", 10*time.Minute, "time after which a match is abandoned, 0 for no limit")
+  games := fs.Int("games", 10, "number of matches to play")
+  format := fs.String("format", "json", "json or csv")
+  out := fs.String("out", "", "file to write the report to, defaults to stdout")
+  if err := fs.Parse(os.Args[2:]); err != nil {
+    return 2
+  }
+  var err error
+  config.Difficulty, err = game.ParseDifficulty(*difficulty)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%v\n", err)
+    return 2
+  }
+  if *format != "json" && *format != "csv" {
+    fmt.Fprintf(os.Stderr, "Unknown format '%s', must be json or csv.\n", *format)
+    return 2
+  }
+
+  report := game.PlayTournament(config, *games)
+
+  var w io.Writer = os.Stdout
+  if *out != "" {
+    f, err := os.Create(*out)
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "Unable to write report: %v\n", err)
+      return 1
+    }
+    defer f.Close()
+    w = f
+  }
+  if *format == "csv" {
+    err = report.WriteCSV(w)
+  } else {
+    err = report.WriteJSON(w)
+  }
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Unable to write report: %v\n", err)
+    return 1
+  }
+  return 0
+}
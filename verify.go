@@ -0,0 +1,38 @@
+package main
+
+import (
+  "encoding/gob"
+  "fmt"
+  "github.com/mik3cap/haunts/game"
+  "github.com/mik3cap/haunts/mrgnet/server"
+  "os"
+)
+
+// When started with -verify the game reads a gobbed server.Turn from stdin,
+// checks it with game.VerifyTurn, or game.VerifyWinner if it is a Winner,
+// and exits.  This is how a server's
+// CommandVerifier checks turns.
+func isVerifying() bool {
+  return len(os.Args) > 1 && os.Args[1] == "-verify"
+}
+
+// Returns the exit status for the process, any problem with the turn is
+// written to stderr.
+func verifyTurn() int {
+  var turn server.Turn
+  err := gob.NewDecoder(os.Stdin).Decode(&turn)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Unable to read turn: %v\n", err)
+    return 1
+  }
+  if turn.Winner {
+    err = game.VerifyWinner(turn.After, turn.Intruders)
+  } else {
+    err = game.VerifyTurn(turn.Before, turn.Execs, turn.After, turn.Intruders, turn.Start)
+  }
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%v\n", err)
+    return 1
+  }
+  return 0
+}